@@ -0,0 +1,139 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// largestEntriesCount is the number of entries reported
+// in Analysis.Largest.
+const largestEntriesCount = 10
+
+// sizeBucketLimits holds the upper bounds (inclusive) of the buckets
+// of the size histogram returned by Analyze.
+var sizeBucketLimits = []int64{
+	1 << 10,
+	64 << 10,
+	1 << 20,
+	16 << 20,
+	256 << 20,
+	1 << 30,
+	math.MaxInt64,
+}
+
+// Analysis summarises the contents of an archive.
+type Analysis struct {
+	// Entries is the number of entries in the archive.
+	Entries int
+	// TotalSize is the sum of the sizes of all entries.
+	TotalSize int64
+	// SizeHistogram counts the regular files by size.
+	SizeHistogram []SizeBucket
+	// Largest holds the largest regular files, biggest first.
+	Largest []EntrySize
+	// Oldest and Newest hold the entries with the earliest
+	// and latest modification times.
+	Oldest, Newest EntryTime
+	// TopLevelSizes holds the total size of the entries below
+	// each top level entry of the archive.
+	TopLevelSizes map[string]int64
+}
+
+// SizeBucket is a single bucket of a size histogram.
+type SizeBucket struct {
+	// MaxSize is the largest size counted in the bucket.
+	MaxSize int64
+	// Count is the number of files in the bucket.
+	Count int
+	// Bytes is the total size of the files in the bucket.
+	Bytes int64
+}
+
+// EntrySize pairs an entry name with its size.
+type EntrySize struct {
+	Name string
+	Size int64
+}
+
+// EntryTime pairs an entry name with its modification time.
+type EntryTime struct {
+	Name    string
+	ModTime time.Time
+}
+
+// Analyze reads the headers of every entry in tarFile, which may be
+// gzip compressed, and returns statistics that help understand what
+// makes up an archive and why it grows.
+func Analyze(tarFile string) (*Analysis, error) {
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a := &Analysis{
+		TopLevelSizes: make(map[string]int64),
+	}
+	for _, limit := range sizeBucketLimits {
+		a.SizeHistogram = append(a.SizeHistogram, SizeBucket{MaxSize: limit})
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		a.add(hdr)
+	}
+	return a, nil
+}
+
+// add accounts for the given entry in the analysis.
+func (a *Analysis) add(hdr *tar.Header) {
+	a.Entries++
+	a.TotalSize += hdr.Size
+	name := strings.TrimPrefix(hdr.Name, "/")
+	a.TopLevelSizes[strings.SplitN(name, "/", 2)[0]] += hdr.Size
+	if a.Oldest.Name == "" || hdr.ModTime.Before(a.Oldest.ModTime) {
+		a.Oldest = EntryTime{hdr.Name, hdr.ModTime}
+	}
+	if a.Newest.Name == "" || hdr.ModTime.After(a.Newest.ModTime) {
+		a.Newest = EntryTime{hdr.Name, hdr.ModTime}
+	}
+	if !hdr.FileInfo().Mode().IsRegular() {
+		return
+	}
+	for i := range a.SizeHistogram {
+		if hdr.Size <= a.SizeHistogram[i].MaxSize {
+			a.SizeHistogram[i].Count++
+			a.SizeHistogram[i].Bytes += hdr.Size
+			break
+		}
+	}
+	a.Largest = addLargest(a.Largest, EntrySize{hdr.Name, hdr.Size})
+}
+
+// addLargest adds e to largest, which holds at most
+// largestEntriesCount entries, biggest first, if it is big enough.
+func addLargest(largest []EntrySize, e EntrySize) []EntrySize {
+	if len(largest) == largestEntriesCount && e.Size <= largest[len(largest)-1].Size {
+		return largest
+	}
+	largest = append(largest, e)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Size > largest[j].Size
+	})
+	if len(largest) > largestEntriesCount {
+		largest = largest[:largestEntriesCount]
+	}
+	return largest
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestAnalyze(c *gc.C) {
+	t.createTestFiles(c)
+	old := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := os.Chtimes(filepath.Join(t.cwd, "TarFile2"), old, old)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+
+	a, err := Analyze(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(a.Entries, gc.Equals, 6)
+	c.Assert(a.TotalSize, gc.Equals, int64(len("TarSubFile1")+len("TarFile1")+len("TarFile2")))
+	c.Assert(a.SizeHistogram[0].Count, gc.Equals, 3)
+	c.Assert(a.SizeHistogram[0].Bytes, gc.Equals, a.TotalSize)
+	c.Assert(a.Largest, gc.HasLen, 3)
+	c.Assert(a.Largest[0], gc.Equals, EntrySize{"TarDirectoryPopulated/TarSubFile1", 11})
+	c.Assert(a.Oldest.Name, gc.Equals, "TarFile2")
+	c.Assert(a.Oldest.ModTime.Equal(old), gc.Equals, true)
+	c.Assert(a.TopLevelSizes, gc.DeepEquals, map[string]int64{
+		"TarDirectoryEmpty":     0,
+		"TarDirectoryPopulated": 11,
+		"TarFile1":              8,
+		"TarFile2":              8,
+	})
+}
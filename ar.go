@@ -0,0 +1,227 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// arMagic starts every ar archive.
+const arMagic = "!<arch>\n"
+
+// arHeaderSize is the size of the header preceding each member.
+const arHeaderSize = 60
+
+// ErrArWriteTooLong is returned when more data is written to
+// an ar member than its header declared.
+var ErrArWriteTooLong = errors.New("ar: write too long")
+
+// ArMember describes a member of an ar archive, such as the
+// debian-binary, control.tar.gz and data.tar.gz members of
+// a Debian package.
+type ArMember struct {
+	// Name is the name of the member, at most 16 bytes long.
+	Name    string
+	ModTime time.Time
+	Uid     int
+	Gid     int
+	// Mode holds the permission and type bits of the member,
+	// such as 0100644 for a regular file.
+	Mode int64
+	Size int64
+}
+
+// ArWriter writes an ar archive in the common format read by
+// dpkg and binutils. Long names, which need GNU or BSD
+// extensions, are not supported.
+type ArWriter struct {
+	w         io.Writer
+	started   bool
+	remaining int64
+	pad       bool
+}
+
+// NewArWriter returns an ArWriter writing to w.
+func NewArWriter(w io.Writer) *ArWriter {
+	return &ArWriter{w: w}
+}
+
+// WriteHeader starts a new member described by m. Exactly m.Size
+// bytes must then be written before the next member is started.
+func (aw *ArWriter) WriteHeader(m *ArMember) error {
+	if err := aw.finishMember(); err != nil {
+		return err
+	}
+	if m.Name == "" || len(m.Name) > 16 || strings.ContainsAny(m.Name, "/ \n") {
+		return fmt.Errorf("ar: invalid member name %q", m.Name)
+	}
+	if m.Size < 0 || m.Size > 9999999999 {
+		return fmt.Errorf("ar: invalid size %d for member %q", m.Size, m.Name)
+	}
+	mode := m.Mode
+	if mode == 0 {
+		mode = 0100644
+	}
+	mtime := m.ModTime.Unix()
+	if m.ModTime.IsZero() || mtime < 0 {
+		mtime = 0
+	}
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", m.Name, mtime, m.Uid, m.Gid, mode, m.Size)
+	if len(hdr) != arHeaderSize {
+		return fmt.Errorf("ar: cannot encode header for member %q", m.Name)
+	}
+	if !aw.started {
+		hdr = arMagic + hdr
+		aw.started = true
+	}
+	if _, err := io.WriteString(aw.w, hdr); err != nil {
+		return err
+	}
+	aw.remaining = m.Size
+	aw.pad = m.Size%2 != 0
+	return nil
+}
+
+// Write writes to the current member.
+func (aw *ArWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > aw.remaining {
+		n, err := aw.Write(p[:aw.remaining])
+		if err == nil {
+			err = ErrArWriteTooLong
+		}
+		return n, err
+	}
+	n, err := aw.w.Write(p)
+	aw.remaining -= int64(n)
+	return n, err
+}
+
+// finishMember pads the current member, if its data was fully written.
+func (aw *ArWriter) finishMember() error {
+	if aw.remaining > 0 {
+		return fmt.Errorf("ar: missed writing %d bytes", aw.remaining)
+	}
+	if aw.pad {
+		// Members start on even offsets.
+		if _, err := io.WriteString(aw.w, "\n"); err != nil {
+			return err
+		}
+		aw.pad = false
+	}
+	return nil
+}
+
+// Close finishes the archive. An archive without members
+// holds just the ar magic. It does not close the underlying
+// writer.
+func (aw *ArWriter) Close() error {
+	if err := aw.finishMember(); err != nil {
+		return err
+	}
+	if !aw.started {
+		aw.started = true
+		_, err := io.WriteString(aw.w, arMagic)
+		return err
+	}
+	return nil
+}
+
+// ArReader reads the members of an ar archive.
+type ArReader struct {
+	r         *bufio.Reader
+	remaining int64
+	pad       bool
+}
+
+// NewArReader returns an ArReader reading from r, checking
+// that r holds an ar archive.
+func NewArReader(r io.Reader) (*ArReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != arMagic {
+		return nil, errors.New("ar: not an ar archive")
+	}
+	return &ArReader{r: br}, nil
+}
+
+// Next advances to the next member, returning io.EOF at the end of
+// the archive. The contents of the member can then be read from ar.
+func (ar *ArReader) Next() (*ArMember, error) {
+	skip := ar.remaining
+	if ar.pad {
+		skip++
+	}
+	if _, err := io.CopyN(ioutil.Discard, ar.r, skip); err != nil {
+		return nil, fmt.Errorf("ar: %v", noEOF(err))
+	}
+	ar.remaining, ar.pad = 0, false
+	hdr := make([]byte, arHeaderSize)
+	if _, err := io.ReadFull(ar.r, hdr); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("ar: %v", noEOF(err))
+	}
+	if string(hdr[58:]) != "`\n" {
+		return nil, errors.New("ar: invalid member header")
+	}
+	field := func(from, to, base int) (int64, error) {
+		s := strings.TrimSpace(string(hdr[from:to]))
+		if s == "" {
+			return 0, nil
+		}
+		return strconv.ParseInt(s, base, 64)
+	}
+	m := &ArMember{
+		// GNU ar ends names with a slash.
+		Name: strings.TrimSuffix(strings.TrimRight(string(hdr[:16]), " "), "/"),
+	}
+	var fields [5]int64
+	for i, f := range []struct{ from, to, base int }{
+		{16, 28, 10}, {28, 34, 10}, {34, 40, 10}, {40, 48, 8}, {48, 58, 10},
+	} {
+		v, err := field(f.from, f.to, f.base)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("ar: invalid header for member %q", m.Name)
+		}
+		fields[i] = v
+	}
+	m.ModTime = time.Unix(fields[0], 0)
+	m.Uid, m.Gid = int(fields[1]), int(fields[2])
+	m.Mode, m.Size = fields[3], fields[4]
+	ar.remaining = m.Size
+	ar.pad = m.Size%2 != 0
+	return m, nil
+}
+
+// Read reads from the current member.
+func (ar *ArReader) Read(p []byte) (int, error) {
+	if ar.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > ar.remaining {
+		p = p[:ar.remaining]
+	}
+	n, err := ar.r.Read(p)
+	ar.remaining -= int64(n)
+	if err == io.EOF && ar.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF,
+// for reads that must not reach the end.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestArRoundTrip(c *gc.C) {
+	t.createTestFiles(c)
+	var data bytes.Buffer
+	_, err := TarFilesToWriter(t.testFiles, &data, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+
+	mtime := time.Unix(1400000000, 0)
+	members := []struct {
+		name     string
+		contents []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", []byte("odd")},
+		{"data.tar.gz", data.Bytes()},
+	}
+	var deb bytes.Buffer
+	aw := NewArWriter(&deb)
+	for _, m := range members {
+		err := aw.WriteHeader(&ArMember{Name: m.name, ModTime: mtime, Size: int64(len(m.contents))})
+		c.Assert(err, gc.IsNil)
+		_, err = aw.Write(m.contents)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(aw.Close(), gc.IsNil)
+	c.Assert(deb.String()[:8+arHeaderSize], gc.Equals,
+		"!<arch>\ndebian-binary   1400000000  0     0     100644  4         `\n")
+
+	ar, err := NewArReader(&deb)
+	c.Assert(err, gc.IsNil)
+	for _, m := range members {
+		hdr, err := ar.Next()
+		c.Assert(err, gc.IsNil)
+		c.Assert(hdr, gc.DeepEquals, &ArMember{
+			Name:    m.name,
+			ModTime: mtime,
+			Mode:    0100644,
+			Size:    int64(len(m.contents)),
+		})
+		if m.name == "control.tar.gz" {
+			// Leave the contents unread.
+			continue
+		}
+		contents, err := ioutil.ReadAll(ar)
+		c.Assert(err, gc.IsNil)
+		c.Assert(contents, gc.DeepEquals, m.contents)
+	}
+	_, err = ar.Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (t *TarSuite) TestArErrors(c *gc.C) {
+	aw := NewArWriter(ioutil.Discard)
+	err := aw.WriteHeader(&ArMember{Name: "a-name-longer-than-16"})
+	c.Assert(err, gc.ErrorMatches, `ar: invalid member name "a-name-longer-than-16"`)
+	c.Assert(aw.WriteHeader(&ArMember{Name: "short", Size: 2}), gc.IsNil)
+	_, err = aw.Write([]byte("abc"))
+	c.Assert(err, gc.Equals, ErrArWriteTooLong)
+	c.Assert(aw.WriteHeader(&ArMember{Name: "next"}), gc.IsNil)
+	c.Assert(aw.WriteHeader(&ArMember{Name: "last", Size: 1}), gc.IsNil)
+	c.Assert(aw.Close(), gc.ErrorMatches, "ar: missed writing 1 bytes")
+
+	_, err = NewArReader(bytes.NewBufferString("!<tar>\n"))
+	c.Assert(err, gc.ErrorMatches, "ar: not an ar archive")
+	ar, err := NewArReader(bytes.NewBufferString(arMagic + "truncated"))
+	c.Assert(err, gc.IsNil)
+	_, err = ar.Next()
+	c.Assert(err, gc.ErrorMatches, "ar: unexpected EOF")
+}
@@ -0,0 +1,191 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// gzipMagic holds the first bytes of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// newArchiveReader returns a tar reader for r, transparently
+// uncompressing it if it holds a gzip stream.
+func newArchiveReader(r io.Reader) (*tar.Reader, error) {
+	compressed, r, err := sniffGzip(r)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		gzr, err := newGzipReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot uncompress archive: %v", err)
+		}
+		return tar.NewReader(gzr), nil
+	}
+	return tar.NewReader(r), nil
+}
+
+// errGzipPadding is returned when the zero bytes
+// padding a gzip stream are followed by other data.
+var errGzipPadding = errors.New("gzip: data after trailing zero padding")
+
+// gzipReader uncompresses every member of a gzip stream, as written
+// by parallel compressors such as pigz or by concatenating compressed
+// files, ignoring the zero bytes some producers pad the stream with.
+type gzipReader struct {
+	br  *bufio.Reader
+	gzr *gzip.Reader
+}
+
+// newGzipReader returns a gzipReader uncompressing r.
+func newGzipReader(r io.Reader) (*gzipReader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	gzr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	gzr.Multistream(false)
+	return &gzipReader{br: br, gzr: gzr}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	for {
+		n, err := g.gzr.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		more, err := nextGzipMember(g.br)
+		if err != nil {
+			return 0, err
+		}
+		if !more {
+			return 0, io.EOF
+		}
+		if err := g.gzr.Reset(g.br); err != nil {
+			return 0, err
+		}
+		g.gzr.Multistream(false)
+	}
+}
+
+// nextGzipMember reports whether another gzip member follows in r,
+// which is positioned at the end of a member. Zero bytes padding the
+// stream are skipped, but must not be followed by anything else.
+func nextGzipMember(r io.ByteScanner) (bool, error) {
+	b, err := r.ReadByte()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if b != 0 {
+		return true, r.UnreadByte()
+	}
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, errGzipPadding
+		}
+	}
+}
+
+// deterministicGzipLevel is the compression level
+// used for deterministic gzip output.
+const deterministicGzipLevel = gzip.BestCompression
+
+// WithGzipHeader returns an Option that makes TarFiles write h as
+// the header of compressed archives, or of each of their gzip
+// members. By default the header holds no name, comment or
+// modification time, so that compressed archives neither leak
+// internal file names nor vary between runs, and its OS field is
+// 255 (unknown), or 0 WithDeterministicGzip. The name and comment
+// must be Latin-1 strings, as required by the gzip format.
+func WithGzipHeader(h gzip.Header) Option {
+	return func(o *options) {
+		o.gzipHeader = &h
+	}
+}
+
+// validateGzipHeader appends to problems the reasons
+// why h cannot be written.
+func validateGzipHeader(h *gzip.Header, problems []string) []string {
+	for _, s := range []string{h.Name, h.Comment} {
+		for _, r := range s {
+			if r == 0 || r > 0xff {
+				return append(problems, "WithGzipHeader needs a Latin-1 name and comment without NUL bytes")
+			}
+		}
+	}
+	return problems
+}
+
+// newGzipWriter returns a gzip writer compressing into w as
+// described by o.
+func newGzipWriter(w io.Writer, o *options) (*gzip.Writer, error) {
+	var gzw *gzip.Writer
+	if o.deterministicGzip {
+		var err error
+		gzw, err = gzip.NewWriterLevel(w, deterministicGzipLevel)
+		if err != nil {
+			return nil, err
+		}
+		// Only the fields that vary between runs or hosts need
+		// resetting; the name and comment are empty already.
+		gzw.Header.ModTime = time.Time{}
+		gzw.Header.OS = 0
+	} else {
+		gzw = gzip.NewWriter(w)
+	}
+	if o.gzipHeader != nil {
+		gzw.Header = *o.gzipHeader
+	}
+	return gzw, nil
+}
+
+// sniffGzip reports whether r holds a gzip stream. The returned
+// reader must be used in place of r.
+func sniffGzip(r io.Reader) (bool, io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return false, nil, err
+	}
+	return err == nil && string(magic) == string(gzipMagic), br, nil
+}
+
+// openArchive opens the tar file at tarFile, which may be gzip
+// compressed or split into volumes. The returned file must be closed
+// by the caller.
+func openArchive(tarFile string) (*tar.Reader, io.Closer, error) {
+	f, err := openInput(tarFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	tr, err := newArchiveReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	}
+	return tr, f, nil
+}
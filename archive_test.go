@@ -0,0 +1,217 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestDeterministicGzipHeader(c *gc.C) {
+	var buf bytes.Buffer
+	o := newOptions([]Option{WithDeterministicGzip()})
+	gzw, err := newGzipWriter(&buf, o)
+	c.Assert(err, gc.IsNil)
+	_, err = gzw.Write([]byte("hello"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(gzw.Close(), gc.IsNil)
+	data := buf.Bytes()
+	// Bytes 4-7 hold the modification time and byte 9 the OS.
+	c.Assert(data[4:8], gc.DeepEquals, []byte{0, 0, 0, 0})
+	c.Assert(data[9], gc.Equals, byte(0))
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(gzr.Header.Name, gc.Equals, "")
+}
+
+func (t *TarSuite) TestTarFilesDeterministicGzip(c *gc.C) {
+	t.createTestFiles(c)
+	first := filepath.Join(t.cwd, "first.tgz")
+	second := filepath.Join(t.cwd, "second.tgz")
+	sum1, err := TarFiles(t.testFiles, first, t.cwd+"/", true, WithDeterministicGzip(), WithManifest())
+	c.Assert(err, gc.IsNil)
+	// Anything dated from the time of writing, which
+	// has a resolution of a second, would differ.
+	time.Sleep(1100 * time.Millisecond)
+	sum2, err := TarFiles(t.testFiles, second, t.cwd+"/", true, WithDeterministicGzip(), WithManifest())
+	c.Assert(err, gc.IsNil)
+	c.Assert(sum1, gc.Equals, sum2)
+	data1, err := ioutil.ReadFile(first)
+	c.Assert(err, gc.IsNil)
+	data2, err := ioutil.ReadFile(second)
+	c.Assert(err, gc.IsNil)
+	c.Assert(bytes.Equal(data1, data2), gc.Equals, true)
+}
+
+func (t *TarSuite) TestDeterministicGzipNeedsCompression(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false, WithDeterministicGzip())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDeterministicGzip needs a compressed archive")
+}
+
+func (t *TarSuite) TestTarFilesWithGzipHeader(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	h := readGzipHeader(c, outputTar)
+	c.Assert(h.Name, gc.Equals, "")
+	c.Assert(h.Comment, gc.Equals, "")
+	c.Assert(h.ModTime.IsZero(), gc.Equals, true)
+
+	mtime := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithGzipHeader(gzip.Header{
+		Name:    "backup.tar",
+		Comment: "machine-0",
+		ModTime: mtime,
+		OS:      3,
+	}))
+	c.Assert(err, gc.IsNil)
+	h = readGzipHeader(c, outputTar)
+	c.Assert(h.Name, gc.Equals, "backup.tar")
+	c.Assert(h.Comment, gc.Equals, "machine-0")
+	c.Assert(h.ModTime.Equal(mtime), gc.Equals, true)
+	c.Assert(h.OS, gc.Equals, byte(3))
+}
+
+func (t *TarSuite) TestWithGzipHeaderInvalid(c *gc.C) {
+	outputTar := filepath.Join(t.cwd, "out.tar")
+	_, err := TarFiles([]string{"x"}, outputTar, "", false, WithGzipHeader(gzip.Header{}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithGzipHeader needs a compressed archive")
+	_, err = TarFiles([]string{"x"}, outputTar, "", true, WithGzipHeader(gzip.Header{Name: "日本"}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithGzipHeader needs a Latin-1 name and comment without NUL bytes")
+}
+
+func readGzipHeader(c *gc.C, file string) gzip.Header {
+	f, err := os.Open(file)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	c.Assert(err, gc.IsNil)
+	return gzr.Header
+}
+
+// multiMemberGzip returns data compressed as a sequence of gzip
+// members split at the given offsets, as written by parallel
+// compressors such as pigz --independent or by concatenating
+// compressed files.
+func multiMemberGzip(c *gc.C, data []byte, splits ...int) []byte {
+	var buf bytes.Buffer
+	start := 0
+	for _, end := range append(splits, len(data)) {
+		gzw := gzip.NewWriter(&buf)
+		_, err := gzw.Write(data[start:end])
+		c.Assert(err, gc.IsNil)
+		c.Assert(gzw.Close(), gc.IsNil)
+		start = end
+	}
+	return buf.Bytes()
+}
+
+func (t *TarSuite) TestMultiMemberGzip(c *gc.C) {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for _, name := range []string{"a", "b", "c"} {
+		contents := bytes.Repeat([]byte(name), 1000)
+		c.Assert(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))}), gc.IsNil)
+		_, err := tw.Write(contents)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	// The members end in the middle of a header and of contents,
+	// and are followed by an empty member and zero padding.
+	data := multiMemberGzip(c, tarBuf.Bytes(), 700, 1600, 2100)
+	data = append(data, multiMemberGzip(c, nil)...)
+	data = append(data, make([]byte, 512)...)
+
+	files, err := ExtractToMap(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 3)
+	c.Assert(files["c"], gc.DeepEquals, bytes.Repeat([]byte("c"), 1000))
+
+	tarFile := filepath.Join(c.MkDir(), "multi.tar.gz")
+	c.Assert(ioutil.WriteFile(tarFile, data, 0644), gc.IsNil)
+	outputDir := c.MkDir()
+	c.Assert(UntarFiles(tarFile, outputDir, true), gc.IsNil)
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "c"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.DeepEquals, files["c"])
+
+	stats, err := Stats(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats.Entries, gc.Equals, 3)
+	c.Assert(stats.UncompressedSize, gc.Equals, int64(tarBuf.Len()))
+	c.Assert(stats.CompressedSize, gc.Equals, int64(len(data)))
+	problems, err := ValidateArchive(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.HasLen, 0)
+	idx, err := BuildIndex(tarFile)
+	c.Assert(err, gc.IsNil)
+	c.Assert(idx.SeekPoints, gc.HasLen, 4)
+	c.Assert(SaveIndex(tarFile), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(ExtractEntry(tarFile, "c", &buf), gc.IsNil)
+	c.Assert(buf.Bytes(), gc.DeepEquals, files["c"])
+}
+
+func (t *TarSuite) TestPigzIndependentGzip(c *gc.C) {
+	// The fixture is compressed in the layout pigz --independent
+	// writes: a single member whose 32KiB blocks are compressed
+	// without the dictionary of the previous ones, each ending
+	// with an empty stored block.
+	tarFile := filepath.Join("testdata", "pigz-independent.tar.gz")
+	data, err := ioutil.ReadFile(tarFile)
+	c.Assert(err, gc.IsNil)
+	var big bytes.Buffer
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&big, "line %05d of the big file\n", i)
+	}
+
+	files, err := ExtractToMap(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{
+		"big":   big.Bytes(),
+		"small": []byte("small file\n"),
+	})
+	outputDir := c.MkDir()
+	c.Assert(UntarFiles(tarFile, outputDir, true), gc.IsNil)
+	contents, err := ioutil.ReadFile(filepath.Join(outputDir, "big"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.DeepEquals, big.Bytes())
+
+	problems, err := ValidateArchive(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.HasLen, 0)
+	var buf bytes.Buffer
+	c.Assert(ExtractEntry(tarFile, "small", &buf), gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "small file\n")
+}
+
+func (t *TarSuite) TestParallelGzipPadded(c *gc.C) {
+	t.createTestFiles(c)
+	var buf bytes.Buffer
+	_, err := TarFilesToWriter(t.testFiles, &buf, t.cwd+"/", true, WithConcurrency(Concurrency{Compress: 4}))
+	c.Assert(err, gc.IsNil)
+	data := append(buf.Bytes(), make([]byte, 100)...)
+	files, err := ExtractToMap(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 3)
+}
+
+func (t *TarSuite) TestGzipDataAfterPadding(c *gc.C) {
+	data := multiMemberGzip(c, []byte("hello"))
+	data = append(data, 0, 0, 'x')
+	gzr, err := newGzipReader(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	contents, err := ioutil.ReadAll(gzr)
+	c.Assert(err, gc.Equals, errGzipPadding)
+	c.Assert(string(contents), gc.Equals, "hello")
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WithAtomicExtract returns an Option that makes UntarFiles extract
+// into a temporary directory next to the output folder, which is
+// renamed into place only once extraction succeeds. A failed restore
+// then never leaves a half populated output folder. The output
+// folder must not exist or be empty.
+func WithAtomicExtract() Option {
+	return func(o *options) {
+		o.atomic = true
+	}
+}
+
+// extractAtomically calls extract with a staging directory next to
+// outputFolder, and replaces outputFolder with it if extract succeeds.
+// The staging directory is removed otherwise.
+func extractAtomically(outputFolder string, extract func(dir string) error) (err error) {
+	outputFolder = filepath.Clean(outputFolder)
+	mode := os.FileMode(0755)
+	info, statErr := os.Stat(outputFolder)
+	switch {
+	case statErr == nil:
+		if !info.IsDir() {
+			return fmt.Errorf("cannot extract atomically: %q is not a directory", outputFolder)
+		}
+		empty, err := isEmptyDir(outputFolder)
+		if err != nil {
+			return fmt.Errorf("cannot extract atomically: %v", err)
+		}
+		if !empty {
+			return fmt.Errorf("cannot extract atomically: %q is not empty", outputFolder)
+		}
+		mode = info.Mode().Perm()
+	case !os.IsNotExist(statErr):
+		return fmt.Errorf("cannot extract atomically: %v", statErr)
+	}
+	// The staging directory must be on the same filesystem as the
+	// output folder for the final rename to be atomic.
+	staging, err := ioutil.TempDir(filepath.Dir(outputFolder), "."+filepath.Base(outputFolder)+".extract-")
+	if err != nil {
+		return fmt.Errorf("cannot create staging directory: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(staging)
+		}
+	}()
+	if err := extract(staging); err != nil {
+		return err
+	}
+	if err := os.Chmod(staging, mode); err != nil {
+		return fmt.Errorf("cannot set mode of staging directory: %v", err)
+	}
+	// rename replaces an empty directory, but
+	// not on every platform, so remove it first.
+	if statErr == nil {
+		if err := os.Remove(outputFolder); err != nil {
+			return fmt.Errorf("cannot replace %q: %v", outputFolder, err)
+		}
+	}
+	if err := os.Rename(staging, outputFolder); err != nil {
+		return fmt.Errorf("cannot move extracted files into place: %v", err)
+	}
+	return nil
+}
+
+// isEmptyDir reports whether the directory dir has no entries.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// WithDirectWrite returns an Option that makes TarFiles write the
+// archive straight to its final path. By default, it is written to
+// a temporary file next to it, flushed to stable storage and renamed
+// into place once complete, so that a crash never leaves a half
+// written archive under the final name. Archives stored WithTarget
+// or WithVolumeSize are always written directly.
+func WithDirectWrite() Option {
+	return func(o *options) {
+		o.directWrite = true
+	}
+}
+
+// atomicFile is a file written under a temporary name,
+// and renamed to its final path once closed.
+type atomicFile struct {
+	*os.File
+	path string
+	// replaced, if set, removes what else the file
+	// replaces once it is renamed into place.
+	replaced func()
+}
+
+// createAtomic creates an atomicFile to be renamed to path.
+// The temporary name is unique, so that concurrent writers
+// of the same path do not write into the same file.
+func createAtomic(path string) (*atomicFile, error) {
+	removeStaleAtomic(path)
+	for i := 0; ; i++ {
+		tmp := fmt.Sprintf("%s.%d-%d.tmp", path, os.Getpid(), rand.Uint32())
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &atomicFile{File: f, path: path}, nil
+	}
+}
+
+// Close flushes the file to stable storage, closes
+// it and renames it to its final path.
+func (f *atomicFile) Close() error {
+	if err := f.finish(); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// finish flushes the file to stable storage and closes it, leaving
+// it under its temporary name. The file is removed if that fails.
+func (f *atomicFile) finish() error {
+	if err := fsync(f.File); err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// commit renames the finished file to its final path.
+// The file is removed if that fails.
+func (f *atomicFile) commit() error {
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if f.replaced != nil {
+		f.replaced()
+	}
+	return nil
+}
+
+// abort closes and removes the file.
+func (f *atomicFile) abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// removeStaleAtomic removes the temporary files that createAtomic left
+// next to path in runs that crashed before renaming them into place.
+// Errors are ignored, as a file that cannot be removed now will be
+// retried by the next run.
+func removeStaleAtomic(path string) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pid, ok := atomicPid(entry.Name(), base)
+		if !ok || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// atomicPid returns the pid encoded in the name of a
+// temporary file created by createAtomic for base.
+func atomicPid(name, base string) (int, bool) {
+	if !strings.HasPrefix(name, base+".") || !strings.HasSuffix(name, ".tmp") {
+		return 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ".tmp"), "-")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 32); err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestAtomicExtract(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	restore := filepath.Join(t.cwd, "restore")
+
+	failing := func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		if hdr.Name == "TarFile2" {
+			return nil, errors.New("disk on fire")
+		}
+		return r, nil
+	}
+	err = UntarFiles(outputTar, restore, false, WithAtomicExtract(), WithContentFilter(failing))
+	c.Assert(err, gc.ErrorMatches, `cannot filter contents of "TarFile2": disk on fire`)
+	_, err = os.Stat(restore)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	t.assertNoStagingDirs(c)
+
+	c.Assert(os.Mkdir(restore, 0750), gc.IsNil)
+	err = UntarFiles(outputTar, restore, false, WithAtomicExtract())
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, restore)
+	info, err := os.Stat(restore)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0750))
+	t.assertNoStagingDirs(c)
+
+	err = UntarFiles(outputTar, restore, false, WithAtomicExtract())
+	c.Assert(err, gc.ErrorMatches, `cannot extract atomically: ".*restore" is not empty`)
+}
+
+func (t *TarSuite) assertNoStagingDirs(c *gc.C) {
+	infos, err := ioutil.ReadDir(t.cwd)
+	c.Assert(err, gc.IsNil)
+	for _, info := range infos {
+		matched, _ := filepath.Match(".restore.extract-*", info.Name())
+		c.Assert(matched, gc.Equals, false, gc.Commentf("staging directory %q left behind", info.Name()))
+	}
+}
+
+func (t *TarSuite) TestTarFilesAtomic(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	t.PatchValue(&fsync, func(f *os.File) error {
+		return errors.New("disk on fire")
+	})
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.ErrorMatches, "error closing backup file: disk on fire")
+	_, err = os.Stat(outputTar)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	t.assertNoTempArchives(c)
+
+	t.PatchValue(&fsync, (*os.File).Sync)
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	t.assertNoTempArchives(c)
+	t.assertTarContents(c, testExpectedTarContents, outputTar, false)
+}
+
+func (t *TarSuite) TestTarFilesDirectWrite(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	synced := false
+	t.PatchValue(&fsync, func(f *os.File) error {
+		synced = true
+		return nil
+	})
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithDirectWrite())
+	c.Assert(err, gc.IsNil)
+	c.Assert(synced, gc.Equals, false)
+	t.assertTarContents(c, testExpectedTarContents, outputTar, false)
+
+	err = UntarFiles(outputTar, c.MkDir(), false, WithDirectWrite())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDirectWrite only applies to archive creation")
+}
+
+func (t *TarSuite) assertNoTempArchives(c *gc.C) {
+	infos, err := ioutil.ReadDir(t.cwd)
+	c.Assert(err, gc.IsNil)
+	for _, info := range infos {
+		matched, _ := filepath.Match("*.tmp", info.Name())
+		c.Assert(matched, gc.Equals, false, gc.Commentf("temporary archive %q left behind", info.Name()))
+	}
+}
+
+func (t *TarSuite) TestTarFilesRemovesStaleTempFiles(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	// A pid that cannot belong to a running process.
+	stale := outputTar + ".2147483647-123.tmp"
+	c.Assert(ioutil.WriteFile(stale, []byte("partial"), 0644), gc.IsNil)
+	live := outputTar + ".1-123.tmp"
+	c.Assert(ioutil.WriteFile(live, []byte("partial"), 0644), gc.IsNil)
+
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(stale)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(live)
+	c.Assert(err, gc.IsNil)
+}
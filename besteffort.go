@@ -0,0 +1,56 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+)
+
+// WithBestEffort returns an Option that makes UntarFiles extract
+// everything it can read from a truncated or corrupt archive, such as
+// a backup cut off during a transfer. Reaching the corruption makes it
+// return a *PartialExtractionError listing what was recovered, instead
+// of a plain error. The entry being read when the corruption was found
+// is not extracted.
+func WithBestEffort() Option {
+	return func(o *options) {
+		o.bestEffort = true
+	}
+}
+
+// PartialExtractionError is returned by UntarFiles WithBestEffort when
+// the archive could only be partly read.
+type PartialExtractionError struct {
+	// Extracted holds the names of the entries extracted.
+	Extracted []string
+	// Truncated holds the name of the entry being read when the
+	// corruption was found, or "" if it was found between entries.
+	Truncated string
+	// Err is the error reading the archive.
+	Err error
+}
+
+func (e *PartialExtractionError) Error() string {
+	if e.Truncated == "" {
+		return fmt.Sprintf("archive is corrupt after %d entries: %v", len(e.Extracted), e.Err)
+	}
+	return fmt.Sprintf("archive is corrupt after %d entries, in %q: %v", len(e.Extracted), e.Truncated, e.Err)
+}
+
+// corrupt returns the error to return when reading the archive
+// failed with err while reading the named entry, if any.
+func (x *extractor) corrupt(name string, err error) error {
+	if !x.opts.bestEffort {
+		if name == "" {
+			return fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		return fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	x.opts.log().Warnf("archive is corrupt, stopping extraction: %v", err)
+	return &PartialExtractionError{
+		Extracted: x.extracted,
+		Truncated: name,
+		Err:       err,
+	}
+}
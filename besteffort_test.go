@@ -0,0 +1,88 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+
+	"github.com/juju/tar/tartest"
+)
+
+func (t *TarSuite) TestBestEffort(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "complete.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"first", "first file"},
+		{"second", "second file"},
+		{"third", strings.Repeat("cut off ", 200)},
+	})
+	data, err := ioutil.ReadFile(tarFile)
+	c.Assert(err, gc.IsNil)
+	// Cut the archive in the middle of the contents of "third".
+	truncated := data[:3*512+512+800]
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(data)
+	c.Assert(gzw.Close(), gc.IsNil)
+
+	for _, test := range []struct {
+		data       []byte
+		compressed bool
+		truncated  string
+	}{
+		{truncated, false, "third"},
+		{gz.Bytes()[:gz.Len()-20], true, ""},
+	} {
+		c.Assert(ioutil.WriteFile(tarFile, test.data, 0644), gc.IsNil)
+		err = UntarFiles(tarFile, c.MkDir(), test.compressed)
+		c.Assert(err, gc.ErrorMatches, "failed while reading tar .*: unexpected EOF")
+
+		outputDir := c.MkDir()
+		err = UntarFiles(tarFile, outputDir, test.compressed, WithBestEffort())
+		c.Assert(err, gc.FitsTypeOf, &PartialExtractionError{})
+		perr := err.(*PartialExtractionError)
+		if test.truncated != "" {
+			c.Assert(perr.Extracted, gc.DeepEquals, []string{"first", "second"})
+			c.Assert(perr.Truncated, gc.Equals, test.truncated)
+			c.Assert(err, gc.ErrorMatches, `archive is corrupt after 2 entries, in "third": unexpected EOF`)
+			_, err = os.Stat(filepath.Join(outputDir, "third"))
+			c.Assert(os.IsNotExist(err), gc.Equals, true)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(outputDir, "second"))
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(contents), gc.Equals, "second file")
+	}
+
+	err = UntarFiles(tarFile, c.MkDir(), true, WithBestEffort(), WithAtomicExtract())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithBestEffort cannot be used with WithAtomicExtract")
+}
+
+func (t *TarSuite) TestBestEffortFinishesTree(c *gc.C) {
+	data := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"ro/":      {Mode: 0555},
+		"ro/file":  {Contents: "recovered"},
+		"skipme":   {Contents: "skipped"},
+		"truncate": {Contents: strings.Repeat("cut off ", 200)},
+	})
+	tarFile := filepath.Join(t.cwd, "truncated.tar")
+	// Cut the archive in the middle of the contents of "truncate".
+	c.Assert(ioutil.WriteFile(tarFile, data[:6*512+800], 0644), gc.IsNil)
+	outputDir := c.MkDir()
+	skip := func(hdr *tar.Header) bool {
+		return hdr.Name == "skipme"
+	}
+	err := UntarFiles(tarFile, outputDir, false, WithBestEffort(), WithSkipFunc(skip), WithSyncPolicy(SyncAtEnd))
+	c.Assert(err, gc.FitsTypeOf, &PartialExtractionError{})
+	c.Assert(err.(*PartialExtractionError).Extracted, gc.DeepEquals, []string{"ro/", "ro/file"})
+	info, err := os.Stat(filepath.Join(outputDir, "ro"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0555))
+}
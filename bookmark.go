@@ -0,0 +1,135 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"compress/gzip"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Bookmark marks a point between two entries of an archive stream
+// from which the stream can be regenerated. If a transfer of the
+// stream breaks after a bookmark, it can be resumed by passing the
+// bookmark to TarFilesToWriter WithResume and the same file list and
+// options: the entries before the bookmark are not written again and
+// the output continues exactly at Offset.
+type Bookmark struct {
+	// Offset is the number of bytes of the stream
+	// written before the bookmark.
+	Offset int64
+	// Entries is the number of entries written
+	// before the bookmark.
+	Entries int
+	// Interval is the interval the bookmarks were requested
+	// at, needed to regenerate the following ones identically.
+	Interval int64
+	// DigestState holds the state of the archive hash after
+	// the first Offset bytes, so the checksum of the whole
+	// stream can be computed when resuming.
+	DigestState []byte
+}
+
+// BookmarkFunc is called with each bookmark as it is reached.
+type BookmarkFunc func(Bookmark)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzipMembers compresses what is written to it into w as a sequence
+// of gzip members, so that compression can start afresh at every
+// bookmark. Readers see the members as a single gzip stream.
+type gzipMembers struct {
+	w    io.Writer
+	opts *options
+	gzw  *gzip.Writer
+}
+
+func (g *gzipMembers) Write(p []byte) (int, error) {
+	return g.gzw.Write(p)
+}
+
+// restart ends the current gzip member, if any, and starts a new one.
+func (g *gzipMembers) restart() error {
+	if err := g.Close(); err != nil {
+		return err
+	}
+	gzw, err := newGzipWriter(g.w, g.opts)
+	if err != nil {
+		return err
+	}
+	g.gzw = gzw
+	return nil
+}
+
+// Close ends the current gzip member.
+func (g *gzipMembers) Close() error {
+	if g.gzw == nil {
+		return nil
+	}
+	err := g.gzw.Close()
+	g.gzw = nil
+	return err
+}
+
+// bookmarker reports bookmarks while an archive is written, and
+// skips the entries written before the bookmark being resumed from.
+type bookmarker struct {
+	every   int64
+	fn      BookmarkFunc
+	counter *countingWriter
+	hash    hash.Hash
+	gz      *gzipMembers
+	last    int64
+	entries int
+	skip    int
+}
+
+// beforeEntry is called before each entry is written to tarw. It
+// reports a bookmark when at least every bytes were written since
+// the last one, and reports whether the entry must be skipped
+// because it was written before the bookmark being resumed from.
+func (b *bookmarker) beforeEntry(tarw entryWriter) (bool, error) {
+	n := b.entries
+	b.entries++
+	if n < b.skip {
+		return true, nil
+	}
+	if b.every <= 0 || b.counter.n-b.last < b.every {
+		return false, nil
+	}
+	if err := tarw.Flush(); err != nil {
+		return false, err
+	}
+	if b.gz != nil {
+		if err := b.gz.restart(); err != nil {
+			return false, fmt.Errorf("cannot compress backup file: %v", err)
+		}
+	}
+	b.last = b.counter.n
+	state, err := b.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return false, fmt.Errorf("cannot save hash state: %v", err)
+	}
+	if b.fn != nil {
+		b.fn(Bookmark{
+			Offset:      b.counter.n,
+			Entries:     n,
+			Interval:    b.every,
+			DigestState: state,
+		})
+	}
+	return false, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestBookmarksResume(c *gc.C) {
+	t.createTestFiles(c)
+	for _, compress := range []bool{false, true} {
+		var full bytes.Buffer
+		var bookmarks []Bookmark
+		shaSum, err := TarFilesToWriter(t.testFiles, &full, t.cwd+"/", compress,
+			WithBookmarks(1, func(b Bookmark) { bookmarks = append(bookmarks, b) }))
+		c.Assert(err, gc.IsNil)
+		c.Assert(len(bookmarks) > 2, gc.Equals, true)
+
+		b := bookmarks[len(bookmarks)/2]
+		var rest bytes.Buffer
+		resumedSum, err := TarFilesToWriter(t.testFiles, &rest, t.cwd+"/", compress, WithResume(b))
+		c.Assert(err, gc.IsNil)
+		c.Assert(resumedSum, gc.Equals, shaSum)
+		c.Assert(append(full.Bytes()[:b.Offset:b.Offset], rest.Bytes()...), gc.DeepEquals, full.Bytes())
+
+		// The files skipped while resuming are not reported as changed.
+		var report CreateReport
+		_, err = TarFilesToWriter(t.testFiles, ioutil.Discard, t.cwd+"/", compress, WithResume(bookmarks[len(bookmarks)-1]), WithCreateReport(&report))
+		c.Assert(err, gc.IsNil)
+		c.Assert(report.Changed, gc.HasLen, 0)
+
+		outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+		_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", compress)
+		c.Assert(err, gc.IsNil)
+		t.assertTarContents(c, testExpectedTarContents, outputTar, compress)
+	}
+}
+
+func (t *TarSuite) TestBookmarksInvalidCombination(c *gc.C) {
+	var buf bytes.Buffer
+	_, err := TarFilesToWriter(nil, &buf, "", false, WithBookmarks(0, func(Bookmark) {}), WithManifest())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: bookmarks cannot be used with WithManifest; "+
+		"WithBookmarks needs a positive interval")
+}
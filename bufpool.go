@@ -0,0 +1,105 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity of the largest buffer kept for
+// reuse, so that a single huge entry does not pin its memory.
+const maxPooledBuffer = 4 << 20
+
+// buffers holds the buffers entry contents are read into,
+// shared by all extractions.
+var buffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns b to the pool, unless it is too big to keep.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
+// WithMemoryBudget returns an Option that limits the memory UntarFiles
+// holds for entry contents at any one time to about max bytes, making
+// extraction wait for pending writes to finish when needed. A single
+// entry larger than max is still extracted, on its own. This mostly
+// matters WithConcurrency, where several entries may be held at once.
+// The peak memory used is recorded in Report.PeakMemory WithReport.
+func WithMemoryBudget(max int64) Option {
+	return func(o *options) {
+		o.memoryBudget = max
+	}
+}
+
+// memoryBudget accounts for the memory held by an extraction.
+type memoryBudget struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	// max is the budget, or zero for no limit.
+	max  int64
+	used int64
+	peak int64
+}
+
+func newMemoryBudget(max int64) *memoryBudget {
+	m := &memoryBudget{max: max}
+	m.freed = sync.NewCond(&m.mu)
+	return m
+}
+
+// acquire accounts for n more bytes, first waiting for enough
+// memory to be released if that would exceed the budget.
+func (m *memoryBudget) acquire(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.max > 0 && m.used > 0 && m.used+n > m.max {
+		m.freed.Wait()
+	}
+	m.add(n)
+}
+
+// grow accounts for n more bytes without waiting, for
+// buffers that turned out bigger than expected.
+func (m *memoryBudget) grow(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(n)
+}
+
+// add accounts for n more bytes. It is called with m.mu held.
+func (m *memoryBudget) add(n int64) {
+	m.used += n
+	if m.used > m.peak {
+		m.peak = m.used
+	}
+}
+
+// release accounts for n bytes being freed.
+func (m *memoryBudget) release(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used -= n
+	m.freed.Broadcast()
+}
+
+// maxUsed returns the most memory accounted for at once.
+func (m *memoryBudget) maxUsed() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peak
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestMemoryBudget(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+
+	var report Report
+	err := UntarFiles(outputTar, c.MkDir(), false, WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.PeakMemory, gc.Equals, int64(1008))
+
+	report = Report{}
+	err = UntarFiles(outputTar, c.MkDir(), false, WithReport(&report),
+		WithConcurrency(Concurrency{Write: 4}), WithMemoryBudget(1020))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.PeakMemory >= 1008, gc.Equals, true)
+	c.Assert(report.PeakMemory <= 1020, gc.Equals, true)
+
+	// A single entry over the budget is still extracted.
+	err = UntarFiles(outputTar, c.MkDir(), false,
+		WithConcurrency(Concurrency{Write: 4}), WithMemoryBudget(10))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestMemoryBudgetAccounting(c *gc.C) {
+	m := newMemoryBudget(100)
+	m.acquire(60)
+	done := make(chan struct{})
+	go func() {
+		m.acquire(60)
+		close(done)
+	}()
+	m.grow(10)
+	m.release(70)
+	<-done
+	c.Assert(m.maxUsed(), gc.Equals, int64(70))
+	m.release(60)
+}
@@ -0,0 +1,186 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// defaultRedaction replaces the text redacted from a SupportBundle
+// when no Replacement is given.
+const defaultRedaction = "[REDACTED]"
+
+// maxRedactLineLength is the length of the longest line whose text
+// a SupportBundle can redact. Longer lines are replaced whole.
+const maxRedactLineLength = 1 << 20
+
+// SupportBundle describes a compressed archive of diagnostic files,
+// such as logs and configuration, as attached to support requests.
+type SupportBundle struct {
+	// Files lists the files and directories collected, which
+	// may be patterns as accepted by TarFiles.
+	Files []string
+	// Strip is removed from the start of the names
+	// of the collected files, as for TarFiles.
+	Strip string
+	// MaxFileSize, if not zero, keeps only the last MaxFileSize
+	// bytes of larger files, which is where logs hold the most
+	// recent events. The first line kept, which is usually cut,
+	// is dropped too.
+	MaxFileSize int64
+	// Redact lists the expressions whose matches in the
+	// contents of the collected files are replaced. They
+	// are matched against one line at a time, so that
+	// large files are not held in memory, and lines longer
+	// than 1MiB are replaced whole.
+	Redact []*regexp.Regexp
+	// Replacement literally replaces the text matched
+	// by Redact, "[REDACTED]" if it is empty.
+	Replacement string
+	// Options are used when writing the archive. They must
+	// not include WithContentFilter, which Redact relies on.
+	Options []Option
+}
+
+// Write writes the bundle to w as a gzip compressed archive embedding
+// a manifest, and returns its checksum as TarFilesToWriter does.
+// Truncated files are recorded as described by WithFileRange.
+func (b *SupportBundle) Write(w io.Writer) (shaSum string, err error) {
+	if newOptions(b.Options).contentFilter != nil {
+		return "", &ConfigError{Problems: []string{"WithContentFilter cannot be used with a SupportBundle"}}
+	}
+	opts := append([]Option{WithManifest()}, b.Options...)
+	files, err := expandGlobs(b.Files)
+	if err != nil {
+		return "", fmt.Errorf("cannot collect files: %v", err)
+	}
+	if b.MaxFileSize > 0 {
+		truncated, err := b.truncate(files)
+		if err != nil {
+			return "", fmt.Errorf("cannot collect files: %v", err)
+		}
+		opts = append(opts, truncated...)
+	}
+	if b.MaxFileSize > 0 || len(b.Redact) > 0 {
+		opts = append(opts, WithContentFilter(b.filter))
+	}
+	return TarFilesToWriter(files, w, b.Strip, true, opts...)
+}
+
+// truncate returns the options making the regular files in and
+// below files keep only their last MaxFileSize bytes.
+func (b *SupportBundle) truncate(files []string) ([]Option, error) {
+	var opts []Option
+	for _, root := range files {
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && info.Size() > b.MaxFileSize {
+				opts = append(opts, WithFileRange(p, FileRange{Offset: -b.MaxFileSize}))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// filter is a ContentFilter dropping the first line of truncated
+// regular files and replacing, one line at a time, the text of
+// regular files matched by Redact.
+func (b *SupportBundle) filter(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+	offset, _, truncated := EntryRange(hdr)
+	truncated = truncated && offset > 0
+	if hdr.Typeflag != tar.TypeReg || !truncated && len(b.Redact) == 0 {
+		return r, nil
+	}
+	br := bufio.NewReaderSize(r, maxRedactLineLength)
+	if truncated {
+		// Text cut at the start of the line would
+		// not be matched by Redact any more.
+		dropped, err := skipLine(br)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		hdr.PAXRecords[paxRangeOffset] = strconv.FormatInt(offset+dropped, 10)
+	}
+	if len(b.Redact) == 0 {
+		return br, nil
+	}
+	replacement := b.Replacement
+	if replacement == "" {
+		replacement = defaultRedaction
+	}
+	return &redactReader{
+		r:           br,
+		redact:      b.Redact,
+		replacement: []byte(replacement),
+	}, nil
+}
+
+// skipLine reads up to and including the next newline from r,
+// and returns the number of bytes read.
+func skipLine(r *bufio.Reader) (int64, error) {
+	var n int64
+	for {
+		line, err := r.ReadSlice('\n')
+		n += int64(len(line))
+		if err != bufio.ErrBufferFull {
+			return n, err
+		}
+	}
+}
+
+// redactReader replaces the text matched by redact in
+// the lines read from r with replacement.
+type redactReader struct {
+	r           *bufio.Reader
+	redact      []*regexp.Regexp
+	replacement []byte
+
+	// line holds what is left of the current line,
+	// and err the error that ended it, if any.
+	line []byte
+	err  error
+}
+
+func (r *redactReader) Read(p []byte) (int, error) {
+	for len(r.line) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.nextLine()
+	}
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+	return n, nil
+}
+
+// nextLine reads the next line, redacted, into r.line.
+func (r *redactReader) nextLine() {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// The line is too long to be matched.
+		_, r.err = skipLine(r.r)
+		r.line = append([]byte(nil), r.replacement...)
+		if r.err == nil {
+			r.line = append(r.line, '\n')
+		}
+		return
+	}
+	r.line, r.err = append([]byte(nil), line...), err
+	for _, re := range r.redact {
+		r.line = re.ReplaceAllLiteral(r.line, r.replacement)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSupportBundle(c *gc.C) {
+	dir := filepath.Join(t.cwd, "var")
+	c.Assert(os.MkdirAll(filepath.Join(dir, "log"), 0755), gc.IsNil)
+	log := strings.Repeat("old line\n", 100) + "token=abc123 connected\nlast line\n"
+	files := map[string]string{
+		"log/machine-0.log": log,
+		"log/unit-0.log":    "short\n",
+		"agent.conf":        "apipassword: hunter2\ntag: machine-0",
+		"notes.txt":         "not collected",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(contents), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+
+	b := &SupportBundle{
+		Files:       []string{filepath.Join(dir, "log"), filepath.Join(dir, "*.conf")},
+		Strip:       dir + string(os.PathSeparator),
+		MaxFileSize: 40,
+		Redact: []*regexp.Regexp{
+			regexp.MustCompile(`token=\S+`),
+			regexp.MustCompile(`(?m)password: .*$`),
+		},
+	}
+	var buf bytes.Buffer
+	shaSum, err := b.Write(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(shaSum, gc.Not(gc.Equals), "")
+
+	bundleFile := filepath.Join(t.cwd, "bundle.tar.gz")
+	c.Assert(ioutil.WriteFile(bundleFile, buf.Bytes(), 0644), gc.IsNil)
+	m, err := ReadManifest(bundleFile)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Entries, gc.HasLen, 4)
+
+	extracted, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(extracted, gc.HasLen, 3)
+	c.Assert(string(extracted["log/machine-0.log"]), gc.Equals, "[REDACTED] connected\nlast line\n")
+	c.Assert(string(extracted["log/unit-0.log"]), gc.Equals, "short\n")
+	c.Assert(string(extracted["agent.conf"]), gc.Equals, "api[REDACTED]\ntag: machine-0")
+}
+
+func (t *TarSuite) TestSupportBundleCutSecret(c *gc.C) {
+	dir := c.MkDir()
+	long := "key=" + strings.Repeat("x", maxRedactLineLength) + "\n"
+	for name, contents := range map[string]string{
+		"cut.log":  "old line\ntoken=abc123 connected\nlast line\n",
+		"long.log": long + "token=abc123\n",
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644), gc.IsNil)
+	}
+	b := &SupportBundle{
+		Files:  []string{dir},
+		Strip:  dir + string(os.PathSeparator),
+		Redact: []*regexp.Regexp{regexp.MustCompile(`token=\S+`)},
+		// The cut falls inside the token of cut.log.
+		MaxFileSize: int64(len("en=abc123 connected\nlast line\n")),
+	}
+	var buf bytes.Buffer
+	_, err := b.Write(&buf)
+	c.Assert(err, gc.IsNil)
+	extracted, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(extracted["cut.log"]), gc.Equals, "last line\n")
+
+	b.MaxFileSize = 0
+	buf.Reset()
+	_, err = b.Write(&buf)
+	c.Assert(err, gc.IsNil)
+	extracted, err = ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(extracted["long.log"]), gc.Equals, "[REDACTED]\n[REDACTED]\n")
+
+	b.Options = []Option{WithContentFilter(func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		return r, nil
+	})}
+	_, err = b.Write(&buf)
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithContentFilter cannot be used with a SupportBundle")
+}
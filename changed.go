@@ -0,0 +1,103 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io"
+)
+
+// FileChangedError describes a file whose size changed while it
+// was being archived. The entry holds the size recorded in its
+// header: the contents of files that shrank are padded with zeros
+// and those of files that grew are truncated, so the archive stays
+// readable, but the entry does not hold a consistent copy.
+type FileChangedError struct {
+	// Path is the path of the file.
+	Path string
+	// Name is the name of the entry in the archive.
+	Name string
+	// Size is the size recorded in the header of the entry.
+	Size int64
+	// Read is the number of bytes read before the file ended,
+	// or -1 if the file held more than Size bytes.
+	Read int64
+}
+
+func (e *FileChangedError) Error() string {
+	if e.Read < 0 {
+		return fmt.Sprintf("file %q changed while being archived: grew beyond %d bytes", e.Path, e.Size)
+	}
+	return fmt.Sprintf("file %q changed while being archived: shrank from %d to %d bytes", e.Path, e.Size, e.Read)
+}
+
+// sizedReader reads exactly size bytes from r, padding the contents
+// with zeros if r ends early, and records whether r held a
+// different number of bytes.
+type sizedReader struct {
+	r    io.Reader
+	size int64
+
+	// n is the number of bytes returned so far,
+	// and read the number of them read from r.
+	n, read int64
+	// ended records that r was read to its end.
+	ended bool
+	grew  bool
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.n >= s.size {
+		if !s.ended {
+			// Look for contents beyond the expected size.
+			var b [1]byte
+			n, _ := io.ReadFull(s.r, b[:])
+			s.grew = n > 0
+			s.ended = true
+		}
+		return 0, io.EOF
+	}
+	if remaining := s.size - s.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if s.ended {
+		// The file ended early: pad it.
+		for i := range p {
+			p[i] = 0
+		}
+		s.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	s.read += int64(n)
+	if err == io.EOF {
+		s.ended = true
+		return n, nil
+	}
+	return n, err
+}
+
+// changed returns a *FileChangedError if the contents
+// read did not match the expected size.
+func (s *sizedReader) changed(path, name string) *FileChangedError {
+	switch {
+	case s.grew:
+		return &FileChangedError{Path: path, Name: name, Size: s.size, Read: -1}
+	case s.read != s.size:
+		return &FileChangedError{Path: path, Name: name, Size: s.size, Read: s.read}
+	}
+	return nil
+}
+
+// fileChanged reports that the file described by
+// e changed while it was being archived.
+func (a *archiver) fileChanged(e *FileChangedError) {
+	a.opts.warn(Warning{
+		Kind:    WarningFileChanged,
+		Path:    e.Path,
+		Message: e.Error(),
+	})
+	a.opts.createReport.changed(e)
+}
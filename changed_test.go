@@ -0,0 +1,73 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSizedReader(c *gc.C) {
+	for i, test := range []struct {
+		contents string
+		size     int64
+		read     string
+		changed  *FileChangedError
+	}{{
+		contents: "hello",
+		size:     5,
+		read:     "hello",
+	}, {
+		contents: "hel",
+		size:     5,
+		read:     "hel\x00\x00",
+		changed:  &FileChangedError{Path: "path", Name: "name", Size: 5, Read: 3},
+	}, {
+		contents: "hello world",
+		size:     5,
+		read:     "hello",
+		changed:  &FileChangedError{Path: "path", Name: "name", Size: 5, Read: -1},
+	}, {
+		contents: "",
+		size:     0,
+		read:     "",
+	}} {
+		c.Logf("test %d: %q", i, test.contents)
+		sized := &sizedReader{r: strings.NewReader(test.contents), size: test.size}
+		data, err := ioutil.ReadAll(sized)
+		c.Assert(err, gc.IsNil)
+		c.Check(string(data), gc.Equals, test.read)
+		c.Check(sized.changed("path", "name"), gc.DeepEquals, test.changed)
+	}
+}
+
+func (t *TarSuite) TestSizedReaderKeepsArchiveReadable(c *gc.C) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range map[string]string{"shrunk": "shru", "grown": "grown!"} {
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 5}
+		c.Assert(tw.WriteHeader(hdr), gc.IsNil)
+		_, err := io.Copy(tw, &sizedReader{r: strings.NewReader(contents), size: 5})
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	files, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{
+		"shrunk": []byte("shru\x00"),
+		"grown":  []byte("grown"),
+	})
+}
+
+func (t *TarSuite) TestFileChangedError(c *gc.C) {
+	err := &FileChangedError{Path: "/var/log/syslog", Size: 10, Read: 4}
+	c.Assert(err, gc.ErrorMatches, `file "/var/log/syslog" changed while being archived: shrank from 10 to 4 bytes`)
+	err.Read = -1
+	c.Assert(err, gc.ErrorMatches, `file "/var/log/syslog" changed while being archived: grew beyond 10 bytes`)
+}
@@ -0,0 +1,230 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// The jtar command creates, extracts, lists, verifies and compares
+// archives using the github.com/juju/tar package, so they can be
+// handled with the same checksums and checks as the Go code that
+// produces them.
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jtar "github.com/juju/tar"
+)
+
+const usage = `usage: jtar <command> [flags] [args]
+
+commands:
+  create  -f archive [-z] [-C dir] [-manifest] files...
+  extract -f archive [-C dir] [-verify]
+  list    -f archive [-v]
+  verify  -f archive
+  diff    old-archive new-archive|dir
+`
+
+// commands maps the name of every subcommand to its implementation.
+var commands = map[string]func(args []string) error{
+	"create":  create,
+	"extract": extract,
+	"list":    list,
+	"verify":  verify,
+	"diff":    diff,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "jtar: unknown command %q\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "jtar %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns a flag set for the named subcommand,
+// with the -f flag common to most of them.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("jtar "+name, flag.ExitOnError)
+	archive := fs.String("f", "", "archive `file`")
+	return fs, archive
+}
+
+func create(args []string) error {
+	fs, archive := newFlagSet("create")
+	compress := fs.Bool("z", false, "gzip compress the archive")
+	dir := fs.String("C", ".", "archive the files relative to `dir`")
+	manifest := fs.Bool("manifest", false, "embed a manifest of the archived files")
+	fs.Parse(args)
+	if *archive == "" || fs.NArg() == 0 {
+		return fmt.Errorf("an archive and the files to archive are needed")
+	}
+	root, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	files := make([]string, fs.NArg())
+	for i, name := range fs.Args() {
+		files[i] = filepath.Join(root, name)
+	}
+	var opts []jtar.Option
+	if *manifest {
+		opts = append(opts, jtar.WithManifest())
+	}
+	shaSum, err := jtar.TarFiles(files, *archive, root+string(os.PathSeparator), *compress, opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Println(shaSum)
+	return nil
+}
+
+func extract(args []string) error {
+	fs, archive := newFlagSet("extract")
+	dir := fs.String("C", ".", "extract into `dir`")
+	verifyContents := fs.Bool("verify", false, "verify the extracted files against the embedded manifest")
+	fs.Parse(args)
+	if *archive == "" {
+		return fmt.Errorf("an archive is needed")
+	}
+	compressed, err := isCompressed(*archive)
+	if err != nil {
+		return err
+	}
+	var opts []jtar.Option
+	if *verifyContents {
+		opts = append(opts, jtar.WithVerifyContents())
+	}
+	return jtar.UntarFiles(*archive, *dir, compressed, opts...)
+}
+
+func list(args []string) error {
+	fs, archive := newFlagSet("list")
+	verbose := fs.Bool("v", false, "show the mode, size and modification time of entries")
+	fs.Parse(args)
+	if *archive == "" {
+		return fmt.Errorf("an archive is needed")
+	}
+	f, err := os.Open(*archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return jtar.WalkArchive(f, func(hdr *tar.Header, r io.Reader) error {
+		if !*verbose {
+			fmt.Println(hdr.Name)
+			return nil
+		}
+		name := hdr.Name
+		if hdr.Typeflag == tar.TypeSymlink {
+			name += " -> " + hdr.Linkname
+		}
+		fmt.Printf("%v %10d %s %s\n", hdr.FileInfo().Mode(), hdr.Size, hdr.ModTime.Format("2006-01-02 15:04"), name)
+		return nil
+	})
+}
+
+func verify(args []string) error {
+	fs, archive := newFlagSet("verify")
+	fs.Parse(args)
+	if *archive == "" {
+		return fmt.Errorf("an archive is needed")
+	}
+	result, err := jtar.Verify(*archive)
+	if err != nil {
+		return err
+	}
+	for _, f := range result.Findings {
+		fmt.Printf("%s: %s: %s\n", f.Kind, f.Path, f.Message)
+	}
+	switch err := jtar.VerifyManifest(*archive); err {
+	case nil:
+		fmt.Println("contents match the manifest")
+	case jtar.ErrNoManifest:
+	default:
+		return err
+	}
+	if len(result.Findings) > 0 {
+		return fmt.Errorf("%d suspicious entries found", len(result.Findings))
+	}
+	return nil
+}
+
+func diff(args []string) error {
+	fs := flag.NewFlagSet("jtar diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("an archive and an archive or directory to compare it with are needed")
+	}
+	result, err := compare(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	for _, name := range result.Added {
+		fmt.Println("+", name)
+	}
+	for _, name := range result.Removed {
+		fmt.Println("-", name)
+	}
+	for _, m := range result.Modified {
+		reasons := make([]string, len(m.Reasons))
+		for i, r := range m.Reasons {
+			reasons[i] = string(r)
+		}
+		fmt.Printf("M %s (%s)\n", m.Path, strings.Join(reasons, ", "))
+	}
+	if !result.Empty() {
+		return fmt.Errorf("differences found")
+	}
+	return nil
+}
+
+// compare compares the archive old with the archive
+// or directory new.
+func compare(old, new string) (*jtar.Diff, error) {
+	info, err := os.Stat(new)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return jtar.CompareArchiveToDir(old, new)
+	}
+	a, err := os.Open(old)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	b, err := os.Open(new)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	return jtar.DiffArchives(a, b)
+}
+
+// isCompressed reports whether the archive at
+// path starts with the gzip magic number.
+func isCompressed(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
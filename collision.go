@@ -0,0 +1,195 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CollisionPolicy decides what UntarFiles does with an entry whose
+// name differs from that of an earlier entry but would refer to the
+// same file on some filesystems.
+type CollisionPolicy int
+
+const (
+	// CollisionIgnore does not look for collisions, so colliding
+	// entries overwrite each other where the filesystem treats
+	// them as the same. This is the default.
+	CollisionIgnore CollisionPolicy = iota
+	// CollisionError fails the extraction with a
+	// *NameCollisionError on the first collision.
+	CollisionError
+	// CollisionRename extracts the colliding entry, and those
+	// below it, under a name with a "~N" suffix that does not
+	// collide.
+	CollisionRename
+	// CollisionSkip does not extract colliding
+	// entries, nor those below them.
+	CollisionSkip
+)
+
+// WithCaseCollisions returns an Option that makes UntarFiles detect
+// entries whose names only differ in case, such as "Foo" and "foo",
+// which collide on case-insensitive filesystems like those of macOS
+// and Windows, and handle them as described by p. Collisions are
+// listed in Report.Collisions WithReport.
+func WithCaseCollisions(p CollisionPolicy) Option {
+	return func(o *options) {
+		o.caseCollisions = p
+	}
+}
+
+// NameCollisionError is returned by UntarFiles when an entry collides
+// with an earlier one and the policy is CollisionError.
+type NameCollisionError struct {
+	// Name is the name of the colliding entry.
+	Name string
+	// Existing is the name it collides with.
+	Existing string
+	// Kind describes the collision, such as "case".
+	Kind string
+}
+
+func (e *NameCollisionError) Error() string {
+	return fmt.Sprintf("entry %q collides with %q (%s)", e.Name, e.Existing, e.Kind)
+}
+
+// Collision describes an entry that collided with an earlier one.
+type Collision struct {
+	// Name is the name of the colliding entry.
+	Name string
+	// Existing is the name it collides with.
+	Existing string
+	// Kind describes the collision, such as "case".
+	Kind string
+	// ExtractedAs is the name the entry was extracted
+	// under, or "" if it was skipped.
+	ExtractedAs string
+}
+
+// validate appends to problems any problem with the policy,
+// which is given to the named option.
+func (p CollisionPolicy) validate(option string, problems []string) []string {
+	if p < CollisionIgnore || p > CollisionSkip {
+		problems = append(problems, fmt.Sprintf("%s: unknown collision policy %d", option, p))
+	}
+	return problems
+}
+
+// collisionDetector detects names that collide when folded.
+type collisionDetector struct {
+	kind   string
+	policy CollisionPolicy
+	fold   func(string) string
+
+	// names maps the folded form of every path seen
+	// so far to the path itself.
+	names map[string]string
+	// renames maps colliding paths to the names
+	// they are extracted under.
+	renames map[string]string
+}
+
+// newCaseCollisions returns a detector of case collisions
+// handling them as described by policy.
+func newCaseCollisions(policy CollisionPolicy) *collisionDetector {
+	caser := cases.Fold()
+	return newCollisionDetector("case", policy, caser.String)
+}
+
+// newNormalizationCollisions returns a detector of Unicode
+// normalization collisions handling them as described by policy.
+func newNormalizationCollisions(policy CollisionPolicy) *collisionDetector {
+	return newCollisionDetector("normalization", policy, norm.NFC.String)
+}
+
+func newCollisionDetector(kind string, policy CollisionPolicy, fold func(string) string) *collisionDetector {
+	return &collisionDetector{
+		kind:    kind,
+		policy:  policy,
+		fold:    fold,
+		names:   make(map[string]string),
+		renames: make(map[string]string),
+	}
+}
+
+// resolve returns the name under which the entry described by hdr,
+// extracted under the given output name, must be extracted, and
+// false if it must be skipped. Every component of the name is
+// checked, so the entries below a renamed directory follow it.
+func (d *collisionDetector) resolve(name string, hdr *tar.Header, report *Report) (string, bool, error) {
+	resolved := ""
+	for _, part := range strings.Split(cleanManifestPath(name), "/") {
+		p := path.Join(resolved, part)
+		if renamed, ok := d.renames[p]; ok {
+			resolved = renamed
+			continue
+		}
+		existing, ok := d.names[d.fold(p)]
+		if !ok {
+			d.names[d.fold(p)] = p
+			resolved = p
+			continue
+		}
+		if existing == p {
+			resolved = p
+			continue
+		}
+		c := Collision{
+			Name:     hdr.Name,
+			Existing: existing,
+			Kind:     d.kind,
+		}
+		switch d.policy {
+		case CollisionError:
+			return "", false, &NameCollisionError{Name: hdr.Name, Existing: existing, Kind: d.kind}
+		case CollisionSkip:
+			report.collided(c)
+			return "", false, nil
+		}
+		renamed := d.rename(p)
+		d.renames[p] = renamed
+		d.names[d.fold(renamed)] = renamed
+		resolved = renamed
+		c.ExtractedAs = renamed
+		report.collided(c)
+	}
+	return resolved, true, nil
+}
+
+// rename returns a name for p, with a "~N" suffix
+// before its extension, that has not been seen.
+func (d *collisionDetector) rename(p string) string {
+	ext := path.Ext(p)
+	if ext == p || ext == path.Base(p) {
+		ext = ""
+	}
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s~%d%s", base, i, ext)
+		if _, ok := d.names[d.fold(candidate)]; !ok {
+			return candidate
+		}
+	}
+}
+
+// resolveCollisions applies every collision detector in turn to
+// the output name of the entry described by hdr, returning the name
+// it must be extracted under, and false if it must be skipped.
+func (x *extractor) resolveCollisions(name string, hdr *tar.Header) (string, bool, error) {
+	for _, d := range x.collisions {
+		var ok bool
+		var err error
+		if name, ok, err = d.resolve(name, hdr, x.opts.report); !ok || err != nil {
+			return "", false, err
+		}
+	}
+	return name, true, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestCaseCollisions(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "collisions.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"Foo/", ""},
+		{"Foo/a.txt", "upper"},
+		{"foo/", ""},
+		{"foo/a.txt", "lower"},
+		{"foo/B.txt", "b"},
+		{"README", "readme"},
+		{"readme", "other readme"},
+	})
+	for _, test := range []struct {
+		policy     CollisionPolicy
+		files      map[string]string
+		collisions []Collision
+		err        string
+	}{{
+		policy: CollisionRename,
+		files: map[string]string{
+			"Foo/a.txt":   "upper",
+			"foo~1/a.txt": "lower",
+			"foo~1/B.txt": "b",
+			"README":      "readme",
+			"readme~1":    "other readme",
+		},
+		collisions: []Collision{
+			{Name: "foo/", Existing: "Foo", Kind: "case", ExtractedAs: "foo~1"},
+			{Name: "readme", Existing: "README", Kind: "case", ExtractedAs: "readme~1"},
+		},
+	}, {
+		policy: CollisionSkip,
+		files: map[string]string{
+			"Foo/a.txt": "upper",
+			"README":    "readme",
+		},
+		collisions: []Collision{
+			{Name: "foo/", Existing: "Foo", Kind: "case"},
+			{Name: "foo/a.txt", Existing: "Foo", Kind: "case"},
+			{Name: "foo/B.txt", Existing: "Foo", Kind: "case"},
+			{Name: "readme", Existing: "README", Kind: "case"},
+		},
+	}, {
+		policy: CollisionError,
+		files: map[string]string{
+			"Foo/a.txt": "upper",
+		},
+		err: `entry "foo/" collides with "Foo" \(case\)`,
+	}} {
+		outputDir := c.MkDir()
+		var report Report
+		err := UntarFiles(tarFile, outputDir, false, WithCaseCollisions(test.policy), WithReport(&report))
+		if test.err != "" {
+			c.Assert(err, gc.ErrorMatches, test.err)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+		c.Assert(report.Collisions, gc.DeepEquals, test.collisions)
+		files := 0
+		for _, e := range report.Entries {
+			if e.Type == "file" {
+				files++
+			}
+		}
+		c.Assert(files, gc.Equals, len(test.files))
+		for name, contents := range test.files {
+			data, err := ioutil.ReadFile(filepath.Join(outputDir, filepath.FromSlash(name)))
+			c.Assert(err, gc.IsNil)
+			c.Assert(string(data), gc.Equals, contents)
+		}
+	}
+}
+
+func (t *TarSuite) TestValidateCaseCollisions(c *gc.C) {
+	o := newOptions([]Option{WithCaseCollisions(CollisionPolicy(9))})
+	c.Assert(o.validate(opExtract), gc.ErrorMatches, `invalid configuration: WithCaseCollisions: unknown collision policy 9`)
+}
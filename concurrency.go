@@ -0,0 +1,542 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Concurrency sets how many goroutines each stage of archiving or
+// extraction may use. The best values depend heavily on the storage
+// involved: local SSDs gain little from reading ahead, while network
+// backed volumes may need many requests in flight. The zero value
+// runs every stage in the calling goroutine.
+type Concurrency struct {
+	// Walk is the number of directories listed concurrently
+	// ahead of the archiver. It only applies to archive creation.
+	Walk int
+
+	// Read is, when archiving, the number of small files read
+	// concurrently ahead of the archiver and, when extracting,
+	// the number of chunks of the archive read ahead.
+	Read int
+
+	// Compress is, when archiving, the number of goroutines
+	// compressing blocks of the archive and, when extracting, the
+	// number of chunks decompressed ahead. It is ignored for
+	// uncompressed archives. Archives compressed in parallel are
+	// made of a sequence of gzip members, so they differ from
+	// those compressed serially, but not between worker counts.
+	Compress int
+
+	// Write is, when archiving, the number of chunks of output
+	// that may be queued for the goroutine writing the archive
+	// and, when extracting, the number of files written
+	// concurrently.
+	Write int
+}
+
+// WithConcurrency returns an Option that sets the number of
+// goroutines used by each stage of archiving or extraction.
+func WithConcurrency(c Concurrency) Option {
+	return func(o *options) {
+		o.concurrency = c
+	}
+}
+
+// validate appends to problems the reasons why c
+// cannot be used for op.
+func (c Concurrency) validate(op operation, problems []string) []string {
+	if c.Walk < 0 || c.Read < 0 || c.Compress < 0 || c.Write < 0 {
+		problems = append(problems, "WithConcurrency needs non-negative worker counts")
+	}
+	if c.Walk != 0 && op != opCreate {
+		problems = append(problems, "Concurrency.Walk only applies to "+opCreate.String())
+	}
+	return problems
+}
+
+// readAheadLimit is the size of the largest
+// file whose contents are read ahead.
+const readAheadLimit = 1 << 20
+
+// lookahead lists directories and reads small files concurrently,
+// ahead of the archiver walking them in order.
+type lookahead struct {
+	walk        chan struct{}
+	read        chan struct{}
+	window      int
+	dereference bool
+	// skipped and unselectedSize tell the files left
+	// out of the archive, which are not looked at.
+	skipped        func(string) bool
+	unselectedSize func(os.FileInfo) string
+
+	mu      sync.Mutex
+	pending map[string]*prefetch
+}
+
+// prefetch holds what was found ahead about a single path.
+type prefetch struct {
+	done chan struct{}
+	// names holds the sorted entries of a directory.
+	names []string
+	// data holds the contents of a small regular file.
+	data []byte
+}
+
+// newLookahead returns a lookahead for the files archived as
+// described by o, skipping the files for which skipped returns
+// true, or nil if neither walking nor reading is concurrent or
+// if the files are read from a ReadFS.
+func newLookahead(o *options, skipped func(string) bool) *lookahead {
+	c := o.concurrency
+	if c.Walk == 0 && c.Read == 0 || o.readFS != nil {
+		return nil
+	}
+	l := &lookahead{
+		window:         c.Walk,
+		dereference:    o.dereference,
+		skipped:        skipped,
+		unselectedSize: o.unselectedSize,
+		pending:        make(map[string]*prefetch),
+	}
+	if c.Walk > 0 {
+		l.walk = make(chan struct{}, c.Walk)
+	}
+	if c.Read > 0 {
+		l.read = make(chan struct{}, c.Read)
+		if c.Read > l.window {
+			l.window = c.Read
+		}
+	}
+	return l
+}
+
+// scheduleFrom starts looking ahead at the paths
+// following paths[i], up to the window size.
+func (l *lookahead) scheduleFrom(paths []string, i int) {
+	if l == nil {
+		return
+	}
+	end := i + 1 + l.window
+	if end > len(paths) {
+		end = len(paths)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range paths[i+1 : end] {
+		if _, ok := l.pending[p]; ok || l.skipped(p) {
+			continue
+		}
+		pf := &prefetch{done: make(chan struct{})}
+		l.pending[p] = pf
+		go l.fetch(p, pf)
+	}
+}
+
+// fetch lists the directory or reads the file at fileName.
+func (l *lookahead) fetch(fileName string, pf *prefetch) {
+	defer close(pf.done)
+	stat := os.Lstat
+	if l.dereference {
+		stat = os.Stat
+	}
+	fInfo, err := stat(fileName)
+	if err != nil {
+		return
+	}
+	switch {
+	case fInfo.IsDir() && l.walk != nil:
+		l.walk <- struct{}{}
+		defer func() { <-l.walk }()
+		f, err := os.Open(fileName)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			return
+		}
+		sort.Strings(names)
+		pf.names = names
+	case fInfo.Mode().IsRegular() && fInfo.Size() <= readAheadLimit && l.read != nil && l.unselectedSize(fInfo) == "":
+		l.read <- struct{}{}
+		defer func() { <-l.read }()
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return
+		}
+		pf.data = data
+	}
+}
+
+// take waits for and returns what was found ahead about
+// fileName, or nil if it was not looked at.
+func (l *lookahead) take(fileName string) *prefetch {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	pf := l.pending[fileName]
+	delete(l.pending, fileName)
+	l.mu.Unlock()
+	if pf != nil {
+		<-pf.done
+	}
+	return pf
+}
+
+// drop forgets about the given paths, which
+// will not be walked any further.
+func (l *lookahead) drop(paths []string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range paths {
+		delete(l.pending, p)
+	}
+}
+
+// parallelGzipBlock is the amount of input compressed
+// into each gzip member when compressing in parallel.
+const parallelGzipBlock = 1 << 20
+
+// parallelGzip compresses what is written to it into w as a
+// sequence of gzip members, one per block, compressing several
+// blocks concurrently.
+type parallelGzip struct {
+	w    io.Writer
+	opts *options
+	buf  []byte
+	sem  chan struct{}
+	// queue holds the blocks in order, waiting to be written.
+	queue chan *gzipBlock
+	done  chan struct{}
+	empty bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// gzipBlock holds a block being compressed.
+type gzipBlock struct {
+	out   bytes.Buffer
+	err   error
+	ready chan struct{}
+}
+
+func newParallelGzip(w io.Writer, workers int, o *options) *parallelGzip {
+	g := &parallelGzip{
+		w:     w,
+		opts:  o,
+		sem:   make(chan struct{}, workers),
+		queue: make(chan *gzipBlock, workers),
+		done:  make(chan struct{}),
+		empty: true,
+	}
+	go g.writeBlocks()
+	return g
+}
+
+// writeBlocks writes the compressed blocks in order.
+func (g *parallelGzip) writeBlocks() {
+	defer close(g.done)
+	for b := range g.queue {
+		<-b.ready
+		if g.failed() != nil {
+			continue
+		}
+		err := b.err
+		if err == nil {
+			_, err = g.w.Write(b.out.Bytes())
+		}
+		if err != nil {
+			g.mu.Lock()
+			g.err = err
+			g.mu.Unlock()
+		}
+	}
+}
+
+func (g *parallelGzip) failed() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+func (g *parallelGzip) Write(p []byte) (int, error) {
+	if err := g.failed(); err != nil {
+		return 0, err
+	}
+	total := len(p)
+	for len(p) > 0 {
+		n := parallelGzipBlock - len(g.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		g.buf = append(g.buf, p[:n]...)
+		p = p[n:]
+		if len(g.buf) == parallelGzipBlock {
+			g.flush()
+		}
+	}
+	return total, nil
+}
+
+// flush starts compressing the buffered block.
+func (g *parallelGzip) flush() {
+	data := g.buf
+	g.buf = nil
+	g.empty = false
+	b := &gzipBlock{ready: make(chan struct{})}
+	g.sem <- struct{}{}
+	g.queue <- b
+	go func() {
+		defer func() {
+			<-g.sem
+			close(b.ready)
+		}()
+		gzw, err := newGzipWriter(&b.out, g.opts)
+		if err == nil {
+			_, err = gzw.Write(data)
+		}
+		if err == nil {
+			err = gzw.Close()
+		}
+		b.err = err
+	}()
+}
+
+// Close compresses the remaining input and waits
+// for every block to be written.
+func (g *parallelGzip) Close() error {
+	if len(g.buf) > 0 || g.empty {
+		g.flush()
+	}
+	close(g.queue)
+	<-g.done
+	return g.failed()
+}
+
+// asyncChunk is the size of the chunks queued
+// for writing by asyncWriter.
+const asyncChunk = 64 << 10
+
+// asyncWriter writes to w from its own goroutine,
+// letting a number of chunks queue up.
+type asyncWriter struct {
+	buf   []byte
+	queue chan []byte
+	done  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newAsyncWriter(w io.Writer, chunks int) *asyncWriter {
+	a := &asyncWriter{
+		queue: make(chan []byte, chunks),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(a.done)
+		for chunk := range a.queue {
+			if a.failed() != nil {
+				continue
+			}
+			if _, err := w.Write(chunk); err != nil {
+				a.mu.Lock()
+				a.err = err
+				a.mu.Unlock()
+			}
+		}
+	}()
+	return a
+}
+
+func (a *asyncWriter) failed() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	if err := a.failed(); err != nil {
+		return 0, err
+	}
+	a.buf = append(a.buf, p...)
+	if len(a.buf) >= asyncChunk {
+		a.queue <- a.buf
+		a.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close writes the remaining output and waits for
+// every chunk to be written.
+func (a *asyncWriter) Close() error {
+	if len(a.buf) > 0 {
+		a.queue <- a.buf
+		a.buf = nil
+	}
+	close(a.queue)
+	<-a.done
+	return a.failed()
+}
+
+// readAheadChunk is the size of the chunks read ahead by aheadReader.
+const readAheadChunk = 64 << 10
+
+// aheadReader reads from another reader in its own
+// goroutine, keeping a number of chunks ready.
+type aheadReader struct {
+	chunks chan []byte
+	stop   chan struct{}
+	once   sync.Once
+	// err is set before chunks is closed.
+	err error
+	cur []byte
+}
+
+func newAheadReader(r io.Reader, chunks int) *aheadReader {
+	a := &aheadReader{
+		chunks: make(chan []byte, chunks),
+		stop:   make(chan struct{}),
+	}
+	go a.fill(r)
+	return a
+}
+
+// fill reads r until it fails or the reader is closed.
+func (a *aheadReader) fill(r io.Reader) {
+	defer close(a.chunks)
+	for {
+		buf := make([]byte, readAheadChunk)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			select {
+			case a.chunks <- buf[:n]:
+			case <-a.stop:
+				a.err = io.ErrClosedPipe
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			a.err = io.EOF
+			return
+		}
+		if err != nil {
+			a.err = err
+			return
+		}
+	}
+}
+
+func (a *aheadReader) Read(p []byte) (int, error) {
+	for len(a.cur) == 0 {
+		chunk, ok := <-a.chunks
+		if !ok {
+			return 0, a.err
+		}
+		a.cur = chunk
+	}
+	n := copy(p, a.cur)
+	a.cur = a.cur[n:]
+	return n, nil
+}
+
+// Close stops reading ahead.
+func (a *aheadReader) Close() error {
+	a.once.Do(func() { close(a.stop) })
+	return nil
+}
+
+// fileWriters writes extracted files concurrently.
+type fileWriters struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+	// busy holds the files being written, so that an entry
+	// is not written over by an earlier one for the same path.
+	busy map[string]chan struct{}
+}
+
+func newFileWriters(workers int) *fileWriters {
+	return &fileWriters{
+		sem:  make(chan struct{}, workers),
+		busy: make(map[string]chan struct{}),
+	}
+}
+
+// write starts calling write, which creates the file at fullPath.
+func (p *fileWriters) write(fullPath string, write func() error) {
+	done := make(chan struct{})
+	p.mu.Lock()
+	prev := p.busy[fullPath]
+	p.busy[fullPath] = done
+	p.mu.Unlock()
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if prev != nil {
+			<-prev
+		}
+		err := write()
+		p.mu.Lock()
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		if p.busy[fullPath] == done {
+			delete(p.busy, fullPath)
+		}
+		p.mu.Unlock()
+		close(done)
+		<-p.sem
+	}()
+}
+
+// waitFor waits for the pending writes of the file at fullPath.
+func (p *fileWriters) waitFor(fullPath string) {
+	p.mu.Lock()
+	pending := p.busy[fullPath]
+	p.mu.Unlock()
+	if pending != nil {
+		<-pending
+	}
+}
+
+// waitForParents waits for the pending writes of the
+// files at any of the parent directories of fullPath.
+func (p *fileWriters) waitForParents(fullPath string) {
+	for dir := filepath.Dir(fullPath); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		p.waitFor(dir)
+	}
+}
+
+// failed returns the first error found writing a file, if any.
+func (p *fileWriters) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// wait waits for every file to be written and
+// returns the first error found, if any.
+func (p *fileWriters) wait() error {
+	p.wg.Wait()
+	return p.failed()
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+var testConcurrency = Concurrency{Walk: 2, Read: 3, Compress: 2, Write: 2}
+
+func (t *TarSuite) TestConcurrencyRoundTrip(c *gc.C) {
+	t.createTestFiles(c)
+	for _, compress := range []bool{false, true} {
+		outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+		serialSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", compress)
+		c.Assert(err, gc.IsNil)
+		serial, err := ioutil.ReadFile(outputTar)
+		c.Assert(err, gc.IsNil)
+
+		shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", compress, WithConcurrency(testConcurrency))
+		c.Assert(err, gc.IsNil)
+		t.assertTarContents(c, testExpectedTarContents, outputTar, compress)
+		if !compress {
+			c.Assert(shaSum, gc.Equals, serialSum)
+			parallel, err := ioutil.ReadFile(outputTar)
+			c.Assert(err, gc.IsNil)
+			c.Assert(parallel, gc.DeepEquals, serial)
+		}
+
+		outputDir := filepath.Join(t.cwd, "extracted")
+		c.Assert(os.Mkdir(outputDir, 0755), gc.IsNil)
+		extract := testConcurrency
+		extract.Walk = 0
+		err = UntarFiles(outputTar, outputDir, compress, WithConcurrency(extract))
+		c.Assert(err, gc.IsNil)
+		t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+		c.Assert(os.RemoveAll(outputDir), gc.IsNil)
+	}
+}
+
+func (t *TarSuite) TestParallelGzipIndependentOfWorkers(c *gc.C) {
+	data := bytes.Repeat([]byte("some data to compress "), parallelGzipBlock/8)
+	var outputs [][]byte
+	for _, workers := range []int{1, 4} {
+		var buf bytes.Buffer
+		g := newParallelGzip(&buf, workers, newOptions([]Option{WithDeterministicGzip()}))
+		_, err := g.Write(data)
+		c.Assert(err, gc.IsNil)
+		c.Assert(g.Close(), gc.IsNil)
+		outputs = append(outputs, buf.Bytes())
+	}
+	c.Assert(outputs[0], gc.DeepEquals, outputs[1])
+}
+
+func (t *TarSuite) TestConcurrencyValidate(c *gc.C) {
+	err := UntarFiles("missing.tar", t.cwd, true, WithConcurrency(Concurrency{Walk: 1, Read: -1}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithConcurrency needs non-negative worker counts; "+
+		"Concurrency.Walk only applies to archive creation")
+	var buf bytes.Buffer
+	_, err = TarFilesToWriter(nil, &buf, "", true,
+		WithConcurrency(Concurrency{Compress: 2}), WithBookmarks(1, func(Bookmark) {}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: bookmarks cannot be used with Concurrency.Compress")
+}
+
+func (t *TarSuite) TestLookaheadSkipsUnselected(c *gc.C) {
+	dir := c.MkDir()
+	small := filepath.Join(dir, "small")
+	c.Assert(ioutil.WriteFile(small, []byte("small"), 0644), gc.IsNil)
+	big := filepath.Join(dir, "big")
+	c.Assert(ioutil.WriteFile(big, bytes.Repeat([]byte("x"), 100), 0644), gc.IsNil)
+	ignored := filepath.Join(dir, "ignored")
+	c.Assert(ioutil.WriteFile(ignored, []byte("ignored"), 0644), gc.IsNil)
+
+	o := newOptions([]Option{WithConcurrency(Concurrency{Read: 3}), WithMaxSize(10)})
+	l := newLookahead(o, func(p string) bool { return p == ignored })
+	l.scheduleFrom([]string{dir, small, big, ignored}, 0)
+	pf := l.take(small)
+	c.Assert(pf, gc.NotNil)
+	c.Assert(string(pf.data), gc.Equals, "small")
+	pf = l.take(big)
+	c.Assert(pf, gc.NotNil)
+	c.Assert(pf.data, gc.IsNil)
+	c.Assert(l.take(ignored), gc.IsNil)
+}
+
+func (t *TarSuite) TestConcurrentWritesBeforeLinks(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "replace.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "target"},
+	})
+	// The file is always written before the symlink is
+	// created in its place, which fails as it does serially.
+	for i := 0; i < 20; i++ {
+		outputDir := c.MkDir()
+		err := UntarFiles(tarFile, outputDir, false, WithConcurrency(Concurrency{Write: 4}))
+		c.Assert(err, gc.ErrorMatches, `cannot extract symlink ".*": .*: file exists`)
+		info, err := os.Lstat(filepath.Join(outputDir, "a"))
+		c.Assert(err, gc.IsNil)
+		c.Assert(info.Mode().IsRegular(), gc.Equals, true)
+	}
+}
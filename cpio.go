@@ -0,0 +1,157 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// cpio file type bits, as found in st_mode.
+const (
+	cpioTypeFifo    = 0010000
+	cpioTypeChar    = 0020000
+	cpioTypeDir     = 0040000
+	cpioTypeBlock   = 0060000
+	cpioTypeReg     = 0100000
+	cpioTypeSymlink = 0120000
+)
+
+// cpioTrailer is the name of the entry ending a cpio archive.
+const cpioTrailer = "TRAILER!!!"
+
+// errCpioWriteTooLong is returned when more data is
+// written to a cpio entry than its header declared.
+var errCpioWriteTooLong = errors.New("cpio: write too long")
+
+// cpioWriter is an entryWriter writing a cpio archive in the
+// "newc" format, where headers are made of hexadecimal fields.
+type cpioWriter struct {
+	w io.Writer
+	// ino numbers the entries, as every entry
+	// of a newc archive needs a distinct inode.
+	ino uint32
+	// remaining holds the number of bytes still to be
+	// written for the current entry, and pad the padding
+	// following them.
+	remaining int64
+	pad       int64
+}
+
+func newCpioWriter(w io.Writer) *cpioWriter {
+	return &cpioWriter{w: w}
+}
+
+func (cw *cpioWriter) WriteHeader(h *tar.Header) error {
+	if err := cw.finishEntry(); err != nil {
+		return err
+	}
+	var mode int64
+	var size int64
+	var link string
+	switch h.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		mode, size = cpioTypeReg, h.Size
+	case tar.TypeDir:
+		mode = cpioTypeDir
+	case tar.TypeSymlink:
+		mode, link = cpioTypeSymlink, h.Linkname
+		size = int64(len(link))
+	case tar.TypeChar:
+		mode = cpioTypeChar
+	case tar.TypeBlock:
+		mode = cpioTypeBlock
+	case tar.TypeFifo:
+		mode = cpioTypeFifo
+	default:
+		return fmt.Errorf("cannot store %s in a cpio archive", entryType(h))
+	}
+	if size > 0xffffffff {
+		return fmt.Errorf("cannot store %d bytes in a cpio archive", size)
+	}
+	// Files in initramfs images are named relative to the
+	// root, and directories carry no trailing slash.
+	name := path.Clean(strings.TrimPrefix(h.Name, "/"))
+	nlink := 1
+	if h.Typeflag == tar.TypeDir {
+		nlink = 2
+	}
+	mtime := h.ModTime.Unix()
+	if mtime < 0 {
+		mtime = 0
+	}
+	cw.ino++
+	if err := cw.writeHeader(name, cw.ino, mode|h.Mode&07777, h.Uid, h.Gid, nlink, mtime, size, h.Devmajor, h.Devminor); err != nil {
+		return err
+	}
+	cw.remaining = size
+	cw.pad = cpioPadding(size)
+	if link != "" {
+		if _, err := cw.Write([]byte(link)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes a newc header and the name following it.
+func (cw *cpioWriter) writeHeader(name string, ino uint32, mode int64, uid, gid, nlink int, mtime, size, devmajor, devminor int64) error {
+	hdr := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, uint32(mode), uint32(uid), uint32(gid), uint32(nlink), uint32(mtime), uint32(size),
+		0, 0, uint32(devmajor), uint32(devminor), len(name)+1, 0)
+	hdr += name + "\x00"
+	hdr += strings.Repeat("\x00", int(cpioPadding(int64(len(hdr)))))
+	_, err := io.WriteString(cw.w, hdr)
+	return err
+}
+
+func (cw *cpioWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > cw.remaining {
+		n, err := cw.Write(p[:cw.remaining])
+		if err == nil {
+			err = errCpioWriteTooLong
+		}
+		return n, err
+	}
+	n, err := cw.w.Write(p)
+	cw.remaining -= int64(n)
+	return n, err
+}
+
+// finishEntry pads the current entry, if its data was fully written.
+func (cw *cpioWriter) finishEntry() error {
+	if cw.remaining > 0 {
+		return fmt.Errorf("cpio: missed writing %d bytes", cw.remaining)
+	}
+	if cw.pad > 0 {
+		if _, err := cw.w.Write(make([]byte, cw.pad)); err != nil {
+			return err
+		}
+		cw.pad = 0
+	}
+	return nil
+}
+
+func (cw *cpioWriter) Flush() error {
+	return cw.finishEntry()
+}
+
+// Close writes the trailer ending the archive.
+// It does not close the underlying writer.
+func (cw *cpioWriter) Close() error {
+	if err := cw.finishEntry(); err != nil {
+		return err
+	}
+	return cw.writeHeader(cpioTrailer, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+}
+
+// cpioPadding returns the padding needed after n bytes
+// to align the next header or data on 4 bytes.
+func cpioPadding(n int64) int64 {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	gc "launchpad.net/gocheck"
+)
+
+type cpioEntry struct {
+	name string
+	mode int64
+	data string
+}
+
+// readCpio parses the newc archive in data.
+func readCpio(c *gc.C, data []byte) []cpioEntry {
+	var entries []cpioEntry
+	field := func(hdr []byte, i int) int64 {
+		v, err := strconv.ParseInt(string(hdr[6+8*i:14+8*i]), 16, 64)
+		c.Assert(err, gc.IsNil)
+		return v
+	}
+	for {
+		c.Assert(len(data) >= 110, gc.Equals, true)
+		c.Assert(string(data[:6]), gc.Equals, "070701")
+		hdr := data[:110]
+		size, namesize := field(hdr, 6), field(hdr, 11)
+		name := string(data[110 : 110+namesize-1])
+		off := 110 + namesize
+		off += cpioPadding(off)
+		if name == cpioTrailer {
+			return entries
+		}
+		entries = append(entries, cpioEntry{
+			name: name,
+			mode: field(hdr, 1),
+			data: string(data[off : off+size]),
+		})
+		off += size
+		off += cpioPadding(off)
+		data = data[off:]
+	}
+}
+
+func (t *TarSuite) TestCpioFormat(c *gc.C) {
+	src := filepath.Join(t.cwd, "init")
+	c.Assert(os.MkdirAll(filepath.Join(src, "bin"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "bin", "init"), []byte("#!/bin/sh\n"), 0755), gc.IsNil)
+	c.Assert(os.Symlink("bin/init", filepath.Join(src, "init")), gc.IsNil)
+
+	for i, compress := range []bool{false, true} {
+		c.Logf("test %d: compress %v", i, compress)
+		output := filepath.Join(t.cwd, "initramfs.img")
+		fileList := []string{filepath.Join(src, "bin"), filepath.Join(src, "init")}
+		_, err := TarFiles(fileList, output, src+"/", compress, WithFormat(FormatCpio))
+		c.Assert(err, gc.IsNil)
+		data, err := ioutil.ReadFile(output)
+		c.Assert(err, gc.IsNil)
+		if compress {
+			gzr, err := gzip.NewReader(bytes.NewReader(data))
+			c.Assert(err, gc.IsNil)
+			data, err = ioutil.ReadAll(gzr)
+			c.Assert(err, gc.IsNil)
+		}
+		c.Assert(readCpio(c, data), gc.DeepEquals, []cpioEntry{
+			{"bin", cpioTypeDir | 0755, ""},
+			{"bin/init", cpioTypeReg | 0755, "#!/bin/sh\n"},
+			{"init", cpioTypeSymlink | 0777, "bin/init"},
+		})
+	}
+}
+
+func (t *TarSuite) TestCpioFormatValidation(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.cpio"), "", false, WithFormat(FormatCpio), WithDedup())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDedup cannot be used with cpio archives")
+}
@@ -0,0 +1,133 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithDedup returns an Option that makes TarFiles store regular files
+// with the same contents as an earlier one as hard links to it, which
+// shrinks archives of trees holding many identical files. Every file
+// is read twice, once to compute its digest and once to archive it.
+// UntarFiles extracts the links as hard links, or as copies where
+// hard links cannot be created.
+func WithDedup() Option {
+	return func(o *options) {
+		o.dedup = true
+	}
+}
+
+// dedupEntry turns h into a hard link to the first entry archived
+// with the same contents as r, if any, and reports whether it did.
+// Contents that cannot be read again are never deduplicated.
+func (a *archiver) dedupEntry(h *tar.Header, r io.Reader) (bool, error) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok || h.Typeflag != tar.TypeReg || h.Size == 0 {
+		return false, nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, nil
+	}
+	digest := sha256.New()
+	n, err := io.Copy(digest, rs)
+	if err != nil {
+		return false, fmt.Errorf("cannot compute digest of %q: %v", h.Name, err)
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return false, fmt.Errorf("cannot compute digest of %q: %v", h.Name, err)
+	}
+	key := fmt.Sprintf("%d:%x", n, digest.Sum(nil))
+	first, ok := a.contents[key]
+	if !ok {
+		a.contents[key] = h.Name
+		return false, nil
+	}
+	h.Typeflag = tar.TypeLink
+	h.Linkname = first
+	h.Size = 0
+	return true, nil
+}
+
+// extractLink extracts the hard link with the given header at
+// fullPath, copying its target where links cannot be created.
+func (x *extractor) extractLink(fullPath string, hdr *tar.Header) error {
+	name, ok := x.opts.outputName(hdr.Linkname)
+	if !ok {
+		return fmt.Errorf("cannot extract hard link %q: target %q is not extracted", hdr.Name, hdr.Linkname)
+	}
+	target, err := extractPath(x.outputFolder, name)
+	if err != nil {
+		return err
+	}
+	root, err := extractPath(x.outputFolder, "")
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("cannot extract hard link %q: target %q is outside the extraction directory", hdr.Name, hdr.Linkname)
+	}
+	if x.files != nil {
+		x.files.waitFor(target)
+	}
+	// A symlinked directory may lead the target out of the
+	// extraction directory whatever its name says.
+	if !resolvesWithin(root, target) {
+		return fmt.Errorf("cannot extract hard link %q: target %q is outside the extraction directory", hdr.Name, hdr.Linkname)
+	}
+	if err := x.remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot extract hard link %q: %v", fullPath, err)
+	}
+	if err := x.link(target, fullPath); err == nil {
+		return nil
+	} else if x.root != nil {
+		return fmt.Errorf("cannot extract hard link %q: %v", fullPath, err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("cannot extract hard link %q: %v", fullPath, err)
+	}
+	contents, err := ioutil.ReadFile(target)
+	if err != nil {
+		return fmt.Errorf("cannot extract hard link %q: %v", fullPath, err)
+	}
+	if err := x.writeFile(fullPath, contents, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if x.unsynced != nil {
+		x.unsynced.add(fullPath)
+	}
+	x.opts.report.degrade(Degradation{
+		Kind:    DegradationLinkCopied,
+		Path:    hdr.Name,
+		Message: fmt.Sprintf("hard link to %q extracted as a copy of its target", hdr.Linkname),
+	})
+	return nil
+}
+
+// resolvesWithin reports whether the path p, once its symlinks
+// are resolved, is below root. Paths that do not exist are.
+func resolvesWithin(root, p string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realRoot, real)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestDedup(c *gc.C) {
+	srcDir := filepath.Join(t.cwd, "charms")
+	for _, dir := range []string{"a", "b"} {
+		c.Assert(os.MkdirAll(filepath.Join(srcDir, dir), 0755), gc.IsNil)
+	}
+	shared := strings.Repeat("identical charm contents\n", 1000)
+	for name, contents := range map[string]string{
+		"a/charm.zip": shared,
+		"b/charm.zip": shared,
+		"b/other":     "different",
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(srcDir, name), []byte(contents), 0644), gc.IsNil)
+	}
+	plainTar := filepath.Join(t.cwd, "plain.tar")
+	_, err := TarDirectory(srcDir, plainTar, false)
+	c.Assert(err, gc.IsNil)
+	dedupTar := filepath.Join(t.cwd, "dedup.tar")
+	_, err = TarDirectory(srcDir, dedupTar, false, WithDedup())
+	c.Assert(err, gc.IsNil)
+
+	headers := readHeaders(c, dedupTar)
+	link := headers["charms/b/charm.zip"]
+	c.Assert(link, gc.NotNil)
+	c.Assert(link.Typeflag, gc.Equals, byte(tar.TypeLink))
+	c.Assert(link.Linkname, gc.Equals, "charms/a/charm.zip")
+	c.Assert(headers["charms/b/other"].Typeflag, gc.Equals, byte(tar.TypeReg))
+	plain, err := os.Stat(plainTar)
+	c.Assert(err, gc.IsNil)
+	dedup, err := os.Stat(dedupTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(dedup.Size() < plain.Size()-int64(len(shared)/2), gc.Equals, true)
+
+	for _, concurrency := range []Concurrency{{}, {Write: 4}} {
+		outputDir := c.MkDir()
+		err = UntarFiles(dedupTar, outputDir, false, WithConcurrency(concurrency))
+		c.Assert(err, gc.IsNil)
+		first, err := os.Stat(filepath.Join(outputDir, "charms", "a", "charm.zip"))
+		c.Assert(err, gc.IsNil)
+		second, err := os.Stat(filepath.Join(outputDir, "charms", "b", "charm.zip"))
+		c.Assert(err, gc.IsNil)
+		c.Assert(os.SameFile(first, second), gc.Equals, true)
+		data, err := ioutil.ReadFile(filepath.Join(outputDir, "charms", "b", "charm.zip"))
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, shared)
+	}
+
+	// Files stored as hard links are left out with hard links.
+	_, err = TarDirectory(srcDir, dedupTar, false, WithDedup(), WithoutTypes(EntryHardLink))
+	c.Assert(err, gc.IsNil)
+	headers = readHeaders(c, dedupTar)
+	c.Assert(headers["charms/a/charm.zip"], gc.NotNil)
+	c.Assert(headers["charms/b/charm.zip"], gc.IsNil)
+	c.Assert(headers["charms/b/other"], gc.NotNil)
+
+	err = UntarFiles(dedupTar, c.MkDir(), false, WithDedup())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDedup only applies to archive creation")
+}
+
+func (t *TarSuite) TestDedupReaders(c *gc.C) {
+	root := c.MkDir()
+	srcDir := filepath.Join(root, "charms")
+	c.Assert(os.Mkdir(srcDir, 0755), gc.IsNil)
+	shared := "charm\nsecret=1\n"
+	for _, name := range []string{"a", "b"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(srcDir, name), []byte(shared), 0644), gc.IsNil)
+	}
+	dedupTar := filepath.Join(t.cwd, "dedup.tar")
+	_, err := TarDirectory(srcDir, dedupTar, false, WithDedup(), WithManifest())
+	c.Assert(err, gc.IsNil)
+	c.Assert(readHeaders(c, dedupTar)["charms/b"].Typeflag, gc.Equals, byte(tar.TypeLink))
+
+	c.Assert(VerifyManifest(dedupTar), gc.IsNil)
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte(shared)))
+	err = VerifyAgainstManifest(dedupTar, strings.NewReader(digest+"  charms/a\n"+digest+"  charms/b\n"))
+	c.Assert(err, gc.IsNil)
+	err = VerifyAgainstManifest(dedupTar, strings.NewReader(digest+"  charms/a\n"+strings.Repeat("0", 64)+"  charms/b\n"))
+	c.Assert(err, gc.ErrorMatches, "archive does not match manifest: digest mismatch: charms/b")
+
+	d, err := CompareArchiveToDir(dedupTar, root)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Empty(), gc.Equals, true, gc.Commentf("%#v", d))
+
+	var buf bytes.Buffer
+	err = ExtractRange(dedupTar, "charms/b", 6, -1, &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "secret=1\n")
+
+	f, err := os.Open(dedupTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	matches, err := SearchArchive(f, regexp.MustCompile("secret"), SearchOptions{Names: []string{"*/b"}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(matches, gc.DeepEquals, []Match{{Name: "charms/b", Line: 2, Offset: 6, Text: "secret=1"}})
+}
+
+func (t *TarSuite) TestUntarHardLinkOutside(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "escape.tar")
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	tw := tar.NewWriter(f)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}), gc.IsNil)
+	c.Assert(tw.Close(), gc.IsNil)
+	c.Assert(f.Close(), gc.IsNil)
+	err = UntarFiles(tarFile, c.MkDir(), false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract hard link "passwd": target "../../etc/passwd" is outside the extraction directory`)
+}
+
+func (t *TarSuite) TestUntarHardLinkThroughSymlink(c *gc.C) {
+	outside := c.MkDir()
+	secret := filepath.Join(outside, "secret")
+	c.Assert(ioutil.WriteFile(secret, []byte("secret"), 0600), gc.IsNil)
+	tarFile := filepath.Join(t.cwd, "escape.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "d", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "stolen", Typeflag: tar.TypeLink, Linkname: "d/secret"},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract hard link "stolen": target "d/secret" is outside the extraction directory`)
+	_, err = os.Lstat(filepath.Join(outputDir, "stolen"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
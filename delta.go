@@ -0,0 +1,120 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CreateDelta creates at targetPath an archive holding the changes
+// made to the tree at dir since base was taken: the files added or
+// modified since, and whiteout entries, as understood by ApplyOverlay,
+// for the files removed. Paths in base are relative to dir, as they
+// are in the manifest of an archive created by TarFiles WithManifest
+// with dir as strip prefix. If compress is true, the archive is gzip
+// compressed.
+//
+// The returned manifest describes the whole tree at dir, and is to be
+// used as the base of the next delta, so that a full backup followed
+// by its deltas can be restored in sequence with ApplyDelta.
+func CreateDelta(base *Manifest, dir, targetPath string, compress bool, opts ...Option) (_ *Manifest, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return nil, err
+	}
+	dir = filepath.Clean(dir)
+	current, headers, err := dirManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	diff := DiffManifests(base, current)
+
+	var entries []Entry
+	// removed holds the removed paths, and those whose type
+	// changed, below which nothing needs whiting out.
+	var removed []string
+	whiteout := func(name string) {
+		for _, r := range removed {
+			if strings.HasPrefix(name, r+"/") {
+				return
+			}
+		}
+		removed = append(removed, name)
+		dir, base := path.Split(name)
+		entries = append(entries, Entry{Header: &tar.Header{
+			Name:     dir + whiteoutPrefix + base,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			ModTime:  current.modTime(),
+		}})
+	}
+	add := func(name string) {
+		hdr := headers[name]
+		e := Entry{Header: hdr}
+		if hdr.Typeflag == tar.TypeReg {
+			e.Path = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		entries = append(entries, e)
+	}
+	// Whiteouts come first, so that the paths they remove can be
+	// replaced by entries of the delta: extraction does not replace
+	// what exists with something of another type, nor symlinks.
+	changed := append([]string(nil), diff.Removed...)
+	for _, m := range diff.Modified {
+		for _, reason := range m.Reasons {
+			if reason == DiffType || reason == DiffLink {
+				changed = append(changed, m.Path)
+			}
+		}
+	}
+	sort.Strings(changed)
+	for _, name := range changed {
+		whiteout(name)
+	}
+	written := append([]string(nil), diff.Added...)
+	for _, m := range diff.Modified {
+		written = append(written, m.Path)
+	}
+	sort.Strings(written)
+	for _, name := range written {
+		add(name)
+	}
+
+	err = writeOutput(targetPath, o, func(w io.Writer) error {
+		return writeEntries(w, "", compress, sha256.New(), o, func(a *archiver) error {
+			for _, e := range entries {
+				if err := a.writeFromEntry(e); err != nil {
+					return fmt.Errorf("backup failed: %v", err)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// ApplyDelta restores a tree at dest from the archives, applying them
+// in order with ApplyOverlay: typically a full backup followed by the
+// deltas created by CreateDelta since. If compressed is true, the
+// archives are expected to be gzip compressed.
+func ApplyDelta(archives []string, dest string, compressed bool, opts ...Option) error {
+	for _, archive := range archives {
+		if err := ApplyOverlay(archive, dest, compressed, opts...); err != nil {
+			return fmt.Errorf("cannot apply %q: %v", archive, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+// archiveNames returns the names of the entries of the
+// uncompressed archive tarFile.
+func archiveNames(c *gc.C, tarFile string) []string {
+	f, err := os.Open(tarFile)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		c.Assert(err, gc.IsNil)
+		names = append(names, hdr.Name)
+	}
+}
+
+func (t *TarSuite) TestDelta(c *gc.C) {
+	dir := filepath.Join(t.cwd, "state")
+	write := func(name, contents string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), gc.IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(contents), 0644), gc.IsNil)
+	}
+	write("same", "same")
+	write("changed", "before")
+	write("removed", "removed")
+	write("olddir/a", "a")
+	write("olddir/sub/b", "b")
+	write("becomes-dir", "file")
+	c.Assert(os.Symlink("same", filepath.Join(dir, "link")), gc.IsNil)
+
+	fileList := []string{dir + "/"}
+	baseFile := filepath.Join(t.cwd, "base.tar")
+	_, err := TarFiles(fileList, baseFile, dir+"/", false, WithManifest())
+	c.Assert(err, gc.IsNil)
+	base, err := ReadManifest(baseFile)
+	c.Assert(err, gc.IsNil)
+
+	write("changed", "after")
+	write("added/new", "new")
+	c.Assert(os.Remove(filepath.Join(dir, "removed")), gc.IsNil)
+	c.Assert(os.RemoveAll(filepath.Join(dir, "olddir")), gc.IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "becomes-dir")), gc.IsNil)
+	write("becomes-dir/inside", "inside")
+	c.Assert(os.Remove(filepath.Join(dir, "link")), gc.IsNil)
+	c.Assert(os.Symlink("changed", filepath.Join(dir, "link")), gc.IsNil)
+
+	deltaFile := filepath.Join(t.cwd, "delta.tar")
+	next, err := CreateDelta(base, dir, deltaFile, false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(archiveNames(c, deltaFile), gc.DeepEquals, []string{
+		".wh.becomes-dir",
+		".wh.link",
+		".wh.olddir",
+		".wh.removed",
+		"added",
+		"added/new",
+		"becomes-dir",
+		"becomes-dir/inside",
+		"changed",
+		"link",
+	})
+
+	restore := filepath.Join(t.cwd, "restore")
+	err = ApplyDelta([]string{baseFile, deltaFile}, restore, false)
+	c.Assert(err, gc.IsNil)
+	diff, err := CompareArchiveToDir(deltaFile, restore)
+	c.Assert(err, gc.IsNil)
+	c.Assert(diff.Modified, gc.HasLen, 0)
+	expected, err := dirState(dir)
+	c.Assert(err, gc.IsNil)
+	restored, err := dirState(restore)
+	c.Assert(err, gc.IsNil)
+	c.Assert(compareStates(expected, restored), gc.DeepEquals, &Diff{})
+
+	// Nothing changed since the last delta.
+	emptyFile := filepath.Join(t.cwd, "empty.tar")
+	_, err = CreateDelta(next, dir, emptyFile, false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(archiveNames(c, emptyFile), gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestDeltaWhiteoutModTime(c *gc.C) {
+	dir := filepath.Join(t.cwd, "state")
+	c.Assert(os.Mkdir(dir, 0755), gc.IsNil)
+	for _, name := range []string{"kept", "removed"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644), gc.IsNil)
+	}
+	base, _, err := dirManifest(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "removed")), gc.IsNil)
+	mtime := time.Unix(1200000000, 0)
+	c.Assert(os.Chtimes(filepath.Join(dir, "kept"), mtime, mtime), gc.IsNil)
+	c.Assert(os.Chtimes(dir, mtime, mtime), gc.IsNil)
+
+	deltaFile := filepath.Join(t.cwd, "delta.tar")
+	_, err = CreateDelta(base, dir, deltaFile, false)
+	c.Assert(err, gc.IsNil)
+	hdr, err := HeadEntry(deltaFile, ".wh.removed")
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.ModTime.Equal(mtime), gc.Equals, true)
+}
@@ -0,0 +1,296 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DiffReason describes why an entry is considered modified.
+type DiffReason string
+
+const (
+	DiffType    DiffReason = "type"
+	DiffSize    DiffReason = "size"
+	DiffMode    DiffReason = "mode"
+	DiffContent DiffReason = "content"
+	DiffLink    DiffReason = "link"
+)
+
+// ModifiedEntry describes an entry present on both sides
+// of a comparison that differs between them.
+type ModifiedEntry struct {
+	Path    string
+	Reasons []DiffReason
+}
+
+// Diff holds the differences found between two trees of entries,
+// the old one and the new one. Paths use forward slashes and are
+// relative to the root of each tree.
+type Diff struct {
+	// Added holds the entries only present in the new tree.
+	Added []string
+	// Removed holds the entries only present in the old tree.
+	Removed []string
+	// Modified holds the entries that differ.
+	Modified []ModifiedEntry
+}
+
+// Empty reports whether no differences were found.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// modeBits holds the mode bits compared between entries.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// entryState holds what is compared about a single entry.
+type entryState struct {
+	mode     os.FileMode
+	size     int64
+	digest   string
+	linkname string
+}
+
+// treeState maps cleaned entry paths to their state.
+type treeState map[string]entryState
+
+// CompareArchiveToDir compares the archive tarFile, which may be
+// gzip compressed, with the directory tree rooted at dir. The archive
+// is taken as the old tree and dir as the new one, so Added lists
+// files created since the archive was made. This can be used to
+// validate a restore or to detect drift since the last backup.
+func CompareArchiveToDir(tarFile, dir string) (*Diff, error) {
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	old, err := archiveState(tr)
+	if err != nil {
+		return nil, err
+	}
+	current, err := dirState(dir)
+	if err != nil {
+		return nil, err
+	}
+	return compareStates(old, current), nil
+}
+
+// DiffArchives compares the archives read from a and b, either of
+// which may be gzip compressed, without extracting them. The archive
+// read from a is taken as the old tree and the one from b as the new
+// one, so Added lists entries only present in b.
+func DiffArchives(a, b io.Reader) (*Diff, error) {
+	before, err := readerState(a)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read first archive: %v", err)
+	}
+	after, err := readerState(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read second archive: %v", err)
+	}
+	return compareStates(before, after), nil
+}
+
+// readerState reads the state of every entry in the archive read from r.
+func readerState(r io.Reader) (treeState, error) {
+	tr, err := newArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return archiveState(tr)
+}
+
+// archiveState reads the state of every entry in tr.
+func archiveState(tr *tar.Reader) (treeState, error) {
+	state := make(treeState)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return state, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if hdr.Name == ManifestName {
+			continue
+		}
+		s := entryState{
+			mode:     hdr.FileInfo().Mode(),
+			size:     hdr.Size,
+			linkname: hdr.Linkname,
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			// Hard links have the contents of their target.
+			if target, ok := state[cleanManifestPath(hdr.Linkname)]; ok && target.mode.IsRegular() {
+				s.size, s.digest, s.linkname = target.size, target.digest, ""
+			}
+		} else if s.mode.IsRegular() {
+			if s.digest, err = digestOf(tr); err != nil {
+				return nil, fmt.Errorf("failed while reading tar contents: %v", err)
+			}
+		} else {
+			s.size = 0
+		}
+		state[cleanManifestPath(hdr.Name)] = s
+	}
+}
+
+// dirState reads the state of every file below dir.
+func dirState(dir string) (treeState, error) {
+	state := make(treeState)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		s := entryState{mode: info.Mode()}
+		switch {
+		case info.Mode().IsRegular():
+			s.size = info.Size()
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			s.digest, err = digestOf(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			if s.linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		state[cleanManifestPath(filepath.ToSlash(rel))] = s
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory %q: %v", dir, err)
+	}
+	return state, nil
+}
+
+// digestOf returns the hex encoded SHA-256 digest of everything read from r.
+func digestOf(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compareStates returns the differences between the before and after trees.
+func compareStates(before, after treeState) *Diff {
+	d := &Diff{}
+	for name, o := range before {
+		n, ok := after[name]
+		if !ok {
+			d.Removed = append(d.Removed, name)
+			continue
+		}
+		var reasons []DiffReason
+		if o.mode&os.ModeType != n.mode&os.ModeType {
+			reasons = append(reasons, DiffType)
+		} else {
+			if o.size != n.size {
+				reasons = append(reasons, DiffSize)
+			}
+			if o.digest != n.digest {
+				reasons = append(reasons, DiffContent)
+			}
+			if o.linkname != n.linkname {
+				reasons = append(reasons, DiffLink)
+			}
+		}
+		// Symlink permissions are meaningless on most systems.
+		if o.mode&os.ModeSymlink == 0 && o.mode&modeBits != n.mode&modeBits {
+			reasons = append(reasons, DiffMode)
+		}
+		if len(reasons) > 0 {
+			d.Modified = append(d.Modified, ModifiedEntry{Path: name, Reasons: reasons})
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.Modified, func(i, j int) bool {
+		return d.Modified[i].Path < d.Modified[j].Path
+	})
+	return d
+}
+
+// DiffManifests compares the trees described by the manifests before
+// and after, such as snapshots taken by DirManifest or the manifests
+// of archives created WithManifest.
+func DiffManifests(before, after *Manifest) *Diff {
+	return compareStates(manifestState(before), manifestState(after))
+}
+
+// manifestState returns the state of every entry in m.
+func manifestState(m *Manifest) treeState {
+	state := make(treeState)
+	if m == nil {
+		return state
+	}
+	for _, e := range m.Entries {
+		name := cleanManifestPath(e.Path)
+		// The root of the tree itself may be listed.
+		if name == "" || name == ManifestName {
+			continue
+		}
+		hdr := &tar.Header{
+			Typeflag: manifestTypeflag(e.Type),
+			Mode:     e.Mode,
+		}
+		s := entryState{
+			mode:     hdr.FileInfo().Mode(),
+			digest:   e.SHA256,
+			linkname: e.Linkname,
+		}
+		if s.mode.IsRegular() {
+			s.size = e.Size
+		}
+		state[name] = s
+	}
+	return state
+}
+
+// manifestTypeflag returns the typeflag of
+// entries of the given manifest type.
+func manifestTypeflag(typ string) byte {
+	switch typ {
+	case "dir":
+		return tar.TypeDir
+	case "symlink":
+		return tar.TypeSymlink
+	case "hardlink":
+		return tar.TypeLink
+	case "chardev":
+		return tar.TypeChar
+	case "blockdev":
+		return tar.TypeBlock
+	case "fifo":
+		return tar.TypeFifo
+	}
+	return tar.TypeReg
+}
@@ -0,0 +1,89 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestCompareArchiveToDirUnchanged(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, true)
+	c.Assert(err, gc.IsNil)
+
+	d, err := CompareArchiveToDir(outputTar, outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Empty(), gc.Equals, true, gc.Commentf("%#v", d))
+}
+
+func (t *TarSuite) TestCompareArchiveToDirChanges(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false)
+	c.Assert(err, gc.IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(outputDir, "TarFile1"), []byte("TarFileX"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(outputDir, "TarFile2"), []byte("longer TarFile2"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = os.Chmod(filepath.Join(outputDir, "TarDirectoryPopulated", "TarSubFile1"), 0600)
+	c.Assert(err, gc.IsNil)
+	err = os.Remove(filepath.Join(outputDir, "TarDirectoryEmpty"))
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(outputDir, "TarNew"), nil, 0644)
+	c.Assert(err, gc.IsNil)
+
+	d, err := CompareArchiveToDir(outputTar, outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Added, gc.DeepEquals, []string{"TarNew"})
+	c.Assert(d.Removed, gc.DeepEquals, []string{"TarDirectoryEmpty"})
+	c.Assert(d.Modified, gc.DeepEquals, []ModifiedEntry{
+		{"TarDirectoryPopulated/TarSubFile1", []DiffReason{DiffMode}},
+		{"TarFile1", []DiffReason{DiffContent}},
+		{"TarFile2", []DiffReason{DiffSize, DiffContent}},
+	})
+}
+
+func (t *TarSuite) TestDiffArchives(c *gc.C) {
+	t.createTestFiles(c)
+	first := filepath.Join(t.cwd, "first.tgz")
+	_, err := TarFiles(t.testFiles, first, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(t.cwd, "TarFile1"), []byte("TarFileX"), 0644)
+	c.Assert(err, gc.IsNil)
+	second := filepath.Join(t.cwd, "second.tar")
+	_, err = TarFiles(t.testFiles[1:], second, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+
+	a, err := os.Open(first)
+	c.Assert(err, gc.IsNil)
+	defer a.Close()
+	b, err := os.Open(second)
+	c.Assert(err, gc.IsNil)
+	defer b.Close()
+	d, err := DiffArchives(a, b)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Added, gc.HasLen, 0)
+	c.Assert(d.Removed, gc.DeepEquals, []string{"TarDirectoryEmpty"})
+	c.Assert(d.Modified, gc.DeepEquals, []ModifiedEntry{
+		{"TarFile1", []DiffReason{DiffContent}},
+	})
+}
+
+func (t *TarSuite) TestDiffArchivesInvalid(c *gc.C) {
+	_, err := DiffArchives(bytes.NewReader([]byte("\x1f\x8bgarbage")), bytes.NewReader(nil))
+	c.Assert(err, gc.ErrorMatches, "cannot read first archive: cannot uncompress archive: .*")
+}
@@ -0,0 +1,47 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The RFC 3230 labels of common digest algorithms, as registered
+// with IANA. The checksums returned by TarFiles are DigestSHA ones.
+const (
+	DigestSHA    = "SHA"
+	DigestSHA256 = "SHA-256"
+	DigestSHA512 = "SHA-512"
+	DigestMD5    = "MD5"
+)
+
+// FormatDigestHeader returns the value of an RFC 3230 Digest header
+// holding the given base64 encoded sum, computed with the algorithm
+// labelled algo, such as DigestSHA for the checksums returned by
+// TarFiles.
+func FormatDigestHeader(algo, sum string) string {
+	return strings.ToUpper(algo) + "=" + sum
+}
+
+// ParseDigestHeader parses the value of an RFC 3230 Digest header,
+// which may hold several digests, and returns the digests keyed by
+// algorithm label. Labels are case insensitive and are returned in
+// upper case, so the checksum of a TarFiles archive is under
+// DigestSHA.
+func ParseDigestHeader(header string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for _, digest := range strings.Split(header, ",") {
+		digest = strings.TrimSpace(digest)
+		if digest == "" {
+			continue
+		}
+		parts := strings.SplitN(digest, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid digest %q", digest)
+		}
+		digests[strings.ToUpper(parts[0])] = parts[1]
+	}
+	return digests, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestDigestHeader(c *gc.C) {
+	header := FormatDigestHeader("sha", "v5MkdfPmw+4zCUk2mzFfJC+cI4g=")
+	c.Assert(header, gc.Equals, "SHA=v5MkdfPmw+4zCUk2mzFfJC+cI4g=")
+
+	digests, err := ParseDigestHeader(header + ", md5=HUXZLQLMuI/KZ5KDcJPcOA==")
+	c.Assert(err, gc.IsNil)
+	c.Assert(digests, gc.DeepEquals, map[string]string{
+		DigestSHA: "v5MkdfPmw+4zCUk2mzFfJC+cI4g=",
+		DigestMD5: "HUXZLQLMuI/KZ5KDcJPcOA==",
+	})
+
+	digests, err = ParseDigestHeader("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(digests, gc.HasLen, 0)
+
+	_, err = ParseDigestHeader("SHA")
+	c.Assert(err, gc.ErrorMatches, `invalid digest "SHA"`)
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ownerDirBits holds the permission bits the owner needs
+// to create entries inside a directory.
+const ownerDirBits os.FileMode = 0700
+
+// deferredDir holds the mode to give an extracted directory
+// once everything below it has been extracted.
+type deferredDir struct {
+	path string
+	mode os.FileMode
+}
+
+// deferDirMode records the mode to give the directory at fullPath
+// once extraction ends. Directories are created writable by their
+// owner so that, as GNU tar does, archives holding read-only
+// directories can be extracted; created tells whether the directory
+// was created by the extraction, in which case the owner bits added
+// are removed again.
+func (x *extractor) deferDirMode(fullPath string, mode os.FileMode, created bool) error {
+	if !chmodDirs {
+		return nil
+	}
+	// MkdirAll applies the process umask and no special bits,
+	// so the mode is set explicitly when those matter.
+	if x.opts.modePolicy != (ModePolicy{}) || mode&specialModeBits != 0 {
+		x.dirs = append(x.dirs, deferredDir{path: fullPath, mode: mode})
+		return nil
+	}
+	if !created || mode&ownerDirBits == ownerDirBits {
+		return nil
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return fmt.Errorf("cannot set proper mode on directory %q: %v", fullPath, err)
+	}
+	x.dirs = append(x.dirs, deferredDir{
+		path: fullPath,
+		mode: info.Mode().Perm() &^ (ownerDirBits &^ mode),
+	})
+	return nil
+}
+
+// applyDirModes sets the modes recorded by deferDirMode, deepest
+// directories first so that a parent that cannot be searched
+// anymore does not prevent setting the mode of its children.
+func (x *extractor) applyDirModes() error {
+	depth := func(p string) int {
+		return strings.Count(p, string(filepath.Separator))
+	}
+	sort.SliceStable(x.dirs, func(i, j int) bool {
+		return depth(x.dirs[i].path) > depth(x.dirs[j].path)
+	})
+	for _, d := range x.dirs {
+		if err := x.chmod(d.path, d.mode); err != nil {
+			return fmt.Errorf("cannot set proper mode on directory %q: %v", d.path, err)
+		}
+	}
+	x.dirs = nil
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestUntarReadOnlyDirectories(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("directory modes are not set on windows")
+	}
+	tarFile := filepath.Join(t.cwd, "readonly.tar")
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	tw := tar.NewWriter(f)
+	for _, e := range []struct {
+		name     string
+		mode     int64
+		contents string
+	}{
+		{"ro/", 0500, ""},
+		{"ro/sub/", 0555, ""},
+		{"ro/sub/file", 0444, "read only"},
+		{"ro/file", 0644, "still written"},
+	} {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode, Typeflag: tar.TypeReg, Size: int64(len(e.contents))}
+		if e.contents == "" {
+			hdr.Typeflag = tar.TypeDir
+		}
+		c.Assert(tw.WriteHeader(hdr), gc.IsNil)
+		_, err := tw.Write([]byte(e.contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	c.Assert(f.Close(), gc.IsNil)
+
+	outputDir := c.MkDir()
+	err = UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	defer os.Chmod(filepath.Join(outputDir, "ro", "sub"), 0755)
+	defer os.Chmod(filepath.Join(outputDir, "ro"), 0755)
+
+	for name, mode := range map[string]os.FileMode{
+		"ro":     0500,
+		"ro/sub": 0555,
+	} {
+		info, err := os.Stat(filepath.Join(outputDir, name))
+		c.Assert(err, gc.IsNil)
+		c.Check(info.Mode().Perm(), gc.Equals, mode, gc.Commentf("%s", name))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "ro", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "still written")
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "ro", "sub", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "read only")
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// DuplicatePolicy decides what UntarFiles does with an entry whose
+// name was already extracted, which tar streams can legally contain,
+// for example after being appended to.
+type DuplicatePolicy int
+
+const (
+	// DuplicateLastWins extracts every entry, so the last entry
+	// with a given name wins, as tar does. This is the default.
+	DuplicateLastWins DuplicatePolicy = iota
+	// DuplicateFirstWins skips entries whose name
+	// was already extracted.
+	DuplicateFirstWins
+	// DuplicateError fails the extraction with a
+	// *DuplicateEntryError on the first duplicate.
+	DuplicateError
+)
+
+// WithDuplicatePolicy returns an Option that makes UntarFiles handle
+// entries whose name was already extracted as described by p.
+// Repeated directory entries are not duplicates. Duplicates are
+// listed in Report.Duplicates WithReport, whatever the policy.
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(o *options) {
+		o.duplicatePolicy = p
+	}
+}
+
+// DuplicateEntryError is returned by UntarFiles WithDuplicatePolicy
+// DuplicateError when an archive holds the same name twice.
+type DuplicateEntryError struct {
+	Name string
+}
+
+func (e *DuplicateEntryError) Error() string {
+	return fmt.Sprintf("duplicate entry %q in archive", e.Name)
+}
+
+// validate appends to problems any problem with the policy.
+func (p DuplicatePolicy) validate(problems []string) []string {
+	if p < DuplicateLastWins || p > DuplicateError {
+		problems = append(problems, fmt.Sprintf("unknown duplicate policy %d", p))
+	}
+	return problems
+}
+
+// duplicate reports whether the entry described by hdr, extracted
+// under the given output name, must be skipped as a duplicate.
+func (x *extractor) duplicate(name string, hdr *tar.Header) (bool, error) {
+	if x.seen == nil || hdr.Typeflag == tar.TypeDir {
+		return false, nil
+	}
+	name = cleanManifestPath(name)
+	if !x.seen[name] {
+		x.seen[name] = true
+		return false, nil
+	}
+	x.opts.report.duplicated(hdr.Name)
+	switch x.opts.duplicatePolicy {
+	case DuplicateFirstWins:
+		return true, nil
+	case DuplicateError:
+		return false, &DuplicateEntryError{Name: hdr.Name}
+	}
+	return false, nil
+}
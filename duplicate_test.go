@@ -0,0 +1,84 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+// testEntry describes a regular file written by writeContentsArchive.
+type testEntry struct {
+	name, contents string
+}
+
+// writeContentsArchive writes an uncompressed archive holding the
+// given regular files, or directories for names ending in "/",
+// to tarFile.
+func writeContentsArchive(c *gc.C, tarFile string, entries []testEntry) {
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(e.contents)),
+		}
+		if strings.HasSuffix(e.name, "/") {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		err := tw.WriteHeader(hdr)
+		c.Assert(err, gc.IsNil)
+		_, err = tw.Write([]byte(e.contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+}
+
+func (t *TarSuite) TestDuplicatePolicy(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "duplicates.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"config", "first"},
+		{"other", "other"},
+		{"./config", "second"},
+	})
+	for _, test := range []struct {
+		policy   DuplicatePolicy
+		contents string
+		err      string
+	}{
+		{DuplicateLastWins, "second", ""},
+		{DuplicateFirstWins, "first", ""},
+		{DuplicateError, "first", `duplicate entry "./config" in archive`},
+	} {
+		outputDir := c.MkDir()
+		var report Report
+		err := UntarFiles(tarFile, outputDir, false, WithDuplicatePolicy(test.policy), WithReport(&report))
+		if test.err == "" {
+			c.Assert(err, gc.IsNil)
+		} else {
+			c.Assert(err, gc.ErrorMatches, test.err)
+			c.Assert(err, gc.FitsTypeOf, &DuplicateEntryError{})
+		}
+		c.Assert(report.Duplicates, gc.DeepEquals, []string{"./config"})
+		contents, err := ioutil.ReadFile(filepath.Join(outputDir, "config"))
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(contents), gc.Equals, test.contents)
+	}
+}
+
+func (t *TarSuite) TestValidateDuplicatePolicy(c *gc.C) {
+	o := newOptions([]Option{WithDuplicatePolicy(DuplicatePolicy(7))})
+	c.Assert(o.validate(opExtract), gc.ErrorMatches, `invalid configuration: unknown duplicate policy 7`)
+	o = newOptions([]Option{WithDuplicatePolicy(DuplicateError)})
+	c.Assert(o.validate(opCreate), gc.ErrorMatches, `invalid configuration: WithDuplicatePolicy only applies to extraction`)
+}
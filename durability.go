@@ -0,0 +1,93 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when UntarFiles flushes
+// extracted files to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNone leaves flushing to the operating
+	// system. This is the default.
+	SyncNone SyncPolicy = iota
+	// SyncEachFile flushes every file as soon as it is
+	// written, which is the slowest but most durable.
+	SyncEachFile
+	// SyncAtEnd flushes every extracted file once they
+	// have all been written, so that the restore is
+	// durable when UntarFiles returns.
+	SyncAtEnd
+)
+
+// WithSyncPolicy returns an Option that makes UntarFiles flush
+// the extracted files to stable storage as described by p.
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(o *options) {
+		o.syncPolicy = p
+	}
+}
+
+// WithEntryDelay returns an Option that makes TarFiles and UntarFiles
+// pause for d before every entry, so that backups and restores on
+// busy machines leave room for other work, at the cost of speed.
+func WithEntryDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.entryDelay = d
+	}
+}
+
+// validate appends to problems any problem with the policy.
+func (p SyncPolicy) validate(problems []string) []string {
+	if p < SyncNone || p > SyncAtEnd {
+		problems = append(problems, fmt.Sprintf("unknown sync policy %d", p))
+	}
+	return problems
+}
+
+// fsync flushes f to stable storage,
+// and may be replaced in tests.
+var fsync = (*os.File).Sync
+
+// pendingSyncs holds the files to flush at the end of an extraction.
+type pendingSyncs struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// add records that the file at fullPath must be flushed.
+func (p *pendingSyncs) add(fullPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths = append(p.paths, fullPath)
+}
+
+// sync flushes every recorded file.
+func (p *pendingSyncs) sync() error {
+	for _, fullPath := range p.paths {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return fmt.Errorf("cannot sync %q: %v", fullPath, err)
+		}
+		err = fsync(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot sync %q: %v", fullPath, err)
+		}
+	}
+	return nil
+}
+
+// pause waits for the delay between entries, if any.
+func (o *options) pause() {
+	if o.entryDelay > 0 {
+		sleep(o.entryDelay)
+	}
+}
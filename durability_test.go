@@ -0,0 +1,60 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSyncPolicy(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false)
+	c.Assert(err, gc.IsNil)
+
+	var synced []string
+	t.PatchValue(&fsync, func(f *os.File) error {
+		synced = append(synced, filepath.Base(f.Name()))
+		return nil
+	})
+	for _, policy := range []SyncPolicy{SyncNone, SyncEachFile, SyncAtEnd} {
+		synced = nil
+		outputDir := c.MkDir()
+		err := UntarFiles(outputTar, outputDir, false, WithSyncPolicy(policy))
+		c.Assert(err, gc.IsNil)
+		sort.Strings(synced)
+		if policy == SyncNone {
+			c.Assert(synced, gc.HasLen, 0)
+		} else {
+			c.Assert(synced, gc.DeepEquals, []string{"TarFile1", "TarFile2", "TarSubFile1"})
+		}
+		t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+	}
+}
+
+func (t *TarSuite) TestEntryDelay(c *gc.C) {
+	t.createTestFiles(c)
+	slept := t.patchClock()
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false, WithEntryDelay(time.Second))
+	c.Assert(err, gc.IsNil)
+	c.Assert(slept(), gc.Equals, 6*time.Second)
+	err = UntarFiles(outputTar, c.MkDir(), false, WithEntryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+	c.Assert(slept(), gc.Equals, 6*time.Second+6*time.Millisecond)
+}
+
+func (t *TarSuite) TestValidateSyncPolicy(c *gc.C) {
+	o := newOptions([]Option{WithSyncPolicy(SyncAtEnd), WithEntryDelay(-time.Second)})
+	c.Assert(o.validate(opCreate), gc.ErrorMatches,
+		`invalid configuration: WithSyncPolicy only applies to extraction; WithEntryDelay needs a positive delay`)
+}
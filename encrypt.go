@@ -0,0 +1,326 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted archives start with a header made of encryptMagic, a
+// byte identifying how the key was derived, a salt and a random nonce
+// prefix. The encrypted stream follows as a sequence of frames, each
+// holding a flag that marks the final frame, the length of the
+// ciphertext and the AES-256-GCM sealed chunk itself. The nonce of
+// each chunk is made of the nonce prefix, the chunk number and the
+// final flag, so chunks cannot be reordered, dropped or truncated
+// without detection.
+const (
+	encryptMagic     = "JTARENC1"
+	encryptChunkSize = 64 << 10
+	encryptSaltSize  = 16
+	noncePrefixSize  = 7
+	encryptKeySize   = 32
+	frameHeaderSize  = 5
+	encryptHeaderLen = len(encryptMagic) + 1 + encryptSaltSize + noncePrefixSize
+)
+
+// Key derivation methods recorded in the header.
+const (
+	kdfRawKey byte = iota
+	kdfScrypt
+)
+
+// scrypt parameters used to derive keys from passphrases.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// errTruncated is returned when an encrypted archive ends
+// before its final frame.
+var errTruncated = errors.New("encrypted archive is truncated")
+
+// encrypted reports whether the options ask for encryption.
+func (o *options) encrypted() bool {
+	return o.encryptionKey != nil || o.passphrase != ""
+}
+
+// deriveKey returns the AES key for the given key derivation method.
+func deriveKey(o *options, kdf byte, salt []byte) ([]byte, error) {
+	key, passphrase := o.encryptionKey, o.passphrase
+	if o.keyProvider != nil && kdf <= kdfScrypt {
+		provided, err := o.providedKey()
+		if err != nil {
+			return nil, err
+		}
+		key, passphrase = provided, string(provided)
+		if kdf == kdfRawKey && len(key) != encryptKeySize {
+			return nil, fmt.Errorf("provided key is %d bytes long, not %d", len(key), encryptKeySize)
+		}
+	}
+	switch kdf {
+	case kdfRawKey:
+		if key == nil {
+			return nil, errors.New("archive is encrypted with a key but none was given")
+		}
+		return key, nil
+	case kdfScrypt:
+		if passphrase == "" {
+			return nil, errors.New("archive is encrypted with a passphrase but none was given")
+		}
+		return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, encryptKeySize)
+	}
+	return nil, fmt.Errorf("unknown key derivation method %d", kdf)
+}
+
+// chunkNonce returns the nonce of the n-th chunk.
+func chunkNonce(prefix []byte, n uint32, final bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], n)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it into w. Close must
+// be called to write the final frame; it does not close w.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+// newEncryptWriter writes the encryption header to w and returns
+// a writer encrypting into it with the key described by o.
+func newEncryptWriter(w io.Writer, o *options) (*encryptWriter, error) {
+	header := make([]byte, encryptHeaderLen)
+	copy(header, encryptMagic)
+	kdf := kdfRawKey
+	if o.encryptionKey == nil {
+		kdf = kdfScrypt
+	}
+	header[len(encryptMagic)] = kdf
+	if _, err := io.ReadFull(rand.Reader, header[len(encryptMagic)+1:]); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %v", err)
+	}
+	salt := header[len(encryptMagic)+1 : len(encryptMagic)+1+encryptSaltSize]
+	aead, err := newAEAD(o, kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[len(header)-noncePrefixSize:],
+		buf:    make([]byte, 0, encryptChunkSize),
+	}, nil
+}
+
+func newAEAD(o *options, kdf byte, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(o, kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Write implements io.Writer.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		total += n
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.writeFrame(false); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Close writes the final frame.
+func (e *encryptWriter) Close() error {
+	return e.writeFrame(true)
+}
+
+func (e *encryptWriter) writeFrame(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.n, final), e.buf, e.header)
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(sealed))
+	if final {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	e.n++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptReader decrypts a stream written by encryptWriter.
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	n      uint32
+	buf    []byte
+	done   bool
+}
+
+// newDecryptReader reads the encryption header from r and returns
+// a reader decrypting the rest of it with the key described by o.
+func newDecryptReader(r io.Reader, o *options) (*decryptReader, error) {
+	header := make([]byte, encryptHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(encryptMagic)) {
+		return nil, errors.New("archive is not encrypted")
+	}
+	kdf := header[len(encryptMagic)]
+	salt := header[len(encryptMagic)+1 : len(encryptMagic)+1+encryptSaltSize]
+	aead, err := newAEAD(o, kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: header[len(header)-noncePrefixSize:],
+	}, nil
+}
+
+// Read implements io.Reader.
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readFrame() error {
+	var frame [frameHeaderSize]byte
+	if _, err := io.ReadFull(d.r, frame[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	final := frame[0] == 1
+	size := binary.BigEndian.Uint32(frame[1:])
+	if size > encryptChunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("encrypted archive is corrupt")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	plain, err := d.aead.Open(sealed[:0], chunkNonce(d.prefix, d.n, final), sealed, d.header)
+	if err != nil {
+		return errors.New("cannot decrypt archive: wrong key or corrupt data")
+	}
+	d.n++
+	d.buf = plain
+	d.done = final
+	return nil
+}
+
+// ReencryptArchive re-encrypts the encrypted archive src into dst, in
+// a single streaming pass that never stores the decrypted archive.
+// The archive is decrypted with the key or passphrase given by oldKey,
+// and encrypted with the one given by newKey, each being given as
+// WithEncryptionKey or WithPassphrase. Every chunk is authenticated
+// before it is re-encrypted, and dst is written to a temporary file
+// renamed into place once complete, so dst may be the same as src.
+func ReencryptArchive(src, dst string, oldKey, newKey Option) error {
+	from := newOptions([]Option{oldKey})
+	to := newOptions([]Option{newKey})
+	var problems []string
+	if !from.encrypted() {
+		problems = append(problems, "ReencryptArchive needs the old key WithEncryptionKey or WithPassphrase")
+	}
+	if !to.encrypted() {
+		problems = append(problems, "ReencryptArchive needs the new key WithEncryptionKey or WithPassphrase")
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	if err := from.validate(opExtract); err != nil {
+		return err
+	}
+	if err := to.validate(opCreate); err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file %q: %v", src, err)
+	}
+	defer f.Close()
+	r, err := newDecryptReader(f, from)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt tar file %q: %v", src, err)
+	}
+	out, err := createAtomic(dst)
+	if err != nil {
+		return fmt.Errorf("cannot create backup file %q: %v", dst, err)
+	}
+	if err := reencrypt(out, r, to); err != nil {
+		out.abort()
+		return fmt.Errorf("cannot re-encrypt %q: %v", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error closing backup file: %v", err)
+	}
+	return nil
+}
+
+// reencrypt encrypts everything read from r into w
+// with the key described by o.
+func reencrypt(w io.Writer, r io.Reader, o *options) error {
+	encw, err := newEncryptWriter(w, o)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(encw, r); err != nil {
+		return err
+	}
+	return encw.Close()
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, encryptKeySize)
+
+func (t *TarSuite) TestEncryptRoundTrip(c *gc.C) {
+	var buf bytes.Buffer
+	o := newOptions([]Option{WithEncryptionKey(testKey)})
+	w, err := newEncryptWriter(&buf, o)
+	c.Assert(err, gc.IsNil)
+	plain := bytes.Repeat([]byte("0123456789"), encryptChunkSize/5)
+	_, err = w.Write(plain)
+	c.Assert(err, gc.IsNil)
+	c.Assert(w.Close(), gc.IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("0123456789")), gc.Equals, false)
+
+	r, err := newDecryptReader(bytes.NewReader(buf.Bytes()), o)
+	c.Assert(err, gc.IsNil)
+	got, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, plain)
+
+	// Dropping the final frame must be detected.
+	truncated := buf.Bytes()[:buf.Len()-100]
+	r, err = newDecryptReader(bytes.NewReader(truncated), o)
+	c.Assert(err, gc.IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, gc.Equals, errTruncated)
+}
+
+func (t *TarSuite) TestTarFilesEncrypted(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz.enc")
+	shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithPassphrase("sekrit"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(shaSum, gc.Equals, shaSumFile(c, outputTar))
+	t.removeTestFiles(c)
+
+	err = UntarFiles(outputTar, c.MkDir(), true, WithPassphrase("wrong"))
+	c.Assert(err, gc.ErrorMatches, `.*cannot decrypt archive: wrong key or corrupt data`)
+	err = UntarFiles(outputTar, c.MkDir(), true, WithEncryptionKey(testKey))
+	c.Assert(err, gc.ErrorMatches, `cannot decrypt tar file .*: archive is encrypted with a passphrase but none was given`)
+
+	outputDir := filepath.Join(t.cwd, "TarOuputFolder")
+	err = os.Mkdir(outputDir, os.FileMode(0755))
+	c.Assert(err, gc.IsNil)
+	err = UntarFiles(outputTar, outputDir, true, WithPassphrase("sekrit"))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestEncryptionOptionsValidated(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false,
+		WithEncryptionKey([]byte("short")), WithPassphrase("x"))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithEncryptionKey needs a 32 byte key; "+
+		"WithEncryptionKey and WithPassphrase cannot be used together")
+}
+
+func (t *TarSuite) TestReencryptArchive(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz.enc")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithEncryptionKey(testKey))
+	c.Assert(err, gc.IsNil)
+	before, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+
+	// The archive is re-encrypted in place.
+	err = ReencryptArchive(outputTar, outputTar, WithEncryptionKey(testKey), WithPassphrase("rotated"))
+	c.Assert(err, gc.IsNil)
+	after, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(after, gc.Not(gc.DeepEquals), before)
+	t.assertNoTempArchives(c)
+
+	err = UntarFiles(outputTar, c.MkDir(), true, WithEncryptionKey(testKey))
+	c.Assert(err, gc.ErrorMatches, "cannot decrypt tar file .*: archive is encrypted with a passphrase but none was given")
+	restore := c.MkDir()
+	err = UntarFiles(outputTar, restore, true, WithPassphrase("rotated"))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, restore)
+
+	// A wrong old key leaves the destination untouched.
+	rotated := filepath.Join(t.cwd, "rotated")
+	err = ReencryptArchive(outputTar, rotated, WithPassphrase("wrong"), WithEncryptionKey(testKey))
+	c.Assert(err, gc.ErrorMatches, "cannot re-encrypt .*: cannot decrypt archive: wrong key or corrupt data")
+	_, err = os.Stat(rotated)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	err = ReencryptArchive(outputTar, rotated, WithPassphrase("rotated"), WithManifest())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: ReencryptArchive needs the new key WithEncryptionKey or WithPassphrase")
+}
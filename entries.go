@@ -0,0 +1,133 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Entry describes an entry produced for TarFromEntries.
+type Entry struct {
+	// Path names a file to archive. If Header is nil, the file is
+	// archived as TarFiles would, directories included with
+	// everything below them; otherwise only its contents are used.
+	Path string
+
+	// Header describes the entry. It is required when Path is empty.
+	Header *tar.Header
+
+	// Reader holds the contents of the entry when Path is empty.
+	// It may be nil for entries other than regular files. If it is
+	// also an io.Closer, it is closed once the entry is written.
+	Reader io.Reader
+}
+
+// TarFromEntries writes to w an archive holding the entries received
+// from ch, until ch is closed, as they are produced, so that arbitrarily
+// large inputs can be archived in constant memory. The names of files
+// archived from their Path have strip removed, as with TarFiles. If
+// compress is true, the archive is gzip compressed. The returned hash
+// is that of the archive, as returned by TarFiles.
+//
+// If an error occurs, the remaining entries are received from ch and
+// closed in the background, so that the producer does not block.
+func TarFromEntries(ch <-chan Entry, w io.Writer, strip string, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		drainEntries(ch)
+		return "", err
+	}
+	if o.volumeSize != 0 {
+		drainEntries(ch)
+		return "", &ConfigError{Problems: []string{"WithVolumeSize cannot be used when writing to an io.Writer"}}
+	}
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		drainEntries(ch)
+		return "", err
+	}
+	err = writeEntries(w, strip, compress, shahash, o, func(a *archiver) error {
+		skipPrefix := ""
+		for e := range ch {
+			if e.Header != nil {
+				if skipPrefix != "" && strings.HasPrefix(cleanManifestPath(e.Header.Name), skipPrefix) {
+					closeEntry(e)
+					continue
+				}
+				skipPrefix = ""
+			}
+			err := a.writeFromEntry(e)
+			if err == SkipDir && e.Header != nil {
+				if skipPrefix = skipDirPrefix(e.Header); skipPrefix != "" {
+					continue
+				}
+			}
+			// Entries archived from their Path are listed
+			// files, as for TarFiles.
+			if err == StopArchiving || err == SkipDir {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("backup failed: %v", err)
+			}
+		}
+		return nil
+	})
+	// Entries left after StopArchiving or an error
+	// still need to be consumed.
+	drainEntries(ch)
+	if err != nil {
+		return "", err
+	}
+	return encodeArchiveHash(shahash), nil
+}
+
+// writeFromEntry writes the archive entries described by e.
+func (a *archiver) writeFromEntry(e Entry) error {
+	if c, ok := e.Reader.(io.Closer); ok {
+		defer c.Close()
+	}
+	if e.Header == nil {
+		if e.Path == "" {
+			return fmt.Errorf("entry has neither a path nor a header")
+		}
+		return a.writeContents(e.Path)
+	}
+	r := e.Reader
+	if e.Path != "" {
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if r == nil && e.Header.Typeflag == tar.TypeReg && e.Header.Size > 0 {
+		return fmt.Errorf("entry %q has no contents", e.Header.Name)
+	}
+	return a.repackEntry(e.Header, r)
+}
+
+// drainEntries receives and closes the entries left in ch in the
+// background.
+func drainEntries(ch <-chan Entry) {
+	go func() {
+		for e := range ch {
+			closeEntry(e)
+		}
+	}()
+}
+
+// closeEntry closes the contents of e, if they are an io.Closer.
+func closeEntry(e Entry) {
+	if c, ok := e.Reader.(io.Closer); ok {
+		c.Close()
+	}
+}
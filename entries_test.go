@@ -0,0 +1,86 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+// closeRecorder is a reader recording whether it was closed.
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (t *TarSuite) TestTarFromEntries(c *gc.C) {
+	t.createTestFiles(c)
+	generated := &closeRecorder{Reader: strings.NewReader("generated")}
+	ch := make(chan Entry)
+	go func() {
+		defer close(ch)
+		ch <- Entry{Path: filepath.Join(t.cwd, "TarDirectoryPopulated")}
+		ch <- Entry{
+			Header: &tar.Header{Name: "rows/", Typeflag: tar.TypeDir, Mode: 0755},
+		}
+		ch <- Entry{
+			Header: &tar.Header{Name: "rows/1", Typeflag: tar.TypeReg, Mode: 0644, Size: 9},
+			Reader: generated,
+		}
+		ch <- Entry{
+			Path:   filepath.Join(t.cwd, "TarFile1"),
+			Header: &tar.Header{Name: "renamed", Typeflag: tar.TypeReg, Mode: 0644, Size: 8},
+		}
+	}()
+	var buf bytes.Buffer
+	shaSum, err := TarFromEntries(ch, &buf, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(generated.closed, gc.Equals, true)
+
+	outputTar := filepath.Join(t.cwd, "entries.tar")
+	c.Assert(ioutil.WriteFile(outputTar, buf.Bytes(), 0644), gc.IsNil)
+	c.Assert(shaSum, gc.Equals, shaSumFile(c, outputTar))
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarDirectoryPopulated", ""},
+		{"TarDirectoryPopulated/TarSubFile1", "TarSubFile1"},
+		{"TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory", ""},
+		{"rows/", ""},
+		{"rows/1", "generated"},
+		{"renamed", "TarFile1"},
+	}, outputTar, false)
+}
+
+func (t *TarSuite) TestTarFromEntriesError(c *gc.C) {
+	ch := make(chan Entry)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(ch)
+		ch <- Entry{Path: filepath.Join(t.cwd, "missing")}
+		// The producer is not blocked by the failure.
+		for i := 0; i < 10; i++ {
+			ch <- Entry{Header: &tar.Header{Name: fmt.Sprint(i), Typeflag: tar.TypeDir}}
+		}
+	}()
+	_, err := TarFromEntries(ch, ioutil.Discard, "", false)
+	c.Assert(err, gc.ErrorMatches, "backup failed: .*missing.*")
+	<-done
+
+	ch = make(chan Entry, 1)
+	ch <- Entry{Header: &tar.Header{Name: "empty", Typeflag: tar.TypeReg, Size: 3}}
+	close(ch)
+	_, err = TarFromEntries(ch, ioutil.Discard, "", false)
+	c.Assert(err, gc.ErrorMatches, `backup failed: entry "empty" has no contents`)
+}
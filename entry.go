@@ -0,0 +1,179 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrEntryNotFound is returned when a named entry
+// is not present in an archive.
+var ErrEntryNotFound = errors.New("entry not found in archive")
+
+// ExtractRange writes length bytes of the contents of the entry
+// called name in tarFile, starting at offset off, to w. If length is
+// negative, everything from off to the end of the entry is written.
+// The contents of hard links are read from their target. When
+// several entries have that name, the last one is read, as it is
+// the one extracted.
+//
+// For uncompressed archives the range is read directly from the file
+// without reading the rest of the entry; compressed archives are read
+// up to the end of the range.
+func ExtractRange(tarFile, name string, off, length int64, w io.Writer) error {
+	if off < 0 {
+		return fmt.Errorf("invalid offset %d", off)
+	}
+	tr, f, in, hdr, err := openContents(tarFile, name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if off > hdr.Size {
+		return fmt.Errorf("offset %d is beyond the end of %q (%d bytes)", off, name, hdr.Size)
+	}
+	if length < 0 || off+length > hdr.Size {
+		length = hdr.Size - off
+	}
+	var r io.Reader = tr
+	if f != nil && hdr.Typeflag != tar.TypeGNUSparse {
+		if _, err := f.Seek(off, io.SeekCurrent); err != nil {
+			return fmt.Errorf("cannot seek in backup file %q: %v", tarFile, err)
+		}
+		r = f
+	} else if _, err := io.CopyN(ioutil.Discard, tr, off); err != nil {
+		return fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	if _, err := io.CopyN(w, r, length); err != nil {
+		return fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	return nil
+}
+
+// HeadEntry returns the header of the entry called name in tarFile
+// without reading its contents, or those of any other entry when
+// the archive is not compressed. When several entries have that
+// name, the last one is returned, as it is the one extracted.
+func HeadEntry(tarFile, name string) (*tar.Header, error) {
+	_, hdr, err := locateEntry(tarFile, name, -1)
+	return hdr, err
+}
+
+// openContents opens tarFile as openTarReader does, and advances it
+// to the entry holding the contents of the entry called name, which
+// is the target of name if it is a hard link, returning its header.
+// As for extraction, the last of several entries with the same name
+// is used, and the target of a hard link is the last entry with its
+// name that comes before the link.
+func openContents(tarFile, name string) (*tar.Reader, *os.File, io.Closer, *tar.Header, error) {
+	before := -1
+	for {
+		n, _, err := locateEntry(tarFile, name, before)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		tr, f, in, err := openTarReader(tarFile)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		hdr, err := nthEntry(tr, n)
+		if err != nil {
+			in.Close()
+			return nil, nil, nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeLink {
+			return tr, f, in, hdr, nil
+		}
+		// The target comes earlier in the archive,
+		// so it is read again from the start.
+		in.Close()
+		name, before = hdr.Linkname, n
+	}
+}
+
+// openTarReader opens tarFile and returns a reader for its entries,
+// and the input to close once done. When the archive is a plain
+// uncompressed file, the file is returned too: the tar reader then
+// skips over contents by seeking, and once an entry is found the
+// file is positioned at the start of its contents.
+func openTarReader(tarFile string) (*tar.Reader, *os.File, io.Closer, error) {
+	in, err := openInput(tarFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	if f, ok := in.(*os.File); ok {
+		// archive/tar reads headers a block at a time straight
+		// from f, which only holds for uncompressed archives,
+		// so sniff the compression first.
+		magic := make([]byte, len(gzipMagic))
+		n, _ := io.ReadFull(f, magic)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			in.Close()
+			return nil, nil, nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+		}
+		if n < len(magic) || string(magic) != string(gzipMagic) {
+			return tar.NewReader(f), f, in, nil
+		}
+	}
+	tr, err := newArchiveReader(in)
+	if err != nil {
+		in.Close()
+		return nil, nil, nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	}
+	return tr, nil, in, nil
+}
+
+// locateEntry scans tarFile for the last entry called name among
+// the first before entries, or all of them if before is negative,
+// and returns its position in the archive and its header. Names are
+// compared in their canonical form, so "./a" and "a" refer to the
+// same entry.
+func locateEntry(tarFile, name string, before int) (int, *tar.Header, error) {
+	tr, _, in, err := openTarReader(tarFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer in.Close()
+	name = cleanManifestPath(name)
+	found := -1
+	var last *tar.Header
+	for n := 0; before < 0 || n < before; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if cleanManifestPath(hdr.Name) == name {
+			found, last = n, hdr
+		}
+	}
+	if last == nil {
+		return 0, nil, ErrEntryNotFound
+	}
+	return found, last, nil
+}
+
+// nthEntry advances tr to the entry at position n
+// in the archive and returns its header.
+func nthEntry(tr *tar.Reader, n int) (*tar.Header, error) {
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrEntryNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if i == n {
+			return hdr, nil
+		}
+	}
+}
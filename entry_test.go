@@ -0,0 +1,101 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) createRangeArchive(c *gc.C, compress bool) string {
+	t.createTestFiles(c)
+	log := filepath.Join(t.cwd, "TarLog")
+	err := ioutil.WriteFile(log, []byte(strings.Repeat("x", 1000)+"the tail"), 0644)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err = TarFiles(append(t.testFiles, log), outputTar, t.cwd+"/", compress)
+	c.Assert(err, gc.IsNil)
+	return outputTar
+}
+
+func (t *TarSuite) TestExtractRange(c *gc.C) {
+	for _, compress := range []bool{false, true} {
+		outputTar := t.createRangeArchive(c, compress)
+		var buf bytes.Buffer
+		err := ExtractRange(outputTar, "TarLog", 1000, -1, &buf)
+		c.Assert(err, gc.IsNil)
+		c.Assert(buf.String(), gc.Equals, "the tail")
+
+		buf.Reset()
+		err = ExtractRange(outputTar, "./TarLog", 998, 5, &buf)
+		c.Assert(err, gc.IsNil)
+		c.Assert(buf.String(), gc.Equals, "xxthe")
+
+		buf.Reset()
+		err = ExtractRange(outputTar, "TarFile2", 3, 100, &buf)
+		c.Assert(err, gc.IsNil)
+		c.Assert(buf.String(), gc.Equals, "File2")
+		t.removeTestFiles(c)
+	}
+}
+
+func (t *TarSuite) TestExtractRangeErrors(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+	err := ExtractRange(outputTar, "TarMissing", 0, -1, ioutil.Discard)
+	c.Assert(err, gc.Equals, ErrEntryNotFound)
+	err = ExtractRange(outputTar, "TarFile1", 9, -1, ioutil.Discard)
+	c.Assert(err, gc.ErrorMatches, `offset 9 is beyond the end of "TarFile1" \(8 bytes\)`)
+	err = ExtractRange(outputTar, "TarFile1", -1, -1, ioutil.Discard)
+	c.Assert(err, gc.ErrorMatches, `invalid offset -1`)
+}
+
+func (t *TarSuite) TestHeadEntry(c *gc.C) {
+	for _, compress := range []bool{false, true} {
+		outputTar := t.createRangeArchive(c, compress)
+		hdr, err := HeadEntry(outputTar, "./TarLog")
+		c.Assert(err, gc.IsNil)
+		c.Assert(hdr.Name, gc.Equals, "TarLog")
+		c.Assert(hdr.Size, gc.Equals, int64(1008))
+
+		_, err = HeadEntry(outputTar, "TarMissing")
+		c.Assert(err, gc.Equals, ErrEntryNotFound)
+		t.removeTestFiles(c)
+	}
+}
+
+func (t *TarSuite) TestEntryDuplicatesLastWins(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "duplicates.tar")
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	tw := tar.NewWriter(f)
+	for _, contents := range []string{"first", "second!"} {
+		c.Assert(tw.WriteHeader(&tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))}), gc.IsNil)
+		_, err = tw.Write([]byte(contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "f"}), gc.IsNil)
+	c.Assert(tw.Close(), gc.IsNil)
+	c.Assert(f.Close(), gc.IsNil)
+
+	hdr, err := HeadEntry(tarFile, "f")
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.Size, gc.Equals, int64(7))
+	for _, name := range []string{"f", "link"} {
+		var buf bytes.Buffer
+		err = ExtractRange(tarFile, name, 0, -1, &buf)
+		c.Assert(err, gc.IsNil)
+		c.Assert(buf.String(), gc.Equals, "second!")
+	}
+	outputDir := c.MkDir()
+	c.Assert(UntarFiles(tarFile, outputDir, false), gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "f"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "second!")
+}
@@ -0,0 +1,144 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ExpectedEntry describes an entry AssertContents
+// expects to find in an archive.
+type ExpectedEntry struct {
+	// Name is the name of the entry. A leading "/" or "./"
+	// and a trailing "/" are ignored.
+	Name string
+	// Body holds the contents expected for a regular file.
+	// The contents are not checked if it is empty.
+	Body string
+	// Type holds the type flag expected for the entry,
+	// such as tar.TypeDir. The type is not checked if it
+	// is zero.
+	Type byte
+}
+
+// ContentMismatch describes an entry that
+// does not match what was expected of it.
+type ContentMismatch struct {
+	// Name is the name of the entry.
+	Name string
+	// Problem describes the mismatch.
+	Problem string
+}
+
+// ContentsMismatchError is returned by AssertContents when
+// the archive does not hold what was expected.
+type ContentsMismatchError struct {
+	// Missing holds the expected entries not
+	// found in the archive.
+	Missing []string
+	// Mismatched holds the entries found in the archive
+	// that do not match what was expected of them.
+	Mismatched []ContentMismatch
+}
+
+func (e *ContentsMismatchError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing from archive: "+strings.Join(e.Missing, ", "))
+	}
+	for _, m := range e.Mismatched {
+		parts = append(parts, fmt.Sprintf("%s: %s", m.Name, m.Problem))
+	}
+	return "archive does not match expected contents: " + strings.Join(parts, "; ")
+}
+
+// AssertContents checks that the archive read from archive, which
+// may be gzip compressed, holds every entry described by spec, so
+// that deployment pipelines can validate the artifacts they produce.
+// Entries not in spec are allowed. If the archive does not match, a
+// *ContentsMismatchError is returned.
+func AssertContents(archive io.Reader, spec []ExpectedEntry) error {
+	expected := make(map[string]ExpectedEntry)
+	var order []string
+	for _, e := range spec {
+		name := cleanManifestPath(e.Name)
+		if _, ok := expected[name]; !ok {
+			order = append(order, name)
+		}
+		expected[name] = e
+	}
+	tr, err := newArchiveReader(archive)
+	if err != nil {
+		return err
+	}
+	mismatched := make(map[string]string)
+	found := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		name := cleanManifestPath(hdr.Name)
+		e, ok := expected[name]
+		if !ok {
+			continue
+		}
+		found[name] = true
+		problem, err := checkExpected(e, hdr, tr)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			mismatched[name] = problem
+		} else {
+			// A later entry of the same name wins, as on extraction.
+			delete(mismatched, name)
+		}
+	}
+	mismatch := &ContentsMismatchError{}
+	for _, name := range order {
+		if !found[name] {
+			mismatch.Missing = append(mismatch.Missing, name)
+		} else if problem, ok := mismatched[name]; ok {
+			mismatch.Mismatched = append(mismatch.Mismatched, ContentMismatch{Name: name, Problem: problem})
+		}
+	}
+	if len(mismatch.Missing)+len(mismatch.Mismatched) > 0 {
+		return mismatch
+	}
+	return nil
+}
+
+// checkExpected returns why the entry with the given header and
+// contents read from r does not match e, or "" if it does.
+func checkExpected(e ExpectedEntry, hdr *tar.Header, r io.Reader) (string, error) {
+	typeflag := hdr.Typeflag
+	if typeflag == tar.TypeRegA {
+		typeflag = tar.TypeReg
+	}
+	if e.Type != 0 && e.Type != typeflag {
+		return fmt.Sprintf("%s entry, expected %s", entryType(hdr), entryType(&tar.Header{Typeflag: e.Type})), nil
+	}
+	if e.Body == "" {
+		return "", nil
+	}
+	if typeflag != tar.TypeReg {
+		return fmt.Sprintf("%s entry has no contents", entryType(hdr)), nil
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	if string(body) != e.Body {
+		return fmt.Sprintf("contents differ (%d bytes, expected %d)", len(body), len(e.Body)), nil
+	}
+	return "", nil
+}
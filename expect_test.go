@@ -0,0 +1,56 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestAssertContents(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+
+	var spec []ExpectedEntry
+	for _, e := range testExpectedTarContents {
+		spec = append(spec, ExpectedEntry{Name: e.Name, Body: e.Body})
+	}
+	spec = append(spec, ExpectedEntry{Name: "/TarDirectoryEmpty/", Type: tar.TypeDir})
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	c.Assert(AssertContents(f, spec), gc.IsNil)
+}
+
+func (t *TarSuite) TestAssertContentsMismatch(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	err = AssertContents(f, []ExpectedEntry{
+		{Name: "TarFile1", Body: "TarFile1"},
+		{Name: "TarFile2", Body: "other"},
+		{Name: "TarDirectoryEmpty", Type: tar.TypeReg},
+		{Name: "TarDirectoryPopulated", Body: "x"},
+		{Name: "missing"},
+	})
+	c.Assert(err, gc.DeepEquals, &ContentsMismatchError{
+		Missing: []string{"missing"},
+		Mismatched: []ContentMismatch{
+			{Name: "TarFile2", Problem: "contents differ (8 bytes, expected 5)"},
+			{Name: "TarDirectoryEmpty", Problem: "dir entry, expected file"},
+			{Name: "TarDirectoryPopulated", Problem: "dir entry has no contents"},
+		},
+	})
+	c.Assert(err, gc.ErrorMatches, `archive does not match expected contents: missing from archive: missing; TarFile2: contents differ .*`)
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// The following errors may be returned by callbacks such as a
+// ContentFilter to control which entries are archived or extracted.
+// They are never returned by the package functions themselves.
+var (
+	// SkipEntry causes the current entry to be left out.
+	SkipEntry = errors.New("skip this entry")
+	// SkipDir causes the current entry and all the remaining
+	// entries of its directory to be left out, as filepath.SkipDir
+	// does for filepath.Walk. The directory of top-level entries,
+	// and of the files listed for archiving, is the whole archive,
+	// so everything that follows them is left out.
+	SkipDir = errors.New("skip this directory")
+	// StopArchiving stops the walk without error. When archiving,
+	// the archive is completed with the entries written so far;
+	// when extracting, the remaining entries are left out.
+	StopArchiving = errors.New("stop archiving")
+)
+
+// isWalkControl reports whether err is one of the errors
+// callbacks use to control the walk.
+func isWalkControl(err error) bool {
+	return err == SkipEntry || err == SkipDir || err == StopArchiving
+}
+
+// skipDirPrefix returns the prefix of the names of the entries left
+// out once SkipDir is returned for the entry described by hdr: those
+// below it for a directory and those of its directory otherwise. It
+// returns "" for top-level entries, after which nothing is kept.
+func skipDirPrefix(hdr *tar.Header) string {
+	name := cleanManifestPath(hdr.Name)
+	if hdr.Typeflag == tar.TypeDir {
+		return name + "/"
+	}
+	if dir := path.Dir(name); dir != "." {
+		return dir + "/"
+	}
+	return ""
+}
+
+// ContentFilter is called with the header and contents of each
+// non-directory entry and returns the contents that should be used
+// in their place. It can be used to redact secrets, rewrite templates
+// or otherwise transform entries while they are archived or extracted.
+//
+// When archiving, the header size is updated to match the length of
+// the returned contents, so the filter may change it freely.
+//
+// The filter may return SkipEntry, SkipDir or StopArchiving
+// to control the walk.
+type ContentFilter func(hdr *tar.Header, r io.Reader) (io.Reader, error)
+
+// SkipFunc is called with the header of each entry of an archive
+// being extracted and reports whether the entry must be left out.
+type SkipFunc func(hdr *tar.Header) bool
+
+// WithSkipFunc returns an Option that makes UntarFiles leave out
+// the entries for which f returns true, such as anything below
+// tmp/ or larger than some size, without listing the archive first.
+func WithSkipFunc(f SkipFunc) Option {
+	return func(o *options) {
+		o.skipFunc = f
+	}
+}
+
+// filterContents runs the contents of r through filter and stages
+// the result in a temporary file so that the header size can be set
+// before the entry is written. The returned function removes the
+// staging file and must always be called.
+func filterContents(hdr *tar.Header, r io.Reader, filter ContentFilter, tmp *runDir) (io.Reader, func(), error) {
+	filtered, err := filter(hdr, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	staging, err := tmp.tempFile("filter")
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create staging file: %v", err)
+	}
+	cleanup := func() {
+		staging.Close()
+		os.Remove(staging.Name())
+	}
+	size, err := io.Copy(staging, filtered)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := staging.Seek(0, 0); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	hdr.Size = size
+	return staging, cleanup, nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+
+	"github.com/juju/tar/tartest"
+)
+
+func redactFilter(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(strings.Replace(string(buf), "File", "-REDACTED-", -1)), nil
+}
+
+func (t *TarSuite) TestTarFilesContentFilter(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false, WithContentFilter(redactFilter))
+	c.Assert(err, gc.IsNil)
+	t.removeTestFiles(c)
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarDirectoryPopulated/TarSubFile1", "TarSub-REDACTED-1"},
+		{"TarFile1", "Tar-REDACTED-1"},
+		{"TarFile2", "Tar-REDACTED-2"},
+	}, outputTar, false)
+}
+
+func (t *TarSuite) TestUntarFilesContentFilter(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false)
+	c.Assert(err, gc.IsNil)
+	t.removeTestFiles(c)
+
+	outputDir := filepath.Join(t.cwd, "TarOuputFolder")
+	err = os.Mkdir(outputDir, os.FileMode(0755))
+	c.Assert(err, gc.IsNil)
+	err = UntarFiles(outputTar, outputDir, false, WithContentFilter(redactFilter))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, []expectedTarContents{
+		{"TarDirectoryPopulated/TarSubFile1", "TarSub-REDACTED-1"},
+		{"TarFile2", "Tar-REDACTED-2"},
+	}, outputDir)
+}
+
+func (t *TarSuite) TestContentFilterError(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	failing := func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		return nil, fmt.Errorf("boom")
+	}
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithContentFilter(failing))
+	c.Assert(err, gc.ErrorMatches, `backup failed: cannot filter contents of ".*": boom`)
+}
+
+// skippingFilter returns a filter returning err for the entry
+// with the given base name and passing the rest through.
+func skippingFilter(base string, err error) ContentFilter {
+	return func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		if filepath.Base(hdr.Name) == base {
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+func (t *TarSuite) TestTarFilesFilterSkipEntry(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false,
+		WithContentFilter(skippingFilter("TarFile1", SkipEntry)))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 5)
+	c.Assert(headers["TarFile1"], gc.IsNil)
+	c.Assert(headers["TarFile2"], gc.NotNil)
+}
+
+func (t *TarSuite) TestTarFilesFilterSkipDir(c *gc.C) {
+	t.createTestFiles(c)
+	dir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	filter := skippingFilter("TarSubFile1", SkipDir)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles([]string{dir, filepath.Join(t.cwd, "TarFile1")}, outputTar, t.cwd+"/", false,
+		WithContentFilter(filter))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers["TarDirectoryPopulated/TarSubFile1"], gc.IsNil)
+	c.Assert(headers["TarFile1"], gc.NotNil)
+}
+
+func (t *TarSuite) TestFilterSkipDirEverywhere(c *gc.C) {
+	entries := map[string]tartest.Entry{
+		"a/":  {},
+		"a/1": {Contents: "1"},
+		"a/2": {Contents: "2"},
+		"b":   {Contents: "b"},
+		"c":   {Contents: "c"},
+	}
+	data := tartest.BuildArchive(c, entries)
+	tarFile := filepath.Join(t.cwd, "skipdir.tar")
+	c.Assert(ioutil.WriteFile(tarFile, data, 0644), gc.IsNil)
+	src := c.MkDir()
+	c.Assert(UntarFiles(tarFile, src, false), gc.IsNil)
+
+	// names returns the canonical names of the entries of the
+	// archive in data, as directories are only named with a
+	// trailing slash by some writers.
+	names := func(data []byte) []string {
+		var names []string
+		err := WalkArchive(bytes.NewReader(data), func(hdr *tar.Header, r io.Reader) error {
+			names = append(names, cleanManifestPath(hdr.Name))
+			return nil
+		})
+		c.Assert(err, gc.IsNil)
+		return names
+	}
+	for _, test := range []struct {
+		base string
+		kept []string
+	}{
+		{"1", []string{"a", "b", "c"}},
+		{"b", []string{"a", "a/1", "a/2"}},
+	} {
+		filter := skippingFilter(test.base, SkipDir)
+		c.Logf("SkipDir for %q", test.base)
+
+		var created bytes.Buffer
+		files := []string{filepath.Join(src, "a"), filepath.Join(src, "b"), filepath.Join(src, "c")}
+		_, err := TarFilesToWriter(files, &created, src+"/", false, WithContentFilter(filter))
+		c.Assert(err, gc.IsNil)
+		c.Check(names(created.Bytes()), gc.DeepEquals, test.kept)
+
+		var repacked bytes.Buffer
+		w, err := NewWriter(&repacked, false, WithContentFilter(filter))
+		c.Assert(err, gc.IsNil)
+		_, err = w.ReadFrom(bytes.NewReader(data))
+		c.Assert(err, gc.IsNil)
+		c.Assert(w.Close(), gc.IsNil)
+		c.Check(names(repacked.Bytes()), gc.DeepEquals, test.kept)
+
+		outputDir := c.MkDir()
+		c.Assert(UntarFiles(tarFile, outputDir, false, WithContentFilter(filter)), gc.IsNil)
+		var extracted []string
+		for _, name := range []string{"a/1", "a/2", "b", "c"} {
+			if _, err := os.Stat(filepath.Join(outputDir, name)); err == nil {
+				extracted = append(extracted, name)
+			}
+		}
+		var kept []string
+		for _, name := range test.kept {
+			if name != "a" {
+				kept = append(kept, name)
+			}
+		}
+		c.Check(extracted, gc.DeepEquals, kept)
+
+		r, err := NewReader(bytes.NewReader(data), WithContentFilter(filter))
+		c.Assert(err, gc.IsNil)
+		var read []string
+		for {
+			hdr, _, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			c.Assert(err, gc.IsNil)
+			read = append(read, cleanManifestPath(hdr.Name))
+		}
+		c.Check(read, gc.DeepEquals, test.kept)
+
+		var walked []string
+		err = WalkArchive(bytes.NewReader(data), func(hdr *tar.Header, r io.Reader) error {
+			if hdr.Typeflag == tar.TypeReg {
+				if _, err := filter(hdr, r); err != nil {
+					return err
+				}
+			}
+			walked = append(walked, cleanManifestPath(hdr.Name))
+			return nil
+		})
+		c.Assert(err, gc.IsNil)
+		c.Check(walked, gc.DeepEquals, test.kept)
+	}
+}
+
+func (t *TarSuite) TestTarFilesFilterStopArchiving(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false,
+		WithContentFilter(skippingFilter("TarFile1", StopArchiving)))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers["TarDirectoryPopulated/TarSubFile1"], gc.NotNil)
+	c.Assert(headers["TarFile1"], gc.IsNil)
+	c.Assert(headers["TarFile2"], gc.IsNil)
+}
+
+func (t *TarSuite) TestUntarFilesFilterControl(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false, WithContentFilter(skippingFilter("TarSubFile1", SkipDir)))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, "TarDirectoryPopulated", "TarSubFile1"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(filepath.Join(outputDir, "TarFile1"))
+	c.Assert(err, gc.IsNil)
+
+	outputDir = c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false, WithContentFilter(skippingFilter("TarFile1", StopArchiving)))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, "TarFile1"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(filepath.Join(outputDir, "TarFile2"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestUntarFilesSkipFunc(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "skip.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{name: "tmp/"},
+		{name: "tmp/scratch", contents: "scratch"},
+		{name: "small", contents: "x"},
+		{name: "large", contents: "xxxxxxxxxx"},
+	})
+	var seen []string
+	skip := func(hdr *tar.Header) bool {
+		seen = append(seen, hdr.Name)
+		return strings.HasPrefix(hdr.Name, "tmp/") || hdr.Size > 5
+	}
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false, WithSkipFunc(skip))
+	c.Assert(err, gc.IsNil)
+	c.Assert(seen, gc.DeepEquals, []string{"tmp/", "tmp/scratch", "small", "large"})
+	for _, name := range []string{"tmp", "large"} {
+		_, err = os.Lstat(filepath.Join(outputDir, name))
+		c.Check(os.IsNotExist(err), gc.Equals, true, gc.Commentf("%s", name))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "small"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "x")
+
+	_, err = TarFiles(nil, tarFile, "", false, WithSkipFunc(skip))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithSkipFunc only applies to extraction")
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+)
+
+// WithFlatten returns an Option that makes UntarFiles extract every
+// regular file straight into the output folder, or the directory
+// given WithPrefixPath, ignoring the directories of the archive, so
+// that all the *.log or *.crt files of a backup can be harvested in
+// one place. Directories, links and other entries are not extracted.
+// Files sharing a base name, such as "a/app.log" and "b/app.log",
+// are handled as described by p, with CollisionIgnore letting the
+// last one win.
+func WithFlatten(p CollisionPolicy) Option {
+	return func(o *options) {
+		o.flatten = true
+		o.flattenCollisions = p
+	}
+}
+
+// flattener maps the names of entries to names
+// in a single directory.
+type flattener struct {
+	policy CollisionPolicy
+	prefix string
+
+	// names maps the names given so far to
+	// the entries extracted under them.
+	names map[string]string
+}
+
+func newFlattener(policy CollisionPolicy, prefix string) *flattener {
+	return &flattener{
+		policy: policy,
+		prefix: prefix,
+		names:  make(map[string]string),
+	}
+}
+
+// resolve returns the name under which the entry described by hdr
+// must be extracted, and false if it must be skipped.
+func (f *flattener) resolve(hdr *tar.Header, report *Report) (string, bool, error) {
+	if !hdr.FileInfo().Mode().IsRegular() {
+		return "", false, nil
+	}
+	name := path.Join(f.prefix, path.Base(cleanManifestPath(hdr.Name)))
+	existing, ok := f.names[name]
+	if !ok || existing == hdr.Name || f.policy == CollisionIgnore {
+		f.names[name] = hdr.Name
+		return name, true, nil
+	}
+	c := Collision{
+		Name:     hdr.Name,
+		Existing: existing,
+		Kind:     "flatten",
+	}
+	switch f.policy {
+	case CollisionError:
+		return "", false, &NameCollisionError{Name: hdr.Name, Existing: existing, Kind: c.Kind}
+	case CollisionSkip:
+		report.collided(c)
+		return "", false, nil
+	}
+	renamed := f.rename(name)
+	f.names[renamed] = hdr.Name
+	c.ExtractedAs = renamed
+	report.collided(c)
+	return renamed, true, nil
+}
+
+// rename returns a name for p, with a "~N" suffix
+// before its extension, that has not been given.
+func (f *flattener) rename(p string) string {
+	ext := path.Ext(p)
+	if ext == path.Base(p) {
+		ext = ""
+	}
+	base := p[:len(p)-len(ext)]
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s~%d%s", base, i, ext)
+		if _, ok := f.names[candidate]; !ok {
+			return candidate
+		}
+	}
+}
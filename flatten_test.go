@@ -0,0 +1,97 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+// writeFlattenArchive writes an archive holding log files
+// in several directories to tarFile.
+func writeFlattenArchive(c *gc.C, tarFile string) {
+	writeContentsArchive(c, tarFile, []testEntry{
+		{name: "var/"},
+		{name: "var/a/"},
+		{name: "var/a/app.log", contents: "a"},
+		{name: "var/b/app.log", contents: "b"},
+		{name: "var/b/other.log", contents: "other"},
+	})
+}
+
+// readFlattened returns the contents of the files in dir by name.
+func readFlattened(c *gc.C, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	files := make(map[string]string)
+	for _, info := range infos {
+		c.Assert(info.Mode().IsRegular(), gc.Equals, true, gc.Commentf("%s", info.Name()))
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		c.Assert(err, gc.IsNil)
+		files[info.Name()] = string(data)
+	}
+	return files
+}
+
+func (t *TarSuite) TestUntarFilesFlatten(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "logs.tar")
+	writeFlattenArchive(c, tarFile)
+	for i, test := range []struct {
+		policy     CollisionPolicy
+		files      map[string]string
+		collisions []Collision
+	}{{
+		policy: CollisionIgnore,
+		files:  map[string]string{"app.log": "b", "other.log": "other"},
+	}, {
+		policy: CollisionRename,
+		files:  map[string]string{"app.log": "a", "app~1.log": "b", "other.log": "other"},
+		collisions: []Collision{{
+			Name:        "var/b/app.log",
+			Existing:    "var/a/app.log",
+			Kind:        "flatten",
+			ExtractedAs: "app~1.log",
+		}},
+	}, {
+		policy: CollisionSkip,
+		files:  map[string]string{"app.log": "a", "other.log": "other"},
+		collisions: []Collision{{
+			Name:     "var/b/app.log",
+			Existing: "var/a/app.log",
+			Kind:     "flatten",
+		}},
+	}} {
+		c.Logf("test %d: policy %d", i, test.policy)
+		outputDir := c.MkDir()
+		var report Report
+		err := UntarFiles(tarFile, outputDir, false, WithFlatten(test.policy), WithReport(&report))
+		c.Assert(err, gc.IsNil)
+		c.Check(readFlattened(c, outputDir), gc.DeepEquals, test.files)
+		c.Check(report.Collisions, gc.DeepEquals, test.collisions)
+	}
+}
+
+func (t *TarSuite) TestUntarFilesFlattenError(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "logs.tar")
+	writeFlattenArchive(c, tarFile)
+	err := UntarFiles(tarFile, c.MkDir(), false, WithFlatten(CollisionError))
+	c.Assert(err, gc.FitsTypeOf, &NameCollisionError{})
+	c.Assert(err, gc.ErrorMatches, `entry "var/b/app.log" collides with "var/a/app.log" \(flatten\)`)
+}
+
+func (t *TarSuite) TestUntarFilesFlattenPrefix(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "links.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "etc/ssl/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/ssl/ca.crt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/ssl/link.crt", Typeflag: tar.TypeSymlink, Linkname: "ca.crt"},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false, WithFlatten(CollisionError), WithPrefixPath("certs"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(readFlattened(c, filepath.Join(outputDir, "certs")), gc.DeepEquals, map[string]string{"ca.crt": ""})
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// ArchiveFormat is the format of the archives created by TarFiles.
+type ArchiveFormat int
+
+const (
+	// FormatTar creates tar archives, gzip compressed
+	// if requested. This is the default.
+	FormatTar ArchiveFormat = iota
+	// FormatZip creates zip archives, for consumers such
+	// as Windows tools. When compression is requested,
+	// files are deflated, otherwise they are stored.
+	// Owners, hard links and special files cannot be
+	// stored in zip archives.
+	FormatZip
+	// FormatCpio creates cpio archives in the "newc" format
+	// read by the Linux kernel for initramfs images, gzip
+	// compressed if requested. Hard links cannot be stored
+	// in cpio archives.
+	FormatCpio
+)
+
+// WithFormat returns an Option that makes TarFiles create
+// archives in format f.
+func WithFormat(f ArchiveFormat) Option {
+	return func(o *options) {
+		o.format = f
+	}
+}
+
+// validate appends to problems any problem with the format
+// and the options it is used with.
+func (f ArchiveFormat) validate(o *options, problems []string) []string {
+	var format string
+	switch f {
+	case FormatTar:
+		return problems
+	case FormatZip:
+		format = "zip"
+	case FormatCpio:
+		format = "cpio"
+	default:
+		return append(problems, fmt.Sprintf("unknown archive format %d", f))
+	}
+	incompatible := func(set bool, name string) {
+		if set {
+			problems = append(problems, name+" cannot be used with "+format+" archives")
+		}
+	}
+	incompatible(o.bookmarkFunc != nil || o.resume != nil, "bookmarks")
+	incompatible(o.seekableEvery != 0, "WithSeekableGzip")
+	incompatible(o.dedup, "WithDedup")
+	if f == FormatZip {
+		incompatible(o.encrypted(), "encryption")
+		incompatible(o.deterministicGzip, "WithDeterministicGzip")
+		incompatible(o.gzipHeader != nil, "WithGzipHeader")
+		incompatible(o.volumeSize != 0, "WithVolumeSize")
+		incompatible(o.concurrency.Compress != 0, "Concurrency.Compress")
+	}
+	return problems
+}
+
+// entryWriter writes the entries of an archive.
+// It is implemented by *tar.Writer.
+type entryWriter interface {
+	io.Writer
+	// WriteHeader starts a new entry described by h.
+	WriteHeader(h *tar.Header) error
+	// Flush writes out any buffered data.
+	Flush() error
+	// Close finishes the archive.
+	Close() error
+}
+
+// newEntryWriter returns an entryWriter writing an
+// archive in the format given by o to w.
+func newEntryWriter(w io.Writer, compress bool, o *options) entryWriter {
+	switch o.format {
+	case FormatZip:
+		method := zip.Store
+		if compress {
+			method = zip.Deflate
+		}
+		return &zipWriter{zw: zip.NewWriter(w), method: method}
+	case FormatCpio:
+		return newCpioWriter(w)
+	}
+	return tar.NewWriter(w)
+}
@@ -0,0 +1,501 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteFS is a file system UntarToFS extracts archives into, such as
+// an in-memory file system, a chroot or a remote file system. Names
+// are slash separated, relative to the root of the file system and
+// never contain ".." elements.
+type WriteFS interface {
+	// MkdirAll creates the named directory
+	// and any missing parents.
+	MkdirAll(name string, perm os.FileMode) error
+	// Create returns a writer storing the named file, replacing
+	// any existing one. The file is complete once the writer
+	// is closed successfully.
+	Create(name string) (io.WriteCloser, error)
+	// Symlink creates newname as a symbolic link to oldname.
+	Symlink(oldname, newname string) error
+	// Chmod changes the mode of the named file.
+	Chmod(name string, mode os.FileMode) error
+	// Chtimes changes the access and modification
+	// times of the named file.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// LinkFS is implemented by the WriteFS
+// implementations supporting hard links.
+type LinkFS interface {
+	WriteFS
+	// Link creates newname as a hard link to oldname.
+	Link(oldname, newname string) error
+}
+
+// ReadFS is a file system TarFromFS archives files from, such as
+// a configuration store. Names are slash separated.
+type ReadFS interface {
+	// Open returns a reader for the contents
+	// of the named regular file.
+	Open(name string) (io.ReadCloser, error)
+	// Stat returns the info of the named file. If the file
+	// is a symlink, it describes the symlink itself.
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the info of the files
+	// in the named directory.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Readlink returns the target of the named symlink.
+	Readlink(name string) (string, error)
+}
+
+// errIsSymlink is returned when OSFS would follow a symlink.
+var errIsSymlink = errors.New("is a symlink")
+
+// OSFS is a WriteFS and a ReadFS storing
+// files in the directory it names.
+type OSFS string
+
+// path returns the local path of the named file.
+func (fsys OSFS) path(name string) (string, error) {
+	return extractPath(string(fsys), name)
+}
+
+// MkdirAll implements WriteFS.
+func (fsys OSFS) MkdirAll(name string, perm os.FileMode) error {
+	p, err := fsys.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// Create implements WriteFS. It refuses to
+// write through an existing symlink.
+func (fsys OSFS) Create(name string) (io.WriteCloser, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errIsSymlink}
+	}
+	// oNoFollow closes the race with a symlink swapped in.
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|oNoFollow, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Symlink implements WriteFS.
+func (fsys OSFS) Symlink(oldname, newname string) error {
+	p, err := fsys.path(newname)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(linkTarget(oldname), p)
+}
+
+// Link implements LinkFS.
+func (fsys OSFS) Link(oldname, newname string) error {
+	oldPath, err := fsys.path(oldname)
+	if err != nil {
+		return err
+	}
+	newPath, err := fsys.path(newname)
+	if err != nil {
+		return err
+	}
+	return os.Link(oldPath, newPath)
+}
+
+// Chmod implements WriteFS. It does not follow symlinks.
+func (fsys OSFS) Chmod(name string, mode os.FileMode) error {
+	p, err := fsys.noSymlink("chmod", name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
+
+// Chtimes implements WriteFS. It does not follow symlinks.
+func (fsys OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := fsys.noSymlink("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, atime, mtime)
+}
+
+// noSymlink returns the local path of the named file,
+// or an error if it is a symlink.
+func (fsys OSFS) noSymlink(op, name string) (string, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return "", &os.PathError{Op: op, Path: p, Err: errIsSymlink}
+	}
+	return p, nil
+}
+
+// Open implements ReadFS.
+func (fsys OSFS) Open(name string) (io.ReadCloser, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat implements ReadFS.
+func (fsys OSFS) Stat(name string) (os.FileInfo, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+// ReadDir implements ReadFS.
+func (fsys OSFS) ReadDir(name string) ([]os.FileInfo, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadDir(p)
+}
+
+// Readlink implements ReadFS.
+func (fsys OSFS) Readlink(name string) (string, error) {
+	p, err := fsys.path(name)
+	if err != nil {
+		return "", err
+	}
+	link, err := os.Readlink(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(link), nil
+}
+
+// TarFromFS writes to w an archive of the files in paths, and of
+// everything below the directories among them, read from fsys rather
+// than from the local disk, and returns its checksum as
+// TarFilesToWriter does. If compress is true, the archive will also be
+// gzip compressed. The entries are named after paths. Symlinks are
+// always archived as symlinks, and files are neither read ahead nor
+// matched against patterns. Owners are only recorded from the info of
+// files whose Sys method returns a *tar.Header, as those of
+// tar.Header.FileInfo do, rather than looked up in the local accounts.
+// Options reading the local disk, such as WithDedup, WithSnapshot or
+// WithFileRange, cannot be used.
+func TarFromFS(fsys ReadFS, paths []string, w io.Writer, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return "", err
+	}
+	var problems []string
+	if o.volumeSize != 0 {
+		problems = append(problems, "WithVolumeSize cannot be used when writing to an io.Writer")
+	}
+	for _, opt := range []struct {
+		set  bool
+		name string
+	}{
+		{o.dereference, "WithDereference"},
+		{o.dedup, "WithDedup"},
+		{o.fileRanges != nil, "WithFileRange"},
+	} {
+		if opt.set {
+			problems = append(problems, opt.name+" cannot be used with TarFromFS")
+		}
+	}
+	if len(problems) > 0 {
+		return "", &ConfigError{Problems: problems}
+	}
+	o.readFS = fsys
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return "", err
+	}
+	err = writeEntries(w, "", compress, shahash, o, func(a *archiver) error {
+		return a.writeList(paths)
+	})
+	if err != nil {
+		return "", err
+	}
+	o.log().Infof("created archive")
+	return encodeArchiveHash(shahash), nil
+}
+
+// openFS opens name in fsys for archiving, as openFile does for
+// local files. Directories are listed by readDirNames instead.
+func openFS(fsys ReadFS, name string) (io.ReadCloser, os.FileInfo, error) {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info = fsFileInfo{info}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return nil, info, nil
+	case info.IsDir():
+		return ioutil.NopCloser(strings.NewReader("")), info, nil
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// fsFileInfo hides the system specific info of a file of a ReadFS,
+// from which tar.FileInfoHeader would look up the owners in the local
+// accounts, unless it is a header.
+type fsFileInfo struct {
+	os.FileInfo
+}
+
+func (fi fsFileInfo) Sys() interface{} {
+	if hdr, ok := fi.FileInfo.Sys().(*tar.Header); ok {
+		return hdr
+	}
+	return nil
+}
+
+// readDirNames returns the names of the files in
+// the directory fileName, opened as f by openFile.
+func (a *archiver) readDirNames(f io.Reader, fileName string) ([]string, error) {
+	fsys := a.opts.readFS
+	if fsys == nil {
+		return f.(*os.File).Readdirnames(-1)
+	}
+	infos, err := fsys.ReadDir(path.Clean(fileName))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, nil
+}
+
+// readlink returns the target of the symlink fileName.
+func (a *archiver) readlink(fileName string) (string, error) {
+	if a.opts.readFS != nil {
+		return a.opts.readFS.Readlink(fileName)
+	}
+	return os.Readlink(fileName)
+}
+
+// separator returns the separator of the
+// names of the files being archived.
+func (a *archiver) separator() string {
+	if a.opts.readFS != nil {
+		return "/"
+	}
+	return string(os.PathSeparator)
+}
+
+// join returns the name of the file
+// called name in the directory dir.
+func (a *archiver) join(dir, name string) string {
+	if a.opts.readFS != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// UntarToFS extracts the archive read from r, which may be encrypted
+// and gzip compressed, into fsys. Entries are selected and renamed as
+// by NewReader, their modes set WithModePolicy and their times
+// restored WithPreserveTimes. Hard links are only extracted if fsys
+// implements LinkFS, and device and fifo entries are extracted as
+// regular files. Entries below a symlink extracted earlier, entries
+// replacing one and hard links to files below one are refused, so
+// that the archive cannot write or read outside of fsys through
+// them. Options only making sense on a local disk, such as
+// WithSecureRoot or WithAtomic, are ignored.
+func UntarToFS(r io.Reader, fsys WriteFS, opts ...Option) error {
+	tr, err := NewReader(r, opts...)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	x := &fsExtractor{
+		fsys:     fsys,
+		opts:     tr.x.opts,
+		symlinks: make(map[string]bool),
+	}
+	for {
+		hdr, contents, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.extract(hdr, contents); err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// fsExtractor extracts entries into a WriteFS.
+type fsExtractor struct {
+	fsys WriteFS
+	opts *options
+
+	// symlinks holds the names of the symlinks extracted so far.
+	symlinks map[string]bool
+	// dirs holds the directories extracted so far, whose
+	// modes and times are set once everything is extracted.
+	dirs []*tar.Header
+}
+
+// extract extracts the entry described by hdr,
+// whose contents are read from r.
+func (x *fsExtractor) extract(hdr *tar.Header, r io.Reader) error {
+	name := cleanManifestPath(hdr.Name)
+	if name == "" {
+		return nil
+	}
+	if dir := x.symlinkParent(name); dir != "" {
+		return fmt.Errorf("cannot extract %q: parent %q is a symlink", name, dir)
+	}
+	if x.symlinks[name] {
+		return fmt.Errorf("cannot extract %q: it would replace a symlink", name)
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := x.fsys.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cannot create directory %q: %v", dir, err)
+		}
+	}
+	mode := x.opts.modePolicy.mode(hdr)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// The directory must stay writable until
+		// everything below it is extracted.
+		if err := x.fsys.MkdirAll(name, 0700|mode.Perm()); err != nil {
+			return fmt.Errorf("cannot create directory %q: %v", name, err)
+		}
+		x.dirs = append(x.dirs, hdr)
+		return nil
+	case tar.TypeSymlink:
+		if err := x.fsys.Symlink(hdr.Linkname, name); err != nil {
+			return fmt.Errorf("cannot extract symlink %q: %v", name, err)
+		}
+		x.symlinks[name] = true
+		return nil
+	case tar.TypeLink:
+		lfs, ok := x.fsys.(LinkFS)
+		if !ok {
+			return fmt.Errorf("cannot extract hard link %q: file system does not support hard links", name)
+		}
+		target := cleanManifestPath(hdr.Linkname)
+		if dir := x.symlinkParent(target); dir != "" {
+			return fmt.Errorf("cannot extract hard link %q: parent %q of its target is a symlink", name, dir)
+		}
+		if err := lfs.Link(target, name); err != nil {
+			return fmt.Errorf("cannot extract hard link %q: %v", name, err)
+		}
+		return nil
+	}
+	if !hdr.FileInfo().Mode().IsRegular() {
+		x.opts.report.degrade(Degradation{
+			Kind:    DegradationType,
+			Path:    hdr.Name,
+			Message: fmt.Sprintf("%s entry extracted as a regular file", entryType(hdr)),
+		})
+	}
+	w, err := x.fsys.Create(name)
+	if err != nil {
+		return fmt.Errorf("cannot create file %q: %v", name, err)
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write file %q: %v", name, err)
+	}
+	if err := x.fsys.Chmod(name, mode); err != nil {
+		return fmt.Errorf("cannot set mode of %q: %v", name, err)
+	}
+	x.restoreTimes(name, hdr)
+	return nil
+}
+
+// symlinkParent returns the first parent of name
+// that is a symlink extracted earlier, if any.
+func (x *fsExtractor) symlinkParent(name string) string {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if x.symlinks[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// restoreTimes restores the times of the entry
+// extracted as name, as requested by WithPreserveTimes.
+func (x *fsExtractor) restoreTimes(name string, hdr *tar.Header) {
+	if !x.opts.preserveTimes || hdr.ModTime.IsZero() {
+		return
+	}
+	atime, mtime := entryTimes(hdr)
+	if err := x.fsys.Chtimes(name, atime, mtime); err != nil {
+		x.opts.report.degrade(Degradation{
+			Kind:    DegradationTimes,
+			Path:    hdr.Name,
+			Message: fmt.Sprintf("cannot restore times: %v", err),
+		})
+	}
+}
+
+// finish sets the modes and times of the extracted
+// directories, deepest directories first.
+func (x *fsExtractor) finish() error {
+	depth := func(hdr *tar.Header) int {
+		return strings.Count(cleanManifestPath(hdr.Name), "/")
+	}
+	sort.SliceStable(x.dirs, func(i, j int) bool {
+		return depth(x.dirs[i]) > depth(x.dirs[j])
+	})
+	for _, hdr := range x.dirs {
+		name := cleanManifestPath(hdr.Name)
+		if err := x.fsys.Chmod(name, x.opts.modePolicy.mode(hdr)); err != nil {
+			return fmt.Errorf("cannot set mode of %q: %v", name, err)
+		}
+		x.restoreTimes(name, hdr)
+	}
+	return nil
+}
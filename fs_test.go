@@ -0,0 +1,330 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+
+	"github.com/juju/tar/tartest"
+)
+
+// memFile is a file stored by memFS.
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	mtime   time.Time
+	link    string
+	symlink bool
+	dir     bool
+}
+
+// memFS is an in-memory WriteFS.
+type memFS map[string]*memFile
+
+func (fsys memFS) MkdirAll(name string, perm os.FileMode) error {
+	for ; name != "."; name = path.Dir(name) {
+		if _, ok := fsys[name]; !ok {
+			fsys[name] = &memFile{dir: true, mode: perm}
+		}
+	}
+	return nil
+}
+
+func (fsys memFS) Create(name string) (io.WriteCloser, error) {
+	f := &memFile{}
+	fsys[name] = f
+	return &memWriter{f: f}, nil
+}
+
+func (fsys memFS) Symlink(oldname, newname string) error {
+	fsys[newname] = &memFile{symlink: true, link: oldname}
+	return nil
+}
+
+func (fsys memFS) Chmod(name string, mode os.FileMode) error {
+	fsys[name].mode = mode
+	return nil
+}
+
+func (fsys memFS) Chtimes(name string, atime, mtime time.Time) error {
+	fsys[name].mtime = mtime.UTC()
+	return nil
+}
+
+func (fsys memFS) Open(name string) (io.ReadCloser, error) {
+	f, ok := fsys[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (fsys memFS) Stat(name string) (os.FileInfo, error) {
+	f, ok := fsys[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     int64(f.mode),
+		Size:     int64(len(f.data)),
+		ModTime:  f.mtime,
+		Typeflag: tar.TypeReg,
+	}
+	switch {
+	case f.dir:
+		hdr.Typeflag = tar.TypeDir
+	case f.symlink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = f.link
+	}
+	return hdr.FileInfo(), nil
+}
+
+func (fsys memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	for p := range fsys {
+		if path.Dir(p) == name {
+			info, _ := fsys.Stat(p)
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func (fsys memFS) Readlink(name string) (string, error) {
+	return fsys[name].link, nil
+}
+
+// memWriter writes the contents of a memFile.
+type memWriter struct {
+	f   *memFile
+	buf bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	w.f.data = w.buf.Bytes()
+	return nil
+}
+
+func (t *TarSuite) TestUntarToFS(c *gc.C) {
+	// The time of the entries built by tartest.
+	mtime := time.Unix(1400000000, 0).UTC()
+	archive := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"root/":             {Mode: 0750},
+		"root/etc/app.conf": {Contents: "conf", Mode: 0640},
+		"root/link":         {Type: tar.TypeSymlink, Linkname: "etc/app.conf"},
+		"root/fifo":         {Type: tar.TypeFifo, Mode: 0600},
+	})
+	fsys := make(memFS)
+	report := &Report{}
+	err := UntarToFS(bytes.NewReader(archive), fsys, WithPreserveTimes(), WithReport(report))
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(fsys["root"], gc.DeepEquals, &memFile{dir: true, mode: 0750, mtime: mtime})
+	c.Assert(fsys["root/etc"].dir, gc.Equals, true)
+	c.Assert(fsys["root/etc/app.conf"], gc.DeepEquals, &memFile{data: []byte("conf"), mode: 0640, mtime: mtime})
+	c.Assert(fsys["root/link"], gc.DeepEquals, &memFile{symlink: true, link: "etc/app.conf"})
+	c.Assert(fsys["root/fifo"].data, gc.HasLen, 0)
+	c.Assert(report.Degradations, gc.HasLen, 1)
+	c.Assert(report.Degradations[0].Kind, gc.Equals, DegradationType)
+}
+
+func (t *TarSuite) TestUntarToFSOptions(c *gc.C) {
+	archive := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"root/keep": {Contents: "keep"},
+		"root/skip": {Contents: "skip"},
+	})
+	fsys := make(memFS)
+	skip := func(hdr *tar.Header) bool {
+		return hdr.Name == "root/skip"
+	}
+	err := UntarToFS(bytes.NewReader(archive), fsys, WithStripComponents(1), WithSkipFunc(skip), WithModePolicy(ModePolicy{FileMode: 0600}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fsys, gc.DeepEquals, memFS{
+		"keep": {data: []byte("keep"), mode: 0600},
+	})
+}
+
+func (t *TarSuite) TestUntarToFSSymlinkParent(c *gc.C) {
+	archive := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"escape":        {Type: tar.TypeSymlink, Linkname: "/etc"},
+		"escape/passwd": {Contents: "root"},
+	})
+	fsys := make(memFS)
+	err := UntarToFS(bytes.NewReader(archive), fsys)
+	c.Assert(err, gc.ErrorMatches, `cannot extract "escape/passwd": parent "escape" is a symlink`)
+	_, ok := fsys["escape/passwd"]
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (t *TarSuite) TestUntarToFSHardLink(c *gc.C) {
+	archive := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"file": {Contents: "data"},
+		"hard": {Type: tar.TypeLink, Linkname: "file"},
+	})
+	err := UntarToFS(bytes.NewReader(archive), make(memFS))
+	c.Assert(err, gc.ErrorMatches, `cannot extract hard link "hard": file system does not support hard links`)
+
+	dir := c.MkDir()
+	err = UntarToFS(bytes.NewReader(archive), OSFS(dir))
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "hard"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "data")
+}
+
+func (t *TarSuite) TestTarFromFS(c *gc.C) {
+	mtime := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := memFS{
+		"config":          {dir: true, mode: 0755, mtime: mtime},
+		"config/app.conf": {data: []byte("conf"), mode: 0640, mtime: mtime},
+		"config/db":       {dir: true, mode: 0700, mtime: mtime},
+		"config/db/url":   {data: []byte("mongodb://"), mode: 0600, mtime: mtime},
+		"config/current":  {symlink: true, link: "app.conf", mtime: mtime},
+		"other":           {data: []byte("not archived"), mode: 0644},
+	}
+	var buf bytes.Buffer
+	shaSum, err := TarFromFS(fsys, []string{"config"}, &buf, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(shaSum, gc.Not(gc.Equals), "")
+
+	extracted := make(memFS)
+	err = UntarToFS(bytes.NewReader(buf.Bytes()), extracted, WithPreserveTimes())
+	c.Assert(err, gc.IsNil)
+	delete(fsys, "other")
+	// Symlink times are not restored.
+	fsys["config/current"].mtime = time.Time{}
+	c.Assert(extracted, gc.DeepEquals, fsys)
+}
+
+func (t *TarSuite) TestTarFromFSOSFS(c *gc.C) {
+	t.createTestFiles(c)
+	var buf bytes.Buffer
+	_, err := TarFromFS(OSFS(t.cwd), []string{"TarDirectoryEmpty", "TarFile1"}, &buf, false)
+	c.Assert(err, gc.IsNil)
+	files, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	want, err := ioutil.ReadFile(filepath.Join(t.cwd, "TarFile1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{"TarFile1": want})
+}
+
+func (t *TarSuite) TestTarFromFSDereference(c *gc.C) {
+	_, err := TarFromFS(make(memFS), []string{"x"}, ioutil.Discard, false, WithDereference())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDereference cannot be used with TarFromFS")
+}
+
+func (t *TarSuite) TestTarFromFSDiskOptions(c *gc.C) {
+	fsys := memFS{"x": {data: []byte("x"), mode: 0644}}
+	_, err := TarFromFS(fsys, []string{"x"}, ioutil.Discard, false, WithDedup(), WithFileRange("x", FileRange{Offset: 1}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: "+
+		"WithDedup cannot be used with TarFromFS; "+
+		"WithFileRange cannot be used with TarFromFS")
+	_, err = TarFromFS(fsys, []string{"x"}, ioutil.Discard, false, WithSnapshot(context.Background(), SnapshotFuncs{}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithSnapshot only applies to TarDirectory")
+}
+
+// sysFS is a memFS whose files have the system
+// specific info of a local file.
+type sysFS struct {
+	memFS
+	local os.FileInfo
+}
+
+func (fsys sysFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fsys.memFS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return sysFileInfo{info, fsys.local.Sys()}, nil
+}
+
+type sysFileInfo struct {
+	os.FileInfo
+	sys interface{}
+}
+
+func (fi sysFileInfo) Sys() interface{} {
+	return fi.sys
+}
+
+func (t *TarSuite) TestTarFromFSOwner(c *gc.C) {
+	local, err := os.Stat(c.MkDir())
+	c.Assert(err, gc.IsNil)
+	fsys := sysFS{memFS{"x": {data: []byte("x"), mode: 0644}}, local}
+	var buf bytes.Buffer
+	_, err = TarFromFS(fsys, []string{"x"}, &buf, false)
+	c.Assert(err, gc.IsNil)
+	hdr, err := tar.NewReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.Uname, gc.Equals, "")
+	c.Assert(hdr.Uid, gc.Equals, 0)
+
+	// Owners given by headers are recorded.
+	owned := sysFS{fsys.memFS, (&tar.Header{Uname: "juju", Uid: 42}).FileInfo()}
+	buf.Reset()
+	_, err = TarFromFS(owned, []string{"x"}, &buf, false)
+	c.Assert(err, gc.IsNil)
+	hdr, err = tar.NewReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.Uname, gc.Equals, "juju")
+	c.Assert(hdr.Uid, gc.Equals, 42)
+}
+
+func (t *TarSuite) TestUntarToFSReplaceSymlink(c *gc.C) {
+	outside := c.MkDir()
+	victim := filepath.Join(outside, "victim")
+	c.Assert(ioutil.WriteFile(victim, []byte("safe"), 0644), gc.IsNil)
+	tarFile := filepath.Join(t.cwd, "evil.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: victim},
+		{Name: "evil", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	archive, err := ioutil.ReadFile(tarFile)
+	c.Assert(err, gc.IsNil)
+	dir := c.MkDir()
+	err = UntarToFS(bytes.NewReader(archive), OSFS(dir))
+	c.Assert(err, gc.ErrorMatches, `cannot extract "evil": it would replace a symlink`)
+	data, err := ioutil.ReadFile(victim)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "safe")
+
+	// OSFS does not follow symlinks either.
+	_, err = OSFS(dir).Create("evil")
+	c.Assert(err, gc.ErrorMatches, `open .*/evil: is a symlink`)
+	err = OSFS(dir).Chmod("evil", 0777)
+	c.Assert(err, gc.ErrorMatches, `chmod .*/evil: is a symlink`)
+	info, err := os.Stat(victim)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0644))
+}
+
+func (t *TarSuite) TestUntarToFSHardLinkThroughSymlink(c *gc.C) {
+	outside := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644), gc.IsNil)
+	archive := tartest.BuildArchive(c, map[string]tartest.Entry{
+		"out":    {Type: tar.TypeSymlink, Linkname: outside},
+		"stolen": {Type: tar.TypeLink, Linkname: "out/secret"},
+	})
+	dir := c.MkDir()
+	err := UntarToFS(bytes.NewReader(archive), OSFS(dir))
+	c.Assert(err, gc.ErrorMatches, `cannot extract hard link "stolen": parent "out" of its target is a symlink`)
+	_, err = os.Lstat(filepath.Join(dir, "stolen"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
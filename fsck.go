@@ -0,0 +1,375 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ProblemKind identifies the kind of an ArchiveProblem.
+type ProblemKind string
+
+const (
+	// ProblemChecksum is reported for headers whose checksum does
+	// not match; the rest of the archive cannot be checked.
+	ProblemChecksum ProblemKind = "checksum"
+	// ProblemHeader is reported for headers holding invalid
+	// fields, and for unexpected blocks of zeros.
+	ProblemHeader ProblemKind = "header"
+	// ProblemEncoding is reported for entry names that are
+	// not valid UTF-8.
+	ProblemEncoding ProblemKind = "encoding"
+	// ProblemSize is reported for entries whose size is
+	// invalid or does not suit their type.
+	ProblemSize ProblemKind = "size"
+	// ProblemTruncated is reported when the archive
+	// ends in the middle of an entry.
+	ProblemTruncated ProblemKind = "truncated"
+	// ProblemTrailer is reported when the archive does not end
+	// with the two blocks of zeros marking its end.
+	ProblemTrailer ProblemKind = "trailer"
+	// ProblemCompression is reported when the gzip
+	// stream holding the archive is corrupt.
+	ProblemCompression ProblemKind = "compression"
+)
+
+// ArchiveProblem describes a problem found by ValidateArchive.
+type ArchiveProblem struct {
+	Kind ProblemKind
+	// Offset is the offset in the uncompressed archive
+	// of the header of the entry with the problem.
+	Offset int64
+	// Name is the name of the entry, if known.
+	Name    string
+	Message string
+}
+
+func (p ArchiveProblem) String() string {
+	if p.Name == "" {
+		return fmt.Sprintf("offset %d: %s", p.Offset, p.Message)
+	}
+	return fmt.Sprintf("offset %d: %q: %s", p.Offset, p.Name, p.Message)
+}
+
+// blockSize is the size of the blocks of a tar archive.
+const blockSize = 512
+
+// ValidateArchive reads the whole archive from r, which may be gzip
+// compressed, and checks its format without extracting anything: the
+// header checksums and fields, the encoding of the names, the sizes of
+// the entries, and that the archive is neither truncated nor missing
+// its end-of-archive blocks. It returns the problems found, stopping
+// at the first one that prevents reading further. An error is only
+// returned if r cannot be read, along with the problems found until
+// then.
+func ValidateArchive(r io.Reader) ([]ArchiveProblem, error) {
+	compressed, r, err := sniffGzip(r)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		gzr, err := newGzipReader(r)
+		if err != nil {
+			return []ArchiveProblem{{Kind: ProblemCompression, Message: err.Error()}}, nil
+		}
+		r = gzr
+	}
+	v := &archiveValidator{r: r}
+	if err := v.run(); err != nil && err != errStop {
+		return v.problems, err
+	}
+	return v.problems, nil
+}
+
+// archiveValidator holds the state of ValidateArchive.
+type archiveValidator struct {
+	r        io.Reader
+	offset   int64
+	problems []ArchiveProblem
+
+	// longName and paxName hold the name given to the next
+	// entry by a GNU long name or a PAX header, and paxSize
+	// its size given by a PAX header, if not negative.
+	longName string
+	paxName  string
+	paxSize  int64
+}
+
+// errStop stops the validation after a problem
+// preventing reading further.
+var errStop = fmt.Errorf("validation stopped")
+
+func (v *archiveValidator) problem(kind ProblemKind, offset int64, name, format string, args ...interface{}) {
+	v.problems = append(v.problems, ArchiveProblem{
+		Kind:    kind,
+		Offset:  offset,
+		Name:    name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// read reads the next block, reporting truncation
+// and corrupt compression as problems.
+func (v *archiveValidator) read(block []byte, name string) error {
+	_, err := io.ReadFull(v.r, block)
+	switch err {
+	case nil:
+		v.offset += blockSize
+		return nil
+	case io.EOF:
+		return io.EOF
+	case io.ErrUnexpectedEOF:
+		v.problem(ProblemTruncated, v.offset, name, "archive ends in the middle of a block")
+		return errStop
+	case gzip.ErrChecksum, gzip.ErrHeader:
+		v.problem(ProblemCompression, v.offset, name, "%v", err)
+		return errStop
+	}
+	if _, ok := err.(flate.CorruptInputError); ok {
+		v.problem(ProblemCompression, v.offset, name, "%v", err)
+		return errStop
+	}
+	return err
+}
+
+func (v *archiveValidator) run() error {
+	v.paxSize = -1
+	block := make([]byte, blockSize)
+	for {
+		offset := v.offset
+		err := v.read(block, "")
+		if err == io.EOF {
+			v.problem(ProblemTrailer, offset, "", "archive ends without end-of-archive blocks")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isZeroBlock(block) {
+			err := v.read(block, "")
+			if err == io.EOF {
+				v.problem(ProblemTrailer, offset, "", "archive ends with a single end-of-archive block")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if isZeroBlock(block) {
+				return nil
+			}
+			v.problem(ProblemHeader, offset, "", "unexpected block of zeros")
+			offset += blockSize
+		}
+		if err := v.entry(offset, block); err != nil {
+			return err
+		}
+	}
+}
+
+// entry checks the entry with the given header
+// block, and reads past its contents.
+func (v *archiveValidator) entry(offset int64, block []byte) error {
+	name := v.headerName(block)
+	if !validChecksum(block) {
+		v.problem(ProblemChecksum, offset, name, "header checksum does not match")
+		return errStop
+	}
+	size, err := parseNumeric(block[124:136])
+	if err != nil || size < 0 {
+		v.problem(ProblemHeader, offset, name, "invalid size field %q", strings.TrimRight(string(block[124:136]), "\x00"))
+		return errStop
+	}
+	typeflag := block[156]
+	if v.paxSize >= 0 && typeflag != 'x' && typeflag != 'g' && typeflag != 'L' && typeflag != 'K' {
+		size = v.paxSize
+	}
+	switch typeflag {
+	case 'x', 'g', 'L', 'K':
+		contents, err := v.contents(size, name)
+		if err != nil {
+			return err
+		}
+		switch typeflag {
+		case 'x':
+			v.pax(offset, name, contents)
+		case 'L':
+			v.longName = string(bytes.TrimRight(contents, "\x00"))
+		}
+		return nil
+	}
+	if name = v.entryName(block); !utf8.ValidString(name) {
+		v.problem(ProblemEncoding, offset, name, "name is not valid UTF-8")
+	}
+	v.longName, v.paxName, v.paxSize = "", "", -1
+	switch typeflag {
+	case '1', '2', '3', '4', '5', '6':
+		if size != 0 {
+			v.problem(ProblemSize, offset, name, "%s entry has a size of %d", entryType(&tar.Header{Typeflag: typeflag}), size)
+		}
+		// These entries have no contents,
+		// whatever their size field says.
+		return nil
+	}
+	_, err = v.contents(size, name)
+	return err
+}
+
+// contents reads the contents of an entry of the given size.
+func (v *archiveValidator) contents(size int64, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	block := make([]byte, blockSize)
+	start := v.offset
+	for remaining := size; remaining > 0; remaining -= blockSize {
+		err := v.read(block, name)
+		if err == io.EOF {
+			v.problem(ProblemTruncated, start-blockSize, name, "archive ends after %d of %d bytes of contents", v.offset-start, size)
+			return nil, errStop
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Only extended headers are kept, which are small.
+		if buf.Len() < 1<<20 {
+			n := int64(blockSize)
+			if remaining < n {
+				n = remaining
+			}
+			buf.Write(block[:n])
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// pax checks the records of a PAX header.
+func (v *archiveValidator) pax(offset int64, name string, records []byte) {
+	for len(records) > 0 {
+		sp := bytes.IndexByte(records, ' ')
+		if sp < 0 {
+			v.problem(ProblemHeader, offset, name, "invalid PAX record")
+			return
+		}
+		n, err := strconv.Atoi(string(records[:sp]))
+		if err != nil || n <= sp || n > len(records) || records[n-1] != '\n' {
+			v.problem(ProblemHeader, offset, name, "invalid PAX record")
+			return
+		}
+		record := string(records[sp+1 : n-1])
+		records = records[n:]
+		eq := strings.IndexByte(record, '=')
+		if eq < 0 {
+			v.problem(ProblemHeader, offset, name, "invalid PAX record %q", record)
+			continue
+		}
+		key, value := record[:eq], record[eq+1:]
+		switch key {
+		case "path":
+			v.paxName = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				v.problem(ProblemSize, offset, name, "invalid PAX size %q", value)
+				continue
+			}
+			v.paxSize = size
+		}
+		if !utf8.ValidString(value) && (key == "path" || key == "linkpath" || key == "uname" || key == "gname") {
+			v.problem(ProblemEncoding, offset, name, "PAX %s is not valid UTF-8", key)
+		}
+	}
+}
+
+// headerName returns the name recorded in a header block. Only
+// POSIX ustar headers have a name prefix: GNU headers, whose magic
+// is "ustar  ", hold other fields in its place.
+func (v *archiveValidator) headerName(block []byte) string {
+	name := cString(block[0:100])
+	if string(block[257:263]) == "ustar\x00" {
+		if prefix := cString(block[345:500]); prefix != "" {
+			name = prefix + "/" + name
+		}
+	}
+	return name
+}
+
+// entryName returns the name of the entry with the given header
+// block, taking the preceding extended headers into account.
+func (v *archiveValidator) entryName(block []byte) string {
+	switch {
+	case v.paxName != "":
+		return v.paxName
+	case v.longName != "":
+		return v.longName
+	}
+	return v.headerName(block)
+}
+
+// validChecksum reports whether the checksum of the header block
+// matches, computed with either unsigned or signed bytes as both
+// are found in the wild.
+func validChecksum(block []byte) bool {
+	recorded, err := parseNumeric(block[148:156])
+	if err != nil {
+		return false
+	}
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return recorded == unsigned || recorded == signed
+}
+
+// parseNumeric parses a numeric header field, in octal
+// or in the base-256 encoding used for large values.
+func parseNumeric(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		if field[0]&0x40 != 0 {
+			return -1, nil
+		}
+		var n int64
+		for i, b := range field {
+			if i == 0 {
+				b &= 0x7f
+			}
+			if n > (1<<63-1)>>8 {
+				return 0, fmt.Errorf("numeric field overflows")
+			}
+			n = n<<8 | int64(b)
+		}
+		return n, nil
+	}
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 8, 64)
+}
+
+// cString returns the string held by a NUL terminated field.
+func cString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return string(field)
+}
+
+func isZeroBlock(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
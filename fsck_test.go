@@ -0,0 +1,143 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing/iotest"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+// validArchive returns an archive holding a file, a directory,
+// and a file with a long name stored in a PAX header.
+func validArchive(c *gc.C) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	long := "dir/" + string(bytes.Repeat([]byte("x"), 150))
+	for _, hdr := range []*tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644, Size: 600},
+		{Name: long, Typeflag: tar.TypeReg, Mode: 0644, Size: 3, Format: tar.FormatPAX},
+	} {
+		c.Assert(tw.WriteHeader(hdr), gc.IsNil)
+		_, err := tw.Write(bytes.Repeat([]byte("a"), int(hdr.Size)))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	return buf.Bytes()
+}
+
+func (t *TarSuite) TestValidateArchive(c *gc.C) {
+	data := validArchive(c)
+	problems, err := ValidateArchive(bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.HasLen, 0)
+
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(data)
+	c.Assert(gzw.Close(), gc.IsNil)
+	problems, err = ValidateArchive(&gz)
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.HasLen, 0)
+
+	// The archive written by TarFiles is valid too.
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	contents, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	problems, err = ValidateArchive(bytes.NewReader(contents))
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestValidateArchiveProblems(c *gc.C) {
+	valid := validArchive(c)
+	corrupt := func(f func(data []byte) []byte) []byte {
+		data := make([]byte, len(valid))
+		copy(data, valid)
+		return f(data)
+	}
+	// Entry offsets: dir/ at 0, dir/file at 512 with contents
+	// in two blocks, and the PAX header of the long name at 1536.
+	for i, test := range []struct {
+		data     []byte
+		problems []ArchiveProblem
+	}{{
+		data: corrupt(func(data []byte) []byte {
+			data[512+3] = 'Z'
+			return data
+		}),
+		problems: []ArchiveProblem{{Kind: ProblemChecksum, Offset: 512, Name: "dirZfile", Message: "header checksum does not match"}},
+	}, {
+		data:     valid[:512+512+100],
+		problems: []ArchiveProblem{{Kind: ProblemTruncated, Offset: 1024, Name: "dir/file", Message: "archive ends in the middle of a block"}},
+	}, {
+		data:     valid[:512+512],
+		problems: []ArchiveProblem{{Kind: ProblemTruncated, Offset: 512, Name: "dir/file", Message: "archive ends after 0 of 600 bytes of contents"}},
+	}, {
+		data:     valid[:len(valid)-1024],
+		problems: []ArchiveProblem{{Kind: ProblemTrailer, Offset: int64(len(valid) - 1024), Message: "archive ends without end-of-archive blocks"}},
+	}, {
+		data:     valid[:len(valid)-512],
+		problems: []ArchiveProblem{{Kind: ProblemTrailer, Offset: int64(len(valid) - 1024), Message: "archive ends with a single end-of-archive block"}},
+	}, {
+		data:     rawArchive(c, &tar.Header{Name: "bad\xffname", Typeflag: tar.TypeSymlink, Linkname: "x", Format: tar.FormatGNU}),
+		problems: []ArchiveProblem{{Kind: ProblemEncoding, Name: "bad\xffname", Message: "name is not valid UTF-8"}},
+	}, {
+		// GNU headers hold the access time where
+		// ustar headers hold the name prefix.
+		data: rawArchive(c, &tar.Header{
+			Name:       "bad\xffgnu",
+			Typeflag:   tar.TypeSymlink,
+			Linkname:   "x",
+			AccessTime: time.Unix(1234567890, 0),
+			Format:     tar.FormatGNU,
+		}),
+		problems: []ArchiveProblem{{Kind: ProblemEncoding, Name: "bad\xffgnu", Message: "name is not valid UTF-8"}},
+	}, {
+		data: func() []byte {
+			var gz bytes.Buffer
+			gzw := gzip.NewWriter(&gz)
+			gzw.Write(valid)
+			c.Assert(gzw.Close(), gc.IsNil)
+			data := gz.Bytes()
+			// Make the first deflate block use the reserved type.
+			data[10] = 0x07
+			return data
+		}(),
+		problems: []ArchiveProblem{{Kind: ProblemCompression, Message: "flate: corrupt input before offset 1"}},
+	}} {
+		problems, err := ValidateArchive(bytes.NewReader(test.data))
+		c.Check(err, gc.IsNil)
+		c.Check(problems, gc.DeepEquals, test.problems, gc.Commentf("test %d", i))
+	}
+
+	// Problems found before a read error are returned with it.
+	bad := rawArchive(c, &tar.Header{Name: "bad\xffname", Typeflag: tar.TypeSymlink, Linkname: "x", Format: tar.FormatGNU})
+	r := io.MultiReader(bytes.NewReader(bad[:512]), iotest.ErrReader(errors.New("disk on fire")))
+	problems, err := ValidateArchive(r)
+	c.Check(err, gc.ErrorMatches, "disk on fire")
+	c.Check(problems, gc.DeepEquals, []ArchiveProblem{{Kind: ProblemEncoding, Name: "bad\xffname", Message: "name is not valid UTF-8"}})
+}
+
+// rawArchive returns an archive holding a single entry
+// with the given header and no contents.
+func rawArchive(c *gc.C, hdr *tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	c.Assert(tw.WriteHeader(hdr), gc.IsNil)
+	c.Assert(tw.Close(), gc.IsNil)
+	return buf.Bytes()
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// globMeta holds the characters that make a name a glob pattern.
+const globMeta = `*?[`
+
+// expandGlobs returns fileList with every pattern replaced by the
+// files it matches, in lexical order. Names of existing files are
+// kept as they are, even when they hold pattern characters.
+//
+// Patterns follow filepath.Match, except that a "**" path element
+// matches any number of directories, including none. Matches below
+// another match are dropped, as archiving a directory archives
+// everything below it.
+func expandGlobs(fileList []string) ([]string, error) {
+	var expanded []string
+	for _, name := range fileList {
+		if !strings.ContainsAny(name, globMeta) {
+			expanded = append(expanded, name)
+			continue
+		}
+		if _, err := os.Lstat(name); err == nil {
+			expanded = append(expanded, name)
+			continue
+		}
+		matches, err := glob(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand %q: %v", name, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("cannot expand %q: no files match", name)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// glob returns the files matching pattern, leaving out those
+// below another match.
+func glob(pattern string) ([]string, error) {
+	elems := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	// The walk starts at the longest leading path holding no pattern.
+	base := 0
+	for base < len(elems)-1 && !strings.ContainsAny(elems[base], globMeta) {
+		base++
+	}
+	root := filepath.FromSlash(strings.Join(elems[:base], "/"))
+	switch {
+	case base == 1 && elems[0] == "":
+		root = string(filepath.Separator)
+	case root == "":
+		root = "."
+	}
+	for _, elem := range elems[base:] {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	recursive := false
+	for _, elem := range elems[base:] {
+		recursive = recursive || elem == "**"
+	}
+	var matches []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		relElems := strings.Split(filepath.ToSlash(rel), "/")
+		if matchElems(elems[base:], relElems) {
+			matches = append(matches, p)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Without "**" nothing deeper than the pattern can match.
+		if info.IsDir() && !recursive && len(relElems) >= len(elems)-base {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// matchElems reports whether the path elements
+// in name match the pattern elements.
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesGlobs(c *gc.C) {
+	for _, dir := range []string{"agents/machine-0", "agents/unit-1", "etc/juju/nested"} {
+		c.Assert(os.MkdirAll(filepath.Join(t.cwd, dir), 0755), gc.IsNil)
+	}
+	for _, name := range []string{
+		"agents/machine-0/agent.conf",
+		"agents/unit-1/agent.conf",
+		"etc/juju/juju.conf",
+		"etc/juju/nested/deep.conf",
+		"etc/juju/notes.txt",
+		"star*",
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(t.cwd, name), []byte(name), 0644), gc.IsNil)
+	}
+	outputTar := filepath.Join(t.cwd, "globs.tar")
+	for _, test := range []struct {
+		patterns []string
+		names    []string
+	}{{
+		patterns: []string{"agents/*"},
+		names: []string{
+			"agents/machine-0", "agents/machine-0/agent.conf",
+			"agents/unit-1", "agents/unit-1/agent.conf",
+		},
+	}, {
+		patterns: []string{"etc/**/*.conf"},
+		names:    []string{"etc/juju/juju.conf", "etc/juju/nested/deep.conf"},
+	}, {
+		patterns: []string{"**/agent.conf", "star*"},
+		names:    []string{"agents/machine-0/agent.conf", "agents/unit-1/agent.conf", "star*"},
+	}, {
+		patterns: []string{"etc/juju/*.txt"},
+		names:    []string{"etc/juju/notes.txt"},
+	}} {
+		var fileList []string
+		for _, p := range test.patterns {
+			fileList = append(fileList, filepath.Join(t.cwd, p))
+		}
+		_, err := TarFiles(fileList, outputTar, t.cwd+"/", false)
+		c.Assert(err, gc.IsNil)
+		var names []string
+		for name := range readHeaders(c, outputTar) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.Check(names, gc.DeepEquals, test.names, gc.Commentf("%v", test.patterns))
+	}
+
+	_, err := TarFiles([]string{filepath.Join(t.cwd, "missing/*")}, outputTar, "", false)
+	c.Assert(err, gc.ErrorMatches, `backup failed: cannot expand ".*missing/\*": no files match`)
+	_, err = TarFiles([]string{filepath.Join(t.cwd, "[")}, outputTar, "", false)
+	c.Assert(err, gc.ErrorMatches, `backup failed: cannot expand ".*\[": syntax error in pattern`)
+}
+
+func (t *TarSuite) TestMatchElems(c *gc.C) {
+	for _, test := range []struct {
+		pattern, name []string
+		match         bool
+	}{
+		{[]string{"**"}, []string{"a", "b"}, true},
+		{[]string{"**", "*.conf"}, []string{"x.conf"}, true},
+		{[]string{"**", "*.conf"}, []string{"a", "b", "x.conf"}, true},
+		{[]string{"**", "*.conf"}, []string{"a", "x.txt"}, false},
+		{[]string{"a", "**", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "*"}, []string{"a", "b", "c"}, false},
+	} {
+		c.Check(matchElems(test.pattern, test.name), gc.Equals, test.match, gc.Commentf("%v %v", test.pattern, test.name))
+	}
+}
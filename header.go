@@ -0,0 +1,116 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+)
+
+// Strictness decides what UntarFiles does with an entry
+// whose type it does not know.
+type Strictness int
+
+const (
+	// Strict fails the extraction with a *HeaderError.
+	// This is the default.
+	Strict Strictness = iota
+	// Lenient skips the entry and carries on extracting.
+	Lenient
+)
+
+// WithStrictness returns an Option that makes UntarFiles
+// handle entries of unknown type as described by s.
+func WithStrictness(s Strictness) Option {
+	return func(o *options) {
+		o.strictness = s
+	}
+}
+
+// validate appends to problems any problem with the strictness.
+func (s Strictness) validate(problems []string) []string {
+	if s < Strict || s > Lenient {
+		problems = append(problems, fmt.Sprintf("unknown strictness %d", s))
+	}
+	return problems
+}
+
+// HeaderError is returned by UntarFiles when an entry
+// has a malformed header.
+type HeaderError struct {
+	// Name is the name of the entry.
+	Name string
+	// Problem describes what is wrong with the header.
+	Problem string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid header for %q: %s", e.Name, e.Problem)
+}
+
+// headerModeBits holds the bits of a header mode that tar
+// implementations set: the permissions, setuid, setgid and
+// sticky bits and the C_ISxxx file type bits.
+const headerModeBits = 07777 | 0170000
+
+// knownTypes holds the type flags of the entries UntarFiles extracts.
+var knownTypes = map[byte]bool{
+	tar.TypeReg:           true,
+	tar.TypeRegA:          true,
+	tar.TypeLink:          true,
+	tar.TypeSymlink:       true,
+	tar.TypeChar:          true,
+	tar.TypeBlock:         true,
+	tar.TypeDir:           true,
+	tar.TypeFifo:          true,
+	tar.TypeCont:          true,
+	tar.TypeGNUSparse:     true,
+	tar.TypeXGlobalHeader: true,
+}
+
+// hasParentRef reports whether the slash separated
+// name has a ".." component.
+func hasParentRef(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHeader returns a *HeaderError if hdr is malformed.
+// It reports whether the entry must be skipped, either because
+// it carries no file or because its type is unknown and the
+// extraction is lenient.
+func (x *extractor) checkHeader(hdr *tar.Header) (skip bool, err error) {
+	var problem string
+	switch {
+	case strings.IndexByte(hdr.Name, 0) >= 0:
+		problem = "name contains a NUL byte"
+	case strings.IndexByte(hdr.Linkname, 0) >= 0:
+		problem = "link name contains a NUL byte"
+	case hasParentRef(hdr.Name):
+		problem = "name refers to a parent directory"
+	case hdr.Size < 0:
+		problem = fmt.Sprintf("negative size %d", hdr.Size)
+	case hdr.Mode < 0 || hdr.Mode&^headerModeBits != 0:
+		problem = fmt.Sprintf("invalid mode %#o", hdr.Mode)
+	case !knownTypes[hdr.Typeflag]:
+		if x.opts.strictness == Lenient {
+			x.opts.log().Warnf("skipping %q: unknown type %q", hdr.Name, hdr.Typeflag)
+			return true, nil
+		}
+		problem = fmt.Sprintf("unknown type %q", hdr.Typeflag)
+	case hdr.Typeflag == tar.TypeXGlobalHeader:
+		// Global headers hold metadata for the
+		// entries that follow, not a file.
+		x.opts.log().Debugf("skipping %q: global header", hdr.Name)
+		return true, nil
+	default:
+		return false, nil
+	}
+	return false, &HeaderError{Name: hdr.Name, Problem: problem}
+}
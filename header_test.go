@@ -0,0 +1,142 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestUntarFilesUnknownTypeStrict(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "unknown.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "vendor", Typeflag: 'Z', Mode: 0644},
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false)
+	herr, ok := err.(*HeaderError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("got %#v", err))
+	c.Assert(herr.Name, gc.Equals, "vendor")
+	c.Assert(err, gc.ErrorMatches, `invalid header for "vendor": unknown type 'Z'`)
+	_, err = os.Lstat(filepath.Join(outputDir, "vendor"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestUntarFilesUnknownTypeLenient(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "unknown.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "vendor", Typeflag: 'Z', Mode: 0644},
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false, WithStrictness(Lenient))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Lstat(filepath.Join(outputDir, "vendor"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Lstat(filepath.Join(outputDir, "file"))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestUntarFilesInvalidMode(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "mode.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 01000644},
+	})
+	err := UntarFiles(tarFile, c.MkDir(), false, WithStrictness(Lenient))
+	c.Assert(err, gc.FitsTypeOf, &HeaderError{})
+	c.Assert(err, gc.ErrorMatches, `invalid header for "file": invalid mode 01000644`)
+}
+
+func (t *TarSuite) TestUntarFilesGlobalHeaderSkipped(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "global.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "x"}},
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	infos, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 1)
+	c.Assert(infos[0].Name(), gc.Equals, "file")
+}
+
+func (t *TarSuite) TestCheckHeader(c *gc.C) {
+	x := &extractor{opts: newOptions(nil)}
+	for i, test := range []struct {
+		hdr     tar.Header
+		problem string
+	}{{
+		hdr:     tar.Header{Name: "a\x00b", Typeflag: tar.TypeReg},
+		problem: "name contains a NUL byte",
+	}, {
+		hdr:     tar.Header{Name: "link", Linkname: "a\x00b", Typeflag: tar.TypeSymlink},
+		problem: "link name contains a NUL byte",
+	}, {
+		hdr:     tar.Header{Name: "file", Size: -1, Typeflag: tar.TypeReg},
+		problem: "negative size -1",
+	}, {
+		hdr:     tar.Header{Name: "file", Mode: -1, Typeflag: tar.TypeReg},
+		problem: "invalid mode -01",
+	}, {
+		hdr: tar.Header{Name: "file", Mode: 0104755, Typeflag: tar.TypeReg},
+	}} {
+		c.Logf("test %d: %q", i, test.hdr.Name)
+		skip, err := x.checkHeader(&test.hdr)
+		c.Check(skip, gc.Equals, false)
+		if test.problem == "" {
+			c.Check(err, gc.IsNil)
+			continue
+		}
+		c.Check(err, gc.DeepEquals, &HeaderError{Name: test.hdr.Name, Problem: test.problem})
+	}
+}
+
+func (t *TarSuite) TestWithStrictnessInvalid(c *gc.C) {
+	err := UntarFiles("x.tar", c.MkDir(), false, WithStrictness(Strictness(5)))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: unknown strictness 5")
+	_, err = TarFiles([]string{"x"}, "x.tar", "", false, WithStrictness(Lenient))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithStrictness only applies to extraction")
+}
+
+// FuzzUntarFiles checks that extracting arbitrary
+// archives returns errors rather than panicking.
+func FuzzUntarFiles(f *testing.F) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file"})
+	tw.Close()
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dir := t.TempDir()
+		tarFile := filepath.Join(dir, "fuzz.tar")
+		if err := ioutil.WriteFile(tarFile, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		UntarFiles(tarFile, filepath.Join(dir, "out"), false, WithStrictness(Lenient))
+	})
+}
+
+func (t *TarSuite) TestUntarParentRef(c *gc.C) {
+	outside := c.MkDir()
+	tarFile := filepath.Join(t.cwd, "dotdot.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "../pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := UntarFiles(tarFile, filepath.Join(outside, "output"), false)
+	c.Assert(err, gc.ErrorMatches, `invalid header for "../pwned": name refers to a parent directory`)
+	_, err = os.Lstat(filepath.Join(outside, "pwned"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
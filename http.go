@@ -0,0 +1,98 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// tarContentType and gzipContentType are the content
+	// types of uncompressed and compressed archives.
+	tarContentType  = "application/x-tar"
+	gzipContentType = "application/gzip"
+)
+
+// maxUploadSize is the largest archive ReceiveTar accepts.
+const maxUploadSize = 1 << 30
+
+// defaultUploadLimits are the limits ReceiveTar extracts
+// archives with when no others are given WithLimits.
+var defaultUploadLimits = Limits{
+	MaxTotalSize: 4 << 30,
+	MaxEntries:   100000,
+	MaxFileSize:  1 << 30,
+	MaxDepth:     64,
+}
+
+// ServeTar writes an archive of the files in fileList, created as
+// by TarFilesToWriter, as the response w. The Content-Type is set,
+// and as the checksum is only known once the archive is written, it
+// is sent in an RFC 3230 Digest trailer.
+func ServeTar(w http.ResponseWriter, fileList []string, strip string, compress bool, opts ...Option) error {
+	contentType := tarContentType
+	if compress {
+		contentType = gzipContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Trailer", "Digest")
+	shaSum, err := TarFilesToWriter(fileList, w, strip, compress, opts...)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Digest", FormatDigestHeader(DigestSHA, shaSum))
+	return nil
+}
+
+// ReceiveTar extracts the archive uploaded as the body of r into
+// dest, as UntarFiles does. The archive is taken to be compressed if
+// the Content-Type of the request says so. If the request has an RFC
+// 3230 Digest header with a SHA checksum, as sent by ServeTar, the
+// body is checked against it before anything is extracted.
+//
+// As the archive comes from a client, uploads larger than 1GiB are
+// refused and, unless other limits are given WithLimits, extraction
+// is bounded to 4GiB in 100000 entries of at most 1GiB, 64 levels
+// deep. Entries are never extracted outside of dest, but symlinks
+// already in dest are followed unless WithSecureExtraction is used.
+func ReceiveTar(r *http.Request, dest string, opts ...Option) error {
+	o := newOptions(opts)
+	if o.limits == (Limits{}) {
+		opts = append(opts[:len(opts):len(opts)], WithLimits(defaultUploadLimits))
+	}
+	tmp := newRunDir(o.tempDir)
+	defer tmp.remove()
+	f, err := tmp.tempFile("upload")
+	if err != nil {
+		return fmt.Errorf("cannot create staging file: %v", err)
+	}
+	defer f.Close()
+	shahash := sha1.New()
+	n, err := io.Copy(io.MultiWriter(f, shahash), io.LimitReader(r.Body, maxUploadSize+1))
+	if err != nil {
+		return fmt.Errorf("cannot read uploaded archive: %v", err)
+	}
+	if n > maxUploadSize {
+		return fmt.Errorf("uploaded archive is larger than %d bytes", int64(maxUploadSize))
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot write staging file: %v", err)
+	}
+	digests, err := ParseDigestHeader(r.Header.Get("Digest"))
+	if err != nil {
+		return fmt.Errorf("cannot parse Digest header: %v", err)
+	}
+	if want, ok := digests[DigestSHA]; ok {
+		if got := encodeArchiveHash(shahash); got != want {
+			return fmt.Errorf("uploaded archive has checksum %q, not %q", got, want)
+		}
+	}
+	contentType := r.Header.Get("Content-Type")
+	compressed := strings.HasPrefix(contentType, gzipContentType) || strings.HasPrefix(contentType, "application/x-gzip")
+	return UntarFiles(f.Name(), dest, compressed, opts...)
+}
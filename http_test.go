@@ -0,0 +1,69 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestServeAndReceiveTar(c *gc.C) {
+	t.createTestFiles(c)
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := ServeTar(w, t.testFiles, trimPath, true)
+		c.Check(err, gc.IsNil)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	c.Assert(err, gc.IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/gzip")
+	digest := resp.Trailer.Get("Digest")
+	c.Assert(digest, gc.Matches, "SHA=.*")
+
+	req, err := http.NewRequest("PUT", "/", bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Digest", digest)
+	outputDir := c.MkDir()
+	err = ReceiveTar(req, outputDir)
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+
+	req, err = http.NewRequest("PUT", "/", bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Digest", "SHA=bogus")
+	err = ReceiveTar(req, c.MkDir())
+	c.Assert(err, gc.ErrorMatches, `uploaded archive has checksum ".*", not "bogus"`)
+}
+
+func (t *TarSuite) TestReceiveTarDefaultLimits(c *gc.C) {
+	deep := strings.Repeat("d/", 64) + "file"
+	tarFile := filepath.Join(t.cwd, "deep.tar")
+	writeContentsArchive(c, tarFile, []testEntry{{deep, "deep"}})
+	body, err := ioutil.ReadFile(tarFile)
+	c.Assert(err, gc.IsNil)
+
+	req, err := http.NewRequest("PUT", "/", bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	err = ReceiveTar(req, c.MkDir())
+	c.Assert(err, gc.ErrorMatches, `extraction of ".*/file" exceeds MaxDepth of 64`)
+
+	req, err = http.NewRequest("PUT", "/", bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	err = ReceiveTar(req, c.MkDir(), WithLimits(Limits{MaxEntries: 1}))
+	c.Assert(err, gc.IsNil)
+}
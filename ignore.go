@@ -0,0 +1,180 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WithIgnoreFile returns an Option that makes TarFiles read the files
+// called name, such as ".tarignore", found in the directories being
+// archived, and leave out the files below those directories that
+// they match, so that applications can describe what must never end
+// up in a backup.
+//
+// Ignore files follow the gitignore syntax: blank lines and lines
+// starting with "#" are ignored; a pattern ending with "/" only
+// matches directories; a pattern holding another "/" is relative to
+// the directory of the ignore file, while one without matches at any
+// depth; "**" matches any number of directories; and a pattern
+// starting with "!" includes again what an earlier one left out. The
+// patterns of deeper ignore files take precedence. As for git, a file
+// cannot be included again once its directory is left out. The ignore
+// files themselves are archived unless they match a pattern.
+func WithIgnoreFile(name string) Option {
+	return func(o *options) {
+		o.ignoreFile = name
+	}
+}
+
+// validateIgnoreFile appends to problems the
+// reasons why name cannot be used.
+func validateIgnoreFile(name string, problems []string) []string {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		problems = append(problems, fmt.Sprintf("WithIgnoreFile needs a file name, not %q", name))
+	}
+	return problems
+}
+
+// ignoreRule is a pattern read from an ignore file.
+type ignoreRule struct {
+	// elems holds the path elements of the pattern.
+	elems []string
+	// negate is set if the pattern includes files again.
+	negate bool
+	// dirOnly is set if the pattern only matches directories.
+	dirOnly bool
+}
+
+// ignoreSet holds the rules of the
+// ignore file found in dir.
+type ignoreSet struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// parseIgnore returns the rules read from r.
+func parseIgnore(r io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			// An escaped leading "#" or "!".
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		rule.elems = strings.Split(path.Clean(line), "/")
+		for _, elem := range rule.elems {
+			if _, err := filepath.Match(elem, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", scanner.Text(), err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// readIgnores reads the ignore file of the directory dir, which
+// ends with a separator, if WithIgnoreFile was given. The returned
+// function drops its rules once the directory is archived.
+func (a *archiver) readIgnores(dir string) (func(), error) {
+	name := a.opts.ignoreFile
+	if name == "" {
+		return func() {}, nil
+	}
+	fileName := a.join(dir, name)
+	var f io.ReadCloser
+	var err error
+	if a.opts.readFS != nil {
+		f, err = a.opts.readFS.Open(fileName)
+	} else {
+		f, err = os.Open(fileName)
+	}
+	if os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ignore file %q: %v", fileName, err)
+	}
+	defer f.Close()
+	rules, err := parseIgnore(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ignore file %q: %v", fileName, err)
+	}
+	n := len(a.ignores)
+	a.ignores = append(a.ignores, ignoreSet{dir: dir, rules: rules})
+	return func() {
+		a.ignores = a.ignores[:n]
+	}, nil
+}
+
+// ignored reports whether fileName is matched
+// by the ignore files read so far.
+func (a *archiver) ignored(fileName string) bool {
+	ignored := false
+	var isDir *bool
+	for _, set := range a.ignores {
+		rel := strings.TrimPrefix(fileName, set.dir)
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		for _, rule := range set.rules {
+			if rule.negate != ignored || !matchElems(rule.elems, elems) {
+				continue
+			}
+			if rule.dirOnly {
+				if isDir == nil {
+					dir := a.isDir(fileName)
+					isDir = &dir
+				}
+				if !*isDir {
+					continue
+				}
+			}
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// isDir reports whether fileName is a directory, only
+// following symlinks if they are archived dereferenced.
+func (a *archiver) isDir(fileName string) bool {
+	var info os.FileInfo
+	var err error
+	if a.opts.readFS != nil {
+		info, err = a.opts.readFS.Stat(fileName)
+	} else if a.opts.dereference {
+		info, err = os.Stat(fileName)
+	} else {
+		info, err = os.Lstat(fileName)
+	}
+	return err == nil && info.IsDir()
+}
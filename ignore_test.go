@@ -0,0 +1,85 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesWithIgnoreFile(c *gc.C) {
+	dir := filepath.Join(t.cwd, "app")
+	files := map[string]string{
+		".tarignore":        "# scratch space\n*.tmp\n/cache/\nbuild/\n!keep.tmp\nlogs/**/*.gz\n",
+		"app.conf":          "conf",
+		"scratch.tmp":       "tmp",
+		"keep.tmp":          "keep",
+		"cache/blob":        "blob",
+		"data/cache/state":  "state",
+		"data/build/out":    "out",
+		"data/build.txt":    "notes",
+		"logs/2014/app.gz":  "gz",
+		"logs/app.log":      "log",
+		"vendor/.tarignore": "!*.tmp\nREADME\n",
+		"vendor/lib.tmp":    "lib",
+		"vendor/README":     "readme",
+		"vendor/sub/notes":  "notes",
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), gc.IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(contents), 0644), gc.IsNil)
+	}
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles([]string{dir}, outputTar, t.cwd+"/", false, WithIgnoreFile(".tarignore"))
+	c.Assert(err, gc.IsNil)
+
+	var names []string
+	for name := range readHeaders(c, outputTar) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.Assert(names, gc.DeepEquals, []string{
+		"app",
+		"app/.tarignore",
+		"app/app.conf",
+		"app/data",
+		"app/data/build.txt",
+		"app/data/cache",
+		"app/data/cache/state",
+		"app/keep.tmp",
+		"app/logs",
+		"app/logs/2014",
+		"app/logs/app.log",
+		"app/vendor",
+		"app/vendor/.tarignore",
+		"app/vendor/lib.tmp",
+		"app/vendor/sub",
+		"app/vendor/sub/notes",
+	})
+}
+
+func (t *TarSuite) TestParseIgnore(c *gc.C) {
+	rules, err := parseIgnore(strings.NewReader("# comment\n\n\\#hash\n!/a/b/\nc  \n"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(rules, gc.DeepEquals, []ignoreRule{
+		{elems: []string{"**", "#hash"}},
+		{elems: []string{"a", "b"}, negate: true, dirOnly: true},
+		{elems: []string{"**", "c"}},
+	})
+	_, err = parseIgnore(strings.NewReader("[\n"))
+	c.Assert(err, gc.ErrorMatches, `invalid pattern "\[": syntax error in pattern`)
+}
+
+func (t *TarSuite) TestWithIgnoreFileInvalid(c *gc.C) {
+	_, err := TarFiles([]string{"x"}, filepath.Join(t.cwd, "out.tar"), "", false, WithIgnoreFile("a/.tarignore"))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithIgnoreFile needs a file name, not "a/.tarignore"`)
+	err = UntarFiles("x.tar", c.MkDir(), false, WithIgnoreFile(".tarignore"))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithIgnoreFile only applies to archive creation")
+}
@@ -0,0 +1,250 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// IndexVersion is the version of the index format
+// written by this package.
+const IndexVersion = 1
+
+// Index maps the names of the entries of an archive to the location
+// of their contents, so single entries can be read without scanning
+// the archive from the start.
+type Index struct {
+	Version int                   `json:"version"`
+	Entries map[string]IndexEntry `json:"entries"`
+	// Compressed records whether the archive is gzip compressed,
+	// in which case the entry offsets are positions in the
+	// uncompressed stream.
+	Compressed bool `json:"compressed,omitempty"`
+	// SeekPoints holds the positions at which decompression
+	// can start, in increasing order, for compressed archives.
+	SeekPoints []SeekPoint `json:"seekPoints,omitempty"`
+	// ArchiveSize and ArchiveModTime record the size and the
+	// modification time, in nanoseconds since the Unix epoch,
+	// of the archive when it was indexed, so that the index
+	// is not used once the archive is rewritten.
+	ArchiveSize    int64 `json:"archiveSize"`
+	ArchiveModTime int64 `json:"archiveModTime"`
+}
+
+// IndexEntry holds the location of the contents of an entry.
+type IndexEntry struct {
+	// Offset is the position of the contents in the archive.
+	Offset int64 `json:"offset"`
+	// Size is the size of the contents.
+	Size int64 `json:"size"`
+}
+
+// SeekPoint is the start of a gzip member of a compressed archive,
+// from which the archive can be decompressed independently of the
+// preceding data.
+type SeekPoint struct {
+	// Compressed is the position of the member in the archive.
+	Compressed int64 `json:"compressed"`
+	// Uncompressed is the position of the member's
+	// contents in the uncompressed stream.
+	Uncompressed int64 `json:"uncompressed"`
+}
+
+// IndexPath returns the path ExtractEntry looks for
+// the index of tarFile at.
+func IndexPath(tarFile string) string {
+	return tarFile + ".idx"
+}
+
+// BuildIndex reads the archive tarFile and returns an index of its
+// regular files and of the hard links to them. For compressed
+// archives every gzip member becomes a seek point, so archives
+// written WithSeekableGzip can be read at random efficiently.
+func BuildIndex(tarFile string) (*Index, error) {
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	}
+	compressed, r, err := sniffGzip(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	}
+	idx := &Index{
+		Version:        IndexVersion,
+		Entries:        make(map[string]IndexEntry),
+		Compressed:     compressed,
+		ArchiveSize:    info.Size(),
+		ArchiveModTime: info.ModTime().UnixNano(),
+	}
+	if compressed {
+		members, err := newMemberReader(r.(byteReader), idx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot uncompress backup file %q: %v", tarFile, err)
+		}
+		r = members
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	} else {
+		r = f
+	}
+	// archive/tar reads exactly the header blocks and skips
+	// contents by reading them, so after Next the count is
+	// the position of the contents.
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || hdr.Typeflag == tar.TypeGNUSparse {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			// The contents of a hard link are those of its
+			// target, which comes earlier in the archive.
+			if target, ok := idx.Entries[cleanManifestPath(hdr.Linkname)]; ok {
+				idx.Entries[cleanManifestPath(hdr.Name)] = target
+			}
+			continue
+		}
+		idx.Entries[cleanManifestPath(hdr.Name)] = IndexEntry{
+			Offset: counter.n,
+			Size:   hdr.Size,
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteIndex writes idx to w in JSON form.
+func WriteIndex(idx *Index, w io.Writer) error {
+	return json.NewEncoder(w).Encode(idx)
+}
+
+// ReadIndex reads an index written by WriteIndex.
+func ReadIndex(r io.Reader) (*Index, error) {
+	var idx Index
+	if err := json.NewDecoder(r).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("cannot decode index: %v", err)
+	}
+	if idx.Version != IndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+// SaveIndex builds the index of tarFile and saves it
+// at IndexPath(tarFile).
+func SaveIndex(tarFile string) error {
+	idx, err := BuildIndex(tarFile)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(IndexPath(tarFile))
+	if err != nil {
+		return fmt.Errorf("cannot create index: %v", err)
+	}
+	if err := WriteIndex(idx, f); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write index: %v", err)
+	}
+	return f.Close()
+}
+
+// ExtractEntry writes the contents of the entry called name in
+// archive to w. If an index saved by SaveIndex exists, the contents
+// are read directly from their position in the archive, or for
+// compressed archives from the closest preceding seek point;
+// otherwise, or if the archive changed since it was indexed,
+// the archive is scanned from the start.
+func ExtractEntry(archive, name string, w io.Writer) error {
+	idxFile, err := os.Open(IndexPath(archive))
+	if os.IsNotExist(err) {
+		return ExtractRange(archive, name, 0, -1, w)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open index: %v", err)
+	}
+	idx, err := ReadIndex(idxFile)
+	idxFile.Close()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file %q: %v", archive, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot read backup file %q: %v", archive, err)
+	}
+	if info.Size() != idx.ArchiveSize || info.ModTime().UnixNano() != idx.ArchiveModTime {
+		f.Close()
+		return ExtractRange(archive, name, 0, -1, w)
+	}
+	entry, ok := idx.Entries[cleanManifestPath(name)]
+	if !ok {
+		return ErrEntryNotFound
+	}
+	if !idx.Compressed {
+		if _, err := io.Copy(w, io.NewSectionReader(f, entry.Offset, entry.Size)); err != nil {
+			return fmt.Errorf("failed while reading tar contents: %v", err)
+		}
+		return nil
+	}
+	point := idx.seekPoint(entry.Offset)
+	if _, err := f.Seek(point.Compressed, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot read backup file %q: %v", archive, err)
+	}
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("cannot uncompress backup file %q: %v", archive, err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, gzr, entry.Offset-point.Uncompressed); err != nil {
+		return fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	if _, err := io.CopyN(w, gzr, entry.Size); err != nil {
+		return fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	return nil
+}
+
+// seekPoint returns the last seek point at or
+// before the given uncompressed offset.
+func (idx *Index) seekPoint(offset int64) SeekPoint {
+	i := sort.Search(len(idx.SeekPoints), func(i int) bool {
+		return idx.SeekPoints[i].Uncompressed > offset
+	})
+	if i == 0 {
+		return SeekPoint{}
+	}
+	return idx.SeekPoints[i-1]
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestExtractEntryWithIndex(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+	idx, err := BuildIndex(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(idx.Entries, gc.HasLen, 4)
+	c.Assert(idx.Entries["TarLog"].Size, gc.Equals, int64(1008))
+
+	var buf bytes.Buffer
+	c.Assert(WriteIndex(idx, &buf), gc.IsNil)
+	read, err := ReadIndex(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(read, gc.DeepEquals, idx)
+
+	c.Assert(SaveIndex(outputTar), gc.IsNil)
+	buf.Reset()
+	err = ExtractEntry(outputTar, "TarDirectoryPopulated/TarSubFile1", &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "TarSubFile1")
+	err = ExtractEntry(outputTar, "TarMissing", &buf)
+	c.Assert(err, gc.Equals, ErrEntryNotFound)
+}
+
+func (t *TarSuite) TestExtractEntryWithoutIndex(c *gc.C) {
+	outputTar := t.createRangeArchive(c, true)
+	var buf bytes.Buffer
+	err := ExtractEntry(outputTar, "TarFile2", &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "TarFile2")
+}
+
+func (t *TarSuite) TestExtractEntrySeekableGzip(c *gc.C) {
+	t.createTestFiles(c)
+	log := filepath.Join(t.cwd, "TarLog")
+	err := ioutil.WriteFile(log, []byte(strings.Repeat("x", 5000)+"the tail"), 0644)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err = TarFiles(append(t.testFiles, log), outputTar, t.cwd+"/", true, WithSeekableGzip(1024))
+	c.Assert(err, gc.IsNil)
+	t.assertTarContents(c, testExpectedTarContents, outputTar, true)
+
+	f, err := os.Open(IndexPath(outputTar))
+	c.Assert(err, gc.IsNil)
+	idx, err := ReadIndex(f)
+	f.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(idx.Compressed, gc.Equals, true)
+	c.Assert(len(idx.SeekPoints) > 5, gc.Equals, true)
+	c.Assert(idx.SeekPoints[0], gc.Equals, SeekPoint{})
+
+	for name, want := range map[string]string{
+		"TarLog":                            strings.Repeat("x", 5000) + "the tail",
+		"TarFile2":                          "TarFile2",
+		"TarDirectoryPopulated/TarSubFile1": "TarSubFile1",
+	} {
+		var buf bytes.Buffer
+		err = ExtractEntry(outputTar, name, &buf)
+		c.Assert(err, gc.IsNil)
+		c.Assert(buf.String(), gc.Equals, want)
+	}
+}
+
+func (t *TarSuite) TestBuildIndexCompressed(c *gc.C) {
+	outputTar := t.createRangeArchive(c, true)
+	idx, err := BuildIndex(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(idx.SeekPoints, gc.DeepEquals, []SeekPoint{{}})
+	c.Assert(SaveIndex(outputTar), gc.IsNil)
+	var buf bytes.Buffer
+	err = ExtractEntry(outputTar, "TarLog", &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.Len(), gc.Equals, 1008)
+
+	_, err = BuildIndex(filepath.Join(t.cwd, "missing"))
+	c.Assert(err, gc.ErrorMatches, "cannot open backup file .*")
+}
+
+func (t *TarSuite) TestExtractEntryStaleIndex(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+	c.Assert(SaveIndex(outputTar), gc.IsNil)
+
+	// Rewriting the archive removes its index.
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(IndexPath(outputTar))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	// An index left over by another tool is not used.
+	c.Assert(SaveIndex(outputTar), gc.IsNil)
+	other := filepath.Join(t.cwd, "other.tar")
+	writeContentsArchive(c, other, []testEntry{{"TarFile2", "changed since indexed"}})
+	data, err := ioutil.ReadFile(other)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ioutil.WriteFile(outputTar, data, 0644), gc.IsNil)
+	var buf bytes.Buffer
+	err = ExtractEntry(outputTar, "TarFile2", &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "changed since indexed")
+}
+
+func (t *TarSuite) TestBuildIndexHardLink(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "links.tar")
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	tw := tar.NewWriter(f)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Size: 8}), gc.IsNil)
+	_, err = tw.Write([]byte("contents"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "file"}), gc.IsNil)
+	c.Assert(tw.Close(), gc.IsNil)
+	c.Assert(f.Close(), gc.IsNil)
+
+	idx, err := BuildIndex(tarFile)
+	c.Assert(err, gc.IsNil)
+	c.Assert(idx.Entries["link"], gc.Equals, idx.Entries["file"])
+	c.Assert(SaveIndex(tarFile), gc.IsNil)
+	var buf bytes.Buffer
+	err = ExtractEntry(tarFile, "link", &buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "contents")
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// KeyProvider provides the key of encrypted archives on demand, so
+// that interactive tools can prompt for a passphrase and services can
+// fetch keys from a secret store only when they are needed.
+type KeyProvider interface {
+	// GetKey returns the key of the archive being extracted: the
+	// passphrase for archives created WithPassphrase, or the raw
+	// key for archives created WithEncryptionKey.
+	GetKey(ctx context.Context) ([]byte, error)
+}
+
+// KeyProviderFunc is a function implementing KeyProvider.
+type KeyProviderFunc func(ctx context.Context) ([]byte, error)
+
+// GetKey implements KeyProvider by calling f.
+func (f KeyProviderFunc) GetKey(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// WithKeyProvider returns an Option that makes UntarFiles ask p for
+// the key when, and only when, the archive turns out to be encrypted.
+// The key is asked for at most once per extraction, and ctx is passed
+// to p.
+func WithKeyProvider(ctx context.Context, p KeyProvider) Option {
+	return func(o *options) {
+		o.keyProvider = p
+		o.keyContext = ctx
+	}
+}
+
+// providedKey returns the key given by the key provider of o,
+// asking for it the first time only.
+func (o *options) providedKey() ([]byte, error) {
+	if o.keyProvided == nil {
+		key, err := o.keyProvider.GetKey(o.keyContext)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get key: %v", err)
+		}
+		o.keyProvided = key
+	}
+	return o.keyProvided, nil
+}
+
+// maybeDecrypt returns a reader decrypting r if it holds an encrypted
+// archive, using the key given by the key provider of o, or a reader
+// returning what r holds otherwise.
+func (o *options) maybeDecrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(encryptMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, []byte(encryptMagic)) {
+		return br, nil
+	}
+	return newDecryptReader(br, o)
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestKeyProvider(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz.enc")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithPassphrase("secret"))
+	c.Assert(err, gc.IsNil)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "prompt")
+	calls := 0
+	provider := KeyProviderFunc(func(ctx context.Context) ([]byte, error) {
+		c.Check(ctx.Value(ctxKey{}), gc.Equals, "prompt")
+		calls++
+		return []byte("secret"), nil
+	})
+	restore := c.MkDir()
+	err = UntarFiles(outputTar, restore, true, WithKeyProvider(ctx, provider), WithSpaceCheck())
+	c.Assert(err, gc.IsNil)
+	c.Assert(calls, gc.Equals, 1)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, restore)
+
+	// The provider is not asked for the key of unencrypted archives.
+	plainTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err = TarFiles(t.testFiles, plainTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	err = UntarFiles(plainTar, c.MkDir(), true, WithKeyProvider(ctx, provider))
+	c.Assert(err, gc.IsNil)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (t *TarSuite) TestKeyProviderErrors(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.enc")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithEncryptionKey(testKey))
+	c.Assert(err, gc.IsNil)
+
+	failing := KeyProviderFunc(func(context.Context) ([]byte, error) {
+		return nil, errors.New("prompt cancelled")
+	})
+	err = UntarFiles(outputTar, c.MkDir(), false, WithKeyProvider(context.Background(), failing))
+	c.Assert(err, gc.ErrorMatches, `cannot decrypt tar file ".*": cannot get key: prompt cancelled`)
+
+	short := KeyProviderFunc(func(context.Context) ([]byte, error) {
+		return []byte("short"), nil
+	})
+	err = UntarFiles(outputTar, c.MkDir(), false, WithKeyProvider(context.Background(), short))
+	c.Assert(err, gc.ErrorMatches, `cannot decrypt tar file ".*": provided key is 5 bytes long, not 32`)
+
+	err = UntarFiles(outputTar, c.MkDir(), false, WithKeyProvider(context.Background(), short), WithEncryptionKey(testKey))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithKeyProvider cannot be used with WithEncryptionKey or WithPassphrase")
+}
@@ -0,0 +1,139 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Media types of OCI image layers.
+const (
+	MediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Layer describes an OCI image layer created by CreateLayer.
+type Layer struct {
+	// MediaType is the media type of the layer.
+	MediaType string
+	// Digest is the digest of the layer as stored,
+	// such as "sha256:4f2d...".
+	Digest string
+	// DiffID is the digest of the uncompressed layer,
+	// listed in the rootfs of image configurations.
+	DiffID string
+	// Size is the size of the layer as stored.
+	Size int64
+}
+
+// layerTime is the modification time of every entry of a layer.
+var layerTime = time.Unix(0, 0)
+
+// CreateLayer writes an OCI image layer holding everything below
+// srcDir to w, gzip compressed if compress is true. Entries are named
+// relative to srcDir, and their headers are normalized so that the
+// same tree always gives the same layer: modification times are set
+// to the epoch, owner names and other times are left out, and the
+// names of directories end with a slash.
+func CreateLayer(srcDir string, w io.Writer, compress bool, opts ...Option) (_ *Layer, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return nil, err
+	}
+	var problems []string
+	if o.encrypted() {
+		problems = append(problems, "encryption cannot be used with CreateLayer")
+	}
+	if o.format != FormatTar {
+		problems = append(problems, "WithFormat cannot be used with CreateLayer")
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	o.layer = true
+	o.deterministicGzip = true
+	srcDir = filepath.Clean(srcDir)
+	f, err := os.Open(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read layer directory: %v", err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read layer directory: %v", err)
+	}
+	sort.Strings(names)
+	fileList := make([]string, len(names))
+	for i, name := range names {
+		fileList[i] = filepath.Join(srcDir, name)
+	}
+
+	digest := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, digest)}
+	layer := &Layer{MediaType: MediaTypeLayer}
+	var out io.Writer = counter
+	var gzw io.WriteCloser
+	if compress {
+		layer.MediaType = MediaTypeLayerGzip
+		if gzw, err = newGzipWriter(counter, o); err != nil {
+			return nil, fmt.Errorf("cannot compress layer: %v", err)
+		}
+		out = gzw
+	}
+	// The stored stream is the uncompressed layer, hashed for the DiffID.
+	diffID := sha256.New()
+	if err := writeArchive(fileList, out, srcDir+string(os.PathSeparator), false, diffID, o); err != nil {
+		return nil, err
+	}
+	if gzw != nil {
+		if err := gzw.Close(); err != nil {
+			return nil, fmt.Errorf("cannot compress layer: %v", err)
+		}
+	}
+	layer.Digest = layerDigest(digest)
+	layer.DiffID = layerDigest(diffID)
+	layer.Size = counter.n
+	return layer, nil
+}
+
+// layerDigest returns the OCI digest of the data hashed by h.
+func layerDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeLayerHeader normalizes h for inclusion in a layer.
+func normalizeLayerHeader(h *tar.Header) {
+	h.ModTime = layerTime
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	h.Uname = ""
+	h.Gname = ""
+	if h.Typeflag == tar.TypeDir && !strings.HasSuffix(h.Name, "/") {
+		h.Name += "/"
+	}
+}
+
+// ApplyLayer extracts the OCI image layer in layerFile over the tree
+// at dest, gzip compressed if compressed is true. Whiteout entries,
+// named with a ".wh." prefix, remove their sibling without the prefix
+// from dest instead of being extracted, and ".wh..wh..opq" entries
+// remove everything their directory held before the layer.
+func ApplyLayer(layerFile, dest string, compressed bool, opts ...Option) error {
+	opts = append(opts[:len(opts):len(opts)], func(o *options) {
+		o.whiteouts = whiteoutAUFS
+	})
+	return UntarFiles(layerFile, dest, compressed, opts...)
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestCreateLayer(c *gc.C) {
+	src := filepath.Join(t.cwd, "rootfs")
+	c.Assert(os.MkdirAll(filepath.Join(src, "etc"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("box\n"), 0644), gc.IsNil)
+
+	var first bytes.Buffer
+	layer, err := CreateLayer(src, &first, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(layer.MediaType, gc.Equals, MediaTypeLayerGzip)
+	c.Assert(layer.Size, gc.Equals, int64(first.Len()))
+	c.Assert(layer.Digest, gc.Equals, sha256Digest(first.Bytes()))
+	gzr, err := gzip.NewReader(bytes.NewReader(first.Bytes()))
+	c.Assert(err, gc.IsNil)
+	raw, err := ioutil.ReadAll(gzr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(layer.DiffID, gc.Equals, sha256Digest(raw))
+
+	tr := tar.NewReader(bytes.NewReader(raw))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(hdr.ModTime.Equal(layerTime), gc.Equals, true)
+		c.Assert(hdr.Uname, gc.Equals, "")
+		names = append(names, hdr.Name)
+	}
+	c.Assert(names, gc.DeepEquals, []string{"etc/", "etc/hostname"})
+
+	later := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(filepath.Join(src, "etc", "hostname"), later, later), gc.IsNil)
+	var second bytes.Buffer
+	again, err := CreateLayer(src, &second, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(again, gc.DeepEquals, layer)
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (t *TarSuite) TestApplyLayer(c *gc.C) {
+	dest := filepath.Join(t.cwd, "rootfs")
+	for _, name := range []string{"gone", "kept", "opaque/old", "opaque/sub/old", "dir/gone/file"} {
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), gc.IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte("lower"), 0644), gc.IsNil)
+	}
+	layerFile := filepath.Join(t.cwd, "layer.tar")
+	writeContentsArchive(c, layerFile, []testEntry{
+		{".wh.gone", ""},
+		{"opaque/", ""},
+		{"opaque/new", "upper"},
+		{"opaque/.wh..wh..opq", ""},
+		{"dir/.wh.gone", ""},
+		{"dir/.wh.missing", ""},
+		{"added", "upper"},
+	})
+	err := ApplyLayer(layerFile, dest, false)
+	c.Assert(err, gc.IsNil)
+
+	var files []string
+	err = filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+		c.Assert(err, gc.IsNil)
+		rel, _ := filepath.Rel(dest, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	sort.Strings(files)
+	c.Assert(files, gc.DeepEquals, []string{".", "added", "dir", "kept", "opaque", "opaque/new"})
+}
+
+func (t *TarSuite) TestApplyLayerInvalidWhiteout(c *gc.C) {
+	layerFile := filepath.Join(t.cwd, "layer.tar")
+	writeContentsArchive(c, layerFile, []testEntry{{".wh...", ""}})
+	err := ApplyLayer(layerFile, filepath.Join(t.cwd, "rootfs"), false)
+	c.Assert(err, gc.ErrorMatches, `invalid whiteout "\.wh\.\.\."`)
+}
+
+func (t *TarSuite) TestApplyLayerSymlinkParent(c *gc.C) {
+	outside := c.MkDir()
+	victim := filepath.Join(outside, "victim")
+	c.Assert(ioutil.WriteFile(victim, []byte("safe"), 0644), gc.IsNil)
+	layerFile := filepath.Join(t.cwd, "layer.tar")
+	writeTestArchive(c, layerFile, []*tar.Header{
+		{Name: "l", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "l/.wh.victim", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := ApplyLayer(layerFile, filepath.Join(t.cwd, "rootfs"), false)
+	c.Assert(err, gc.ErrorMatches, `cannot remove "l/victim": parent "l" is a symlink`)
+	data, err := ioutil.ReadFile(victim)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "safe")
+}
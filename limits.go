@@ -0,0 +1,113 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+)
+
+// Limits bounds what an extraction may create, protecting services
+// that extract untrusted archives from archives crafted to exhaust
+// their resources. A zero field means no limit.
+type Limits struct {
+	// MaxTotalSize is the maximum number of bytes
+	// extracted over all files.
+	MaxTotalSize int64
+	// MaxEntries is the maximum number of entries extracted.
+	MaxEntries int
+	// MaxFileSize is the maximum size of a single file.
+	MaxFileSize int64
+	// MaxDepth is the maximum number of path
+	// components of an entry name.
+	MaxDepth int
+}
+
+// WithLimits returns an Option that makes UntarFiles abort with a
+// *LimitError as soon as the extraction exceeds any of limits.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// validate appends to problems the reasons
+// why l cannot be used.
+func (l Limits) validate(problems []string) []string {
+	if l.MaxTotalSize < 0 || l.MaxEntries < 0 || l.MaxFileSize < 0 || l.MaxDepth < 0 {
+		problems = append(problems, "WithLimits needs non-negative limits")
+	}
+	return problems
+}
+
+// LimitError is returned when an extraction exceeds its Limits.
+type LimitError struct {
+	// Limit names the Limits field that was exceeded.
+	Limit string
+	// Path is the name of the entry exceeding the limit.
+	Path string
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("extraction of %q exceeds %s of %d", e.Path, e.Limit, e.Max)
+}
+
+// limiter enforces the limits of an extraction.
+type limiter struct {
+	limits  Limits
+	entries int
+	total   int64
+}
+
+// checkHeader checks the entry described by hdr against the limits,
+// before its contents are read.
+func (l *limiter) checkHeader(hdr *tar.Header) error {
+	l.entries++
+	if max := l.limits.MaxEntries; max > 0 && l.entries > max {
+		return &LimitError{Limit: "MaxEntries", Path: hdr.Name, Max: int64(max)}
+	}
+	if max := l.limits.MaxDepth; max > 0 && len(strings.Split(cleanManifestPath(hdr.Name), "/")) > max {
+		return &LimitError{Limit: "MaxDepth", Path: hdr.Name, Max: int64(max)}
+	}
+	return l.checkSize(hdr.Name, hdr.Size)
+}
+
+// checkSize checks that an entry with contents of the given size
+// fits the limits, without recording it.
+func (l *limiter) checkSize(name string, size int64) error {
+	if max := l.limits.MaxFileSize; max > 0 && size > max {
+		return &LimitError{Limit: "MaxFileSize", Path: name, Max: max}
+	}
+	if max := l.limits.MaxTotalSize; max > 0 && l.total+size > max {
+		return &LimitError{Limit: "MaxTotalSize", Path: name, Max: max}
+	}
+	return nil
+}
+
+// readLimit returns the number of bytes of contents that can be
+// read for the next entry before a limit is certainly exceeded,
+// or -1 if there is no limit.
+func (l *limiter) readLimit() int64 {
+	limit := int64(-1)
+	if max := l.limits.MaxFileSize; max > 0 {
+		limit = max
+	}
+	if max := l.limits.MaxTotalSize; max > 0 && (limit < 0 || max-l.total < limit) {
+		limit = max - l.total
+	}
+	return limit
+}
+
+// extracted checks the actual size of the contents of
+// an entry against the limits and records it.
+func (l *limiter) extracted(name string, size int64) error {
+	if err := l.checkSize(name, size); err != nil {
+		return err
+	}
+	l.total += size
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+var limitsTests = []struct {
+	about  string
+	limits Limits
+	err    string
+}{{
+	about:  "within limits",
+	limits: Limits{MaxTotalSize: 1000, MaxEntries: 6, MaxFileSize: 11, MaxDepth: 2},
+}, {
+	about:  "too many entries",
+	limits: Limits{MaxEntries: 5},
+	err:    `extraction of "TarFile2" exceeds MaxEntries of 5`,
+}, {
+	about:  "file too big",
+	limits: Limits{MaxFileSize: 10},
+	err:    `extraction of "TarDirectoryPopulated/TarSubFile1" exceeds MaxFileSize of 10`,
+}, {
+	about:  "too much in total",
+	limits: Limits{MaxTotalSize: 20},
+	err:    `extraction of "TarFile2" exceeds MaxTotalSize of 20`,
+}, {
+	about:  "too deep",
+	limits: Limits{MaxDepth: 1},
+	err:    `extraction of "TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory" exceeds MaxDepth of 1`,
+}}
+
+func (t *TarSuite) TestLimits(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	for i, test := range limitsTests {
+		c.Logf("test %d: %s", i, test.about)
+		err := UntarFiles(outputTar, c.MkDir(), false, WithLimits(test.limits))
+		if test.err == "" {
+			c.Check(err, gc.IsNil)
+			continue
+		}
+		c.Check(err, gc.FitsTypeOf, &LimitError{})
+		c.Check(err, gc.ErrorMatches, test.err)
+	}
+}
+
+func (t *TarSuite) TestLimitsFilterExpansion(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "small.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{{Name: "small", Typeflag: tar.TypeReg, Mode: 0644}})
+	expand := func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		return bytes.NewReader(make([]byte, 1<<20)), nil
+	}
+	err := UntarFiles(tarFile, t.cwd, false, WithContentFilter(expand), WithLimits(Limits{MaxFileSize: 100}))
+	c.Assert(err, gc.ErrorMatches, `extraction of "small" exceeds MaxFileSize of 100`)
+}
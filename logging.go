@@ -0,0 +1,44 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"github.com/juju/loggo"
+)
+
+// Logger receives the log messages of archive creation and
+// extraction. Its methods may be called concurrently.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// WithLogger returns an Option that makes TarFiles and UntarFiles
+// log to l instead of the "juju.tar" loggo logger.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// defaultLogger is the Logger used when none is given.
+var defaultLogger Logger = loggoLogger{loggo.GetLogger("juju.tar")}
+
+// loggoLogger adapts a loggo.Logger to Logger.
+type loggoLogger struct {
+	loggo.Logger
+}
+
+func (l loggoLogger) Warnf(format string, args ...interface{}) {
+	l.Warningf(format, args...)
+}
+
+// log returns the Logger to log to.
+func (o *options) log() Logger {
+	if o.logger == nil {
+		return defaultLogger
+	}
+	return o.logger
+}
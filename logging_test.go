@@ -0,0 +1,65 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	gc "launchpad.net/gocheck"
+)
+
+// recordingLogger is a Logger recording the messages it is given.
+type recordingLogger struct {
+	mu    sync.Mutex
+	debug []string
+	info  []string
+	warn  []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.info = append(l.info, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func (t *TarSuite) TestLogger(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	var created recordingLogger
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false, WithLogger(&created))
+	c.Assert(err, gc.IsNil)
+	c.Assert(created.debug, gc.HasLen, 6)
+	c.Assert(created.debug[0], gc.Matches, `archived ".*TarDirectoryEmpty" as "TarDirectoryEmpty/?"`)
+	c.Assert(created.info, gc.DeepEquals, []string{fmt.Sprintf("created archive %q", outputTar)})
+
+	dupTar := filepath.Join(t.cwd, "dup.tar")
+	writeContentsArchive(c, dupTar, []testEntry{
+		{"file", "first"},
+		{"file", "second"},
+	})
+	var extracted recordingLogger
+	outputDir := c.MkDir()
+	err = UntarFiles(dupTar, outputDir, false, WithLogger(&extracted), WithDuplicatePolicy(DuplicateFirstWins))
+	c.Assert(err, gc.IsNil)
+	c.Assert(extracted.debug, gc.DeepEquals, []string{
+		fmt.Sprintf("extracted %q to %q", "file", filepath.Join(outputDir, "file")),
+		`skipping "file": duplicate entry`,
+	})
+	c.Assert(extracted.info, gc.DeepEquals, []string{fmt.Sprintf("extracted %q into %q", dupTar, outputDir)})
+}
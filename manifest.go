@@ -0,0 +1,553 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestMismatchError is returned by VerifyAgainstManifest when the
+// archive does not match the manifest.
+type ManifestMismatchError struct {
+	// Mismatched holds the entries whose digest differs
+	// from the one in the manifest.
+	Mismatched []string
+	// Missing holds the manifest entries not found in the archive.
+	Missing []string
+	// Unexpected holds the regular files in the archive
+	// that are not listed in the manifest.
+	Unexpected []string
+}
+
+func (e *ManifestMismatchError) Error() string {
+	var parts []string
+	add := func(what string, names []string) {
+		if len(names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", what, strings.Join(names, ", ")))
+		}
+	}
+	add("digest mismatch", e.Mismatched)
+	add("missing from archive", e.Missing)
+	add("not in manifest", e.Unexpected)
+	return "archive does not match manifest: " + strings.Join(parts, "; ")
+}
+
+// VerifyAgainstManifest checks, in a single pass over the archive,
+// that every regular file in tarFile has the SHA-256 digest recorded
+// for it in manifest, and that the archive and manifest list the same
+// files. The archive may be gzip compressed.
+//
+// The manifest may be in the format produced by sha256sum, or a JSON
+// array of objects with "path" and "sha256" fields. If the archive
+// does not match, a *ManifestMismatchError is returned.
+func VerifyAgainstManifest(tarFile string, manifest io.Reader) error {
+	expected, err := parseManifest(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot read manifest: %v", err)
+	}
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifyDigests(tr, expected)
+}
+
+// verifyDigests reads the remaining entries of tr and checks
+// that their SHA-256 digests are the expected ones.
+func verifyDigests(tr *tar.Reader, expected map[string]string) error {
+	mismatch := &ManifestMismatchError{}
+	seen := make(map[string]bool)
+	// digests holds the digests of the regular files
+	// read so far, for the hard links to them.
+	digests := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || hdr.Name == ManifestName {
+			continue
+		}
+		name := cleanManifestPath(hdr.Name)
+		want, ok := expected[name]
+		if hdr.Typeflag == tar.TypeLink {
+			// Hard links have the contents of their target, and
+			// manifests only list them with a digest if they
+			// were made from a tree holding a copy.
+			if ok {
+				seen[name] = true
+				if digests[cleanManifestPath(hdr.Linkname)] != want {
+					mismatch.Mismatched = append(mismatch.Mismatched, name)
+				}
+			}
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return fmt.Errorf("failed while reading tar contents: %v", err)
+		}
+		digest := hex.EncodeToString(h.Sum(nil))
+		digests[name] = digest
+		if !ok {
+			mismatch.Unexpected = append(mismatch.Unexpected, name)
+			continue
+		}
+		seen[name] = true
+		if digest != want {
+			mismatch.Mismatched = append(mismatch.Mismatched, name)
+		}
+	}
+	for name := range expected {
+		if !seen[name] {
+			mismatch.Missing = append(mismatch.Missing, name)
+		}
+	}
+	sort.Strings(mismatch.Missing)
+	if len(mismatch.Mismatched)+len(mismatch.Missing)+len(mismatch.Unexpected) > 0 {
+		return mismatch
+	}
+	return nil
+}
+
+// parseManifest reads a sha256sum or JSON manifest and returns the
+// lower case hex digests of regular files keyed by cleaned path. A
+// JSON manifest may be a Manifest or just its list of entries.
+func parseManifest(r io.Reader) (map[string]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string)
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		var m Manifest
+		if trimmed[0] == '[' {
+			err = json.Unmarshal(trimmed, &m.Entries)
+		} else if err = json.Unmarshal(trimmed, &m); err == nil {
+			err = m.checkVersion()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return m.digests(), nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		// sha256sum writes "<digest>  <path>", or "<digest> *<path>"
+		// for files read in binary mode, starting the line with a
+		// backslash when it escaped the path.
+		escaped := strings.HasPrefix(text, "\\")
+		if escaped {
+			text = text[1:]
+		}
+		i := strings.Index(text, " ")
+		if i != 64 || len(text) < i+3 || (text[i+1] != ' ' && text[i+1] != '*') {
+			return nil, fmt.Errorf("line %d: invalid sha256sum line", line)
+		}
+		if _, err := hex.DecodeString(text[:i]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid sha256sum digest %q", line, text[:i])
+		}
+		name := text[i+2:]
+		if escaped {
+			var ok bool
+			if name, ok = unescapeSumPath(name); !ok {
+				return nil, fmt.Errorf("line %d: invalid escape in sha256sum path", line)
+			}
+		}
+		digests[cleanManifestPath(name)] = strings.ToLower(text[:i])
+	}
+	return digests, scanner.Err()
+}
+
+// unescapeSumPath undoes the escaping of backslashes
+// and line breaks in a path written by sha256sum.
+func unescapeSumPath(p string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] != '\\' {
+			b.WriteByte(p[i])
+			continue
+		}
+		if i++; i == len(p) {
+			return "", false
+		}
+		switch p[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// ManifestName is the name of the manifest entry
+// written first in archives created WithManifest.
+const ManifestName = ".tar-manifest.json"
+
+// ManifestVersion is the version of the manifest format written by
+// this package. Version 2 added owners and extended attributes. Fields
+// are only ever added to the format, so manifests of every earlier
+// version can be read, and their missing fields are left empty.
+const ManifestVersion = 2
+
+// ManifestSchema is the JSON schema of manifests, as
+// written by EncodeManifest and embedded WithManifest.
+const ManifestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/juju/tar/manifest.schema.json",
+  "title": "Archive manifest",
+  "type": "object",
+  "required": ["version", "entries"],
+  "properties": {
+    "version": {"type": "integer", "minimum": 1, "maximum": 2},
+    "entries": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["path", "size", "mode", "mtime"],
+        "properties": {
+          "path": {"type": "string"},
+          "type": {"enum": ["file", "dir", "symlink", "hardlink", "chardev", "blockdev", "fifo", "other"]},
+          "size": {"type": "integer", "minimum": 0},
+          "mode": {"type": "integer", "minimum": 0},
+          "mtime": {"type": "string", "format": "date-time"},
+          "linkname": {"type": "string"},
+          "sha256": {"type": "string", "pattern": "^[0-9a-f]{64}$"},
+          "uid": {"type": "integer"},
+          "gid": {"type": "integer"},
+          "uname": {"type": "string"},
+          "gname": {"type": "string"},
+          "xattrs": {
+            "type": "object",
+            "additionalProperties": {"type": "string", "contentEncoding": "base64"}
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// ErrNoManifest is returned when an archive
+// does not start with a manifest entry.
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	Version int             `json:"version"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes a single archive entry.
+type ManifestEntry struct {
+	Path     string    `json:"path"`
+	Type     string    `json:"type,omitempty"`
+	Size     int64     `json:"size"`
+	Mode     int64     `json:"mode"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"linkname,omitempty"`
+	// SHA256 holds the hex encoded digest of the
+	// contents of regular files.
+	SHA256 string `json:"sha256,omitempty"`
+	Uid    int    `json:"uid,omitempty"`
+	Gid    int    `json:"gid,omitempty"`
+	Uname  string `json:"uname,omitempty"`
+	Gname  string `json:"gname,omitempty"`
+	// Xattrs holds the extended attributes of the entry, by name.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+func newManifest() *Manifest {
+	return &Manifest{Version: ManifestVersion}
+}
+
+// modTime returns the modification time of the newest entry of m,
+// which is that of the manifest entry written with it.
+func (m *Manifest) modTime() time.Time {
+	var t time.Time
+	for _, e := range m.Entries {
+		if e.ModTime.After(t) {
+			t = e.ModTime
+		}
+	}
+	return t
+}
+
+// add records the entry with the given header and, for regular
+// files, the digest of its contents.
+func (m *Manifest) add(hdr *tar.Header, digest hash.Hash) {
+	e := ManifestEntry{
+		Path:     hdr.Name,
+		Type:     entryType(hdr),
+		Size:     hdr.Size,
+		Mode:     hdr.Mode,
+		ModTime:  hdr.ModTime.UTC(),
+		Linkname: hdr.Linkname,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+	}
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			if e.Xattrs == nil {
+				e.Xattrs = make(map[string][]byte)
+			}
+			e.Xattrs[strings.TrimPrefix(key, xattrPrefix)] = []byte(value)
+		}
+	}
+	if digest != nil {
+		e.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	}
+	m.Entries = append(m.Entries, e)
+}
+
+// digests returns the digests of the regular files
+// in the manifest keyed by cleaned path.
+func (m *Manifest) digests() map[string]string {
+	digests := make(map[string]string)
+	for _, e := range m.Entries {
+		if e.SHA256 != "" {
+			digests[cleanManifestPath(e.Path)] = strings.ToLower(e.SHA256)
+		}
+	}
+	return digests
+}
+
+// entryType returns a short name for the type of the entry.
+func entryType(hdr *tar.Header) string {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "chardev"
+	case tar.TypeBlock:
+		return "blockdev"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	if hdr.FileInfo().Mode().IsRegular() {
+		return "file"
+	}
+	return "other"
+}
+
+// writeWithManifest writes m as the first entry of tarw,
+// followed by all the entries read from tr.
+func writeWithManifest(tarw entryWriter, m *Manifest, tr *tar.Reader) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("cannot encode manifest: %v", err)
+	}
+	// The manifest is dated from its entries, so that the
+	// archive is the same whenever they are written again.
+	hdr := &tar.Header{
+		Name:     ManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  m.modTime(),
+	}
+	if err := tarw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("cannot write manifest: %v", err)
+	}
+	if _, err := tarw.Write(data); err != nil {
+		return fmt.Errorf("cannot write manifest: %v", err)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read staging file: %v", err)
+		}
+		if err := tarw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("cannot write header for %q: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(tarw, tr); err != nil {
+			return fmt.Errorf("failed to write %q: %v", hdr.Name, err)
+		}
+	}
+}
+
+// ReadManifest returns the manifest embedded in tarFile by
+// TarFiles WithManifest. Only the start of the archive is read.
+// If the archive has no manifest, ErrNoManifest is returned.
+func ReadManifest(tarFile string) (*Manifest, error) {
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readManifestEntry(tr)
+}
+
+// readManifestEntry reads the manifest from the first entry of tr.
+func readManifestEntry(tr *tar.Reader) (*Manifest, error) {
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed while reading tar header: %v", err)
+	}
+	if hdr.Name != ManifestName {
+		return nil, ErrNoManifest
+	}
+	return decodeManifest(tr)
+}
+
+// DecodeManifest reads a JSON manifest, of this or any earlier
+// version, from r.
+func DecodeManifest(r io.Reader) (*Manifest, error) {
+	return decodeManifest(r)
+}
+
+// EncodeManifest writes m to w as JSON, as described by
+// ManifestSchema.
+func EncodeManifest(w io.Writer, m *Manifest) error {
+	if m.Version < 1 || m.Version > ManifestVersion {
+		return fmt.Errorf("cannot encode manifest: unsupported version %d", m.Version)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return fmt.Errorf("cannot encode manifest: %v", err)
+	}
+	return nil
+}
+
+// DirManifest returns a manifest describing every file below dir,
+// with paths relative to dir, as the manifest of an archive created
+// by TarFiles WithManifest with dir as strip prefix would. It can be
+// saved as a snapshot of the tree, and later compared with another
+// by DiffManifests or used as the base of CreateDelta.
+func DirManifest(dir string) (*Manifest, error) {
+	m, _, err := dirManifest(filepath.Clean(dir))
+	return m, err
+}
+
+// decodeManifest decodes the JSON manifest read from r.
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("cannot decode manifest: %v", err)
+	}
+	if err := m.checkVersion(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// checkVersion checks that m is in a version of the
+// format that can be read.
+func (m *Manifest) checkVersion() error {
+	if m.Version < 1 || m.Version > ManifestVersion {
+		return fmt.Errorf("cannot decode manifest: unsupported version %d", m.Version)
+	}
+	return nil
+}
+
+// VerifyManifest checks that the contents of tarFile match the
+// manifest embedded in it, returning a *ManifestMismatchError if
+// they do not.
+func VerifyManifest(tarFile string) error {
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := readManifestEntry(tr)
+	if err != nil {
+		return err
+	}
+	return verifyDigests(tr, m.digests())
+}
+
+// cleanManifestPath returns the canonical form of an archive or
+// manifest path, so that "./a/b" and "a/b" compare equal.
+func cleanManifestPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// dirManifest returns a manifest describing every file below
+// dir, and the headers of the files keyed by cleaned path.
+func dirManifest(dir string) (*Manifest, map[string]*tar.Header, error) {
+	m := newManifest()
+	headers := make(map[string]*tar.Header)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("cannot create tar header for %q: %v", p, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		var digest hash.Hash
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			digest = sha256.New()
+			_, err = io.Copy(digest, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		m.add(hdr, digest)
+		headers[cleanManifestPath(hdr.Name)] = hdr
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read directory %q: %v", dir, err)
+	}
+	return m, headers, nil
+}
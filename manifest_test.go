@@ -0,0 +1,271 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *TarSuite) createManifestArchive(c *gc.C, compress bool) string {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", compress)
+	c.Assert(err, gc.IsNil)
+	return outputTar
+}
+
+func (t *TarSuite) TestVerifyAgainstManifestSHA256Sum(c *gc.C) {
+	outputTar := t.createManifestArchive(c, true)
+	manifest := fmt.Sprintf("%s  ./TarFile1\n%s *TarFile2\n%s  TarDirectoryPopulated/TarSubFile1\n",
+		sha256Hex("TarFile1"), sha256Hex("TarFile2"), sha256Hex("TarSubFile1"))
+	err := VerifyAgainstManifest(outputTar, strings.NewReader(manifest))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestVerifyAgainstManifestJSON(c *gc.C) {
+	outputTar := t.createManifestArchive(c, false)
+	manifest := fmt.Sprintf(`[
+		{"path": "TarFile1", "sha256": %q},
+		{"path": "TarFile2", "sha256": %q},
+		{"path": "TarDirectoryPopulated/TarSubFile1", "sha256": %q}
+	]`, sha256Hex("TarFile1"), strings.ToUpper(sha256Hex("TarFile2")), sha256Hex("TarSubFile1"))
+	err := VerifyAgainstManifest(outputTar, strings.NewReader(manifest))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestVerifyAgainstManifestMismatch(c *gc.C) {
+	outputTar := t.createManifestArchive(c, false)
+	manifest := fmt.Sprintf("%s  TarFile1\n%s  TarFile2\n%s  TarMissing\n",
+		sha256Hex("TarFile1"), sha256Hex("tampered"), sha256Hex("TarMissing"))
+	err := VerifyAgainstManifest(outputTar, strings.NewReader(manifest))
+	c.Assert(err, gc.FitsTypeOf, &ManifestMismatchError{})
+	mismatch := err.(*ManifestMismatchError)
+	c.Assert(mismatch.Mismatched, gc.DeepEquals, []string{"TarFile2"})
+	c.Assert(mismatch.Missing, gc.DeepEquals, []string{"TarMissing"})
+	c.Assert(mismatch.Unexpected, gc.DeepEquals, []string{"TarDirectoryPopulated/TarSubFile1"})
+}
+
+func (t *TarSuite) TestVerifyAgainstManifestInvalid(c *gc.C) {
+	outputTar := t.createManifestArchive(c, false)
+	err := VerifyAgainstManifest(outputTar, strings.NewReader("not a manifest\n"))
+	c.Assert(err, gc.ErrorMatches, "cannot read manifest: line 1: invalid sha256sum line")
+}
+
+func (t *TarSuite) TestTarFilesWithManifest(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	c.Assert(shaSum, gc.Equals, shaSumFile(c, outputTar))
+
+	m, err := ReadManifest(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Version, gc.Equals, ManifestVersion)
+	c.Assert(m.Entries, gc.HasLen, len(testExpectedTarContents))
+	byPath := make(map[string]ManifestEntry)
+	for _, e := range m.Entries {
+		byPath[e.Path] = e
+	}
+	c.Assert(byPath["TarFile1"].Type, gc.Equals, "file")
+	c.Assert(byPath["TarFile1"].Size, gc.Equals, int64(8))
+	c.Assert(byPath["TarFile1"].SHA256, gc.Equals, sha256Hex("TarFile1"))
+	c.Assert(byPath["TarDirectoryEmpty"].Type, gc.Equals, "dir")
+	c.Assert(byPath["TarDirectoryEmpty"].SHA256, gc.Equals, "")
+
+	c.Assert(VerifyManifest(outputTar), gc.IsNil)
+	t.assertTarContents(c, testExpectedTarContents, outputTar, true)
+
+	// The manifest is not extracted.
+	t.removeTestFiles(c)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, true)
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, ManifestName))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestTarFilesWithManifestModTime(c *gc.C) {
+	dir := c.MkDir()
+	older, newer := time.Unix(1000000000, 0), time.Unix(1200000000, 0)
+	for name, mtime := range map[string]time.Time{"older": older, "newer": newer} {
+		p := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(p, []byte(name), 0644), gc.IsNil)
+		c.Assert(os.Chtimes(p, mtime, mtime), gc.IsNil)
+	}
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	files := []string{filepath.Join(dir, "older"), filepath.Join(dir, "newer")}
+	_, err := TarFiles(files, outputTar, dir+"/", false, WithManifest())
+	c.Assert(err, gc.IsNil)
+	hdr, err := HeadEntry(outputTar, ManifestName)
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.ModTime.Equal(newer), gc.Equals, true)
+}
+
+func (t *TarSuite) TestReadManifestMissing(c *gc.C) {
+	outputTar := t.createManifestArchive(c, false)
+	_, err := ReadManifest(outputTar)
+	c.Assert(err, gc.Equals, ErrNoManifest)
+}
+
+func (t *TarSuite) TestVerifyAgainstManifestObject(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithManifest())
+	c.Assert(err, gc.IsNil)
+	m, err := ReadManifest(outputTar)
+	c.Assert(err, gc.IsNil)
+	data, err := json.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	err = VerifyAgainstManifest(outputTar, bytes.NewReader(data))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestUntarFilesVerifyContents(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, true, WithVerifyContents())
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestUntarFilesVerifyContentsMismatch(c *gc.C) {
+	m := newManifest()
+	m.Entries = []ManifestEntry{
+		{Path: "good", SHA256: sha256Hex("good")},
+		{Path: "bad", SHA256: sha256Hex("expected")},
+		{Path: "missing", SHA256: sha256Hex("missing")},
+	}
+	data, err := json.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct{ name, body string }{
+		{ManifestName, string(data)},
+		{"good", "good"},
+		{"bad", "tampered"},
+		{"extra", "extra"},
+	} {
+		err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body))})
+		c.Assert(err, gc.IsNil)
+		_, err = tw.Write([]byte(e.body))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "tampered.tar")
+	err = ioutil.WriteFile(outputTar, buf.Bytes(), 0644)
+	c.Assert(err, gc.IsNil)
+
+	err = UntarFiles(outputTar, c.MkDir(), false, WithVerifyContents())
+	c.Assert(err, gc.FitsTypeOf, &ManifestMismatchError{})
+	mismatch := err.(*ManifestMismatchError)
+	c.Assert(mismatch.Mismatched, gc.DeepEquals, []string{"bad"})
+	c.Assert(mismatch.Missing, gc.DeepEquals, []string{"missing"})
+	c.Assert(mismatch.Unexpected, gc.DeepEquals, []string{"extra"})
+}
+
+func (t *TarSuite) TestUntarFilesVerifyContentsNoManifest(c *gc.C) {
+	outputTar := t.createManifestArchive(c, false)
+	outputDir := c.MkDir()
+	err := UntarFiles(outputTar, outputDir, false, WithVerifyContents())
+	c.Assert(err, gc.ErrorMatches, "cannot verify contents: archive has no manifest")
+	entries, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestManifestVersions(c *gc.C) {
+	v1 := `{"version": 1, "entries": [{"path": "a", "type": "file", "size": 1, "mode": 420, "mtime": "2014-01-01T00:00:00Z", "sha256": "` + sha256Hex("a") + `"}]}`
+	m, err := DecodeManifest(strings.NewReader(v1))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Version, gc.Equals, 1)
+	c.Assert(m.Entries[0].SHA256, gc.Equals, sha256Hex("a"))
+	c.Assert(m.Entries[0].Uname, gc.Equals, "")
+
+	_, err = DecodeManifest(strings.NewReader(`{"version": 3, "entries": []}`))
+	c.Assert(err, gc.ErrorMatches, "cannot decode manifest: unsupported version 3")
+
+	var schema map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(ManifestSchema), &schema), gc.IsNil)
+}
+
+func (t *TarSuite) TestManifestRoundTrip(c *gc.C) {
+	m := newManifest()
+	m.add(&tar.Header{
+		Name:       "etc/hosts",
+		Typeflag:   tar.TypeReg,
+		Mode:       0644,
+		Uid:        1000,
+		Uname:      "ubuntu",
+		ModTime:    time.Unix(1400000000, 0),
+		PAXRecords: map[string]string{xattrPrefix + "user.origin": "juju"},
+	}, sha256.New())
+	var buf bytes.Buffer
+	c.Assert(EncodeManifest(&buf, m), gc.IsNil)
+	decoded, err := DecodeManifest(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(decoded, gc.DeepEquals, m)
+	c.Assert(decoded.Entries[0].Xattrs, gc.DeepEquals, map[string][]byte{"user.origin": []byte("juju")})
+
+	m.Version = 0
+	c.Assert(EncodeManifest(&buf, m), gc.ErrorMatches, "cannot encode manifest: unsupported version 0")
+}
+
+func (t *TarSuite) TestDirManifest(c *gc.C) {
+	dir := filepath.Join(t.cwd, "tree")
+	c.Assert(os.MkdirAll(filepath.Join(dir, "sub"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("one"), 0644), gc.IsNil)
+	before, err := DirManifest(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(before.Version, gc.Equals, ManifestVersion)
+	c.Assert(before.Entries, gc.HasLen, 2)
+	c.Assert(before.Entries[1].Path, gc.Equals, "sub/file")
+	c.Assert(before.Entries[1].SHA256, gc.Equals, sha256Hex("one"))
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("two"), 0644), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644), gc.IsNil)
+	after, err := DirManifest(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(DiffManifests(before, after), gc.DeepEquals, &Diff{
+		Added:    []string{"new"},
+		Modified: []ModifiedEntry{{Path: "sub/file", Reasons: []DiffReason{DiffContent}}},
+	})
+}
+
+func (t *TarSuite) TestParseManifestEscapedPaths(c *gc.C) {
+	sum := sha256Hex("contents")
+	manifest := fmt.Sprintf("\\%s  new\\nline\n\\%s *back\\\\slash\n%s  plain\\name\n", sum, sum, sum)
+	digests, err := parseManifest(strings.NewReader(manifest))
+	c.Assert(err, gc.IsNil)
+	c.Assert(digests, gc.DeepEquals, map[string]string{
+		"new\nline":  sum,
+		`back\slash`: sum,
+		`plain\name`: sum,
+	})
+
+	_, err = parseManifest(strings.NewReader(fmt.Sprintf("\\%s  bad\\escape\n", sum)))
+	c.Assert(err, gc.ErrorMatches, "line 1: invalid escape in sha256sum path")
+	_, err = parseManifest(strings.NewReader(strings.Repeat("z", 64) + "  file\n"))
+	c.Assert(err, gc.ErrorMatches, `line 1: invalid sha256sum digest "z+"`)
+}
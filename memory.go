@@ -0,0 +1,94 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ExtractToMap reads the archive from r, which may be encrypted and
+// gzip compressed, and returns the contents of its regular files keyed
+// by their cleaned names, such as "etc/app.conf", without extracting
+// anything. Hard links are given the contents of their targets;
+// directories, symlinks and other entries are left out.
+//
+// As the whole contents are held in memory, archives that are not
+// trusted should be read WithLimits. The options accepted by
+// NewReader also apply; those only making sense when extracting to
+// disk, such as WithSecureExtraction or WithAtomicExtract, are
+// rejected.
+func ExtractToMap(r io.Reader, opts ...Option) (map[string][]byte, error) {
+	if problems := newOptions(opts).diskProblems("ExtractToMap"); len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	tr, err := NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+	files := make(map[string][]byte)
+	for {
+		hdr, contents, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanManifestPath(hdr.Name)
+		switch {
+		case hdr.Typeflag == tar.TypeLink:
+			data, found := files[cleanManifestPath(hdr.Linkname)]
+			if !found {
+				return nil, fmt.Errorf("cannot extract hard link %q: target %q not found", hdr.Name, hdr.Linkname)
+			}
+			files[name] = data
+		case hdr.FileInfo().Mode().IsRegular():
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(contents); err != nil {
+				return nil, fmt.Errorf("failed while reading tar contents: %v", err)
+			}
+			files[name] = buf.Bytes()
+		}
+	}
+}
+
+// diskProblems returns the problems of the options, given to the
+// function called fn, that only apply to extraction to disk.
+func (o *options) diskProblems(fn string) []string {
+	var problems []string
+	for _, opt := range []struct {
+		set  bool
+		name string
+	}{
+		{o.flatten, "WithFlatten"},
+		{o.secure, "WithSecureExtraction"},
+		{o.atomic, "WithAtomicExtract"},
+		{o.bestEffort, "WithBestEffort"},
+		{o.backupDir != "", "WithBackupDir"},
+		{o.stateFile != "", "WithResumableExtraction"},
+		{o.spaceCheck, "WithSpaceCheck"},
+		{o.preserveTimes, "WithPreserveTimes"},
+		{o.syncPolicy != SyncNone, "WithSyncPolicy"},
+		{o.ownerMap != nil, "WithOwnerMap"},
+		{o.modePolicy != ModePolicy{}, "WithModePolicy"},
+		{o.typeConflicts != TypeConflictError, "WithTypeConflicts"},
+		{o.duplicatePolicy != DuplicateLastWins, "WithDuplicatePolicy"},
+		{o.caseCollisions != CollisionIgnore, "WithCaseCollisions"},
+		{o.normCollisions != CollisionIgnore, "WithNormalizationCollisions"},
+		{o.verifyContents, "WithVerifyContents"},
+		{o.report != nil, "WithReport"},
+		{o.target != nil, "WithTarget"},
+		{o.memoryBudget != 0, "WithMemoryBudget"},
+		{o.concurrency.Write != 0, "Concurrency.Write"},
+	} {
+		if opt.set {
+			problems = append(problems, opt.name+" cannot be used with "+fn)
+		}
+	}
+	return problems
+}
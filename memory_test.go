@@ -0,0 +1,100 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestExtractToMap(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+
+	files, err := ExtractToMap(f)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 3)
+	for name, data := range files {
+		want, err := ioutil.ReadFile(filepath.Join(t.cwd, name))
+		c.Assert(err, gc.IsNil)
+		c.Check(data, gc.DeepEquals, want, gc.Commentf("%s", name))
+	}
+}
+
+func (t *TarSuite) TestExtractToMapOptions(c *gc.C) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{tar.Header{Name: "root/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "root/app.conf", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}, "conf"},
+		{tar.Header{Name: "root/tmp/scratch", Typeflag: tar.TypeReg, Mode: 0644, Size: 7}, "scratch"},
+		{tar.Header{Name: "root/hard", Typeflag: tar.TypeLink, Linkname: "root/app.conf"}, ""},
+		{tar.Header{Name: "root/soft", Typeflag: tar.TypeSymlink, Linkname: "app.conf"}, ""},
+	} {
+		c.Assert(tw.WriteHeader(&e.hdr), gc.IsNil)
+		_, err := tw.Write([]byte(e.contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+
+	skip := func(hdr *tar.Header) bool {
+		return strings.Contains(hdr.Name, "/tmp/")
+	}
+	files, err := ExtractToMap(bytes.NewReader(buf.Bytes()), WithStripComponents(1), WithSkipFunc(skip))
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{
+		"app.conf": []byte("conf"),
+		"hard":     []byte("conf"),
+	})
+
+	_, err = ExtractToMap(bytes.NewReader(buf.Bytes()), WithLimits(Limits{MaxTotalSize: 10}))
+	c.Assert(err, gc.FitsTypeOf, &LimitError{})
+	c.Assert(err, gc.ErrorMatches, `extraction of "root/tmp/scratch" exceeds MaxTotalSize of 10`)
+}
+
+func (t *TarSuite) TestExtractToMapMaxFileSize(c *gc.C) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "big", Typeflag: tar.TypeReg, Mode: 0644, Size: 20}), gc.IsNil)
+	_, err := tw.Write(bytes.Repeat([]byte("x"), 20))
+	c.Assert(err, gc.IsNil)
+	c.Assert(tw.Close(), gc.IsNil)
+	_, err = ExtractToMap(&buf, WithLimits(Limits{MaxFileSize: 10}))
+	c.Assert(err, gc.ErrorMatches, `extraction of "big" exceeds MaxFileSize of 10`)
+}
+
+func (t *TarSuite) TestExtractToMapEncrypted(c *gc.C) {
+	t.createTestFiles(c)
+	var buf bytes.Buffer
+	_, err := TarFilesToWriter(t.testFiles, &buf, t.cwd+"/", true, WithPassphrase("secret"))
+	c.Assert(err, gc.IsNil)
+	files, err := ExtractToMap(bytes.NewReader(buf.Bytes()), WithPassphrase("secret"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(files["TarFile1"]), gc.Equals, "TarFile1")
+
+	_, err = ExtractToMap(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.NotNil)
+}
+
+func (t *TarSuite) TestExtractToMapDiskOptions(c *gc.C) {
+	_, err := ExtractToMap(strings.NewReader(""), WithFlatten(CollisionError), WithAtomicExtract(), WithBestEffort())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: "+
+		"WithFlatten cannot be used with ExtractToMap; "+
+		"WithAtomicExtract cannot be used with ExtractToMap; "+
+		"WithBestEffort cannot be used with ExtractToMap")
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// xattrPrefix prefixes the PAX records holding extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+// errXattrUnsupported is returned by setXattr on
+// platforms without extended attributes.
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// copySymlinks records whether symlinks that cannot be created
+// are replaced by a copy of their target, as creating them
+// needs special privileges on Windows.
+var copySymlinks = runtime.GOOS == "windows"
+
+// symlink creates a symlink; it is a variable so tests can
+// make it fail.
+var symlink = os.Symlink
+
+// restoreMetadata restores the ownership and extended attributes
+// recorded in hdr on the entry extracted at fullPath, reporting
+// whatever cannot be restored.
+func (x *extractor) restoreMetadata(fullPath string, hdr *tar.Header) {
+	report := x.opts.report
+	uid, gid, err := x.opts.mappedOwner(hdr)
+	switch {
+	case err != nil:
+		report.degrade(Degradation{
+			Kind:    DegradationOwnership,
+			Path:    hdr.Name,
+			Message: err.Error(),
+		})
+	case os.Geteuid() == 0:
+		if err := x.lchown(fullPath, uid, gid); err != nil {
+			report.degrade(Degradation{
+				Kind:    DegradationOwnership,
+				Path:    hdr.Name,
+				Message: fmt.Sprintf("cannot restore owner %d:%d: %v", uid, gid, err),
+			})
+		}
+	case uid != os.Getuid() || gid != os.Getgid():
+		report.degrade(Degradation{
+			Kind:    DegradationOwnership,
+			Path:    hdr.Name,
+			Message: fmt.Sprintf("owner %d:%d not restored without root privileges", uid, gid),
+		})
+	}
+	var names []string
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			names = append(names, strings.TrimPrefix(key, xattrPrefix))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := errors.New("extended attributes of symlinks are not restored")
+		if hdr.Typeflag != tar.TypeSymlink {
+			err = x.setXattr(fullPath, name, hdr.PAXRecords[xattrPrefix+name])
+		}
+		if err != nil {
+			report.degrade(Degradation{
+				Kind:    DegradationXattr,
+				Path:    hdr.Name,
+				Message: fmt.Sprintf("cannot restore extended attribute %q: %v", name, err),
+			})
+		}
+	}
+}
+
+// copySymlink replaces the symlink described by hdr by a copy of
+// its target, if the target is a regular file already extracted,
+// and reports whether it did so.
+func (x *extractor) copySymlink(fullPath string, hdr *tar.Header) bool {
+	link := linkTarget(hdr.Linkname)
+	if filepath.IsAbs(link) {
+		return false
+	}
+	root, err := extractPath(x.outputFolder, "")
+	if err != nil {
+		return false
+	}
+	target := filepath.Join(filepath.Dir(fullPath), link)
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	info, err := os.Stat(target)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	contents, err := ioutil.ReadFile(target)
+	if err != nil {
+		return false
+	}
+	if err := x.writeFile(fullPath, contents, info.Mode().Perm()); err != nil {
+		return false
+	}
+	if x.unsynced != nil {
+		x.unsynced.add(fullPath)
+	}
+	x.opts.report.degrade(Degradation{
+		Kind:    DegradationSymlinkCopied,
+		Path:    hdr.Name,
+		Message: fmt.Sprintf("symlink to %q extracted as a copy of its target", hdr.Linkname),
+	})
+	return true
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io"
+	"time"
+)
+
+// Phase names a phase of archive creation or extraction
+// whose duration is reported to Metrics.
+type Phase string
+
+const (
+	// PhaseArchive is the writing of an archive.
+	PhaseArchive Phase = "archive"
+	// PhaseIndex is the indexing of an archive
+	// written WithSeekableGzip.
+	PhaseIndex Phase = "index"
+	// PhaseSpaceCheck is the estimation of the
+	// space needed WithSpaceCheck.
+	PhaseSpaceCheck Phase = "space-check"
+	// PhaseExtract is the extraction of an archive.
+	PhaseExtract Phase = "extract"
+	// PhaseSync is the flushing of the extracted
+	// files WithSyncPolicy SyncAtEnd.
+	PhaseSync Phase = "sync"
+)
+
+// Metrics receives measurements of archive creation and extraction,
+// so they can be exported to a monitoring system. Its methods may be
+// called concurrently and should return quickly.
+type Metrics interface {
+	// BytesRead is called with the number of bytes read, from
+	// the archived files when creating an archive and from the
+	// archive when extracting one.
+	BytesRead(n int64)
+	// BytesWritten is called with the number of bytes written,
+	// to the archive when creating one and to the extracted
+	// files when extracting one.
+	BytesWritten(n int64)
+	// EntryProcessed is called for every entry
+	// archived or extracted.
+	EntryProcessed(hdr *tar.Header)
+	// Error is called with the error an operation failed with.
+	Error(err error)
+	// PhaseDone is called with the time a phase took.
+	PhaseDone(phase Phase, d time.Duration)
+}
+
+// WithMetrics returns an Option that makes TarFiles and
+// UntarFiles report their measurements to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// nopMetrics is the Metrics used when none is given.
+type nopMetrics struct{}
+
+func (nopMetrics) BytesRead(int64)                {}
+func (nopMetrics) BytesWritten(int64)             {}
+func (nopMetrics) EntryProcessed(*tar.Header)     {}
+func (nopMetrics) Error(error)                    {}
+func (nopMetrics) PhaseDone(Phase, time.Duration) {}
+
+// meter returns the Metrics to report to.
+func (o *options) meter() Metrics {
+	if o.metrics == nil {
+		return nopMetrics{}
+	}
+	return o.metrics
+}
+
+// failed reports err, if not nil, to the metrics.
+func (o *options) failed(err error) {
+	if err != nil {
+		o.meter().Error(err)
+	}
+}
+
+// timePhase starts timing the given phase, and returns
+// a function reporting its duration when called.
+func (o *options) timePhase(phase Phase) func() {
+	if o.metrics == nil {
+		return func() {}
+	}
+	start := clock()
+	return func() {
+		o.metrics.PhaseDone(phase, clock().Sub(start))
+	}
+}
+
+// meteredReader reports the bytes read from r to count.
+type meteredReader struct {
+	r     io.Reader
+	count func(int64)
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if n > 0 {
+		m.count(int64(n))
+	}
+	return n, err
+}
+
+// meteredWriter reports the bytes written to w to count.
+type meteredWriter struct {
+	w     io.Writer
+	count func(int64)
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	if n > 0 {
+		m.count(int64(n))
+	}
+	return n, err
+}
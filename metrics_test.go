@@ -0,0 +1,84 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+// recordingMetrics is a Metrics recording what it is told.
+type recordingMetrics struct {
+	mu      sync.Mutex
+	read    int64
+	written int64
+	entries []string
+	errors  []error
+	phases  []Phase
+}
+
+func (m *recordingMetrics) BytesRead(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read += n
+}
+
+func (m *recordingMetrics) BytesWritten(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written += n
+}
+
+func (m *recordingMetrics) EntryProcessed(hdr *tar.Header) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, hdr.Name)
+}
+
+func (m *recordingMetrics) Error(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, err)
+}
+
+func (m *recordingMetrics) PhaseDone(phase Phase, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phases = append(m.phases, phase)
+}
+
+func (t *TarSuite) TestMetrics(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	var created recordingMetrics
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false, WithMetrics(&created))
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(created.written, gc.Equals, info.Size())
+	c.Assert(created.read, gc.Equals, int64(8+8+11))
+	c.Assert(created.entries, gc.HasLen, 6)
+	c.Assert(created.phases, gc.DeepEquals, []Phase{PhaseArchive})
+
+	var extracted recordingMetrics
+	err = UntarFiles(outputTar, c.MkDir(), false, WithMetrics(&extracted), WithSyncPolicy(SyncAtEnd))
+	c.Assert(err, gc.IsNil)
+	c.Assert(extracted.read, gc.Equals, info.Size())
+	c.Assert(extracted.written, gc.Equals, int64(8+8+11))
+	c.Assert(extracted.entries, gc.HasLen, 6)
+	c.Assert(extracted.phases, gc.DeepEquals, []Phase{PhaseSync, PhaseExtract})
+	c.Assert(extracted.errors, gc.HasLen, 0)
+
+	var failed recordingMetrics
+	err = UntarFiles(filepath.Join(t.cwd, "missing.tar"), c.MkDir(), false, WithMetrics(&failed))
+	c.Assert(err, gc.NotNil)
+	c.Assert(failed.errors, gc.DeepEquals, []error{err})
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WithMirror returns an Option that makes TarFiles also write the
+// archive, exactly as stored, to w, so that it can be sent to a
+// remote sink while it is saved locally. The option may be given
+// several times with distinct names. A mirror that fails is no longer
+// written to, but the others still receive the whole archive, and
+// the creation then fails with a *MirrorError naming every mirror
+// that failed. The archive at the main destination is kept in that
+// case. Mirrors are not closed.
+func WithMirror(name string, w io.Writer) Option {
+	return func(o *options) {
+		o.mirrors = append(o.mirrors, &mirrorWriter{name: name, w: w})
+	}
+}
+
+// MirrorError is returned when writing an archive to
+// some of the writers given WithMirror failed.
+type MirrorError struct {
+	// Errors holds the error of each failed mirror, by name.
+	Errors map[string]error
+}
+
+func (e *MirrorError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failed := make([]string, len(names))
+	for i, name := range names {
+		failed[i] = fmt.Sprintf("%q: %v", name, e.Errors[name])
+	}
+	return "cannot write archive to mirror " + strings.Join(failed, ", ")
+}
+
+// mirrorWriter writes to w until it fails, remembering
+// the error instead of returning it, so that a failing
+// mirror does not stop the writers alongside it.
+type mirrorWriter struct {
+	name string
+	w    io.Writer
+	err  error
+}
+
+func (m *mirrorWriter) Write(p []byte) (int, error) {
+	if m.err != nil {
+		return len(p), nil
+	}
+	n, err := m.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	m.err = err
+	return len(p), nil
+}
+
+// mirrorWriters returns the mirrors of o as writers.
+func (o *options) mirrorWriters() []io.Writer {
+	ws := make([]io.Writer, len(o.mirrors))
+	for i, m := range o.mirrors {
+		ws[i] = m
+	}
+	return ws
+}
+
+// mirrorError returns a *MirrorError describing the
+// failed mirrors of o, or nil if none failed.
+func (o *options) mirrorError() error {
+	errs := make(map[string]error)
+	for _, m := range o.mirrors {
+		if m.err != nil {
+			errs[m.name] = m.err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MirrorError{Errors: errs}
+}
+
+// validateMirrors adds the problems with the mirrors of o to problems.
+func (o *options) validateMirrors(problems []string) []string {
+	seen := make(map[string]bool)
+	for _, m := range o.mirrors {
+		switch {
+		case m.w == nil:
+			problems = append(problems, fmt.Sprintf("WithMirror %q needs a writer", m.name))
+		case seen[m.name]:
+			problems = append(problems, fmt.Sprintf("WithMirror %q given more than once", m.name))
+		}
+		seen[m.name] = true
+	}
+	if len(o.mirrors) > 0 && o.resume != nil {
+		problems = append(problems, "WithMirror cannot be used with WithResume")
+	}
+	return problems
+}
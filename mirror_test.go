@@ -0,0 +1,62 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.n = 0
+		return 0, errors.New("connection reset")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func (t *TarSuite) TestMirror(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	var remote bytes.Buffer
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithMirror("remote", &remote))
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(remote.Bytes(), gc.DeepEquals, data)
+
+	remote.Reset()
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", true,
+		WithMirror("remote", &remote),
+		WithMirror("broken", &failingWriter{n: 100}),
+	)
+	c.Assert(err, gc.ErrorMatches, `cannot write archive to mirror "broken": connection reset`)
+	c.Assert(err.(*MirrorError).Errors, gc.HasLen, 1)
+	data, err = ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(remote.Bytes(), gc.DeepEquals, data)
+	t.assertTarContents(c, testExpectedTarContents, outputTar, true)
+}
+
+func (t *TarSuite) TestMirrorValidation(c *gc.C) {
+	var remote bytes.Buffer
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false,
+		WithMirror("remote", &remote),
+		WithMirror("remote", &remote),
+		WithMirror("other", nil),
+	)
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithMirror "remote" given more than once; WithMirror "other" needs a writer`)
+
+	err = UntarFiles(filepath.Join(t.cwd, "out.tar"), t.cwd, false, WithMirror("remote", &remote))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithMirror only applies to archive creation")
+}
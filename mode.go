@@ -0,0 +1,64 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+)
+
+// specialModeBits holds the permission bits
+// beyond the read, write and execute ones.
+const specialModeBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ModePolicy controls the permissions given to extracted files and
+// directories, which otherwise get the mode recorded in the archive.
+// Archives created as root often hold modes unsafe to restore as is.
+type ModePolicy struct {
+	// FileMode, if not zero, replaces the mode
+	// of every extracted file.
+	FileMode os.FileMode
+	// DirMode, if not zero, replaces the mode
+	// of every extracted directory.
+	DirMode os.FileMode
+	// Umask holds bits cleared from every mode,
+	// as the process umask would.
+	Umask os.FileMode
+	// StripSpecial clears the setuid,
+	// setgid and sticky bits.
+	StripSpecial bool
+}
+
+// WithModePolicy returns an Option that makes UntarFiles
+// set the modes of extracted entries as described by p.
+func WithModePolicy(p ModePolicy) Option {
+	return func(o *options) {
+		o.modePolicy = p
+	}
+}
+
+// validate appends to problems the reasons
+// why p cannot be used.
+func (p ModePolicy) validate(problems []string) []string {
+	valid := os.ModePerm | specialModeBits
+	if p.FileMode&^valid != 0 || p.DirMode&^valid != 0 || p.Umask&^os.ModePerm != 0 {
+		problems = append(problems, "WithModePolicy needs permission bits only")
+	}
+	return problems
+}
+
+// mode returns the mode to give to the entry described by hdr.
+func (p ModePolicy) mode(hdr *tar.Header) os.FileMode {
+	mode := hdr.FileInfo().Mode() & (os.ModePerm | specialModeBits)
+	switch {
+	case hdr.Typeflag == tar.TypeDir && p.DirMode != 0:
+		mode = p.DirMode
+	case hdr.Typeflag != tar.TypeDir && p.FileMode != 0:
+		mode = p.FileMode
+	}
+	if p.StripSpecial {
+		mode &^= specialModeBits
+	}
+	return mode &^ p.Umask
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+var modePolicyTests = []struct {
+	about    string
+	policy   ModePolicy
+	fileMode os.FileMode
+	dirMode  os.FileMode
+}{{
+	about:    "archived modes",
+	fileMode: 0777 | os.ModeSetuid,
+	dirMode:  0777 | os.ModeSetgid,
+}, {
+	about:    "strip special bits",
+	policy:   ModePolicy{StripSpecial: true},
+	fileMode: 0777,
+	dirMode:  0777,
+}, {
+	about:    "umask",
+	policy:   ModePolicy{Umask: 022},
+	fileMode: 0755 | os.ModeSetuid,
+	dirMode:  0755 | os.ModeSetgid,
+}, {
+	about:    "fixed modes",
+	policy:   ModePolicy{FileMode: 0640, DirMode: 0750, Umask: 0007},
+	fileMode: 0640,
+	dirMode:  0750,
+}}
+
+func (t *TarSuite) TestModePolicy(c *gc.C) {
+	uid, gid := os.Getuid(), os.Getgid()
+	tarFile := filepath.Join(t.cwd, "modes.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "dir", Typeflag: tar.TypeDir, Mode: 02777, Uid: uid, Gid: gid},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 04777, Uid: uid, Gid: gid},
+	})
+	for i, test := range modePolicyTests {
+		c.Logf("test %d: %s", i, test.about)
+		outputDir := c.MkDir()
+		err := UntarFiles(tarFile, outputDir, false, WithModePolicy(test.policy))
+		c.Assert(err, gc.IsNil)
+		info, err := os.Stat(filepath.Join(outputDir, "dir"))
+		c.Assert(err, gc.IsNil)
+		c.Check(info.Mode()&^os.ModeDir, gc.Equals, test.dirMode)
+		info, err = os.Stat(filepath.Join(outputDir, "dir", "file"))
+		c.Assert(err, gc.IsNil)
+		c.Check(info.Mode(), gc.Equals, test.fileMode)
+	}
+}
+
+func (t *TarSuite) TestModePolicyInvalid(c *gc.C) {
+	err := UntarFiles("missing.tar", t.cwd, false, WithModePolicy(ModePolicy{Umask: os.ModeSetuid}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithModePolicy needs permission bits only")
+}
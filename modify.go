@@ -0,0 +1,119 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ModifyOp is an operation applied by ModifyArchive.
+type ModifyOp struct {
+	// pattern is matched against the names of
+	// the entries to delete.
+	pattern string
+	// name is the name of the entry whose contents
+	// are replaced, if the operation is a replacement.
+	name     string
+	contents []byte
+}
+
+// DeleteEntries returns an operation deleting the entries whose
+// names match the given path.Match pattern, such as
+// "home/*/.ssh/id_rsa", and for directories everything below them.
+func DeleteEntries(pattern string) ModifyOp {
+	return ModifyOp{pattern: pattern}
+}
+
+// ReplaceContents returns an operation replacing the contents of
+// the regular file called name with the given contents.
+func ReplaceContents(name string, contents []byte) ModifyOp {
+	return ModifyOp{
+		name:     cleanManifestPath(name),
+		contents: contents,
+	}
+}
+
+// ModifyArchive reads the archive from src, which may be gzip
+// compressed, and writes a copy to dst with the given operations
+// applied, in a single pass. When several operations apply to an
+// entry, the first one wins. It fails if a file to be replaced is
+// not found or is not a regular file. Otherwise it is like Repack:
+// the options for archive creation apply to the new archive, and the
+// returned hash is that of the new archive.
+func ModifyArchive(src io.Reader, dst io.Writer, compress bool, ops []ModifyOp, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return "", err
+	}
+	if o.volumeSize != 0 {
+		return "", &ConfigError{Problems: []string{"WithVolumeSize cannot be used when writing to an io.Writer"}}
+	}
+	for _, op := range ops {
+		if op.name != "" {
+			continue
+		}
+		if _, err := path.Match(op.pattern, ""); err != nil {
+			return "", fmt.Errorf("invalid pattern %q: %v", op.pattern, err)
+		}
+	}
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return "", err
+	}
+	replaced := make(map[string]bool)
+	// deletedDirs holds the directories deleted so far, as their
+	// contents may be found anywhere later in the archive.
+	var deletedDirs []string
+	err = writeEntries(dst, "", compress, shahash, o, func(a *archiver) error {
+		err := WalkArchive(src, func(hdr *tar.Header, r io.Reader) error {
+			name := cleanManifestPath(hdr.Name)
+			for _, dir := range deletedDirs {
+				if strings.HasPrefix(name, dir) {
+					return nil
+				}
+			}
+			for _, op := range ops {
+				if op.name == "" {
+					if ok, _ := path.Match(op.pattern, name); !ok {
+						continue
+					}
+					if hdr.Typeflag == tar.TypeDir {
+						deletedDirs = append(deletedDirs, name+"/")
+					}
+					return nil
+				}
+				if op.name != name {
+					continue
+				}
+				if !hdr.FileInfo().Mode().IsRegular() {
+					return fmt.Errorf("cannot replace %q: not a regular file", hdr.Name)
+				}
+				replaced[op.name] = true
+				h := *hdr
+				h.Size = int64(len(op.contents))
+				return a.repackEntry(&h, bytes.NewReader(op.contents))
+			}
+			return a.repackEntry(hdr, r)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot modify archive: %v", err)
+		}
+		for _, op := range ops {
+			if op.name != "" && !replaced[op.name] {
+				return fmt.Errorf("cannot replace %q: %v", op.name, ErrEntryNotFound)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return encodeArchiveHash(shahash), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestModifyArchive(c *gc.C) {
+	outputTar := t.createRangeArchive(c, true)
+	data, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	modified := filepath.Join(t.cwd, "modified.tar")
+	var buf bytes.Buffer
+	_, err = ModifyArchive(bytes.NewReader(data), &buf, false, []ModifyOp{
+		DeleteEntries("TarDirectoryPopulated"),
+		DeleteEntries("TarFile?"),
+		ReplaceContents("./TarLog", []byte("scrubbed")),
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(ioutil.WriteFile(modified, buf.Bytes(), 0644), gc.IsNil)
+
+	headers := readHeaders(c, modified)
+	c.Assert(headers, gc.HasLen, 2)
+	c.Assert(headers["TarDirectoryEmpty"], gc.NotNil)
+	var log bytes.Buffer
+	c.Assert(ExtractRange(modified, "TarLog", 0, -1, &log), gc.IsNil)
+	c.Assert(log.String(), gc.Equals, "scrubbed")
+}
+
+func (t *TarSuite) TestModifyArchiveDeleteScatteredDirectory(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "scattered.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "secrets/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "secrets/key", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "public", Typeflag: tar.TypeReg, Mode: 0644},
+		// Appended later, as tar -r does.
+		{Name: "secrets/other-key", Typeflag: tar.TypeReg, Mode: 0600},
+	})
+	f, err := os.Open(tarFile)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = ModifyArchive(f, &buf, false, []ModifyOp{DeleteEntries("secrets")})
+	c.Assert(err, gc.IsNil)
+	files, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{"public": {}})
+}
+
+func (t *TarSuite) TestModifyArchiveErrors(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	_, err = ModifyArchive(f, ioutil.Discard, false, []ModifyOp{ReplaceContents("TarMissing", nil)})
+	c.Assert(err, gc.ErrorMatches, `cannot replace "TarMissing": entry not found in archive`)
+	_, err = ModifyArchive(f, ioutil.Discard, false, []ModifyOp{DeleteEntries("[")})
+	c.Assert(err, gc.ErrorMatches, `invalid pattern "\[": syntax error in pattern`)
+}
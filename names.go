@@ -0,0 +1,127 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/unicode/norm"
+)
+
+// WithNameEncoding returns an Option that makes UntarFiles decode
+// entry and link names from enc, such as charmap.ISO8859_1 or
+// japanese.ShiftJIS, for archives created on systems that did not
+// use UTF-8. Names stored in PAX records are always UTF-8 and are
+// left alone.
+func WithNameEncoding(enc encoding.Encoding) Option {
+	return func(o *options) {
+		o.nameEncoding = enc
+	}
+}
+
+// WithUTF8Names returns an Option that makes TarFiles store every
+// name as UTF-8, in PAX records when it is not plain ASCII. Names
+// that are not valid UTF-8 are converted from legacy, or make
+// TarFiles fail if legacy is nil.
+func WithUTF8Names(legacy encoding.Encoding) Option {
+	return func(o *options) {
+		o.utf8Names = true
+		o.legacyEncoding = legacy
+	}
+}
+
+// WithNFCNames returns an Option that makes TarFiles store every
+// name in Unicode normalization form C, so that names created on
+// macOS, which uses decomposed forms, match those created elsewhere.
+// Names that are not valid UTF-8 are left alone.
+func WithNFCNames() Option {
+	return func(o *options) {
+		o.nfcNames = true
+	}
+}
+
+// WithNormalizationCollisions returns an Option that makes UntarFiles
+// detect entries whose names only differ in their Unicode
+// normalization form, such as "café" composed and decomposed, which
+// look the same and collide on some filesystems, and handle them as
+// described by p. Collisions are listed in Report.Collisions
+// WithReport.
+func WithNormalizationCollisions(p CollisionPolicy) Option {
+	return func(o *options) {
+		o.normCollisions = p
+	}
+}
+
+// utf8Name returns name as UTF-8, as requested by WithUTF8Names.
+func (o *options) utf8Name(name string) (string, error) {
+	if !o.utf8Names || utf8.ValidString(name) {
+		return name, nil
+	}
+	if o.legacyEncoding == nil {
+		return "", fmt.Errorf("name %q is not valid UTF-8", name)
+	}
+	converted, err := o.legacyEncoding.NewDecoder().String(name)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert name %q to UTF-8: %v", name, err)
+	}
+	return converted, nil
+}
+
+// setUTF8Names converts the names in h to UTF-8 and normalizes
+// them as requested by WithUTF8Names and WithNFCNames.
+func (o *options) setUTF8Names(h *tar.Header) error {
+	if !o.utf8Names && !o.nfcNames {
+		return nil
+	}
+	var err error
+	if h.Name, err = o.utf8Name(h.Name); err != nil {
+		return err
+	}
+	if h.Linkname, err = o.utf8Name(h.Linkname); err != nil {
+		return err
+	}
+	if o.nfcNames {
+		h.Name = norm.NFC.String(h.Name)
+		h.Linkname = norm.NFC.String(h.Linkname)
+	}
+	if !isASCII(h.Name) || !isASCII(h.Linkname) {
+		h.Format = tar.FormatPAX
+	}
+	return nil
+}
+
+// decodeNames decodes the names in hdr not stored
+// in PAX records as requested by WithNameEncoding.
+func (o *options) decodeNames(hdr *tar.Header) error {
+	if o.nameEncoding == nil {
+		return nil
+	}
+	decode := func(name *string, paxKey string) error {
+		if _, ok := hdr.PAXRecords[paxKey]; ok || isASCII(*name) {
+			return nil
+		}
+		decoded, err := o.nameEncoding.NewDecoder().String(*name)
+		if err != nil {
+			return fmt.Errorf("cannot decode name %q: %v", *name, err)
+		}
+		*name = decoded
+		return nil
+	}
+	if err := decode(&hdr.Name, "path"); err != nil {
+		return err
+	}
+	return decode(&hdr.Linkname, "linkpath")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
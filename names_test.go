@@ -0,0 +1,97 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestNameEncoding(c *gc.C) {
+	latin1, err := charmap.ISO8859_1.NewEncoder().String("café")
+	c.Assert(err, gc.IsNil)
+	sjis, err := japanese.ShiftJIS.NewEncoder().String("日本")
+	c.Assert(err, gc.IsNil)
+	tarFile := filepath.Join(t.cwd, "legacy.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: latin1, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatGNU},
+	})
+	outputDir := c.MkDir()
+	err = UntarFiles(tarFile, outputDir, false, WithNameEncoding(charmap.ISO8859_1))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, "café"))
+	c.Assert(err, gc.IsNil)
+
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: sjis, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatGNU},
+		{Name: "リンク", Linkname: "日本", Typeflag: tar.TypeSymlink, Format: tar.FormatPAX},
+	})
+	err = UntarFiles(tarFile, outputDir, false, WithNameEncoding(japanese.ShiftJIS))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, "日本"))
+	c.Assert(err, gc.IsNil)
+	link, err := os.Readlink(filepath.Join(outputDir, "リンク"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(link, gc.Equals, "日本")
+}
+
+func (t *TarSuite) TestUTF8Names(c *gc.C) {
+	latin1, err := charmap.ISO8859_1.NewEncoder().String("café")
+	c.Assert(err, gc.IsNil)
+	file := filepath.Join(t.cwd, latin1)
+	c.Assert(ioutil.WriteFile(file, []byte("coffee"), 0644), gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+
+	_, err = TarFiles([]string{file}, outputTar, t.cwd+"/", false, WithUTF8Names(nil))
+	c.Assert(err, gc.ErrorMatches, `backup failed: name "caf\\xe9" is not valid UTF-8`)
+
+	_, err = TarFiles([]string{file}, outputTar, t.cwd+"/", false, WithUTF8Names(charmap.ISO8859_1))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 1)
+	c.Assert(headers["café"], gc.NotNil)
+	c.Assert(headers["café"].PAXRecords["path"], gc.Equals, "café")
+}
+
+func (t *TarSuite) TestNFCNames(c *gc.C) {
+	decomposed := "cafe\u0301"
+	file := filepath.Join(t.cwd, decomposed)
+	c.Assert(ioutil.WriteFile(file, []byte("data"), 0644), gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles([]string{file}, outputTar, t.cwd+"/", false, WithNFCNames())
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 1)
+	c.Assert(headers["café"], gc.NotNil)
+}
+
+func (t *TarSuite) TestNormalizationCollisions(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "collisions.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"café", "composed"},
+		{"cafe\u0301", "decomposed"},
+	})
+	outputDir := c.MkDir()
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithNormalizationCollisions(CollisionRename), WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Collisions, gc.DeepEquals, []Collision{{
+		Name:        "cafe\u0301",
+		Existing:    "café",
+		Kind:        "normalization",
+		ExtractedAs: "cafe\u0301~1",
+	}})
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "cafe\u0301~1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "decomposed")
+
+	err = UntarFiles(tarFile, c.MkDir(), false, WithNormalizationCollisions(CollisionError))
+	c.Assert(err, gc.FitsTypeOf, &NameCollisionError{})
+}
@@ -0,0 +1,506 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+// Option configures optional behaviour of TarFiles and UntarFiles.
+type Option func(*options)
+
+// options holds the settings that can be changed by passing
+// an Option to TarFiles or UntarFiles.
+type options struct {
+	contentFilter     ContentFilter
+	skipFunc          SkipFunc
+	tempDir           string
+	dereference       bool
+	volumeSize        int64
+	encryptionKey     []byte
+	passphrase        string
+	warningFunc       WarningFunc
+	embedManifest     bool
+	verifyContents    bool
+	deterministicGzip bool
+	bookmarkEvery     int64
+	bookmarkFunc      BookmarkFunc
+	resume            *Bookmark
+	excludePatterns   []string
+	unknownPresets    []string
+	concurrency       Concurrency
+	seekableEvery     int64
+	report            *Report
+	createReport      *CreateReport
+	stateFile         string
+	atomic            bool
+	spaceCheck        bool
+	limits            Limits
+	modePolicy        ModePolicy
+	nameEncoding      encoding.Encoding
+	utf8Names         bool
+	legacyEncoding    encoding.Encoding
+	rootName          string
+	stripComponents   int
+	prefixPath        string
+	backupDir         string
+	duplicatePolicy   DuplicatePolicy
+	caseCollisions    CollisionPolicy
+	normCollisions    CollisionPolicy
+	flatten           bool
+	flattenCollisions CollisionPolicy
+	nfcNames          bool
+	target            Target
+	rateLimit         int64
+	syncPolicy        SyncPolicy
+	entryDelay        time.Duration
+	memoryBudget      int64
+	metrics           Metrics
+	logger            Logger
+	dedup             bool
+	typeConflicts     TypeConflictPolicy
+	secure            bool
+	ownerMap          *OwnerMap
+	numericOwner      bool
+	owner             *account
+	group             *account
+	bestEffort        bool
+	directWrite       bool
+	mirrors           []*mirrorWriter
+	format            ArchiveFormat
+	keyProvider       KeyProvider
+	keyContext        context.Context
+	strictness        Strictness
+	pathLimits        PathLimits
+	pathLimitPolicy   PathLimitPolicy
+	unreadable        UnreadablePolicy
+	preserveTimes     bool
+	snapshotter       Snapshotter
+	snapshotContext   context.Context
+	ignoreFile        string
+	minSize           int64
+	maxSize           int64
+	newerThan         time.Time
+	olderThan         time.Time
+	includeTypes      EntryTypes
+	excludeTypes      EntryTypes
+	fileRanges        map[string]FileRange
+	gzipHeader        *gzip.Header
+
+	// readFS is set by TarFromFS to the file
+	// system the archived files are read from.
+	readFS ReadFS
+
+	// keyProvided caches the key given by keyProvider.
+	keyProvided []byte
+
+	// zipInput is set by UnarchiveFiles when extracting a zip archive.
+	zipInput bool
+	// layer is set by CreateLayer, and whiteouts
+	// by ApplyLayer and ApplyOverlay.
+	layer     bool
+	whiteouts whiteoutFormat
+
+	// srcDir holds the directory archived by TarDirectory.
+	srcDir string
+
+	// compress records whether the archive is gzip compressed, so
+	// options that depend on the compression can be validated.
+	compress bool
+}
+
+// newOptions returns the options resulting from applying opts
+// over the defaults.
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// operation identifies what the options are being used for.
+type operation int
+
+const (
+	opCreate operation = iota
+	opExtract
+)
+
+func (op operation) String() string {
+	if op == opCreate {
+		return "archive creation"
+	}
+	return "extraction"
+}
+
+// ConfigError is returned, before any file is touched, when the
+// options given to TarFiles or UntarFiles cannot be used together.
+// It lists every problem found rather than just the first one.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// validate checks that the options make sense together and for the
+// given operation, returning a *ConfigError describing all conflicts.
+func (o *options) validate(op operation) error {
+	var problems []string
+	onlyFor := func(set bool, name string, valid operation) {
+		if set && op != valid {
+			problems = append(problems, name+" only applies to "+valid.String())
+		}
+	}
+	onlyFor(o.dereference, "WithDereference", opCreate)
+	onlyFor(o.volumeSize != 0, "WithVolumeSize", opCreate)
+	onlyFor(o.embedManifest, "WithManifest", opCreate)
+	onlyFor(o.verifyContents, "WithVerifyContents", opExtract)
+	onlyFor(o.deterministicGzip, "WithDeterministicGzip", opCreate)
+	if o.deterministicGzip && !o.compress {
+		problems = append(problems, "WithDeterministicGzip needs a compressed archive")
+	}
+	onlyFor(o.bookmarkFunc != nil, "WithBookmarks", opCreate)
+	onlyFor(o.resume != nil, "WithResume", opCreate)
+	if o.bookmarkFunc != nil || o.resume != nil {
+		if o.encrypted() {
+			problems = append(problems, "bookmarks cannot be used with encryption")
+		}
+		if o.embedManifest {
+			problems = append(problems, "bookmarks cannot be used with WithManifest")
+		}
+		if o.volumeSize != 0 {
+			problems = append(problems, "bookmarks cannot be used with WithVolumeSize")
+		}
+	}
+	if o.bookmarkFunc != nil && o.bookmarkEvery <= 0 {
+		problems = append(problems, "WithBookmarks needs a positive interval")
+	}
+	onlyFor(o.excludePatterns != nil, "WithPreset", opCreate)
+	onlyFor(o.ignoreFile != "", "WithIgnoreFile", opCreate)
+	onlyFor(o.minSize != 0, "WithMinSize", opCreate)
+	onlyFor(o.maxSize != 0, "WithMaxSize", opCreate)
+	onlyFor(!o.newerThan.IsZero(), "WithNewerThan", opCreate)
+	onlyFor(!o.olderThan.IsZero(), "WithOlderThan", opCreate)
+	problems = o.validateSelection(problems)
+	problems = o.validateTypes(problems)
+	onlyFor(o.fileRanges != nil, "WithFileRange", opCreate)
+	problems = o.validateFileRanges(problems)
+	onlyFor(o.gzipHeader != nil, "WithGzipHeader", opCreate)
+	if o.gzipHeader != nil {
+		if !o.compress {
+			problems = append(problems, "WithGzipHeader needs a compressed archive")
+		}
+		problems = validateGzipHeader(o.gzipHeader, problems)
+	}
+	if o.ignoreFile != "" {
+		problems = validateIgnoreFile(o.ignoreFile, problems)
+	}
+	for _, name := range o.unknownPresets {
+		problems = append(problems, fmt.Sprintf("unknown preset %q", name))
+	}
+	onlyFor(o.report != nil, "WithReport", opExtract)
+	onlyFor(o.createReport != nil, "WithCreateReport", opCreate)
+	onlyFor(o.unreadable != UnreadableError, "WithUnreadable", opCreate)
+	onlyFor(o.snapshotter != nil, "WithSnapshot", opCreate)
+	onlyFor(o.preserveTimes, "WithPreserveTimes", opExtract)
+	if o.snapshotter != nil && op == opCreate && o.srcDir == "" {
+		problems = append(problems, "WithSnapshot only applies to TarDirectory")
+	}
+	problems = o.unreadable.validate(problems)
+	onlyFor(o.backupDir != "", "WithBackupDir", opExtract)
+	onlyFor(o.duplicatePolicy != DuplicateLastWins, "WithDuplicatePolicy", opExtract)
+	problems = o.duplicatePolicy.validate(problems)
+	onlyFor(o.caseCollisions != CollisionIgnore, "WithCaseCollisions", opExtract)
+	problems = o.caseCollisions.validate("WithCaseCollisions", problems)
+	onlyFor(o.normCollisions != CollisionIgnore, "WithNormalizationCollisions", opExtract)
+	problems = o.normCollisions.validate("WithNormalizationCollisions", problems)
+	onlyFor(o.flatten, "WithFlatten", opExtract)
+	problems = o.flattenCollisions.validate("WithFlatten", problems)
+	if o.flatten && o.whiteouts != whiteoutNone {
+		problems = append(problems, "WithFlatten cannot be used when applying layers")
+	}
+	onlyFor(o.nfcNames, "WithNFCNames", opCreate)
+	onlyFor(o.stateFile != "", "WithResumableExtraction", opExtract)
+	onlyFor(o.atomic, "WithAtomicExtract", opExtract)
+	onlyFor(o.spaceCheck, "WithSpaceCheck", opExtract)
+	onlyFor(o.limits != Limits{}, "WithLimits", opExtract)
+	problems = o.limits.validate(problems)
+	onlyFor(o.modePolicy != ModePolicy{}, "WithModePolicy", opExtract)
+	problems = o.modePolicy.validate(problems)
+	onlyFor(o.nameEncoding != nil, "WithNameEncoding", opExtract)
+	onlyFor(o.stripComponents != 0, "WithStripComponents", opExtract)
+	if o.stripComponents < 0 {
+		problems = append(problems, "WithStripComponents needs a positive count")
+	}
+	if o.prefixPath != "" {
+		onlyFor(true, "WithPrefixPath", opExtract)
+		if o.prefixPath != cleanManifestPath(o.prefixPath) || o.prefixPath == "." {
+			problems = append(problems, fmt.Sprintf("WithPrefixPath needs a relative path, not %q", o.prefixPath))
+		}
+	}
+	if o.rootName != "" {
+		if o.srcDir == "" {
+			problems = append(problems, "WithRootName only applies to TarDirectory")
+		}
+		if name := strings.TrimSuffix(o.rootName, "/"); name != cleanManifestPath(name) || name == "." {
+			problems = append(problems, fmt.Sprintf("WithRootName needs a relative name, not %q", o.rootName))
+		}
+	}
+	onlyFor(o.utf8Names, "WithUTF8Names", opCreate)
+	if o.stateFile != "" && o.target != nil {
+		problems = append(problems, "WithResumableExtraction cannot be used with WithTarget")
+	}
+	if o.atomic && o.stateFile != "" {
+		problems = append(problems, "WithAtomicExtract cannot be used with WithResumableExtraction")
+	}
+	if o.stateFile != "" && o.concurrency.Write != 0 {
+		problems = append(problems, "WithResumableExtraction cannot be used with Concurrency.Write")
+	}
+	onlyFor(o.seekableEvery != 0, "WithSeekableGzip", opCreate)
+	if o.seekableEvery != 0 {
+		if !o.compress {
+			problems = append(problems, "WithSeekableGzip needs a compressed archive")
+		}
+		if o.seekableEvery < 0 {
+			problems = append(problems, "WithSeekableGzip needs a positive interval")
+		}
+		if o.encrypted() {
+			problems = append(problems, "WithSeekableGzip cannot be used with encryption")
+		}
+		if o.volumeSize != 0 {
+			problems = append(problems, "WithSeekableGzip cannot be used with WithVolumeSize")
+		}
+		if o.concurrency.Compress != 0 {
+			problems = append(problems, "WithSeekableGzip cannot be used with Concurrency.Compress")
+		}
+		if o.bookmarkFunc != nil || o.resume != nil {
+			problems = append(problems, "bookmarks cannot be used with WithSeekableGzip")
+		}
+		if o.target != nil {
+			problems = append(problems, "WithSeekableGzip cannot be used with WithTarget")
+		}
+	}
+	problems = o.concurrency.validate(op, problems)
+	if o.concurrency.Compress != 0 && o.compress && (o.bookmarkFunc != nil || o.resume != nil) {
+		problems = append(problems, "bookmarks cannot be used with Concurrency.Compress")
+	}
+	if o.verifyContents && o.contentFilter != nil {
+		problems = append(problems, "WithVerifyContents cannot be used with WithContentFilter")
+	}
+	onlyFor(o.syncPolicy != SyncNone, "WithSyncPolicy", opExtract)
+	problems = o.syncPolicy.validate(problems)
+	if o.entryDelay < 0 {
+		problems = append(problems, "WithEntryDelay needs a positive delay")
+	}
+	onlyFor(o.memoryBudget != 0, "WithMemoryBudget", opExtract)
+	onlyFor(o.dedup, "WithDedup", opCreate)
+	onlyFor(o.typeConflicts != TypeConflictError, "WithTypeConflicts", opExtract)
+	problems = o.typeConflicts.validate(problems)
+	onlyFor(o.skipFunc != nil, "WithSkipFunc", opExtract)
+	onlyFor(o.strictness != Strict, "WithStrictness", opExtract)
+	problems = o.strictness.validate(problems)
+	onlyFor(o.secure, "WithSecureExtraction", opExtract)
+	onlyFor(o.ownerMap != nil, "WithOwnerMap", opExtract)
+	onlyFor(o.bestEffort, "WithBestEffort", opExtract)
+	if o.bestEffort && o.atomic {
+		problems = append(problems, "WithBestEffort cannot be used with WithAtomicExtract")
+	}
+	onlyFor(o.directWrite, "WithDirectWrite", opCreate)
+	onlyFor(len(o.mirrors) > 0, "WithMirror", opCreate)
+	onlyFor(o.keyProvider != nil, "WithKeyProvider", opExtract)
+	if o.keyProvider != nil && o.encrypted() {
+		problems = append(problems, "WithKeyProvider cannot be used with WithEncryptionKey or WithPassphrase")
+	}
+	problems = o.validateMirrors(problems)
+	onlyFor(o.format != FormatTar, "WithFormat", opCreate)
+	onlyFor(o.pathLimits != 0 || o.pathLimitPolicy != PathLimitWarn, "WithPathLimits", opCreate)
+	problems = o.validatePathLimits(problems)
+	problems = o.format.validate(o, problems)
+	if o.whiteouts != whiteoutNone && o.atomic {
+		problems = append(problems, "WithAtomicExtract cannot be used when applying layers")
+	}
+	if o.zipInput && o.target != nil {
+		problems = append(problems, "zip archives cannot be extracted WithTarget")
+	}
+	onlyFor(o.numericOwner, "WithNumericOwner", opCreate)
+	onlyFor(o.owner != nil, "WithOwner", opCreate)
+	onlyFor(o.group != nil, "WithGroup", opCreate)
+	if o.owner != nil && o.owner.id < 0 {
+		problems = append(problems, "WithOwner needs a non-negative id")
+	}
+	if o.group != nil && o.group.id < 0 {
+		problems = append(problems, "WithGroup needs a non-negative id")
+	}
+	if o.secure && !secureExtraction {
+		problems = append(problems, "WithSecureExtraction is only supported on Linux")
+	}
+	if o.memoryBudget < 0 {
+		problems = append(problems, "WithMemoryBudget needs a positive size")
+	}
+	if o.rateLimit < 0 {
+		problems = append(problems, "WithRateLimit needs a positive rate")
+	}
+	if o.volumeSize < 0 {
+		problems = append(problems, "WithVolumeSize needs a positive size")
+	}
+	if o.encryptionKey != nil && len(o.encryptionKey) != encryptKeySize {
+		problems = append(problems, fmt.Sprintf("WithEncryptionKey needs a %d byte key", encryptKeySize))
+	}
+	if o.encryptionKey != nil && o.passphrase != "" {
+		problems = append(problems, "WithEncryptionKey and WithPassphrase cannot be used together")
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// WithContentFilter returns an Option that passes the contents of
+// every regular entry through f, both when archiving and when
+// extracting.
+func WithContentFilter(f ContentFilter) Option {
+	return func(o *options) {
+		o.contentFilter = f
+	}
+}
+
+// WithRootName returns an Option that makes TarDirectory store the
+// directory under the given name, such as "juju-backup", instead of
+// its base name.
+func WithRootName(name string) Option {
+	return func(o *options) {
+		o.rootName = strings.TrimSuffix(name, "/")
+	}
+}
+
+// WithTempDir returns an Option that places the temporary files
+// needed while archiving or extracting below dir instead of the
+// system temporary directory.
+func WithTempDir(dir string) Option {
+	return func(o *options) {
+		o.tempDir = dir
+	}
+}
+
+// WithDereference returns an Option that makes TarFiles archive the
+// files and directories symlinks point to instead of the symlinks
+// themselves, as tar -h does. Symlink loops cause an error.
+func WithDereference() Option {
+	return func(o *options) {
+		o.dereference = true
+	}
+}
+
+// WithVolumeSize returns an Option that makes TarFiles split the
+// archive into volumes of at most size bytes, named targetPath.000,
+// targetPath.001 and so on. UntarFiles reads such a sequence when
+// given targetPath. The returned hash covers the whole stream.
+func WithVolumeSize(size int64) Option {
+	return func(o *options) {
+		o.volumeSize = size
+	}
+}
+
+// WithEncryptionKey returns an Option that encrypts the archive with
+// AES-256-GCM under the given 32 byte key when archiving, and
+// decrypts it with that key when extracting. Encryption is applied
+// after compression, and the hash returned by TarFiles covers the
+// encrypted file.
+func WithEncryptionKey(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// WithPassphrase is like WithEncryptionKey, but derives the key from
+// the given passphrase using scrypt with a random salt stored in the
+// archive.
+func WithPassphrase(passphrase string) Option {
+	return func(o *options) {
+		o.passphrase = passphrase
+	}
+}
+
+// WithWarningFunc returns an Option that calls f with every
+// warning found while archiving or extracting.
+func WithWarningFunc(f WarningFunc) Option {
+	return func(o *options) {
+		o.warningFunc = f
+	}
+}
+
+// warn reports w to the warning function, if any.
+func (o *options) warn(w Warning) {
+	o.log().Warnf("%v", w)
+	if o.warningFunc != nil {
+		o.warningFunc(w)
+	}
+}
+
+// WithManifest returns an Option that makes TarFiles write a JSON
+// Manifest describing every entry, including the SHA-256 digest of
+// regular files, as the first entry of the archive. The manifest
+// can then be read quickly with ReadManifest. UntarFiles does not
+// extract it.
+func WithManifest() Option {
+	return func(o *options) {
+		o.embedManifest = true
+	}
+}
+
+// WithVerifyContents returns an Option that makes UntarFiles hash
+// every extracted file and compare it with the digest recorded in
+// the manifest embedded by TarFiles WithManifest. Extraction fails
+// if the archive has no manifest; otherwise every file is extracted
+// and a *ManifestMismatchError is returned if any did not match.
+func WithVerifyContents() Option {
+	return func(o *options) {
+		o.verifyContents = true
+	}
+}
+
+// WithDeterministicGzip returns an Option that makes the gzip stream
+// written by TarFiles depend only on the tar stream: the gzip
+// modification time and OS fields are zeroed and the compression
+// level is fixed. Identical tar streams then produce byte-identical
+// compressed archives.
+func WithDeterministicGzip() Option {
+	return func(o *options) {
+		o.deterministicGzip = true
+	}
+}
+
+// WithBookmarks returns an Option that calls f with a Bookmark at the
+// first entry boundary after every interval bytes of output, so that
+// an interrupted transfer can later be resumed WithResume. When the
+// archive is compressed, a new gzip member is started at each
+// bookmark.
+func WithBookmarks(interval int64, f BookmarkFunc) Option {
+	return func(o *options) {
+		o.bookmarkEvery = interval
+		o.bookmarkFunc = f
+	}
+}
+
+// WithResume returns an Option that regenerates an archive stream
+// from the given bookmark onwards: only the output following the
+// bookmark is written, and the returned hash covers the whole stream.
+// The file list and other options must be the same as those of the
+// run that reported the bookmark, and the files must not have changed.
+// WithBookmarks may be used as well to keep reporting bookmarks, but
+// its interval must not change.
+func WithResume(b Bookmark) Option {
+	return func(o *options) {
+		o.resume = &b
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestValidateNoOptions(c *gc.C) {
+	c.Assert(newOptions(nil).validate(opCreate), gc.IsNil)
+	c.Assert(newOptions(nil).validate(opExtract), gc.IsNil)
+}
+
+func (t *TarSuite) TestValidateCreateOnlyOption(c *gc.C) {
+	o := newOptions([]Option{WithDereference()})
+	c.Assert(o.validate(opCreate), gc.IsNil)
+	err := o.validate(opExtract)
+	c.Assert(err, gc.FitsTypeOf, &ConfigError{})
+	c.Assert(err.(*ConfigError).Problems, gc.DeepEquals, []string{
+		"WithDereference only applies to archive creation",
+	})
+}
+
+func (t *TarSuite) TestUntarFilesConfigErrorBeforeIO(c *gc.C) {
+	missing := filepath.Join(t.cwd, "missing.tar")
+	err := UntarFiles(missing, t.cwd, false, WithDereference())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDereference only applies to archive creation")
+	_, isConfig := err.(*ConfigError)
+	c.Assert(isConfig, gc.Equals, true)
+	_, err = os.Stat(missing)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestValidateSeekableGzip(c *gc.C) {
+	o := newOptions([]Option{WithSeekableGzip(-1), WithVolumeSize(10)})
+	err := o.validate(opCreate)
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithSeekableGzip needs a compressed archive; "+
+		"WithSeekableGzip needs a positive interval; WithSeekableGzip cannot be used with WithVolumeSize")
+}
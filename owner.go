@@ -0,0 +1,121 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// OwnerMap maps the owners recorded in an archive to those of the
+// system it is extracted on, for restoring backups onto machines
+// with different accounts. Names are mapped first; an entry whose
+// user or group name is not mapped has its id mapped, if it is.
+type OwnerMap struct {
+	// Users maps user names in the archive to local user names.
+	Users map[string]string
+	// Groups maps group names in the archive to local group names.
+	Groups map[string]string
+	// UIDs maps user ids in the archive to local user ids.
+	UIDs map[int]int
+	// GIDs maps group ids in the archive to local group ids.
+	GIDs map[int]int
+}
+
+// WithOwnerMap returns an Option that makes UntarFiles give extracted
+// entries the owners m maps theirs to. Ownership is only restored
+// when running as root.
+func WithOwnerMap(m OwnerMap) Option {
+	return func(o *options) {
+		o.ownerMap = &m
+	}
+}
+
+// WithNumericOwner returns an Option that makes TarFiles record only
+// the numeric ids of the owners of archived files, not their names,
+// so that archives do not leak local account names and are restored
+// by id on every system.
+func WithNumericOwner() Option {
+	return func(o *options) {
+		o.numericOwner = true
+	}
+}
+
+// WithOwner returns an Option that makes TarFiles record every
+// archived file as owned by the user with the given name and id.
+// The name may be empty.
+func WithOwner(name string, uid int) Option {
+	return func(o *options) {
+		o.owner = &account{name: name, id: uid}
+	}
+}
+
+// WithGroup returns an Option that makes TarFiles record every
+// archived file as belonging to the group with the given name and
+// id. The name may be empty.
+func WithGroup(name string, gid int) Option {
+	return func(o *options) {
+		o.group = &account{name: name, id: gid}
+	}
+}
+
+// account holds the name and id of a user or group.
+type account struct {
+	name string
+	id   int
+}
+
+// setOwner sets the owner recorded in h as requested.
+func (o *options) setOwner(h *tar.Header) {
+	if o.owner != nil {
+		h.Uname, h.Uid = o.owner.name, o.owner.id
+	}
+	if o.group != nil {
+		h.Gname, h.Gid = o.group.name, o.group.id
+	}
+	if o.numericOwner {
+		h.Uname, h.Gname = "", ""
+	}
+}
+
+// lookupUser and lookupGroup are variables so tests
+// can fake the local accounts.
+var (
+	lookupUser  = user.Lookup
+	lookupGroup = user.LookupGroup
+)
+
+// mappedOwner returns the local owner of the entry with the given header.
+func (o *options) mappedOwner(hdr *tar.Header) (uid, gid int, err error) {
+	uid, gid = hdr.Uid, hdr.Gid
+	m := o.ownerMap
+	if m == nil {
+		return uid, gid, nil
+	}
+	if name, ok := m.Users[hdr.Uname]; ok && hdr.Uname != "" {
+		u, err := lookupUser(name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot map user %q to %q: %v", hdr.Uname, name, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("cannot map user %q to %q: invalid uid %q", hdr.Uname, name, u.Uid)
+		}
+	} else if id, ok := m.UIDs[hdr.Uid]; ok {
+		uid = id
+	}
+	if name, ok := m.Groups[hdr.Gname]; ok && hdr.Gname != "" {
+		g, err := lookupGroup(name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot map group %q to %q: %v", hdr.Gname, name, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("cannot map group %q to %q: invalid gid %q", hdr.Gname, name, g.Gid)
+		}
+	} else if id, ok := m.GIDs[hdr.Gid]; ok {
+		gid = id
+	}
+	return uid, gid, nil
+}
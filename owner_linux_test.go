@@ -0,0 +1,43 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestUntarOwnerMap(c *gc.C) {
+	t.patchAccounts()
+	tarFile := filepath.Join(t.cwd, "owners.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Uname: "ubuntu", Gname: "ubuntu", Uid: 1000, Gid: 1000},
+	})
+	outputDir := c.MkDir()
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithReport(&report), WithOwnerMap(OwnerMap{
+		Users:  map[string]string{"ubuntu": "juju"},
+		Groups: map[string]string{"ubuntu": "juju"},
+	}))
+	c.Assert(err, gc.IsNil)
+	if os.Geteuid() != 0 {
+		c.Assert(report.Degradations, gc.DeepEquals, []Degradation{{
+			Kind:    DegradationOwnership,
+			Path:    "file",
+			Message: fmt.Sprintf("owner %d:%d not restored without root privileges", 110, 120),
+		}})
+		return
+	}
+	c.Assert(report.Degradations, gc.HasLen, 0)
+	info, err := os.Lstat(filepath.Join(outputDir, "file"))
+	c.Assert(err, gc.IsNil)
+	st := info.Sys().(*syscall.Stat_t)
+	c.Assert(st.Uid, gc.Equals, uint32(110))
+	c.Assert(st.Gid, gc.Equals, uint32(120))
+}
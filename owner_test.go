@@ -0,0 +1,92 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) patchAccounts() {
+	t.PatchValue(&lookupUser, func(name string) (*user.User, error) {
+		if name == "juju" {
+			return &user.User{Username: name, Uid: "110"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	})
+	t.PatchValue(&lookupGroup, func(name string) (*user.Group, error) {
+		if name == "juju" {
+			return &user.Group{Name: name, Gid: "120"}, nil
+		}
+		return nil, user.UnknownGroupError(name)
+	})
+}
+
+func (t *TarSuite) TestOwnerMap(c *gc.C) {
+	t.patchAccounts()
+	m := &OwnerMap{
+		Users:  map[string]string{"ubuntu": "juju", "gone": "nobody-here"},
+		Groups: map[string]string{"ubuntu": "juju"},
+		UIDs:   map[int]int{1000: 2000},
+		GIDs:   map[int]int{1000: 3000},
+	}
+	o := &options{ownerMap: m}
+	for _, test := range []struct {
+		hdr      tar.Header
+		uid, gid int
+		err      string
+	}{
+		{hdr: tar.Header{Uname: "ubuntu", Gname: "ubuntu", Uid: 1000, Gid: 1000}, uid: 110, gid: 120},
+		{hdr: tar.Header{Uname: "other", Gname: "other", Uid: 1000, Gid: 1000}, uid: 2000, gid: 3000},
+		{hdr: tar.Header{Uid: 5, Gid: 6}, uid: 5, gid: 6},
+		{hdr: tar.Header{Uname: "gone", Uid: 1000}, err: `cannot map user "gone" to "nobody-here": .*`},
+	} {
+		uid, gid, err := o.mappedOwner(&test.hdr)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, gc.IsNil)
+		c.Check(uid, gc.Equals, test.uid)
+		c.Check(gid, gc.Equals, test.gid)
+	}
+}
+
+func (t *TarSuite) TestNumericOwner(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	for _, test := range []struct {
+		opts         []Option
+		uname, gname string
+		uid, gid     int
+	}{{
+		opts: []Option{WithNumericOwner()},
+		uid:  os.Getuid(),
+		gid:  os.Getgid(),
+	}, {
+		opts:  []Option{WithOwner("juju", 110), WithGroup("juju", 120)},
+		uname: "juju",
+		gname: "juju",
+		uid:   110,
+		gid:   120,
+	}, {
+		opts: []Option{WithOwner("juju", 110), WithGroup("", 0), WithNumericOwner()},
+		uid:  110,
+	}} {
+		_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, test.opts...)
+		c.Assert(err, gc.IsNil)
+		for name, hdr := range readHeaders(c, outputTar) {
+			c.Check(hdr.Uname, gc.Equals, test.uname, gc.Commentf("%s", name))
+			c.Check(hdr.Gname, gc.Equals, test.gname, gc.Commentf("%s", name))
+			c.Check(hdr.Uid, gc.Equals, test.uid, gc.Commentf("%s", name))
+			c.Check(hdr.Gid, gc.Equals, test.gid, gc.Commentf("%s", name))
+		}
+	}
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithOwner("", -1))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithOwner needs a non-negative id")
+}
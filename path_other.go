@@ -0,0 +1,32 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !windows
+// +build !windows
+
+package tar
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// chmodDirs records whether the mode of extracted directories is set.
+const chmodDirs = true
+
+// oNoFollow makes opening a file fail if it is a symlink.
+const oNoFollow = syscall.O_NOFOLLOW
+
+// extractPath returns the path at which the entry
+// called name is extracted below outputFolder. Absolute
+// names are joined below it too; names with ".."
+// components are refused by checkHeader.
+func extractPath(outputFolder, name string) (string, error) {
+	return filepath.Join(outputFolder, name), nil
+}
+
+// linkTarget returns the target of a symlink
+// recorded in the archive as linkname.
+func linkTarget(linkname string) string {
+	return linkname
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"path/filepath"
+)
+
+// chmodDirs records whether the mode of extracted directories is
+// set. Windows only maps the mode to the read-only attribute, which
+// is meaningless for directories, so it is left alone.
+const chmodDirs = false
+
+// oNoFollow makes opening a file fail if it is a symlink.
+// Windows has no such flag.
+const oNoFollow = 0
+
+// extractPath returns the path at which the entry
+// called name is extracted below outputFolder.
+func extractPath(outputFolder, name string) (string, error) {
+	abs, err := filepath.Abs(outputFolder)
+	if err != nil {
+		return "", err
+	}
+	return windowsPath(abs, name)
+}
+
+// linkTarget returns the target of a symlink
+// recorded in the archive as linkname.
+func linkTarget(linkname string) string {
+	return filepath.FromSlash(linkname)
+}
@@ -0,0 +1,42 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestUntarLongPathWindows(c *gc.C) {
+	name := strings.Repeat("directory/", 30) + "file"
+	tarFile := filepath.Join(t.cwd, "long.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: strings.TrimSuffix(name, "/file"), Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: name, Typeflag: tar.TypeReg, Mode: 0444},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.IsNil)
+
+	full, err := windowsPath(outputDir, name)
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(full)
+	c.Assert(err, gc.IsNil)
+	// Windows maps modes without write permission to the
+	// read-only attribute.
+	c.Assert(info.Mode().Perm()&0200, gc.Equals, os.FileMode(0))
+}
+
+func (t *TarSuite) TestUntarReservedNameWindows(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "reserved.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "aux.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := UntarFiles(tarFile, c.MkDir(), false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract "aux.txt" on Windows: "aux.txt" is a reserved name`)
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// PathLimits selects the limits on entry names
+// that TarFiles checks WithPathLimits.
+type PathLimits int
+
+const (
+	// PathLimitUSTAR checks that names fit the 100 byte name and
+	// 155 byte prefix fields of USTAR headers, and link targets
+	// the 100 byte link name field, so that the archive can be
+	// read by tools that support neither PAX nor GNU long names.
+	// It only applies to tar archives.
+	PathLimitUSTAR PathLimits = 1 << iota
+	// PathLimitWindows checks that names fit the 260 characters
+	// of Windows paths, and that their components fit the 255
+	// characters of Windows file names and are valid there. As
+	// names are checked on their own, the directory they are
+	// extracted to makes the paths longer still.
+	PathLimitWindows
+)
+
+// PathLimitPolicy decides what TarFiles does with
+// names exceeding the limits checked WithPathLimits.
+type PathLimitPolicy int
+
+const (
+	// PathLimitWarn reports a Warning of kind WarningPathLength
+	// and archives the entry anyway. This is the default.
+	PathLimitWarn PathLimitPolicy = iota
+	// PathLimitError fails the archive creation.
+	PathLimitError
+)
+
+const (
+	ustarNameSize   = 100
+	ustarPrefixSize = 155
+
+	windowsMaxName = 255
+)
+
+// WithPathLimits returns an Option that makes TarFiles check the
+// name of every entry against limits, handling those exceeding them
+// as described by p, so that archives failing to extract on other
+// systems are found when they are created.
+func WithPathLimits(limits PathLimits, p PathLimitPolicy) Option {
+	return func(o *options) {
+		o.pathLimits = limits
+		o.pathLimitPolicy = p
+	}
+}
+
+// validatePathLimits appends to problems any
+// problem with the path limits.
+func (o *options) validatePathLimits(problems []string) []string {
+	if o.pathLimits&^(PathLimitUSTAR|PathLimitWindows) != 0 {
+		problems = append(problems, fmt.Sprintf("WithPathLimits: unknown path limits %#x", int(o.pathLimits)))
+	}
+	if o.pathLimitPolicy < PathLimitWarn || o.pathLimitPolicy > PathLimitError {
+		problems = append(problems, fmt.Sprintf("WithPathLimits: unknown path limit policy %d", o.pathLimitPolicy))
+	}
+	if o.pathLimits&PathLimitUSTAR != 0 && o.format != FormatTar {
+		problems = append(problems, "PathLimitUSTAR only applies to tar archives")
+	}
+	return problems
+}
+
+// checkPathLimits checks the names in h against the limits
+// given WithPathLimits, returning an error for the first one
+// exceeded or warning about all of them, depending on the policy.
+func (o *options) checkPathLimits(h *tar.Header) error {
+	var problems []string
+	if o.pathLimits&PathLimitUSTAR != 0 {
+		if !fitsUSTAR(h.Name) {
+			problems = append(problems, fmt.Sprintf("name does not fit the %d byte name and %d byte prefix of USTAR headers", ustarNameSize, ustarPrefixSize))
+		}
+		if len(h.Linkname) > ustarNameSize {
+			problems = append(problems, fmt.Sprintf("link target is longer than the %d bytes of USTAR headers", ustarNameSize))
+		}
+	}
+	if o.pathLimits&PathLimitWindows != 0 {
+		name := strings.TrimSuffix(h.Name, "/")
+		if n := windowsLength(name); n > windowsMaxPath {
+			problems = append(problems, fmt.Sprintf("name is %d characters long, over the %d of Windows paths", n, windowsMaxPath))
+		}
+		for _, part := range strings.Split(name, "/") {
+			if n := windowsLength(part); n > windowsMaxName {
+				problems = append(problems, fmt.Sprintf("component %q is %d characters long, over the %d of Windows file names", part, n, windowsMaxName))
+			}
+			if err := checkWindowsName(part); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	for _, problem := range problems {
+		if o.pathLimitPolicy == PathLimitError {
+			return fmt.Errorf("entry %q: %s", h.Name, problem)
+		}
+		o.warn(Warning{
+			Kind:    WarningPathLength,
+			Path:    h.Name,
+			Message: problem,
+		})
+	}
+	return nil
+}
+
+// fitsUSTAR reports whether name fits a USTAR header, possibly
+// split at a slash between its prefix and name fields.
+func fitsUSTAR(name string) bool {
+	if len(name) <= ustarNameSize {
+		return true
+	}
+	length := len(name)
+	if length > ustarPrefixSize+1 {
+		length = ustarPrefixSize + 1
+	} else if name[length-1] == '/' {
+		length--
+	}
+	i := strings.LastIndex(name[:length], "/")
+	nlen := len(name) - i - 1
+	return i > 0 && nlen > 0 && nlen <= ustarNameSize
+}
+
+// windowsLength returns the length of s in the UTF-16
+// code units Windows measures paths in.
+func windowsLength(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
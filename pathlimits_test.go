@@ -0,0 +1,81 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestFitsUSTAR(c *gc.C) {
+	for i, test := range []struct {
+		name string
+		fits bool
+	}{
+		{strings.Repeat("a", 100), true},
+		{strings.Repeat("a", 101), false},
+		{strings.Repeat("a", 155) + "/" + strings.Repeat("b", 100), true},
+		{strings.Repeat("a", 156) + "/" + strings.Repeat("b", 100), false},
+		{strings.Repeat("a", 50) + "/" + strings.Repeat("b", 101), false},
+		{strings.Repeat("a", 150) + "/", false},
+		{strings.Repeat("a", 60) + "/" + strings.Repeat("b", 60) + "/", true},
+		{"/" + strings.Repeat("b", 100), false},
+	} {
+		c.Logf("test %d: %d bytes", i, len(test.name))
+		c.Check(fitsUSTAR(test.name), gc.Equals, test.fits)
+	}
+}
+
+func (t *TarSuite) TestTarFilesPathLimits(c *gc.C) {
+	dir := c.MkDir()
+	long := strings.Repeat("x", 120)
+	err := ioutil.WriteFile(filepath.Join(dir, long), []byte("long"), 0644)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(c.MkDir(), "long.tar")
+
+	var warnings []Warning
+	_, err = TarFiles([]string{filepath.Join(dir, long)}, outputTar, dir+"/", false,
+		WithPathLimits(PathLimitUSTAR|PathLimitWindows, PathLimitWarn),
+		WithWarningFunc(func(w Warning) { warnings = append(warnings, w) }))
+	c.Assert(err, gc.IsNil)
+	c.Assert(warnings, gc.DeepEquals, []Warning{{
+		Kind:    WarningPathLength,
+		Path:    long,
+		Message: "name does not fit the 100 byte name and 155 byte prefix of USTAR headers",
+	}})
+	c.Assert(readHeaders(c, outputTar)[long], gc.NotNil)
+
+	_, err = TarFiles([]string{filepath.Join(dir, long)}, outputTar, dir+"/", false,
+		WithPathLimits(PathLimitUSTAR, PathLimitError))
+	c.Assert(err, gc.ErrorMatches, `backup failed: entry "x+": name does not fit .*`)
+
+	_, err = TarFiles([]string{filepath.Join(dir, long)}, outputTar, dir+"/", false,
+		WithPathLimits(PathLimitWindows, PathLimitError))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestCheckPathLimitsWindows(c *gc.C) {
+	o := newOptions([]Option{WithPathLimits(PathLimitWindows, PathLimitError)})
+	component := strings.Repeat("é", 256)
+	err := o.checkPathLimits(&tar.Header{Name: "dir/" + component})
+	c.Assert(err, gc.ErrorMatches, `entry "dir/é+": component "é+" is 256 characters long, over the 255 of Windows file names`)
+	name := strings.Repeat("abcdefghi/", 27)
+	err = o.checkPathLimits(&tar.Header{Name: name})
+	c.Assert(err, gc.ErrorMatches, `entry ".*": name is 269 characters long, over the 260 of Windows paths`)
+	err = o.checkPathLimits(&tar.Header{Name: "dir/aux.txt"})
+	c.Assert(err, gc.ErrorMatches, `entry "dir/aux.txt": "aux.txt" is a reserved name`)
+}
+
+func (t *TarSuite) TestWithPathLimitsInvalid(c *gc.C) {
+	_, err := TarFiles(nil, "x.tar", "", false, WithPathLimits(PathLimitUSTAR, PathLimitWarn), WithFormat(FormatZip))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: PathLimitUSTAR only applies to tar archives")
+	_, err = TarFiles(nil, "x.tar", "", false, WithPathLimits(8, PathLimitPolicy(3)))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithPathLimits: unknown path limits 0x8; WithPathLimits: unknown path limit policy 3")
+	err = UntarFiles("x.tar", c.MkDir(), false, WithPathLimits(PathLimitWindows, PathLimitWarn))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithPathLimits only applies to archive creation")
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ConflictKind describes how extracting an entry
+// would change what exists at its path.
+type ConflictKind string
+
+const (
+	// ConflictOverwrite is reported when an existing
+	// file would be overwritten.
+	ConflictOverwrite ConflictKind = "overwrite"
+	// ConflictDirToFile is reported when an existing directory
+	// would be replaced by a file or a symlink.
+	ConflictDirToFile ConflictKind = "dir-to-file"
+	// ConflictFileToDir is reported when an existing file or
+	// symlink would be replaced by a directory.
+	ConflictFileToDir ConflictKind = "file-to-dir"
+	// ConflictSymlink is reported when an existing
+	// symlink would be replaced.
+	ConflictSymlink ConflictKind = "symlink"
+)
+
+// Conflict describes an archive entry whose extraction
+// would change or destroy something that already exists.
+type Conflict struct {
+	// Name is the name of the entry in the archive, or that of
+	// the directory implied by an entry's name.
+	Name string
+	// Path is where the entry would be extracted.
+	Path string
+	// Kind tells what would happen to the existing file.
+	Kind ConflictKind
+}
+
+// PreflightCheck reads the archive tarFile, as UntarFiles would with
+// the same options, and returns the conflicts its extraction into
+// outputFolder would cause, without changing anything, so that an
+// operator can be asked before a destructive restore. If compressed is
+// true, the archive is expected to be gzip compressed.
+func PreflightCheck(tarFile, outputFolder string, compressed bool, opts ...Option) ([]Conflict, error) {
+	o := newOptions(opts)
+	o.compress = compressed
+	if err := o.validate(opExtract); err != nil {
+		return nil, err
+	}
+	r, closeInput, err := openExtractStream(tarFile, compressed, o)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	var conflicts []Conflict
+	reported := make(map[string]bool)
+	report := func(name, fullPath string, kind ConflictKind) {
+		if !reported[fullPath] {
+			reported[fullPath] = true
+			conflicts = append(conflicts, Conflict{Name: name, Path: fullPath, Kind: kind})
+		}
+	}
+	tr := tar.NewReader(r)
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return conflicts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if first && hdr.Name == ManifestName {
+			continue
+		}
+		if err := o.decodeNames(hdr); err != nil {
+			return nil, err
+		}
+		name, ok := o.outputName(hdr.Name)
+		if !ok {
+			continue
+		}
+		name = cleanManifestPath(name)
+		// Directories implied by the name replace any file in the way.
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			fullPath, err := extractPath(outputFolder, dir)
+			if err != nil {
+				return nil, err
+			}
+			if info, err := os.Lstat(fullPath); err == nil && !info.IsDir() {
+				report(dir+"/", fullPath, ConflictFileToDir)
+			}
+		}
+		fullPath, err := extractPath(outputFolder, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			continue
+		}
+		if kind, ok := conflictKind(hdr, info); ok {
+			report(hdr.Name, fullPath, kind)
+		}
+	}
+}
+
+// conflictKind returns the conflict caused by extracting
+// the entry with the given header over the existing file
+// described by info, if any.
+func conflictKind(hdr *tar.Header, info os.FileInfo) (ConflictKind, bool) {
+	isDir := hdr.Typeflag == tar.TypeDir
+	switch {
+	case info.IsDir() && isDir:
+		return "", false
+	case info.IsDir():
+		return ConflictDirToFile, true
+	case isDir:
+		return ConflictFileToDir, true
+	case info.Mode()&os.ModeSymlink != 0:
+		return ConflictSymlink, true
+	}
+	return ConflictOverwrite, true
+}
@@ -0,0 +1,55 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestPreflightCheck(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "restore.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"etc/", ""},
+		{"etc/juju.conf", "new config"},
+		{"etc/fresh", "not there yet"},
+		{"data", "now a file"},
+		{"logs/", ""},
+		{"link", "replacing a symlink"},
+		{"blocked/file", "below a file"},
+	})
+	outputDir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(outputDir, "etc"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "etc", "juju.conf"), []byte("old"), 0644), gc.IsNil)
+	c.Assert(os.Mkdir(filepath.Join(outputDir, "data"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "logs"), []byte("a file"), 0644), gc.IsNil)
+	c.Assert(os.Symlink("etc", filepath.Join(outputDir, "link")), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "blocked"), []byte("a file"), 0644), gc.IsNil)
+
+	conflicts, err := PreflightCheck(tarFile, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(conflicts, gc.DeepEquals, []Conflict{
+		{Name: "etc/juju.conf", Path: filepath.Join(outputDir, "etc", "juju.conf"), Kind: ConflictOverwrite},
+		{Name: "data", Path: filepath.Join(outputDir, "data"), Kind: ConflictDirToFile},
+		{Name: "logs/", Path: filepath.Join(outputDir, "logs"), Kind: ConflictFileToDir},
+		{Name: "link", Path: filepath.Join(outputDir, "link"), Kind: ConflictSymlink},
+		{Name: "blocked/", Path: filepath.Join(outputDir, "blocked"), Kind: ConflictFileToDir},
+	})
+
+	// Nothing was changed.
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "etc", "juju.conf"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "old")
+
+	conflicts, err = PreflightCheck(tarFile, c.MkDir(), false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(conflicts, gc.HasLen, 0)
+
+	conflicts, err = PreflightCheck(tarFile, outputDir, false, WithPrefixPath("restored"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(conflicts, gc.HasLen, 0)
+}
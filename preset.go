@@ -0,0 +1,58 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"path/filepath"
+)
+
+// presets maps the names accepted by WithPreset to the patterns of
+// the files they exclude. Patterns are matched against the base name
+// of every file and directory with filepath.Match; a matching
+// directory is excluded with all its contents.
+var presets = map[string][]string{
+	"no-vcs": {
+		".git", ".hg", ".svn", ".bzr", "_darcs", "CVS",
+	},
+	"no-caches": {
+		".cache", "__pycache__", ".pytest_cache", ".npm",
+		"*.pyc", "*.pyo", ".DS_Store", "Thumbs.db",
+	},
+	"no-logs": {
+		"*.log", "*.log.[0-9]*", "*.log.gz",
+	},
+	"no-editor-temp": {
+		"*~", "*.swp", "*.swo", ".#*", "#*#",
+	},
+}
+
+// WithPreset returns an Option that makes TarFiles leave out the
+// files matched by each of the named presets: "no-vcs" (version
+// control metadata), "no-caches" (cache directories and compiled
+// files), "no-logs" (log files) and "no-editor-temp" (editor backup
+// and swap files).
+func WithPreset(names ...string) Option {
+	return func(o *options) {
+		for _, name := range names {
+			patterns, ok := presets[name]
+			if !ok {
+				o.unknownPresets = append(o.unknownPresets, name)
+				continue
+			}
+			o.excludePatterns = append(o.excludePatterns, patterns...)
+		}
+	}
+}
+
+// excluded reports whether the file with the given
+// name is excluded by the configured patterns.
+func (o *options) excluded(fileName string) bool {
+	base := filepath.Base(fileName)
+	for _, pattern := range o.excludePatterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
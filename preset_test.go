@@ -0,0 +1,40 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesWithPreset(c *gc.C) {
+	t.createTestFiles(c)
+	dir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	err := os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0755)
+	c.Assert(err, gc.IsNil)
+	for _, name := range []string{".git/HEAD", "app.log", "module.pyc", "notes.txt~", "keep.conf"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false,
+		WithPreset("no-vcs", "no-caches"), WithPreset("no-editor-temp"))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers["TarDirectoryPopulated/.git"], gc.IsNil)
+	c.Assert(headers["TarDirectoryPopulated/.git/HEAD"], gc.IsNil)
+	c.Assert(headers["TarDirectoryPopulated/module.pyc"], gc.IsNil)
+	c.Assert(headers["TarDirectoryPopulated/notes.txt~"], gc.IsNil)
+	c.Assert(headers["TarDirectoryPopulated/app.log"], gc.NotNil)
+	c.Assert(headers["TarDirectoryPopulated/keep.conf"], gc.NotNil)
+	c.Assert(headers["TarFile1"], gc.NotNil)
+}
+
+func (t *TarSuite) TestWithPresetUnknown(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false, WithPreset("no-logs", "no-such"))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: unknown preset "no-such"`)
+}
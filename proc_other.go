@@ -0,0 +1,52 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !windows
+// +build !windows
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// userTempRoot returns the directory below base that holds the run
+// directories of the current user, so that users sharing a temporary
+// directory never share the directory their files are written in.
+func userTempRoot(base string) string {
+	return filepath.Join(base, tempRoot+"-"+strconv.Itoa(os.Getuid()))
+}
+
+// checkTempRoot fails unless root is a directory owned by the current
+// user that no one else can use, as another user could otherwise have
+// created it to read or replace the files written below it.
+func checkTempRoot(root string) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", root)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%q is owned by uid %d", root, st.Uid)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%q has mode %v", root, perm)
+	}
+	return nil
+}
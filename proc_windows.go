@@ -0,0 +1,48 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// stillActive is the exit code of a process that is still running.
+const stillActive = 259
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// A process that cannot be queried exists.
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
+
+// userTempRoot returns the directory below base that holds the run
+// directories of the current user. The temporary directory of each
+// user is already private on Windows.
+func userTempRoot(base string) string {
+	return filepath.Join(base, tempRoot)
+}
+
+// checkTempRoot fails unless root is a directory.
+func checkTempRoot(root string) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", root)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// WithResumableExtraction returns an Option that makes UntarFiles
+// record its progress in stateFile after every entry. If extraction
+// is interrupted, calling UntarFiles again with the same archive,
+// output folder and state file skips the entries already extracted
+// whose size and contents on disk still match the archive. The state
+// file is removed once extraction succeeds.
+func WithResumableExtraction(stateFile string) Option {
+	return func(o *options) {
+		o.stateFile = stateFile
+	}
+}
+
+// extractState is the content of the state file
+// of a resumable extraction.
+type extractState struct {
+	// Size and ModTime identify the archive being extracted.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Entries is the number of entries completely extracted.
+	Entries int `json:"entries"`
+	// Last is the name of the last entry extracted.
+	Last string `json:"last"`
+}
+
+// progress records the progress of a resumable extraction.
+type progress struct {
+	stateFile string
+	// resumed records whether an interrupted run is being
+	// resumed, and done the number of entries it extracted.
+	resumed bool
+	done    int
+	current extractState
+}
+
+// loadProgress returns the progress of the extraction of tarFile
+// recorded in stateFile, which may not exist yet. A state file
+// recorded for a different archive is ignored.
+func loadProgress(stateFile, tarFile string) (*progress, error) {
+	info, err := os.Stat(tarFile)
+	if os.IsNotExist(err) {
+		info, err = os.Stat(volumeName(tarFile, 0))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	p := &progress{
+		stateFile: stateFile,
+		current: extractState{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		},
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read extraction state: %v", err)
+	}
+	var saved extractState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("cannot decode extraction state: %v", err)
+	}
+	if saved.Size == p.current.Size && saved.ModTime.Equal(p.current.ModTime) {
+		p.resumed = true
+		p.done = saved.Entries
+	}
+	return p, nil
+}
+
+// extracted reports whether the entry with the given header and
+// contents, at the current position in the archive, was extracted
+// to fullPath by the interrupted run. The entry following the last
+// one recorded may have been partly extracted, so it is removed.
+func (p *progress) extracted(fullPath string, hdr *tar.Header, contents []byte) bool {
+	n := p.current.Entries
+	if p.resumed && n == p.done {
+		if info, err := os.Lstat(fullPath); err == nil && !info.IsDir() {
+			os.Remove(fullPath)
+		}
+	}
+	if n >= p.done {
+		return false
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return false
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return info.IsDir()
+	case tar.TypeSymlink:
+		link, err := os.Readlink(fullPath)
+		return err == nil && link == linkTarget(hdr.Linkname)
+	}
+	if !info.Mode().IsRegular() || info.Size() != int64(len(contents)) {
+		return false
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	want := sha256.Sum256(contents)
+	return bytes.Equal(h.Sum(nil), want[:])
+}
+
+// completed records that the named entry was extracted.
+func (p *progress) completed(name string) error {
+	p.current.Entries++
+	p.current.Last = name
+	if p.current.Entries <= p.done {
+		// Nothing new to record yet.
+		return nil
+	}
+	data, err := json.Marshal(p.current)
+	if err != nil {
+		return fmt.Errorf("cannot encode extraction state: %v", err)
+	}
+	// The state is written to a temporary file and renamed, so
+	// an interruption never leaves a truncated state file.
+	tmp := p.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("cannot write extraction state: %v", err)
+	}
+	if err := os.Rename(tmp, p.stateFile); err != nil {
+		return fmt.Errorf("cannot write extraction state: %v", err)
+	}
+	return nil
+}
+
+// finish removes the state file of a completed extraction.
+func (p *progress) finish() error {
+	if err := os.Remove(p.stateFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove extraction state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestResumableExtraction(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := filepath.Join(t.cwd, "extracted")
+	c.Assert(os.Mkdir(outputDir, 0755), gc.IsNil)
+	stateFile := filepath.Join(t.cwd, "extract.state")
+
+	failing := func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		if hdr.Name == "TarFile2" {
+			return nil, errors.New("interrupted")
+		}
+		return r, nil
+	}
+	err = UntarFiles(outputTar, outputDir, false, WithResumableExtraction(stateFile), WithContentFilter(failing))
+	c.Assert(err, gc.ErrorMatches, `cannot filter contents of "TarFile2": interrupted`)
+	_, err = os.Stat(stateFile)
+	c.Assert(err, gc.IsNil)
+
+	// An unchanged file is skipped, a modified one extracted again.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	unchanged := filepath.Join(outputDir, "TarFile1")
+	c.Assert(os.Chtimes(unchanged, old, old), gc.IsNil)
+	modified := filepath.Join(outputDir, "TarDirectoryPopulated", "TarSubFile1")
+	c.Assert(ioutil.WriteFile(modified, []byte("garbage"), 0644), gc.IsNil)
+
+	err = UntarFiles(outputTar, outputDir, false, WithResumableExtraction(stateFile))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+	info, err := os.Stat(unchanged)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ModTime().Equal(old), gc.Equals, true)
+	_, err = os.Stat(stateFile)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestResumableExtractionOtherArchive(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	stateFile := filepath.Join(t.cwd, "extract.state")
+	err = ioutil.WriteFile(stateFile, []byte(`{"size": 1, "entries": 100}`), 0644)
+	c.Assert(err, gc.IsNil)
+	outputDir := filepath.Join(t.cwd, "extracted")
+	c.Assert(os.Mkdir(outputDir, 0755), gc.IsNil)
+	err = UntarFiles(outputTar, outputDir, false, WithResumableExtraction(stateFile))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
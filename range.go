@@ -0,0 +1,125 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// PAX records describing the part of a file archived WithFileRange.
+const (
+	paxRangeOffset = "JUJU.range.offset"
+	paxRangeSize   = "JUJU.range.size"
+)
+
+// FileRange selects the bytes of a file that are archived.
+type FileRange struct {
+	// Offset is where the range starts. A negative offset counts
+	// from the end of the file, so that -50<<20 selects the last
+	// 50 MB of a log.
+	Offset int64
+	// Length is the number of bytes archived, or
+	// zero for everything up to the end of the file.
+	Length int64
+}
+
+// WithFileRange returns an Option that makes TarFiles archive only
+// the bytes of the regular file fileName selected by r, as can be done
+// to build compact diagnostic bundles from huge files. Ranges that
+// reach beyond the file are cut at its ends. The offset of the range
+// and the size of the whole file are recorded in PAX records, which
+// EntryRange reads back. The option may be given once per file.
+func WithFileRange(fileName string, r FileRange) Option {
+	return func(o *options) {
+		if o.fileRanges == nil {
+			o.fileRanges = make(map[string]FileRange)
+		}
+		o.fileRanges[filepath.Clean(fileName)] = r
+	}
+}
+
+// EntryRange returns the offset in the original file of the contents
+// of the entry described by hdr and the size of the whole file, if
+// only part of it was archived WithFileRange.
+func EntryRange(hdr *tar.Header) (offset, size int64, ok bool) {
+	offsetRecord, ok1 := hdr.PAXRecords[paxRangeOffset]
+	sizeRecord, ok2 := hdr.PAXRecords[paxRangeSize]
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	offset, err1 := strconv.ParseInt(offsetRecord, 10, 64)
+	size, err2 := strconv.ParseInt(sizeRecord, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return offset, size, true
+}
+
+// validateFileRanges appends to problems the reasons
+// why the ranges given WithFileRange cannot be used.
+func (o *options) validateFileRanges(problems []string) []string {
+	var fileNames []string
+	for fileName := range o.fileRanges {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	for _, fileName := range fileNames {
+		if o.fileRanges[fileName].Length < 0 {
+			problems = append(problems, fmt.Sprintf("WithFileRange needs a non-negative length for %q", fileName))
+		}
+	}
+	return problems
+}
+
+// span returns the offset and length of the bytes
+// selected by r in a file of the given size.
+func (r FileRange) span(size int64) (offset, length int64) {
+	offset = r.Offset
+	if offset < 0 {
+		offset += size
+	}
+	switch {
+	case offset < 0:
+		offset = 0
+	case offset > size:
+		offset = size
+	}
+	length = size - offset
+	if r.Length != 0 && r.Length < length {
+		length = r.Length
+	}
+	return offset, length
+}
+
+// readRange returns a reader for the part of the file fileName, read
+// from r, that is selected WithFileRange, updating h to describe it.
+// It returns r unchanged if no range was given for the file.
+func (a *archiver) readRange(fileName string, h *tar.Header, r io.Reader) (io.Reader, error) {
+	fr, ok := a.opts.fileRanges[filepath.Clean(fileName)]
+	if !ok || h.Typeflag != tar.TypeReg {
+		return r, nil
+	}
+	offset, length := fr.span(h.Size)
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("cannot seek in %q: %v", fileName, err)
+		}
+	} else if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		return nil, fmt.Errorf("cannot skip to offset %d of %q: %v", offset, fileName, err)
+	}
+	if h.PAXRecords == nil {
+		h.PAXRecords = make(map[string]string)
+	}
+	h.PAXRecords[paxRangeOffset] = strconv.FormatInt(offset, 10)
+	h.PAXRecords[paxRangeSize] = strconv.FormatInt(h.Size, 10)
+	h.Format = tar.FormatPAX
+	h.Size = length
+	return io.LimitReader(r, length), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesWithFileRange(c *gc.C) {
+	dir := filepath.Join(t.cwd, "logs")
+	c.Assert(os.Mkdir(dir, 0755), gc.IsNil)
+	logFile := filepath.Join(dir, "app.log")
+	c.Assert(ioutil.WriteFile(logFile, []byte("0123456789"), 0644), gc.IsNil)
+	confFile := filepath.Join(dir, "app.conf")
+	c.Assert(ioutil.WriteFile(confFile, []byte("conf"), 0644), gc.IsNil)
+
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles([]string{dir}, outputTar, t.cwd+"/", false,
+		WithFileRange(logFile, FileRange{Offset: -4}),
+		WithFileRange(confFile, FileRange{Offset: 1, Length: 2}))
+	c.Assert(err, gc.IsNil)
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	files, err := ExtractToMap(f)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{
+		"logs/app.log":  []byte("6789"),
+		"logs/app.conf": []byte("on"),
+	})
+
+	headers := readHeaders(c, outputTar)
+	offset, size, ok := EntryRange(headers["logs/app.log"])
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(offset, gc.Equals, int64(6))
+	c.Assert(size, gc.Equals, int64(10))
+	_, _, ok = EntryRange(headers["logs"])
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (t *TarSuite) TestFileRangeSpan(c *gc.C) {
+	for i, test := range []struct {
+		r              FileRange
+		offset, length int64
+	}{
+		{FileRange{}, 0, 10},
+		{FileRange{Offset: 3}, 3, 7},
+		{FileRange{Offset: 3, Length: 2}, 3, 2},
+		{FileRange{Offset: 8, Length: 5}, 8, 2},
+		{FileRange{Offset: 20}, 10, 0},
+		{FileRange{Offset: -3}, 7, 3},
+		{FileRange{Offset: -30, Length: 4}, 0, 4},
+	} {
+		c.Logf("test %d: %+v", i, test.r)
+		offset, length := test.r.span(10)
+		c.Check(offset, gc.Equals, test.offset)
+		c.Check(length, gc.Equals, test.length)
+	}
+}
+
+func (t *TarSuite) TestWithFileRangeInvalid(c *gc.C) {
+	_, err := TarFiles([]string{"x"}, filepath.Join(t.cwd, "out.tar"), "", false, WithFileRange("x", FileRange{Length: -1}))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithFileRange needs a non-negative length for "x"`)
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io"
+	"time"
+)
+
+// WithRateLimit returns an Option that limits the archive data
+// written by TarFiles, or read by UntarFiles, to bytesPerSecond on
+// average, so that backups running on busy machines do not saturate
+// their disks or network. Bursts of up to a second's worth of data
+// are allowed. The limit applies to the archive as stored, after
+// compression and encryption.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return func(o *options) {
+		o.rateLimit = bytesPerSecond
+	}
+}
+
+// clock and sleep are used by rateLimiter,
+// and may be replaced in tests.
+var (
+	clock = time.Now
+	sleep = time.Sleep
+)
+
+// rateLimiter is a token bucket holding up
+// to a second's worth of tokens, one per byte.
+type rateLimiter struct {
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: float64(rate), last: clock()}
+}
+
+// wait takes n tokens from the bucket, sleeping as long as
+// needed for the bucket to refill when it is overdrawn.
+func (l *rateLimiter) wait(n int) {
+	now := clock()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		sleep(time.Duration(-l.tokens / float64(l.rate) * float64(time.Second)))
+	}
+}
+
+// throttledWriter writes to w no faster than its limiter allows.
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.limiter.wait(len(p))
+	return t.w.Write(p)
+}
+
+// throttledReader reads from r no faster than its limiter allows.
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,55 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+// patchClock replaces the clock used by rate limiters with a fake
+// one that only advances when sleeping, and returns a function
+// returning the total time slept.
+func (t *TarSuite) patchClock() func() time.Duration {
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	t.PatchValue(&clock, func() time.Time { return now })
+	t.PatchValue(&sleep, func(d time.Duration) { now = now.Add(d) })
+	return func() time.Duration { return now.Sub(start) }
+}
+
+func (t *TarSuite) TestRateLimiter(c *gc.C) {
+	slept := t.patchClock()
+	l := newRateLimiter(1000)
+	l.wait(1000)
+	c.Assert(slept(), gc.Equals, time.Duration(0))
+	l.wait(500)
+	c.Assert(slept(), gc.Equals, 500*time.Millisecond)
+	l.wait(2000)
+	c.Assert(slept(), gc.Equals, 2500*time.Millisecond)
+}
+
+func (t *TarSuite) TestRateLimit(c *gc.C) {
+	t.createTestFiles(c)
+	slept := t.patchClock()
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, outputTar, trimPath, false, WithRateLimit(1024))
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(outputTar)
+	c.Assert(err, gc.IsNil)
+	// The first second's worth of data is a free burst.
+	expected := time.Duration(info.Size()-1024) * time.Second / 1024
+	c.Assert(slept(), gc.Equals, expected)
+
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false, WithRateLimit(info.Size()))
+	c.Assert(err, gc.IsNil)
+	c.Assert(slept(), gc.Equals, expected)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
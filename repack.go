@@ -0,0 +1,70 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// Repack reads the archive from src, which may be gzip compressed,
+// and writes it again to dst in a single streaming pass, without
+// extracting anything to disk. If compress is true, the new archive
+// is gzip compressed. The options for archive creation apply to the
+// new archive, so Repack can be used to encrypt old backups, filter
+// or exclude entries, or convert their names. A manifest embedded
+// in src is dropped, and written anew WithManifest. The returned
+// hash is that of the new archive, as returned by TarFiles.
+func Repack(src io.Reader, dst io.Writer, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return "", err
+	}
+	if o.volumeSize != 0 {
+		return "", &ConfigError{Problems: []string{"WithVolumeSize cannot be used when writing to an io.Writer"}}
+	}
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return "", err
+	}
+	err = writeEntries(dst, "", compress, shahash, o, func(a *archiver) error {
+		if err := WalkArchive(src, a.repackEntry); err != nil {
+			return fmt.Errorf("repack failed: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return encodeArchiveHash(shahash), nil
+}
+
+// repackEntry writes a copy of the entry with the given
+// header, reading its contents from r.
+func (a *archiver) repackEntry(hdr *tar.Header, r io.Reader) error {
+	if a.opts.excluded(hdr.Name) {
+		return nil
+	}
+	h := *hdr
+	if err := a.opts.setUTF8Names(&h); err != nil {
+		return err
+	}
+	if !h.FileInfo().Mode().IsRegular() {
+		return a.writeEntry(h.Name, &h, nil)
+	}
+	if a.opts.contentFilter != nil {
+		filtered, cleanup, err := filterContents(&h, r, a.opts.contentFilter, a.tmp)
+		if isWalkControl(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot filter contents of %q: %v", h.Name, err)
+		}
+		defer cleanup()
+		r = filtered
+	}
+	return a.writeEntry(h.Name, &h, r)
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestRepack(c *gc.C) {
+	t.createTestFiles(c)
+	srcTar := filepath.Join(t.cwd, "source.tar.gz")
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, srcTar, trimPath, true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	t.removeTestFiles(c)
+
+	src, err := os.Open(srcTar)
+	c.Assert(err, gc.IsNil)
+	defer src.Close()
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	dst, err := os.Create(outputTar)
+	c.Assert(err, gc.IsNil)
+	shaSum, err := Repack(src, dst, false, WithContentFilter(redactFilter), WithManifest())
+	c.Assert(err, gc.IsNil)
+	c.Assert(dst.Close(), gc.IsNil)
+	c.Assert(shaSum, gc.Equals, shaSumFile(c, outputTar))
+
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarDirectoryPopulated/TarSubFile1", "TarSub-REDACTED-1"},
+		{"TarFile1", "Tar-REDACTED-1"},
+		{"TarFile2", "Tar-REDACTED-2"},
+	}, outputTar, false)
+	c.Assert(VerifyManifest(outputTar), gc.IsNil)
+}
+
+func (t *TarSuite) TestRepackEncrypted(c *gc.C) {
+	outputTar := t.createRangeArchive(c, false)
+	src, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer src.Close()
+	var buf bytes.Buffer
+	_, err = Repack(src, &buf, true, WithEncryptionKey(testKey))
+	c.Assert(err, gc.IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("the tail")), gc.Equals, false)
+
+	encrypted := filepath.Join(t.cwd, "encrypted.tar.gz")
+	c.Assert(ioutil.WriteFile(encrypted, buf.Bytes(), 0644), gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(encrypted, outputDir, true, WithEncryptionKey(testKey))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(filepath.Join(outputDir, "TarLog"))
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"sync"
+)
+
+// Report describes the outcome of an extraction.
+type Report struct {
+	// Entries lists every path created by the extraction, in
+	// the order they were created. Directories that already
+	// existed are not listed.
+	Entries []ReportEntry
+
+	// Duplicates lists the names of the entries that repeated
+	// the name of an earlier entry, in archive order.
+	Duplicates []string
+
+	// Collisions lists the entries whose names collided
+	// with those of earlier entries, in archive order.
+	Collisions []Collision
+
+	// Degradations lists everything that could not be
+	// restored faithfully, in no particular order.
+	Degradations []Degradation
+
+	// PeakMemory is the most memory held
+	// for entry contents at any one time.
+	PeakMemory int64
+
+	mu sync.Mutex
+}
+
+// ReportEntry describes a path created by an extraction.
+type ReportEntry struct {
+	// Path is the path the entry was extracted at.
+	Path string
+	// Name is the name of the entry in the archive.
+	Name string
+	// Type is the type of the entry, named
+	// as in ManifestEntry.Type.
+	Type string
+	// Size is the number of bytes written.
+	Size int64
+	// Overwrote records whether an existing
+	// file was replaced.
+	Overwrote bool
+	// Backup is the path the replaced file was
+	// moved to WithBackupDir, if any.
+	Backup string
+}
+
+// DegradationKind identifies the kind of a Degradation.
+type DegradationKind string
+
+const (
+	// DegradationOwnership is reported when the owner or group
+	// of an entry could not be restored.
+	DegradationOwnership DegradationKind = "ownership"
+	// DegradationXattr is reported when an extended attribute
+	// of an entry could not be restored.
+	DegradationXattr DegradationKind = "xattr"
+	// DegradationSymlinkCopied is reported when a symlink could
+	// not be created and a copy of its target was made instead.
+	DegradationSymlinkCopied DegradationKind = "symlink-copied"
+	// DegradationLinkCopied is reported when a hard link could
+	// not be created and a copy of its target was made instead.
+	DegradationLinkCopied DegradationKind = "link-copied"
+	// DegradationType is reported when an entry of a type that
+	// cannot be extracted was written as a regular file.
+	DegradationType DegradationKind = "type"
+	// DegradationTimes is reported when the times of an
+	// entry could not be restored WithPreserveTimes.
+	DegradationTimes DegradationKind = "times"
+)
+
+// Degradation describes a piece of metadata or an entry
+// that extraction could not restore as archived.
+type Degradation struct {
+	// Kind identifies what was lost.
+	Kind DegradationKind
+	// Path is the name of the entry in the archive.
+	Path string
+	// Message is a human readable description of the loss.
+	Message string
+}
+
+func (d Degradation) String() string {
+	return fmt.Sprintf("%s: %s", d.Path, d.Message)
+}
+
+// WithReport returns an Option that makes UntarFiles fill in r
+// with the outcome of the extraction, so restores can be audited
+// or rolled back. The report is filled in even if extraction fails.
+func WithReport(r *Report) Option {
+	return func(o *options) {
+		o.report = r
+	}
+}
+
+// degrade records d in the report, if any.
+func (r *Report) degrade(d Degradation) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Degradations = append(r.Degradations, d)
+}
+
+// duplicated records in the report, if any, that
+// the named entry repeated an earlier name.
+func (r *Report) duplicated(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duplicates = append(r.Duplicates, name)
+}
+
+// collided records c in the report, if any.
+func (r *Report) collided(c Collision) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Collisions = append(r.Collisions, c)
+}
+
+// setPeakMemory records in the report, if any,
+// the most memory held for entry contents.
+func (r *Report) setPeakMemory(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.PeakMemory = n
+}
+
+// created records in the report, if any, that the entry
+// described by hdr was extracted at fullPath.
+func (r *Report) created(fullPath string, hdr *tar.Header, size int64, overwrote bool, backup string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Entries = append(r.Entries, ReportEntry{
+		Path:      fullPath,
+		Name:      hdr.Name,
+		Type:      entryType(hdr),
+		Size:      size,
+		Overwrote: overwrote,
+		Backup:    backup,
+	})
+}
+
+// CreateReport describes the outcome of an archive creation.
+type CreateReport struct {
+	// Skipped lists the files left out because they could not
+	// be read or were not selected, such as by WithMaxSize, in
+	// the order they were found.
+	Skipped []SkippedFile
+
+	// Changed lists the files whose size changed while
+	// they were being archived, in archive order.
+	Changed []*FileChangedError
+
+	mu sync.Mutex
+}
+
+// SkippedFile describes a file left out of an archive.
+type SkippedFile struct {
+	// Path is the path of the file.
+	Path string
+	// Reason describes why the file was left out.
+	Reason string
+}
+
+// WithCreateReport returns an Option that makes TarFiles fill in r
+// with the outcome of the archive creation. The report is filled in
+// even if the creation fails.
+func WithCreateReport(r *CreateReport) Option {
+	return func(o *options) {
+		o.createReport = r
+	}
+}
+
+// skipped records s in the report, if any.
+func (r *CreateReport) skipped(s SkippedFile) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, s)
+}
+
+// changed records e in the report, if any.
+func (r *CreateReport) changed(e *FileChangedError) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Changed = append(r.Changed, e)
+}
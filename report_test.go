@@ -0,0 +1,100 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestReportDegradations(c *gc.C) {
+	t.PatchValue(&copySymlinks, true)
+	t.PatchValue(&symlink, func(oldname, newname string) error {
+		return errors.New("symlinks not supported")
+	})
+	uid, gid := os.Getuid(), os.Getgid()
+	tarFile := filepath.Join(t.cwd, "degraded.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "data", Typeflag: tar.TypeReg, Mode: 0644, Uid: uid, Gid: gid,
+			PAXRecords: map[string]string{xattrPrefix + "bogus.attr": "value"}},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "data", Uid: uid, Gid: gid},
+		{Name: "outside", Typeflag: tar.TypeSymlink, Linkname: "../data", Uid: uid, Gid: gid},
+	})
+	outputDir := filepath.Join(t.cwd, "out")
+	c.Assert(os.Mkdir(outputDir, 0755), gc.IsNil)
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithReport(&report))
+	c.Assert(err, gc.ErrorMatches, `cannot extract symlink ".*outside": symlinks not supported`)
+
+	info, err := os.Lstat(filepath.Join(outputDir, "link"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().IsRegular(), gc.Equals, true)
+
+	var kinds []string
+	for _, d := range report.Degradations {
+		kinds = append(kinds, d.Path+" "+string(d.Kind))
+	}
+	sort.Strings(kinds)
+	c.Assert(kinds, gc.DeepEquals, []string{"data xattr", "link symlink-copied"})
+}
+
+func (t *TarSuite) TestReportType(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "fifo.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644, Uid: os.Getuid(), Gid: os.Getgid()},
+	})
+	var report Report
+	err := UntarFiles(tarFile, t.cwd, false, WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Degradations, gc.DeepEquals, []Degradation{{
+		Kind:    DegradationType,
+		Path:    "fifo",
+		Message: "fifo entry extracted as a regular file",
+	}})
+}
+
+func (t *TarSuite) TestReportEntries(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(outputDir, "TarDirectoryEmpty"), 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "TarFile1"), nil, 0644), gc.IsNil)
+
+	var report Report
+	err = UntarFiles(outputTar, outputDir, false, WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Entries, gc.DeepEquals, []ReportEntry{{
+		Path: filepath.Join(outputDir, "TarDirectoryPopulated"),
+		Name: "TarDirectoryPopulated",
+		Type: "dir",
+	}, {
+		Path: filepath.Join(outputDir, "TarDirectoryPopulated", "TarDirectoryPopulatedSubDirectory"),
+		Name: "TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory",
+		Type: "dir",
+	}, {
+		Path: filepath.Join(outputDir, "TarDirectoryPopulated", "TarSubFile1"),
+		Name: "TarDirectoryPopulated/TarSubFile1",
+		Type: "file",
+		Size: 11,
+	}, {
+		Path:      filepath.Join(outputDir, "TarFile1"),
+		Name:      "TarFile1",
+		Type:      "file",
+		Size:      8,
+		Overwrote: true,
+	}, {
+		Path: filepath.Join(outputDir, "TarFile2"),
+		Name: "TarFile2",
+		Type: "file",
+		Size: 8,
+	}})
+}
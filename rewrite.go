@@ -0,0 +1,60 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WithStripComponents returns an Option that makes UntarFiles remove
+// the first n components from the name of every entry, as GNU tar's
+// --strip-components does, so archives wrapping everything in a top
+// level directory can be extracted straight into the output folder.
+// Entries with n components or fewer are not extracted.
+func WithStripComponents(n int) Option {
+	return func(o *options) {
+		o.stripComponents = n
+	}
+}
+
+// WithPrefixPath returns an Option that makes UntarFiles extract
+// every entry below the directory prefix of the output folder, such
+// as "restore-2024-05-01", so several restores can be staged side by
+// side. The prefix is added after WithStripComponents is applied.
+func WithPrefixPath(prefix string) Option {
+	return func(o *options) {
+		o.prefixPath = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// outputName returns the name under which the entry called name is
+// extracted, and false if it must not be extracted at all.
+func (o *options) outputName(name string) (string, bool) {
+	if o.stripComponents != 0 {
+		parts := strings.Split(cleanManifestPath(name), "/")
+		if len(parts) <= o.stripComponents {
+			return "", false
+		}
+		name = strings.Join(parts[o.stripComponents:], "/")
+	}
+	if o.prefixPath != "" {
+		name = o.prefixPath + "/" + name
+	}
+	return name, true
+}
+
+// createPrefix creates the directory prefix
+// below outputFolder, if it does not exist.
+func createPrefix(outputFolder, prefix string) error {
+	dir, err := extractPath(outputFolder, prefix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %q: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestStripComponents(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarDirectory(filepath.Join(t.cwd, "TarDirectoryPopulated"), outputTar, false, WithRootName("wrapper/backup"))
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false, WithStripComponents(2))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, []expectedTarContents{
+		{"TarSubFile1", "TarSubFile1"},
+		{"TarDirectoryPopulatedSubDirectory", ""},
+	}, outputDir)
+}
+
+func (t *TarSuite) TestPrefixPath(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	// The first entry is a file, so the prefix
+	// directory is not created by extracting it.
+	_, err := TarFiles(append(t.testFiles[2:], t.testFiles[:2]...), outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false, WithPrefixPath("restore-2024-05-01/"))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, filepath.Join(outputDir, "restore-2024-05-01"))
+
+	err = UntarFiles(outputTar, outputDir, false, WithPrefixPath("/abs"))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithPrefixPath needs a relative path, not "/abs"`)
+}
+
+func (t *TarSuite) TestOutputName(c *gc.C) {
+	o := newOptions([]Option{WithStripComponents(1), WithPrefixPath("prefix")})
+	for _, test := range []struct {
+		name   string
+		result string
+		ok     bool
+	}{
+		{"top", "", false},
+		{"top/", "", false},
+		{"./top/file", "prefix/file", true},
+		{"top/dir/file", "prefix/dir/file", true},
+	} {
+		result, ok := o.outputName(test.name)
+		c.Check(ok, gc.Equals, test.ok, gc.Commentf("name %q", test.name))
+		c.Check(result, gc.Equals, test.result, gc.Commentf("name %q", test.name))
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithBackupDir returns an Option that makes UntarFiles move every
+// regular file it is about to overwrite below dir, at the same path
+// relative to the output folder, so that Rollback can put it back.
+// What is replaced WithTypeConflicts(TypeConflictReplace) is moved
+// there too. The backup is recorded in ReportEntry.Backup.
+func WithBackupDir(dir string) Option {
+	return func(o *options) {
+		o.backupDir = dir
+	}
+}
+
+// backup moves the regular file at fullPath, extracted under the
+// given output name, into the backup directory, and returns the
+// path it was moved to. It returns "" if there is nothing to back up.
+func (x *extractor) backup(fullPath, name string) (string, error) {
+	if x.opts.backupDir == "" {
+		return "", nil
+	}
+	info, err := os.Lstat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil
+	}
+	backupPath := filepath.Join(x.opts.backupDir, filepath.FromSlash(name))
+	// The backup directory is outside of the output
+	// folder, so it is created by path.
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return "", fmt.Errorf("cannot back up %q: %v", fullPath, err)
+	}
+	err = moveFile(fullPath, backupPath, x.renameOut, x.open, x.remove)
+	if err != nil {
+		return "", fmt.Errorf("cannot back up %q: %v", fullPath, err)
+	}
+	return backupPath, nil
+}
+
+// moveFile moves the regular file src to dst, copying it if it
+// cannot be renamed, as across filesystems, using the given
+// functions to rename, open and remove src.
+func moveFile(src, dst string, rename func(src, dst string) error, open func(string) (*os.File, error), remove func(string) error) error {
+	if err := rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return remove(src)
+}
+
+// Rollback undoes the extraction described by r, as filled in by
+// UntarFiles WithReport: the entries it created are removed, in
+// reverse order, and the files it overwrote are restored from their
+// backups. Paths that no longer exist are ignored, and directories
+// that have gained other entries since are left in place. Every
+// path that could not be rolled back is listed in the returned error.
+func Rollback(r *Report) error {
+	var problems []string
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		e := r.Entries[i]
+		var err error
+		switch {
+		case e.Backup != "":
+			// The backup may be of another type than the entry.
+			if err = os.Remove(e.Path); err == nil || os.IsNotExist(err) {
+				err = moveFile(e.Backup, e.Path, os.Rename, os.Open, os.Remove)
+			}
+		case e.Overwrote:
+			err = fmt.Errorf("no backup was made")
+		default:
+			err = os.Remove(e.Path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%q: %v", e.Path, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("cannot roll back extraction: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// relocate rewrites the paths in the report, if any, that
+// are below from as the same paths below to.
+func (r *Report) relocate(from, to string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.Entries {
+		if rel, err := filepath.Rel(from, e.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			r.Entries[i].Path = filepath.Join(to, rel)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestRollback(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	existing := filepath.Join(outputDir, "TarFile1")
+	c.Assert(ioutil.WriteFile(existing, []byte("original"), 0600), gc.IsNil)
+	backupDir := c.MkDir()
+
+	var report Report
+	err = UntarFiles(outputTar, outputDir, false, WithReport(&report), WithBackupDir(backupDir))
+	c.Assert(err, gc.IsNil)
+	contents, err := ioutil.ReadFile(filepath.Join(backupDir, "TarFile1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "original")
+
+	err = Rollback(&report)
+	c.Assert(err, gc.IsNil)
+	contents, err = ioutil.ReadFile(existing)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "original")
+	info, err := os.Stat(existing)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0600))
+	names, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 1)
+	_, err = os.Stat(filepath.Join(backupDir, "TarFile1"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestRollbackWithoutBackup(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "TarFile1"), nil, 0644), gc.IsNil)
+
+	var report Report
+	err = UntarFiles(outputTar, outputDir, false, WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	err = Rollback(&report)
+	c.Assert(err, gc.ErrorMatches, `cannot roll back extraction: ".*TarFile1": no backup was made`)
+	names, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 1)
+	c.Assert(names[0].Name(), gc.Equals, "TarFile1")
+}
+
+func (t *TarSuite) TestRollbackAtomicExtract(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	outputDir := filepath.Join(c.MkDir(), "restore")
+
+	var report Report
+	err = UntarFiles(outputTar, outputDir, false, WithReport(&report), WithAtomicExtract())
+	c.Assert(err, gc.IsNil)
+	for _, e := range report.Entries {
+		c.Assert(e.Path, gc.Equals, filepath.Join(outputDir, filepath.FromSlash(e.Name)))
+	}
+	err = Rollback(&report)
+	c.Assert(err, gc.IsNil)
+	names, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestRollbackDuplicates(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "duplicates.tar")
+	writeContentsArchive(c, tarFile, []testEntry{{"f", "v1"}, {"f", "v2"}})
+	outputDir := c.MkDir()
+	existing := filepath.Join(outputDir, "f")
+	c.Assert(ioutil.WriteFile(existing, []byte("ORIGINAL"), 0644), gc.IsNil)
+
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithReport(&report), WithBackupDir(c.MkDir()))
+	c.Assert(err, gc.IsNil)
+	contents, err := ioutil.ReadFile(existing)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "v2")
+
+	err = Rollback(&report)
+	c.Assert(err, gc.IsNil)
+	contents, err = ioutil.ReadFile(existing)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(contents), gc.Equals, "ORIGINAL")
+}
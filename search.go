@@ -0,0 +1,147 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+)
+
+// maxSearchLineLength is the length of the longest line SearchArchive
+// can match. The rest of an entry with a longer line is not searched.
+const maxSearchLineLength = 1 << 20
+
+// binarySniffLength is the number of bytes SearchArchive
+// looks at to decide whether an entry is binary.
+const binarySniffLength = 8000
+
+// SearchOptions configures SearchArchive.
+type SearchOptions struct {
+	// Names, if not empty, restricts the search to the entries
+	// whose names match one of these path.Match patterns.
+	Names []string
+	// MaxMatches, if positive, stops the search
+	// once that many matches have been found.
+	MaxMatches int
+	// Binary makes SearchArchive search entries that look
+	// binary, which are otherwise skipped as grep does.
+	Binary bool
+}
+
+// Match is a line of an archive entry that matched SearchArchive's
+// pattern.
+type Match struct {
+	// Name is the name of the entry.
+	Name string
+	// Line is the number of the line, counting from 1.
+	Line int
+	// Offset is the position of the start of the
+	// line in the contents of the entry.
+	Offset int64
+	// Text is the line, without its line ending.
+	Text string
+}
+
+// SearchArchive reads the archive from r, which may be gzip
+// compressed, and returns every line of its regular files matching
+// pattern, in archive order, without extracting anything to disk.
+// Hard links are searched as their target.
+func SearchArchive(r io.Reader, pattern *regexp.Regexp, opts SearchOptions) ([]Match, error) {
+	var matches []Match
+	// found holds the matches of every regular file
+	// searched, by name, for the hard links to them.
+	found := make(map[string][]Match)
+	err := WalkArchive(r, func(hdr *tar.Header, r io.Reader) error {
+		name := cleanManifestPath(hdr.Name)
+		var entryMatches []Match
+		switch {
+		case hdr.Typeflag == tar.TypeLink:
+			if !matchesAny(opts.Names, name) {
+				return nil
+			}
+			entryMatches = found[cleanManifestPath(hdr.Linkname)]
+		case hdr.FileInfo().Mode().IsRegular():
+			// Files whose names are not matched are searched
+			// too, in case hard links to them are.
+			var err error
+			if entryMatches, err = searchEntry(r, pattern, opts.Binary); err != nil {
+				return err
+			}
+			found[name] = entryMatches
+			if !matchesAny(opts.Names, name) {
+				return nil
+			}
+		default:
+			return nil
+		}
+		for _, m := range entryMatches {
+			m.Name = name
+			matches = append(matches, m)
+			if opts.MaxMatches > 0 && len(matches) == opts.MaxMatches {
+				return StopArchiving
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// searchEntry returns the lines of the contents read from r
+// matching pattern, skipping binary contents unless binary is set.
+// The names of the matches are left empty.
+func searchEntry(r io.Reader, pattern *regexp.Regexp, binary bool) ([]Match, error) {
+	br := bufio.NewReaderSize(r, binarySniffLength)
+	if !binary {
+		head, _ := br.Peek(binarySniffLength)
+		if bytes.IndexByte(head, 0) >= 0 {
+			return nil, nil
+		}
+	}
+	var lineLength int
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(nil, maxSearchLineLength)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		lineLength = advance
+		return advance, token, err
+	})
+	var matches []Match
+	var offset int64
+	for line := 1; scanner.Scan(); line++ {
+		if pattern.Match(scanner.Bytes()) {
+			matches = append(matches, Match{
+				Line:   line,
+				Offset: offset,
+				Text:   scanner.Text(),
+			})
+		}
+		offset += int64(lineLength)
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, fmt.Errorf("failed while reading tar contents: %v", err)
+	}
+	return matches, nil
+}
+
+// matchesAny reports whether name matches any of the given
+// path.Match patterns, or whether there are no patterns.
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
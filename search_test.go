@@ -0,0 +1,46 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSearchArchive(c *gc.C) {
+	t.createTestFiles(c)
+	config := filepath.Join(t.cwd, "TarConfig")
+	err := ioutil.WriteFile(config, []byte("# settings\r\nport: 17070\nstate-port: 37017\n"), 0644)
+	c.Assert(err, gc.IsNil)
+	binary := filepath.Join(t.cwd, "TarBinary")
+	err = ioutil.WriteFile(binary, []byte("port\x00"), 0644)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err = TarFiles(append(t.testFiles, config, binary), outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+
+	search := func(pattern string, opts SearchOptions) []Match {
+		f, err := os.Open(outputTar)
+		c.Assert(err, gc.IsNil)
+		defer f.Close()
+		matches, err := SearchArchive(f, regexp.MustCompile(pattern), opts)
+		c.Assert(err, gc.IsNil)
+		return matches
+	}
+	c.Assert(search("port", SearchOptions{}), gc.DeepEquals, []Match{
+		{Name: "TarConfig", Line: 2, Offset: 12, Text: "port: 17070"},
+		{Name: "TarConfig", Line: 3, Offset: 24, Text: "state-port: 37017"},
+	})
+	c.Assert(search("port", SearchOptions{MaxMatches: 1}), gc.HasLen, 1)
+	c.Assert(search("port", SearchOptions{Binary: true, Names: []string{"TarB*"}}), gc.DeepEquals, []Match{
+		{Name: "TarBinary", Line: 1, Text: "port\x00"},
+	})
+	c.Assert(search("File", SearchOptions{Names: []string{"TarDirectoryPopulated/*"}}), gc.DeepEquals, []Match{
+		{Name: "TarDirectoryPopulated/TarSubFile1", Line: 1, Text: "TarSubFile1"},
+	})
+}
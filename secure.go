@@ -0,0 +1,177 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WithSecureExtraction returns an Option that makes UntarFiles create
+// every file, directory and link relative to a handle on the output
+// folder, resolving their parent directories in the kernel so that
+// they cannot be outside of it, even if symlinks are swapped in while
+// extracting. Entries whose path escapes the output folder fail the
+// extraction. It needs openat2, available on Linux 5.6 and later.
+func WithSecureExtraction() Option {
+	return func(o *options) {
+		o.secure = true
+	}
+}
+
+// The methods below create, change and remove files at paths below
+// the output folder, through the secure root when there is one.
+
+func (x *extractor) relPath(fullPath string) (string, error) {
+	return filepath.Rel(x.root.path, fullPath)
+}
+
+func (x *extractor) mkdir(fullPath string, mode os.FileMode) error {
+	if x.root == nil {
+		return os.Mkdir(fullPath, mode)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.mkdir(rel, mode)
+}
+
+func (x *extractor) mkdirAll(fullPath string, mode os.FileMode) error {
+	if x.root == nil {
+		return os.MkdirAll(fullPath, mode)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.mkdirAll(rel, mode)
+}
+
+func (x *extractor) create(fullPath string) (*os.File, error) {
+	if x.root == nil {
+		return os.OpenFile(fullPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|oNoFollow, 0666)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return x.root.create(rel)
+}
+
+func (x *extractor) symlink(target, fullPath string) error {
+	if x.root == nil {
+		return symlink(target, fullPath)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.symlink(target, rel)
+}
+
+func (x *extractor) link(target, fullPath string) error {
+	if x.root == nil {
+		return os.Link(target, fullPath)
+	}
+	relTarget, err := x.relPath(target)
+	if err != nil {
+		return err
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.link(relTarget, rel)
+}
+
+func (x *extractor) remove(fullPath string) error {
+	if x.root == nil {
+		return os.Remove(fullPath)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.remove(rel)
+}
+
+func (x *extractor) removeAll(fullPath string) error {
+	if x.root == nil {
+		return os.RemoveAll(fullPath)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.removeAll(rel)
+}
+
+func (x *extractor) chmod(fullPath string, mode os.FileMode) error {
+	if x.root == nil {
+		return os.Chmod(fullPath, mode)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.chmod(rel, mode)
+}
+
+func (x *extractor) chtimes(fullPath string, atime, mtime time.Time) error {
+	if x.root == nil {
+		return os.Chtimes(fullPath, atime, mtime)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.chtimes(rel, atime, mtime)
+}
+
+func (x *extractor) lchown(fullPath string, uid, gid int) error {
+	if x.root == nil {
+		return os.Lchown(fullPath, uid, gid)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.lchown(rel, uid, gid)
+}
+
+func (x *extractor) setXattr(fullPath, name, value string) error {
+	if x.root == nil {
+		return setXattr(fullPath, name, value)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.setXattr(rel, name, value)
+}
+
+func (x *extractor) open(fullPath string) (*os.File, error) {
+	if x.root == nil {
+		return os.Open(fullPath)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return x.root.openFile(rel)
+}
+
+// renameOut moves fullPath to dst, outside of the output folder.
+func (x *extractor) renameOut(fullPath, dst string) error {
+	if x.root == nil {
+		return os.Rename(fullPath, dst)
+	}
+	rel, err := x.relPath(fullPath)
+	if err != nil {
+		return err
+	}
+	return x.root.renameOut(rel, dst)
+}
@@ -0,0 +1,315 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// secureExtraction records whether WithSecureExtraction
+// is supported on this platform.
+const secureExtraction = true
+
+// secureRoot creates files below a directory, resolving
+// paths with openat2 so that they cannot escape it.
+type secureRoot struct {
+	fd   int
+	path string
+}
+
+// resolveBeneath holds the openat2 resolve flags
+// confining paths below the root.
+const resolveBeneath = unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS
+
+// openSecureRoot returns a secureRoot for the directory at
+// path, creating it if needed.
+func openSecureRoot(path string) (*secureRoot, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory %q: %v", path, err)
+	}
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %q: %v", path, err)
+	}
+	r := &secureRoot{fd: fd, path: filepath.Clean(path)}
+	// Check that openat2 is available.
+	probe, err := r.open(".", unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("cannot extract securely: %v", err)
+	}
+	unix.Close(probe)
+	return r, nil
+}
+
+// Close releases the root.
+func (r *secureRoot) Close() error {
+	return unix.Close(r.fd)
+}
+
+// open opens the path rel below the root with the given flags.
+func (r *secureRoot) open(rel string, flags int) (int, error) {
+	fd, err := unix.Openat2(r.fd, rel, &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: resolveBeneath,
+	})
+	if err != nil {
+		return -1, &os.PathError{Op: "openat2", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return fd, nil
+}
+
+// parent opens the directory holding the path rel and returns it
+// with the last element of rel, and a function closing it.
+func (r *secureRoot) parent(rel string) (int, string, func(), error) {
+	dir, base := filepath.Split(filepath.Clean(rel))
+	if base == "" || base == "." || base == ".." {
+		return -1, "", nil, fmt.Errorf("invalid path %q", rel)
+	}
+	if dir == "" {
+		return r.fd, base, func() {}, nil
+	}
+	fd, err := r.open(dir, unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return -1, "", nil, err
+	}
+	return fd, base, func() { unix.Close(fd) }, nil
+}
+
+func (r *secureRoot) mkdir(rel string, mode os.FileMode) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Mkdirat(dirfd, base, unixMode(mode)); err != nil {
+		return &os.PathError{Op: "mkdirat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+func (r *secureRoot) mkdirAll(rel string, mode os.FileMode) error {
+	rel = filepath.Clean(rel)
+	if rel == "." {
+		return nil
+	}
+	elems := strings.Split(rel, string(filepath.Separator))
+	for i := range elems {
+		err := r.mkdir(filepath.Join(elems[:i+1]...), mode)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	// What exists already must be a directory below the root.
+	fd, err := r.open(rel, unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return err
+	}
+	return unix.Close(fd)
+}
+
+func (r *secureRoot) create(rel string) (*os.File, error) {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	fullPath := filepath.Join(r.path, rel)
+	fd, err := unix.Openat(dirfd, base, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0666)
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: fullPath, Err: err}
+	}
+	return os.NewFile(uintptr(fd), fullPath), nil
+}
+
+func (r *secureRoot) symlink(target, rel string) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Symlinkat(target, dirfd, base); err != nil {
+		return &os.PathError{Op: "symlinkat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+func (r *secureRoot) link(target, rel string) error {
+	olddirfd, oldbase, oldDone, err := r.parent(target)
+	if err != nil {
+		return err
+	}
+	defer oldDone()
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Linkat(olddirfd, oldbase, dirfd, base, 0); err != nil {
+		return &os.PathError{Op: "linkat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+func (r *secureRoot) remove(rel string) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	err = unix.Unlinkat(dirfd, base, 0)
+	if err == unix.EISDIR {
+		err = unix.Unlinkat(dirfd, base, unix.AT_REMOVEDIR)
+	}
+	if err != nil {
+		return &os.PathError{Op: "unlinkat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+// removeAll removes the path rel and everything below it.
+func (r *secureRoot) removeAll(rel string) error {
+	fd, err := r.open(rel, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW)
+	if err == nil {
+		dir := os.NewFile(uintptr(fd), filepath.Join(r.path, rel))
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := r.removeAll(filepath.Join(rel, name)); err != nil {
+				return err
+			}
+		}
+	}
+	err = r.remove(rel)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// procPath opens the path rel, which must not be a symlink, with
+// O_PATH, and returns its /proc/self/fd link, which refers to the
+// opened file whatever happens to its path, and a function closing
+// it. Linux cannot change files opened with O_PATH, so they are
+// changed through that link.
+func (r *secureRoot) procPath(op, rel string) (string, func(), error) {
+	fd, err := r.open(rel, unix.O_PATH|unix.O_NOFOLLOW)
+	if err != nil {
+		return "", nil, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return "", nil, &os.PathError{Op: "fstat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		unix.Close(fd)
+		return "", nil, &os.PathError{Op: op, Path: filepath.Join(r.path, rel), Err: unix.ELOOP}
+	}
+	return fmt.Sprintf("/proc/self/fd/%d", fd), func() { unix.Close(fd) }, nil
+}
+
+// chmod changes the mode of the path rel,
+// which must not be a symlink.
+func (r *secureRoot) chmod(rel string, mode os.FileMode) error {
+	p, done, err := r.procPath("chmod", rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Chmod(p, unixMode(mode)); err != nil {
+		return &os.PathError{Op: "chmod", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+// setXattr sets the extended attribute name of the
+// path rel, which must not be a symlink, to value.
+func (r *secureRoot) setXattr(rel, name, value string) error {
+	p, done, err := r.procPath("setxattr", rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Setxattr(p, name, []byte(value), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+// openFile opens the path rel, which
+// must not be a symlink, for reading.
+func (r *secureRoot) openFile(rel string) (*os.File, error) {
+	fd, err := r.open(rel, unix.O_RDONLY|unix.O_NOFOLLOW)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(r.path, rel)), nil
+}
+
+// renameOut moves the path rel to dst, outside of the root.
+func (r *secureRoot) renameOut(rel, dst string) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Renameat(dirfd, base, unix.AT_FDCWD, dst); err != nil {
+		return &os.LinkError{Op: "renameat", Old: filepath.Join(r.path, rel), New: dst, Err: err}
+	}
+	return nil
+}
+
+func (r *secureRoot) chtimes(rel string, atime, mtime time.Time) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+	if err := unix.UtimesNanoAt(dirfd, base, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimensat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+func (r *secureRoot) lchown(rel string, uid, gid int) error {
+	dirfd, base, done, err := r.parent(rel)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := unix.Fchownat(dirfd, base, uid, gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "fchownat", Path: filepath.Join(r.path, rel), Err: err}
+	}
+	return nil
+}
+
+// unixMode returns the mode bits for the system calls
+// corresponding to mode.
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= unix.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= unix.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= unix.S_ISVTX
+	}
+	return m
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSecureExtraction(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithDedup())
+	c.Assert(err, gc.IsNil)
+	outputDir := filepath.Join(c.MkDir(), "not-yet")
+	err = UntarFiles(outputTar, outputDir, false, WithSecureExtraction())
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "TarDirectoryPopulated", "TarSubFile1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "TarSubFile1")
+	_, err = os.Stat(filepath.Join(outputDir, "TarDirectoryPopulated", "TarDirectoryPopulatedSubDirectory"))
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestSecureExtractionSymlinkEscape(c *gc.C) {
+	outside := c.MkDir()
+	tarFile := filepath.Join(t.cwd, "escape.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "evil/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	// Symlinks extracted from the archive are refused before
+	// the secure root sees them, so this one is already there.
+	outputDir := c.MkDir()
+	c.Assert(os.Symlink(outside, filepath.Join(outputDir, "evil")), gc.IsNil)
+	err := UntarFiles(tarFile, outputDir, false, WithSecureExtraction())
+	c.Assert(err, gc.ErrorMatches, `.*openat2 .*/evil: invalid cross-device link`)
+	_, err = os.Lstat(filepath.Join(outside, "pwned"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	tarFile = filepath.Join(t.cwd, "dotdot.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "../pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	outputDir = filepath.Join(outside, "output")
+	err = UntarFiles(tarFile, outputDir, false, WithSecureExtraction())
+	c.Assert(err, gc.NotNil)
+	_, err = os.Lstat(filepath.Join(outside, "pwned"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestSecureExtractionBackupAndXattrs(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "overwrite.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "config", Typeflag: tar.TypeReg, Mode: 0644,
+			PAXRecords: map[string]string{xattrPrefix + "bogus.attr": "value"}},
+		{Name: "conflict", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	outputDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "config"), []byte("old"), 0644), gc.IsNil)
+	c.Assert(os.Mkdir(filepath.Join(outputDir, "conflict"), 0755), gc.IsNil)
+	backupDir := c.MkDir()
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithSecureExtraction(), WithBackupDir(backupDir),
+		WithTypeConflicts(TypeConflictReplace), WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(backupDir, "config"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "old")
+	info, err := os.Stat(filepath.Join(backupDir, "conflict"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.IsDir(), gc.Equals, true)
+	// The attribute is set through the secure root.
+	c.Assert(report.Degradations, gc.HasLen, 1)
+	c.Assert(report.Degradations[0].Message, gc.Matches, `cannot restore extended attribute "bogus.attr": setxattr .*/config: .*`)
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !linux
+// +build !linux
+
+package tar
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// secureExtraction records whether WithSecureExtraction
+// is supported on this platform.
+const secureExtraction = false
+
+var errSecureUnsupported = errors.New("secure extraction is only supported on Linux")
+
+// secureRoot is never used on this platform.
+type secureRoot struct {
+	path string
+}
+
+func openSecureRoot(path string) (*secureRoot, error) {
+	return nil, errSecureUnsupported
+}
+
+func (r *secureRoot) Close() error                               { return nil }
+func (r *secureRoot) mkdir(string, os.FileMode) error            { return errSecureUnsupported }
+func (r *secureRoot) mkdirAll(string, os.FileMode) error         { return errSecureUnsupported }
+func (r *secureRoot) create(string) (*os.File, error)            { return nil, errSecureUnsupported }
+func (r *secureRoot) symlink(string, string) error               { return errSecureUnsupported }
+func (r *secureRoot) link(string, string) error                  { return errSecureUnsupported }
+func (r *secureRoot) remove(string) error                        { return errSecureUnsupported }
+func (r *secureRoot) removeAll(string) error                     { return errSecureUnsupported }
+func (r *secureRoot) chmod(string, os.FileMode) error            { return errSecureUnsupported }
+func (r *secureRoot) lchown(string, int, int) error              { return errSecureUnsupported }
+func (r *secureRoot) chtimes(string, time.Time, time.Time) error { return errSecureUnsupported }
+func (r *secureRoot) setXattr(string, string, string) error      { return errSecureUnsupported }
+func (r *secureRoot) openFile(string) (*os.File, error)          { return nil, errSecureUnsupported }
+func (r *secureRoot) renameOut(string, string) error             { return errSecureUnsupported }
@@ -0,0 +1,151 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WithSeekableGzip returns an Option that makes TarFiles start a new
+// gzip member after every interval bytes of uncompressed data, so
+// that decompression can start at any member, and save an index of
+// the archive next to it at IndexPath(targetPath). ExtractEntry then
+// only needs to decompress from the member preceding an entry. The
+// archive remains a valid gzip stream for every reader. No index is
+// saved by TarFilesToWriter; BuildIndex can be used instead.
+func WithSeekableGzip(interval int64) Option {
+	return func(o *options) {
+		o.seekableEvery = interval
+	}
+}
+
+// syncWriter starts a new gzip member after
+// every bytes written through it.
+type syncWriter struct {
+	gz    *gzipMembers
+	every int64
+	n     int64
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if room := s.every - s.n; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := s.gz.Write(chunk)
+		total += n
+		s.n += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		if s.n == s.every {
+			if err := s.gz.restart(); err != nil {
+				return total, err
+			}
+			s.n = 0
+		}
+	}
+	return total, nil
+}
+
+// byteReader is implemented by readers the gzip
+// package reads from without buffering.
+type byteReader interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// countingByteReader counts the bytes read through it.
+type countingByteReader struct {
+	r byteReader
+	n int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (c *countingByteReader) UnreadByte() error {
+	err := c.r.UnreadByte()
+	if err == nil {
+		c.n--
+	}
+	return err
+}
+
+// memberReader decompresses a sequence of gzip members, recording
+// the start of each one as a seek point of idx. As r is a byte
+// reader, the gzip reader consumes exactly the bytes of each member,
+// so the count of bytes read from r is the position of the next.
+type memberReader struct {
+	r   *countingByteReader
+	gzr *gzip.Reader
+	idx *Index
+	n   int64
+}
+
+func newMemberReader(r byteReader, idx *Index) (*memberReader, error) {
+	m := &memberReader{
+		r:   &countingByteReader{r: r},
+		idx: idx,
+	}
+	m.addPoint()
+	gzr, err := gzip.NewReader(m.r)
+	if err != nil {
+		return nil, err
+	}
+	gzr.Multistream(false)
+	m.gzr = gzr
+	return m, nil
+}
+
+// addPoint records the current position as a seek point.
+func (m *memberReader) addPoint() {
+	m.idx.SeekPoints = append(m.idx.SeekPoints, SeekPoint{
+		Compressed:   m.r.n,
+		Uncompressed: m.n,
+	})
+}
+
+func (m *memberReader) Read(p []byte) (int, error) {
+	for {
+		n, err := m.gzr.Read(p)
+		m.n += int64(n)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		// The member ended; start the next one, if any.
+		more, err := nextGzipMember(m.r)
+		if err != nil {
+			return 0, err
+		}
+		if !more {
+			return 0, io.EOF
+		}
+		start := len(m.idx.SeekPoints)
+		m.addPoint()
+		if err := m.gzr.Reset(m.r); err != nil {
+			m.idx.SeekPoints = m.idx.SeekPoints[:start]
+			return 0, err
+		}
+		m.gzr.Multistream(false)
+	}
+}
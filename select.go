@@ -0,0 +1,126 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// WithMinSize returns an Option that makes TarFiles leave out the
+// regular files smaller than n bytes, such as the zero-byte lock
+// files left out by WithMinSize(1). Skipped files are listed in the
+// CreateReport given WithCreateReport.
+func WithMinSize(n int64) Option {
+	return func(o *options) {
+		o.minSize = n
+	}
+}
+
+// WithMaxSize returns an Option that makes TarFiles leave out the
+// regular files larger than n bytes, such as enormous caches. Zero
+// means no limit. Skipped files are listed in the CreateReport given
+// WithCreateReport.
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithNewerThan returns an Option that makes TarFiles only archive
+// the files modified after t, as tar --newer does, so that what
+// changed recently in a large tree can be archived quickly.
+// Directories are always archived, so that the files kept below
+// them can be extracted with their modes. Unlike those left out by
+// WithMinSize, the files left out are not listed in the CreateReport,
+// as they usually make up most of the tree.
+func WithNewerThan(t time.Time) Option {
+	return func(o *options) {
+		o.newerThan = t
+	}
+}
+
+// WithOlderThan returns an Option that makes TarFiles only archive
+// the files modified before t. As for WithNewerThan, directories are
+// always archived and the files left out are not listed in the
+// CreateReport.
+func WithOlderThan(t time.Time) Option {
+	return func(o *options) {
+		o.olderThan = t
+	}
+}
+
+// validateSelection appends to problems the reasons why
+// the files selected by o cannot be archived.
+func (o *options) validateSelection(problems []string) []string {
+	if o.minSize < 0 {
+		problems = append(problems, "WithMinSize needs a non-negative size")
+	}
+	if o.maxSize < 0 {
+		problems = append(problems, "WithMaxSize needs a non-negative size")
+	}
+	if o.maxSize > 0 && o.minSize > o.maxSize {
+		problems = append(problems, fmt.Sprintf("WithMinSize %d is larger than WithMaxSize %d", o.minSize, o.maxSize))
+	}
+	if !o.newerThan.IsZero() && !o.olderThan.IsZero() && !o.newerThan.Before(o.olderThan) {
+		problems = append(problems, "WithNewerThan needs a time before that given WithOlderThan")
+	}
+	return problems
+}
+
+// unselectedSize returns why the file described by fInfo must
+// be left out because of its size, or "" if it must be archived.
+func (o *options) unselectedSize(fInfo os.FileInfo) string {
+	if fInfo.Mode().IsRegular() {
+		switch size := fInfo.Size(); {
+		case size < o.minSize:
+			return fmt.Sprintf("size %d is smaller than %d", size, o.minSize)
+		case o.maxSize > 0 && size > o.maxSize:
+			return fmt.Sprintf("size %d is larger than %d", size, o.maxSize)
+		}
+	}
+	return ""
+}
+
+// unselectedTime returns why the file described by fInfo must be
+// left out because of its modification time, or "" if it must be
+// archived.
+func (o *options) unselectedTime(fInfo os.FileInfo) string {
+	if fInfo.IsDir() {
+		return ""
+	}
+	switch mtime := fInfo.ModTime(); {
+	case !o.newerThan.IsZero() && !mtime.After(o.newerThan):
+		return fmt.Sprintf("not modified after %v", o.newerThan)
+	case !o.olderThan.IsZero() && !mtime.Before(o.olderThan):
+		return fmt.Sprintf("not modified before %v", o.olderThan)
+	}
+	return ""
+}
+
+// skipUnselected reports whether fileName, described by fInfo, is
+// left out by the selection options, recording it if so.
+func (a *archiver) skipUnselected(fileName string, fInfo os.FileInfo) bool {
+	// Directories are walked even if their type is not
+	// selected; writeContents leaves out their entry.
+	if t := fileEntryType(fInfo.Mode()); t != EntryDir && !a.opts.selectsType(t) {
+		a.opts.log().Debugf("skipping %q: type %v not selected", fileName, t)
+		return true
+	}
+	if reason := a.opts.unselectedTime(fInfo); reason != "" {
+		a.opts.log().Debugf("skipping %q: %s", fileName, reason)
+		return true
+	}
+	reason := a.opts.unselectedSize(fInfo)
+	if reason == "" {
+		return false
+	}
+	a.opts.log().Debugf("skipping %q: %s", fileName, reason)
+	a.opts.createReport.skipped(SkippedFile{
+		Path:   fileName,
+		Reason: reason,
+	})
+	return true
+}
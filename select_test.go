@@ -0,0 +1,85 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesWithSizeLimits(c *gc.C) {
+	dir := filepath.Join(t.cwd, "app")
+	c.Assert(os.Mkdir(dir, 0755), gc.IsNil)
+	for name, size := range map[string]int{"lock": 0, "conf": 10, "cache": 1000} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+		c.Assert(err, gc.IsNil)
+	}
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	report := &CreateReport{}
+	_, err := TarFiles([]string{dir}, outputTar, t.cwd+"/", false,
+		WithMinSize(1), WithMaxSize(100), WithCreateReport(report))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 2)
+	c.Assert(headers["app"], gc.NotNil)
+	c.Assert(headers["app/conf"], gc.NotNil)
+	c.Assert(report.Skipped, gc.DeepEquals, []SkippedFile{{
+		Path:   filepath.Join(dir, "cache"),
+		Reason: "size 1000 is larger than 100",
+	}, {
+		Path:   filepath.Join(dir, "lock"),
+		Reason: "size 0 is smaller than 1",
+	}})
+}
+
+func (t *TarSuite) TestWithSizeLimitsInvalid(c *gc.C) {
+	outputTar := filepath.Join(t.cwd, "out.tar")
+	_, err := TarFiles([]string{"x"}, outputTar, "", false, WithMinSize(-1))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithMinSize needs a non-negative size")
+	_, err = TarFiles([]string{"x"}, outputTar, "", false, WithMinSize(10), WithMaxSize(5))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithMinSize 10 is larger than WithMaxSize 5")
+	err = UntarFiles("x.tar", c.MkDir(), false, WithMaxSize(5))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithMaxSize only applies to archive creation")
+}
+
+func (t *TarSuite) TestTarFilesWithTimeWindow(c *gc.C) {
+	dir := filepath.Join(t.cwd, "app")
+	c.Assert(os.Mkdir(dir, 0755), gc.IsNil)
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"old": 72 * time.Hour, "yesterday": 24 * time.Hour, "today": time.Hour} {
+		p := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(p, []byte(name), 0644), gc.IsNil)
+		c.Assert(os.Chtimes(p, now.Add(-age), now.Add(-age)), gc.IsNil)
+	}
+	sub := filepath.Join(dir, "sub")
+	c.Assert(os.Mkdir(sub, 0755), gc.IsNil)
+	c.Assert(os.Chtimes(sub, now.Add(-72*time.Hour), now.Add(-72*time.Hour)), gc.IsNil)
+
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles([]string{dir}, outputTar, t.cwd+"/", false, WithNewerThan(now.Add(-2*time.Hour)))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 3)
+	c.Assert(headers["app/today"], gc.NotNil)
+	c.Assert(headers["app/sub"], gc.NotNil)
+
+	_, err = TarFiles([]string{dir}, outputTar, t.cwd+"/", false,
+		WithNewerThan(now.Add(-48*time.Hour)), WithOlderThan(now.Add(-2*time.Hour)))
+	c.Assert(err, gc.IsNil)
+	headers = readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 3)
+	c.Assert(headers["app/yesterday"], gc.NotNil)
+}
+
+func (t *TarSuite) TestWithTimeWindowInvalid(c *gc.C) {
+	now := time.Now()
+	_, err := TarFiles([]string{"x"}, filepath.Join(t.cwd, "out.tar"), "", false, WithNewerThan(now), WithOlderThan(now))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithNewerThan needs a time before that given WithOlderThan")
+	err = UntarFiles("x.tar", c.MkDir(), false, WithOlderThan(now))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithOlderThan only applies to archive creation")
+}
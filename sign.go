@@ -0,0 +1,103 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"crypto/ed25519"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBadSignature is returned by VerifyAndUntar when the signature
+// does not match the archive.
+var ErrBadSignature = errors.New("archive signature does not match")
+
+// Signer produces a detached signature of an archive digest.
+type Signer interface {
+	Sign(digest []byte) ([]byte, error)
+}
+
+// Verifier checks a signature produced by a Signer.
+type Verifier interface {
+	Verify(digest, signature []byte) error
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+// Ed25519Signer returns a Signer that signs with the given key.
+func Ed25519Signer(key ed25519.PrivateKey) Signer {
+	return ed25519Signer(key)
+}
+
+func (s ed25519Signer) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), digest), nil
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+// Ed25519Verifier returns a Verifier that accepts only signatures
+// made with the private key matching the given trusted public key.
+func Ed25519Verifier(key ed25519.PublicKey) Verifier {
+	return ed25519Verifier(key)
+}
+
+func (v ed25519Verifier) Verify(digest, signature []byte) error {
+	if !ed25519.Verify(ed25519.PublicKey(v), digest, signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// SignArchive returns a detached signature of the archive whose hash,
+// as returned by TarFiles, is shaSum.
+func SignArchive(shaSum string, signer Signer) ([]byte, error) {
+	digest, err := base64.StdEncoding.DecodeString(shaSum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive hash %q: %v", shaSum, err)
+	}
+	return signer.Sign(digest)
+}
+
+// VerifyAndUntar checks that signature is a valid signature of
+// tarFile made by the key trusted by verifier, and only then
+// extracts it as UntarFiles does. If the signature does not
+// match, nothing is extracted and ErrBadSignature is returned.
+// The archive is read once, into a temporary copy that is
+// extracted once verified, so that changes made to tarFile
+// meanwhile are never extracted.
+func VerifyAndUntar(tarFile, outputFolder string, compressed bool, signature []byte, verifier Verifier, opts ...Option) error {
+	o := newOptions(opts)
+	f, err := openStored(o.storage(), tarFile)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	tmp := newRunDir(o.tempDir)
+	defer tmp.remove()
+	verified, err := tmp.tempFile("verified")
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot create staging file: %v", err)
+	}
+	defer verified.Close()
+	shahash := sha1.New()
+	_, err = io.Copy(io.MultiWriter(verified, shahash), f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("cannot read backup file %q: %v", tarFile, err)
+	}
+	if err := verified.Close(); err != nil {
+		return fmt.Errorf("cannot write staging file: %v", err)
+	}
+	if err := verifier.Verify(shahash.Sum(nil), signature); err != nil {
+		return err
+	}
+	// The copy is local, wherever the archive was stored.
+	opts = append(opts[:len(opts):len(opts)], func(o *options) {
+		o.target = nil
+	})
+	return UntarFiles(verified.Name(), outputFolder, compressed, opts...)
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestVerifyAndUntar(c *gc.C) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	signature, err := SignArchive(shaSum, Ed25519Signer(priv))
+	c.Assert(err, gc.IsNil)
+	t.removeTestFiles(c)
+
+	outputDir := c.MkDir()
+	err = VerifyAndUntar(outputTar, outputDir, true, signature, Ed25519Verifier(otherPub))
+	c.Assert(err, gc.Equals, ErrBadSignature)
+	entries, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 0)
+
+	err = VerifyAndUntar(outputTar, outputDir, true, signature, Ed25519Verifier(pub))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestSignArchiveInvalidHash(c *gc.C) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+	_, err = SignArchive("not base64!", Ed25519Signer(priv))
+	c.Assert(err, gc.ErrorMatches, `invalid archive hash "not base64!": .*`)
+}
+
+// swappingVerifier replaces the archive once it is verified.
+type swappingVerifier struct {
+	Verifier
+	archive string
+	swapped []byte
+}
+
+func (v swappingVerifier) Verify(digest, signature []byte) error {
+	if err := v.Verifier.Verify(digest, signature); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.archive, v.swapped, 0644)
+}
+
+func (t *TarSuite) TestVerifyAndUntarSwappedArchive(c *gc.C) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	signature, err := SignArchive(shaSum, Ed25519Signer(priv))
+	c.Assert(err, gc.IsNil)
+	t.removeTestFiles(c)
+
+	evilTar := filepath.Join(t.cwd, "evil.tar")
+	writeContentsArchive(c, evilTar, []testEntry{{"evil", "unsigned"}})
+	swapped, err := ioutil.ReadFile(evilTar)
+	c.Assert(err, gc.IsNil)
+	verifier := swappingVerifier{Ed25519Verifier(pub), outputTar, swapped}
+	outputDir := c.MkDir()
+	err = VerifyAndUntar(outputTar, outputDir, true, signature, verifier)
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+	_, err = os.Stat(filepath.Join(outputDir, "evil"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// Snapshotter takes filesystem snapshots around the archiving of a
+// directory, so that live data directories are archived as they were
+// at one point in time. Implementations typically run commands such
+// as "btrfs subvolume snapshot", "lvcreate --snapshot" or
+// "zfs snapshot".
+type Snapshotter interface {
+	// PreSnapshot takes a snapshot of srcDir and returns the
+	// directory holding it, which is archived in its place.
+	PreSnapshot(ctx context.Context, srcDir string) (snapshotDir string, err error)
+	// PostSnapshot releases the snapshot held in snapshotDir.
+	// It is called once archiving ends, even if it failed.
+	PostSnapshot(ctx context.Context, snapshotDir string) error
+}
+
+// SnapshotFuncs implements Snapshotter with a pair of functions.
+type SnapshotFuncs struct {
+	Pre  func(ctx context.Context, srcDir string) (string, error)
+	Post func(ctx context.Context, snapshotDir string) error
+}
+
+// PreSnapshot implements Snapshotter by calling f.Pre.
+func (f SnapshotFuncs) PreSnapshot(ctx context.Context, srcDir string) (string, error) {
+	return f.Pre(ctx, srcDir)
+}
+
+// PostSnapshot implements Snapshotter by calling f.Post, if set.
+func (f SnapshotFuncs) PostSnapshot(ctx context.Context, snapshotDir string) error {
+	if f.Post == nil {
+		return nil
+	}
+	return f.Post(ctx, snapshotDir)
+}
+
+// WithSnapshot returns an Option that makes TarDirectory archive a
+// snapshot of the directory taken by s instead of the directory
+// itself. Entries are named as if the directory had been archived,
+// and ctx is passed to s.
+func WithSnapshot(ctx context.Context, s Snapshotter) Option {
+	return func(o *options) {
+		o.snapshotter = s
+		o.snapshotContext = ctx
+	}
+}
+
+// snapshot takes a snapshot of o.srcDir and archives it in its
+// place, returning a function releasing the snapshot.
+func (o *options) snapshot() (release func() error, err error) {
+	ctx := o.snapshotContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	snapshotDir, err := o.snapshotter.PreSnapshot(ctx, o.srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot take snapshot of %q: %v", o.srcDir, err)
+	}
+	o.log().Infof("archiving snapshot %q of %q", snapshotDir, o.srcDir)
+	o.srcDir = filepath.Clean(snapshotDir)
+	return func() error {
+		if err := o.snapshotter.PostSnapshot(ctx, snapshotDir); err != nil {
+			return fmt.Errorf("cannot release snapshot %q: %v", snapshotDir, err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+// copySnapshot returns a Snapshotter copying the directory
+// to snapshot below base, and recording the calls made.
+func copySnapshot(c *gc.C, base string, calls *[]string) Snapshotter {
+	return SnapshotFuncs{
+		Pre: func(ctx context.Context, srcDir string) (string, error) {
+			*calls = append(*calls, "pre "+srcDir)
+			dst := filepath.Join(base, "snap")
+			err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+				c.Assert(err, gc.IsNil)
+				rel, err := filepath.Rel(srcDir, path)
+				c.Assert(err, gc.IsNil)
+				if info.IsDir() {
+					return os.Mkdir(filepath.Join(dst, rel), info.Mode())
+				}
+				data, err := ioutil.ReadFile(path)
+				c.Assert(err, gc.IsNil)
+				return ioutil.WriteFile(filepath.Join(dst, rel), data, info.Mode())
+			})
+			return dst, err
+		},
+		Post: func(ctx context.Context, snapshotDir string) error {
+			*calls = append(*calls, "post "+snapshotDir)
+			return os.RemoveAll(snapshotDir)
+		},
+	}
+}
+
+func (t *TarSuite) TestTarDirectorySnapshot(c *gc.C) {
+	t.createTestFiles(c)
+	srcDir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	base := c.MkDir()
+	var calls []string
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	_, err := TarDirectory(srcDir, outputTar, false, WithSnapshot(context.Background(), copySnapshot(c, base, &calls)))
+	c.Assert(err, gc.IsNil)
+	snapDir := filepath.Join(base, "snap")
+	c.Assert(calls, gc.DeepEquals, []string{"pre " + srcDir, "post " + snapDir})
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarDirectoryPopulated", ""},
+		{"TarDirectoryPopulated/TarSubFile1", "TarSubFile1"},
+		{"TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory", ""},
+	}, outputTar, false)
+	_, err = os.Stat(snapDir)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestTarDirectorySnapshotErrors(c *gc.C) {
+	t.createTestFiles(c)
+	srcDir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	failing := SnapshotFuncs{
+		Pre: func(ctx context.Context, srcDir string) (string, error) {
+			return "", errors.New("no btrfs here")
+		},
+	}
+	_, err := TarDirectory(srcDir, outputTar, false, WithSnapshot(context.Background(), failing))
+	c.Assert(err, gc.ErrorMatches, `cannot take snapshot of ".*TarDirectoryPopulated": no btrfs here`)
+
+	leaking := SnapshotFuncs{
+		Pre: func(ctx context.Context, srcDir string) (string, error) {
+			return srcDir, nil
+		},
+		Post: func(ctx context.Context, snapshotDir string) error {
+			return errors.New("device busy")
+		},
+	}
+	sum, err := TarDirectory(srcDir, outputTar, false, WithSnapshot(context.Background(), leaking))
+	c.Assert(err, gc.ErrorMatches, `cannot release snapshot ".*TarDirectoryPopulated": device busy`)
+	c.Assert(sum, gc.Equals, "")
+
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithSnapshot(context.Background(), leaking))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithSnapshot only applies to TarDirectory")
+}
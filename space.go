@@ -0,0 +1,129 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// spaceBlockSize is the allocation unit assumed when
+// estimating the space an extraction needs.
+const spaceBlockSize = 4096
+
+// errSpaceUnknown is returned by diskSpace on platforms
+// where the available space cannot be found.
+var errSpaceUnknown = errors.New("available disk space cannot be determined on this platform")
+
+// availableSpace returns the space available to unprivileged users
+// on the filesystem holding path; it is a variable so tests can
+// replace it.
+var availableSpace = diskSpace
+
+// WithSpaceCheck returns an Option that makes UntarFiles estimate
+// the space the extracted files need before extracting anything,
+// and fail with an *InsufficientSpaceError if the filesystem of the
+// output folder does not have that much available. The sizes are
+// taken from the manifest embedded by TarFiles WithManifest or, for
+// other archives, from a first pass over the headers.
+func WithSpaceCheck() Option {
+	return func(o *options) {
+		o.spaceCheck = true
+	}
+}
+
+// InsufficientSpaceError is returned when the output
+// folder cannot hold the extracted files.
+type InsufficientSpaceError struct {
+	Path      string
+	Needed    int64
+	Available int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough space to extract into %q: %d bytes needed, %d available",
+		e.Path, e.Needed, e.Available)
+}
+
+// checkSpace returns an *InsufficientSpaceError if the
+// extraction of tarFile does not fit in outputFolder.
+func checkSpace(tarFile, outputFolder string, compressed bool, o *options) error {
+	needed, err := spaceNeeded(tarFile, compressed, o)
+	if err != nil {
+		return err
+	}
+	// The output folder may not exist yet.
+	dir := filepath.Clean(outputFolder)
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	available, err := availableSpace(dir)
+	if err == errSpaceUnknown {
+		o.warn(Warning{
+			Kind:    WarningSpaceUnknown,
+			Path:    outputFolder,
+			Message: err.Error(),
+		})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot find available space: %v", err)
+	}
+	if needed > available {
+		return &InsufficientSpaceError{
+			Path:      outputFolder,
+			Needed:    needed,
+			Available: available,
+		}
+	}
+	return nil
+}
+
+// spaceNeeded estimates the space taken by the entries of tarFile
+// once extracted, rounding every entry up to a whole block.
+func spaceNeeded(tarFile string, compressed bool, o *options) (int64, error) {
+	r, closeInput, err := openExtractStream(tarFile, compressed, o)
+	if err != nil {
+		return 0, err
+	}
+	defer closeInput()
+	var needed int64
+	tr := tar.NewReader(r)
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return needed, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if first && hdr.Name == ManifestName {
+			m, err := decodeManifest(tr)
+			if err != nil {
+				return 0, err
+			}
+			for _, e := range m.Entries {
+				needed += entrySpace(e.Size, e.Type == "dir")
+			}
+			return needed, nil
+		}
+		needed += entrySpace(hdr.Size, hdr.Typeflag == tar.TypeDir)
+	}
+}
+
+// entrySpace returns the space taken by an entry with
+// contents of the given size, or by a directory.
+func entrySpace(size int64, dir bool) int64 {
+	if dir {
+		return spaceBlockSize
+	}
+	return (size + spaceBlockSize - 1) / spaceBlockSize * spaceBlockSize
+}
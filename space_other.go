@@ -0,0 +1,13 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !linux
+// +build !linux
+
+package tar
+
+// diskSpace returns the space available to unprivileged
+// users on the filesystem holding path.
+func diskSpace(path string) (int64, error) {
+	return 0, errSpaceUnknown
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSpaceNeeded(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar.gz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	// Three directories and three small files.
+	needed, err := spaceNeeded(outputTar, true, newOptions(nil))
+	c.Assert(err, gc.IsNil)
+	c.Assert(needed, gc.Equals, int64(6*spaceBlockSize))
+
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	needed, err = spaceNeeded(outputTar, true, newOptions(nil))
+	c.Assert(err, gc.IsNil)
+	c.Assert(needed, gc.Equals, int64(6*spaceBlockSize))
+}
+
+func (t *TarSuite) TestSpaceCheck(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	var checked string
+	t.PatchValue(&availableSpace, func(path string) (int64, error) {
+		checked = path
+		return 5 * spaceBlockSize, nil
+	})
+	restore := filepath.Join(t.cwd, "restore", "here")
+	err = UntarFiles(outputTar, restore, false, WithSpaceCheck())
+	c.Assert(err, gc.FitsTypeOf, &InsufficientSpaceError{})
+	c.Assert(err, gc.ErrorMatches, `not enough space to extract into ".*here": 24576 bytes needed, 20480 available`)
+	c.Assert(checked, gc.Equals, t.cwd)
+	_, err = os.Stat(restore)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	t.PatchValue(&availableSpace, func(string) (int64, error) {
+		return 0, errSpaceUnknown
+	})
+	var warnings []Warning
+	err = UntarFiles(outputTar, t.cwd, false, WithSpaceCheck(), WithWarningFunc(func(w Warning) {
+		warnings = append(warnings, w)
+	}))
+	c.Assert(err, gc.IsNil)
+	c.Assert(warnings, gc.HasLen, 1)
+	c.Assert(warnings[0].Kind, gc.Equals, WarningSpaceUnknown)
+}
+
+func (t *TarSuite) TestDiskSpace(c *gc.C) {
+	available, err := diskSpace(t.cwd)
+	if err == errSpaceUnknown {
+		c.Skip(err.Error())
+	}
+	c.Assert(err, gc.IsNil)
+	c.Assert(available > 0, gc.Equals, true)
+}
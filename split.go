@@ -0,0 +1,113 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// splitOutput is one of the archives written by SplitByPrefix.
+type splitOutput struct {
+	f    *os.File
+	gzw  *gzip.Writer
+	tarw *tar.Writer
+}
+
+func (s *splitOutput) Close() error {
+	err := s.tarw.Close()
+	if s.gzw != nil {
+		if gzErr := s.gzw.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if fErr := s.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
+
+// SplitByPrefix reads the archive src and writes one archive to
+// outDir for each of its top level entries, named after the entry,
+// holding that entry and everything below it. The new archives are
+// gzip compressed if src is, and are named with a .tar.gz or .tar
+// extension accordingly. It returns the paths of the archives
+// created, sorted.
+func SplitByPrefix(src string, outDir string) (paths []string, err error) {
+	in, err := openInput(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open backup file %q: %v", src, err)
+	}
+	defer in.Close()
+	compressed, r, err := sniffGzip(in)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup file %q: %v", src, err)
+	}
+	tr, err := newArchiveReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup file %q: %v", src, err)
+	}
+	ext := ".tar"
+	if compressed {
+		ext = ".tar.gz"
+	}
+	outputs := make(map[string]*splitOutput)
+	defer func() {
+		for _, out := range outputs {
+			if closeErr := out.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("error closing split archive: %v", closeErr)
+			}
+		}
+		if err != nil {
+			paths = nil
+		}
+	}()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if hdr.Name == ManifestName {
+			continue
+		}
+		top := strings.SplitN(cleanManifestPath(hdr.Name), "/", 2)[0]
+		if top == "" {
+			continue
+		}
+		out, ok := outputs[top]
+		if !ok {
+			path := filepath.Join(outDir, top+ext)
+			f, err := os.Create(path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create split archive %q: %v", path, err)
+			}
+			out = &splitOutput{f: f}
+			var w io.Writer = f
+			if compressed {
+				out.gzw = gzip.NewWriter(f)
+				w = out.gzw
+			}
+			out.tarw = tar.NewWriter(w)
+			outputs[top] = out
+			paths = append(paths, path)
+		}
+		if err := out.tarw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("cannot write header for %q: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(out.tarw, tr); err != nil {
+			return nil, fmt.Errorf("failed to write %q: %v", hdr.Name, err)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
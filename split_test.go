@@ -0,0 +1,36 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestSplitByPrefix(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+
+	outDir := c.MkDir()
+	paths, err := SplitByPrefix(outputTar, outDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(paths, gc.DeepEquals, []string{
+		filepath.Join(outDir, "TarDirectoryEmpty.tar.gz"),
+		filepath.Join(outDir, "TarDirectoryPopulated.tar.gz"),
+		filepath.Join(outDir, "TarFile1.tar.gz"),
+		filepath.Join(outDir, "TarFile2.tar.gz"),
+	})
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarDirectoryPopulated", ""},
+		{"TarDirectoryPopulated/TarSubFile1", "TarSubFile1"},
+		{"TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory", ""},
+	}, paths[1], true)
+	t.assertTarContents(c, []expectedTarContents{{"TarFile2", "TarFile2"}}, paths[3], true)
+	a, err := Analyze(paths[1])
+	c.Assert(err, gc.IsNil)
+	c.Assert(a.Entries, gc.Equals, 3)
+}
@@ -0,0 +1,103 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ArchiveStats summarises an archive for capacity planning.
+type ArchiveStats struct {
+	// Entries is the number of entries in the archive.
+	Entries int
+	// TotalSize is the sum of the sizes of all entries.
+	TotalSize int64
+	// SizeByType holds the total size of the entries of each
+	// type, named as in ManifestEntry.Type.
+	SizeByType map[string]int64
+	// Largest holds the largest regular files, biggest first.
+	Largest []EntrySize
+	// DeepestPath is the name of the entry with the most
+	// path components, and Depth is that number.
+	DeepestPath string
+	Depth       int
+	// Compressed records whether the archive is gzip compressed.
+	Compressed bool
+	// CompressedSize and UncompressedSize are the sizes of the
+	// compressed archive and of the tar stream it holds, and
+	// CompressionRatio is the second divided by the first. They
+	// are only set for compressed archives.
+	CompressedSize   int64
+	UncompressedSize int64
+	CompressionRatio float64
+}
+
+// Stats reads the archive from r, which may be gzip compressed,
+// and returns statistics about it. Only the entry headers are
+// parsed, but compressed archives are read to the end to measure
+// the compression ratio.
+func Stats(r io.Reader) (*ArchiveStats, error) {
+	compressedCounter := &countingReader{r: r}
+	compressed, br, err := sniffGzip(compressedCounter)
+	if err != nil {
+		return nil, err
+	}
+	s := &ArchiveStats{
+		SizeByType: make(map[string]int64),
+		Compressed: compressed,
+	}
+	var tarStream io.Reader = br
+	var counter *countingReader
+	if compressed {
+		gzr, err := newGzipReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("cannot uncompress archive: %v", err)
+		}
+		counter = &countingReader{r: gzr}
+		tarStream = counter
+	}
+	tr := tar.NewReader(tarStream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		s.add(hdr)
+	}
+	if compressed {
+		// The tar reader stops at the end of archive marker,
+		// so the padding that follows is counted explicitly.
+		if _, err := io.Copy(ioutil.Discard, tarStream); err != nil {
+			return nil, fmt.Errorf("cannot uncompress archive: %v", err)
+		}
+		s.UncompressedSize = counter.n
+		s.CompressedSize = compressedCounter.n
+		if s.CompressedSize > 0 {
+			s.CompressionRatio = float64(s.UncompressedSize) / float64(s.CompressedSize)
+		}
+	}
+	return s, nil
+}
+
+// add accounts for the given entry in the statistics.
+func (s *ArchiveStats) add(hdr *tar.Header) {
+	s.Entries++
+	s.TotalSize += hdr.Size
+	s.SizeByType[entryType(hdr)] += hdr.Size
+	name := cleanManifestPath(hdr.Name)
+	if depth := strings.Count(name, "/") + 1; depth > s.Depth {
+		s.Depth = depth
+		s.DeepestPath = hdr.Name
+	}
+	if hdr.FileInfo().Mode().IsRegular() {
+		s.Largest = addLargest(s.Largest, EntrySize{hdr.Name, hdr.Size})
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"os"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestStats(c *gc.C) {
+	for _, compress := range []bool{false, true} {
+		outputTar := t.createRangeArchive(c, compress)
+		f, err := os.Open(outputTar)
+		c.Assert(err, gc.IsNil)
+		stats, err := Stats(f)
+		f.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(stats.Entries, gc.Equals, 7)
+		c.Assert(stats.TotalSize, gc.Equals, int64(1008+11+8+8))
+		c.Assert(stats.SizeByType, gc.DeepEquals, map[string]int64{
+			"dir":  0,
+			"file": 1008 + 11 + 8 + 8,
+		})
+		c.Assert(stats.Largest[0], gc.Equals, EntrySize{"TarLog", 1008})
+		c.Assert(stats.Depth, gc.Equals, 2)
+		c.Assert(stats.Compressed, gc.Equals, compress)
+		if compress {
+			info, err := os.Stat(outputTar)
+			c.Assert(err, gc.IsNil)
+			c.Assert(stats.CompressedSize, gc.Equals, info.Size())
+			c.Assert(stats.UncompressedSize%512, gc.Equals, int64(0))
+			c.Assert(stats.CompressionRatio > 1, gc.Equals, true)
+		} else {
+			c.Assert(stats.CompressionRatio, gc.Equals, 0.0)
+		}
+		t.removeTestFiles(c)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// errWriterClosed is returned by the methods
+// of a Writer that has been closed.
+var errWriterClosed = errors.New("tar: writer closed")
+
+// Writer writes an archive to an io.Writer entry by entry, applying
+// the options given to NewWriter as TarFilesToWriter does, so that
+// archives can be assembled from several sources in a pipeline.
+// Its ReadFrom method copies the entries of another archive.
+type Writer struct {
+	opts *options
+	hash hash.Hash
+
+	// ops passes the operations to the goroutine
+	// writing the archive, and errs their results.
+	ops  chan func(a *archiver) error
+	errs chan error
+	// done receives the result of writing the archive.
+	done chan error
+
+	err    error
+	closed bool
+}
+
+// NewWriter returns a Writer writing an archive to w. If compress
+// is true, the archive will also be gzip compressed. The archive is
+// only complete once the Writer is closed.
+func NewWriter(w io.Writer, compress bool, opts ...Option) (*Writer, error) {
+	o := newOptions(opts)
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return nil, err
+	}
+	if o.volumeSize != 0 {
+		return nil, &ConfigError{Problems: []string{"WithVolumeSize cannot be used when writing to an io.Writer"}}
+	}
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return nil, err
+	}
+	tw := &Writer{
+		opts: o,
+		hash: shahash,
+		ops:  make(chan func(a *archiver) error),
+		errs: make(chan error),
+		done: make(chan error, 1),
+	}
+	go func() {
+		tw.done <- writeEntries(w, "", compress, shahash, o, func(a *archiver) error {
+			for op := range tw.ops {
+				err := op(a)
+				tw.errs <- err
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	return tw, nil
+}
+
+// do runs op with the archiver writing the archive. Once an
+// operation fails, the Writer fails every following one.
+func (w *Writer) do(op func(a *archiver) error) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	select {
+	case w.ops <- op:
+		w.err = <-w.errs
+	case err := <-w.done:
+		// The archive could not even be started.
+		w.done <- err
+		w.err = err
+	}
+	return w.err
+}
+
+// AddFiles writes entries for the files in fileList, as TarFiles does,
+// removing strip from the start of their names.
+func (w *Writer) AddFiles(fileList []string, strip string) error {
+	return w.do(func(a *archiver) error {
+		a.strip = strip
+		return a.writeAll(fileList)
+	})
+}
+
+// WriteEntry writes an entry with the header hdr and the contents
+// read from r, which may be nil for entries without contents.
+// The contents of regular files are passed through the filter given
+// WithContentFilter, which may make WriteEntry return SkipDir or
+// StopArchiving; SkipEntry is not returned.
+func (w *Writer) WriteEntry(hdr *tar.Header, r io.Reader) error {
+	return w.do(func(a *archiver) error {
+		return a.writeStreamEntry(hdr, r)
+	})
+}
+
+// ReadFrom implements io.ReaderFrom by writing every entry of the
+// archive read from r, which may be gzip compressed, as WriteEntry
+// does. It returns the number of bytes read from r.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	tr, err := newArchiveReader(counter)
+	if err != nil {
+		return counter.n, err
+	}
+	err = w.do(func(a *archiver) error {
+		skipPrefix := ""
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed while reading tar header: %v", err)
+			}
+			if hdr.Name == ManifestName {
+				continue
+			}
+			if skipPrefix != "" && strings.HasPrefix(cleanManifestPath(hdr.Name), skipPrefix) {
+				continue
+			}
+			skipPrefix = ""
+			err = a.writeStreamEntry(hdr, tr)
+			if err == SkipDir {
+				if skipPrefix = skipDirPrefix(hdr); skipPrefix == "" {
+					return nil
+				}
+				continue
+			}
+			if err == StopArchiving {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	return counter.n, err
+}
+
+// Close completes the archive. It does not close
+// the io.Writer given to NewWriter.
+func (w *Writer) Close() (err error) {
+	if w.closed {
+		return errWriterClosed
+	}
+	w.closed = true
+	defer func() { w.opts.failed(err) }()
+	close(w.ops)
+	return <-w.done
+}
+
+// Sum returns the checksum of the archive, as returned by
+// TarFilesToWriter. It is only valid once the Writer is closed.
+func (w *Writer) Sum() string {
+	return encodeArchiveHash(w.hash)
+}
+
+// writeStreamEntry writes the header h followed by the contents
+// read from r, filtering those of regular files, unless its type
+// is not selected.
+func (a *archiver) writeStreamEntry(h *tar.Header, r io.Reader) error {
+	if t := headerEntryType(h); !a.opts.selectsType(t) {
+		a.opts.log().Debugf("skipping %q: type %v not selected", h.Name, t)
+		return nil
+	}
+	if r != nil && h.FileInfo().Mode().IsRegular() && a.opts.contentFilter != nil {
+		filtered, cleanup, err := filterContents(h, r, a.opts.contentFilter, a.tmp)
+		if err == SkipEntry {
+			a.opts.log().Debugf("skipping %q: filtered out", h.Name)
+			return nil
+		}
+		if isWalkControl(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot filter contents of %q: %v", h.Name, err)
+		}
+		defer cleanup()
+		r = filtered
+	}
+	return a.writeEntry(h.Name, h, r)
+}
+
+// Reader reads the entries of an archive from an io.Reader, applying
+// the options given to NewReader as UntarFiles does but without
+// extracting anything, so that archives can be inspected or
+// transformed in a pipeline. Its WriteTo method writes the entries
+// that are kept as a new, uncompressed archive.
+type Reader struct {
+	tr     *tar.Reader
+	x      *extractor
+	limits *limiter
+	tmp    *runDir
+
+	// cleanup removes the contents of the current
+	// entry, if they were staged.
+	cleanup func()
+
+	// skipPrefix holds the directory whose remaining entries
+	// are skipped after a filter returned SkipDir.
+	skipPrefix string
+}
+
+// NewReader returns a Reader reading the archive from r, which
+// may be encrypted and gzip compressed. Entries are renamed
+// WithStripComponents and WithPrefixPath, skipped WithSkipFunc
+// and WithTypes, checked against the limits given WithLimits and have their
+// contents filtered WithContentFilter.
+func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
+	o := newOptions(opts)
+	if err := o.validate(opExtract); err != nil {
+		return nil, err
+	}
+	var err error
+	if o.encrypted() {
+		r, err = newDecryptReader(r, o)
+	} else if o.keyProvider != nil {
+		r, err = o.maybeDecrypt(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt archive: %v", err)
+	}
+	tr, err := newArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		tr:     tr,
+		x:      &extractor{opts: o},
+		limits: &limiter{limits: o.limits},
+		tmp:    newRunDir(o.tempDir),
+	}, nil
+}
+
+// Next advances to the next entry that is kept and returns its
+// header and a reader for its contents, which is only valid until
+// Next is called again, or io.EOF at the end of the archive.
+func (r *Reader) Next() (*tar.Header, io.Reader, error) {
+	r.release()
+	o := r.x.opts
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			return nil, nil, io.EOF
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if hdr.Name == ManifestName {
+			continue
+		}
+		if skip, err := r.x.checkHeader(hdr); skip || err != nil {
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := o.decodeNames(hdr); err != nil {
+			return nil, nil, err
+		}
+		if r.skipPrefix != "" && strings.HasPrefix(cleanManifestPath(hdr.Name), r.skipPrefix) {
+			continue
+		}
+		r.skipPrefix = ""
+		name, ok := o.outputName(hdr.Name)
+		if !ok || !o.selectsType(headerEntryType(hdr)) || o.skipFunc != nil && o.skipFunc(hdr) {
+			continue
+		}
+		contents, err := r.filter(hdr)
+		switch err {
+		case nil:
+		case SkipEntry:
+			continue
+		case SkipDir:
+			if r.skipPrefix = skipDirPrefix(hdr); r.skipPrefix != "" {
+				continue
+			}
+			return nil, nil, io.EOF
+		case StopArchiving:
+			return nil, nil, io.EOF
+		default:
+			return nil, nil, err
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if hdr.Linkname, ok = o.outputName(hdr.Linkname); !ok {
+				return nil, nil, fmt.Errorf("cannot rename hard link %q: target is not kept", hdr.Name)
+			}
+		}
+		hdr.Name = name
+		if err := r.limits.checkHeader(hdr); err != nil {
+			return nil, nil, err
+		}
+		r.limits.total += hdr.Size
+		return hdr, contents, nil
+	}
+}
+
+// filter returns the contents of the entry described by hdr,
+// passed through the filter given WithContentFilter, if any.
+func (r *Reader) filter(hdr *tar.Header) (io.Reader, error) {
+	filter := r.x.opts.contentFilter
+	if filter == nil || !hdr.FileInfo().Mode().IsRegular() {
+		return r.tr, nil
+	}
+	filtered, cleanup, err := filterContents(hdr, r.tr, filter, r.tmp)
+	if err != nil {
+		if isWalkControl(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot filter contents of %q: %v", hdr.Name, err)
+	}
+	r.cleanup = cleanup
+	return filtered, nil
+}
+
+// release removes the staged contents of the current entry, if any.
+func (r *Reader) release() {
+	if r.cleanup != nil {
+		r.cleanup()
+		r.cleanup = nil
+	}
+}
+
+// WriteTo implements io.WriterTo by writing the remaining entries
+// that are kept to w as an uncompressed archive. It returns the
+// number of bytes written to w.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+	for {
+		hdr, contents, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counter.n, err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return counter.n, fmt.Errorf("cannot write header for %q: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, contents); err != nil {
+			return counter.n, fmt.Errorf("failed to write %q: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return counter.n, fmt.Errorf("cannot close archive: %v", err)
+	}
+	return counter.n, nil
+}
+
+// Close releases the resources held by the Reader. It does not
+// close the io.Reader given to NewReader.
+func (r *Reader) Close() error {
+	r.release()
+	return r.tmp.remove()
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+// upperFilter is a ContentFilter upper casing contents.
+func upperFilter(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(bytes.ToUpper(data)), nil
+}
+
+func (t *TarSuite) TestWriter(c *gc.C) {
+	t.createTestFiles(c)
+	var src bytes.Buffer
+	srcw := tar.NewWriter(&src)
+	c.Assert(srcw.WriteHeader(&tar.Header{Name: "copied", Typeflag: tar.TypeReg, Mode: 0644, Size: 6}), gc.IsNil)
+	_, err := srcw.Write([]byte("copied"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(srcw.Close(), gc.IsNil)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, true, WithContentFilter(upperFilter))
+	c.Assert(err, gc.IsNil)
+	c.Assert(w.AddFiles([]string{filepath.Join(t.cwd, "TarFile1")}, t.cwd+"/"), gc.IsNil)
+	err = w.WriteEntry(&tar.Header{Name: "added", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}, strings.NewReader("added"))
+	c.Assert(err, gc.IsNil)
+	n, err := w.ReadFrom(&src)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(4*512))
+	c.Assert(w.Close(), gc.IsNil)
+	c.Assert(w.Close(), gc.Equals, errWriterClosed)
+	c.Assert(w.WriteEntry(&tar.Header{Name: "late"}, nil), gc.Equals, errWriterClosed)
+
+	sum := sha1.Sum(buf.Bytes())
+	c.Assert(w.Sum(), gc.Equals, base64.StdEncoding.EncodeToString(sum[:]))
+	files, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{
+		"TarFile1": []byte("TARFILE1"),
+		"added":    []byte("ADDED"),
+		"copied":   []byte("COPIED"),
+	})
+}
+
+func (t *TarSuite) TestWriterFailsAfterError(c *gc.C) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, false)
+	c.Assert(err, gc.IsNil)
+	err = w.WriteEntry(&tar.Header{Name: "short", Typeflag: tar.TypeReg, Size: 10}, strings.NewReader("x"))
+	c.Assert(err, gc.IsNil)
+	err = w.WriteEntry(&tar.Header{Name: "next", Typeflag: tar.TypeReg}, nil)
+	c.Assert(err, gc.ErrorMatches, `cannot write header for "next": .*`)
+	err = w.AddFiles(nil, "")
+	c.Assert(err, gc.ErrorMatches, `cannot write header for "next": .*`)
+	c.Assert(w.Close(), gc.NotNil)
+}
+
+func (t *TarSuite) TestReader(c *gc.C) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{tar.Header{Name: "root/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "root/app.conf", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}, "conf"},
+		{tar.Header{Name: "root/tmp/scratch", Typeflag: tar.TypeReg, Mode: 0644, Size: 7}, "scratch"},
+		{tar.Header{Name: "root/hard", Typeflag: tar.TypeLink, Linkname: "root/app.conf"}, ""},
+	} {
+		c.Assert(tw.WriteHeader(&e.hdr), gc.IsNil)
+		_, err := tw.Write([]byte(e.contents))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+
+	skip := func(hdr *tar.Header) bool {
+		return strings.Contains(hdr.Name, "/tmp/")
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), WithStripComponents(1), WithSkipFunc(skip), WithContentFilter(upperFilter))
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	hdr, contents, err := r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.Name, gc.Equals, "app.conf")
+	c.Assert(hdr.Size, gc.Equals, int64(4))
+	data, err := ioutil.ReadAll(contents)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "CONF")
+
+	var out bytes.Buffer
+	n, err := r.WriteTo(&out)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(out.Len()))
+	headers := readStreamHeaders(c, &out)
+	c.Assert(headers, gc.HasLen, 1)
+	c.Assert(headers[0].Name, gc.Equals, "hard")
+	c.Assert(headers[0].Linkname, gc.Equals, "app.conf")
+	_, _, err = r.Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (t *TarSuite) TestReaderLimits(c *gc.C) {
+	t.createTestFiles(c)
+	var buf bytes.Buffer
+	_, err := TarFilesToWriter(t.testFiles, &buf, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	r, err := NewReader(&buf, WithLimits(Limits{MaxTotalSize: 10}))
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	_, err = r.WriteTo(ioutil.Discard)
+	c.Assert(err, gc.FitsTypeOf, &LimitError{})
+}
+
+// readStreamHeaders returns the headers of the archive read from r.
+func readStreamHeaders(c *gc.C, r io.Reader) []*tar.Header {
+	var headers []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		c.Assert(err, gc.IsNil)
+		headers = append(headers, hdr)
+	}
+}
@@ -5,159 +5,1252 @@ package tar
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 // TarFiles creates a tar archive at targetPath holding the files listed
-// in fileList. If compress is true, the archive will also be gzip
+// in fileList. Names in fileList may be glob patterns, where a "**"
+// element matches any number of directories. If compress is true,
+// the archive will also be gzip compressed.
+func TarFiles(fileList []string, targetPath, strip string, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
+		return "", err
+	}
+	return tarFiles(fileList, targetPath, strip, compress, o)
+}
+
+// TarDirectory creates a tar archive at targetPath holding the
+// directory srcDir and everything below it. The directory is stored
+// under its base name, or under the name given WithRootName, so
+// /var/lib/juju can be archived as juju-backup/ without computing a
+// strip prefix. If compress is true, the archive will also be gzip
 // compressed.
-func TarFiles(fileList []string, targetPath, strip string, compress bool) (shaSum string, err error) {
-	shahash := sha1.New()
-	if err := tarAndHashFiles(fileList, targetPath, strip, compress, shahash); err != nil {
+func TarDirectory(srcDir, targetPath string, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	o.srcDir = filepath.Clean(srcDir)
+	if err := o.validate(opCreate); err != nil {
+		return "", err
+	}
+	if o.rootName == "" {
+		o.rootName = filepath.Base(o.srcDir)
+	}
+	if o.snapshotter != nil {
+		release, snapErr := o.snapshot()
+		if snapErr != nil {
+			return "", snapErr
+		}
+		defer func() {
+			if releaseErr := release(); releaseErr != nil {
+				if err == nil {
+					shaSum, err = "", releaseErr
+				} else {
+					o.log().Warnf("%v", releaseErr)
+				}
+			}
+		}()
+	}
+	return tarFiles([]string{o.srcDir}, targetPath, "", compress, o)
+}
+
+// tarFiles creates a tar archive at targetPath as
+// described by the validated options o.
+func tarFiles(fileList []string, targetPath, strip string, compress bool, o *options) (shaSum string, err error) {
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return "", err
+	}
+	if err := tarAndHashFiles(fileList, targetPath, strip, compress, shahash, o); err != nil {
+		return "", err
+	}
+	if o.seekableEvery != 0 {
+		indexed := o.timePhase(PhaseIndex)
+		if err := SaveIndex(targetPath); err != nil {
+			return "", fmt.Errorf("cannot index backup file: %v", err)
+		}
+		indexed()
+	}
+	o.log().Infof("created archive %q", targetPath)
+	return encodeArchiveHash(shahash), nil
+}
+
+// TarFilesToWriter is like TarFiles, but writes the archive to w
+// instead of a file, so it can be streamed to a remote sink.
+func TarFilesToWriter(fileList []string, w io.Writer, strip string, compress bool, opts ...Option) (shaSum string, err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compress
+	if err := o.validate(opCreate); err != nil {
 		return "", err
 	}
+	if o.volumeSize != 0 {
+		return "", &ConfigError{Problems: []string{"WithVolumeSize cannot be used when writing to an io.Writer"}}
+	}
+	shahash, err := newArchiveHash(o)
+	if err != nil {
+		return "", err
+	}
+	if err := writeArchive(fileList, w, strip, compress, shahash, o); err != nil {
+		return "", err
+	}
+	o.log().Infof("created archive")
+	return encodeArchiveHash(shahash), nil
+}
+
+// newArchiveHash returns the hash used to compute the archive
+// checksum, restored to the bookmarked state when resuming.
+func newArchiveHash(o *options) (hash.Hash, error) {
+	shahash := sha1.New()
+	if o.resume != nil {
+		if err := shahash.(encoding.BinaryUnmarshaler).UnmarshalBinary(o.resume.DigestState); err != nil {
+			return nil, fmt.Errorf("cannot resume from bookmark: %v", err)
+		}
+	}
+	return shahash, nil
+}
+
+// encodeArchiveHash returns the checksum of an archive
+// as returned by TarFiles.
+func encodeArchiveHash(shahash hash.Hash) string {
 	// we use a base64 encoded sha1 hash, because this is the hash
 	// used by RFC 3230 Digest headers in http responses
-	encodedHash := base64.StdEncoding.EncodeToString(shahash.Sum(nil))
-	return encodedHash, nil
+	return base64.StdEncoding.EncodeToString(shahash.Sum(nil))
+}
+
+func tarAndHashFiles(fileList []string, targetPath, strip string, compress bool, hashw hash.Hash, o *options) error {
+	return writeOutput(targetPath, o, func(w io.Writer) error {
+		return writeArchive(fileList, w, strip, compress, hashw, o)
+	})
+}
+
+// writeOutput creates the archive at targetPath as described by o,
+// calling write to write its contents.
+func writeOutput(targetPath string, o *options, write func(w io.Writer) error) (err error) {
+	f, err := createOutput(targetPath, o)
+	if err != nil {
+		return fmt.Errorf("cannot create backup file %q", targetPath)
+	}
+	defer func() {
+		// The archive is complete when only mirrors failed.
+		_, mirrored := err.(*MirrorError)
+		if af, ok := f.(interface{ abort() }); ok && err != nil && !mirrored {
+			af.abort()
+			return
+		}
+		if closeErr := f.Close(); closeErr != nil && (err == nil || mirrored) {
+			err = fmt.Errorf("error closing backup file: %v", closeErr)
+		}
+	}()
+	return write(f)
+}
+
+// writeArchive writes an archive of the files in fileList to out,
+// also writing everything written to out to hashw.
+func writeArchive(fileList []string, out io.Writer, strip string, compress bool, hashw hash.Hash, o *options) error {
+	return writeEntries(out, strip, compress, hashw, o, func(a *archiver) error {
+		return a.writeAll(fileList)
+	})
 }
 
-func tarAndHashFiles(fileList []string, targetPath, strip string, compress bool, hashw io.Writer) (err error) {
+// writeEntries writes an archive holding the entries written by
+// write to out, also writing everything written to out to hashw.
+func writeEntries(out io.Writer, strip string, compress bool, hashw hash.Hash, o *options, write func(a *archiver) error) (err error) {
 	checkClose := func(w io.Closer) {
 		if closeErr := w.Close(); closeErr != nil && err == nil {
 			err = fmt.Errorf("error closing backup file: %v", closeErr)
 		}
 	}
-	f, err := os.Create(targetPath)
-	if err != nil {
-		return fmt.Errorf("cannot create backup file %q", targetPath)
+	// Mirrors are checked once everything has been flushed to them.
+	defer func() {
+		if err == nil {
+			err = o.mirrorError()
+		}
+	}()
+	defer o.timePhase(PhaseArchive)()
+	if o.metrics != nil {
+		out = &meteredWriter{w: out, count: o.metrics.BytesWritten}
+	}
+	if o.rateLimit > 0 {
+		out = &throttledWriter{w: out, limiter: newRateLimiter(o.rateLimit)}
+	}
+	if o.concurrency.Write > 0 {
+		aw := newAsyncWriter(out, o.concurrency.Write)
+		defer checkClose(aw)
+		out = aw
+	}
+	counter := &countingWriter{w: out}
+	if o.resume != nil {
+		counter.n = o.resume.Offset
 	}
-	defer checkClose(f)
+	w := io.MultiWriter(append([]io.Writer{counter, hashw}, o.mirrorWriters()...)...)
 
-	w := io.MultiWriter(f, hashw)
+	if o.encrypted() {
+		encw, err := newEncryptWriter(w, o)
+		if err != nil {
+			return fmt.Errorf("cannot encrypt backup file: %v", err)
+		}
+		defer checkClose(encw)
+		w = encw
+	}
 
-	if compress {
-		gzw := gzip.NewWriter(w)
-		defer checkClose(gzw)
-		w = gzw
+	var gz *gzipMembers
+	if o.format == FormatZip {
+		// Zip archives compress each file themselves.
+	} else if compress && o.concurrency.Compress > 0 {
+		pgz := newParallelGzip(w, o.concurrency.Compress, o)
+		defer checkClose(pgz)
+		w = pgz
+	} else if compress {
+		gz = &gzipMembers{w: w, opts: o}
+		if err := gz.restart(); err != nil {
+			return fmt.Errorf("cannot compress backup file: %v", err)
+		}
+		defer checkClose(gz)
+		w = gz
+		if o.seekableEvery != 0 {
+			w = &syncWriter{gz: gz, every: o.seekableEvery}
+		}
 	}
 
-	tarw := tar.NewWriter(w)
+	tmp := newRunDir(o.tempDir)
+	defer tmp.remove()
+
+	tarw := newEntryWriter(w, compress, o)
 	defer checkClose(tarw)
-	for _, ent := range fileList {
-		if err := writeContents(ent, strip, tarw); err != nil {
+	a := &archiver{
+		tarw:  tarw,
+		strip: strip,
+		opts:  o,
+		tmp:   tmp,
+	}
+	a.ahead = newLookahead(o, a.skipped)
+	if o.dedup {
+		a.contents = make(map[string]string)
+	}
+	if o.bookmarkFunc != nil || o.resume != nil {
+		a.bookmarks = &bookmarker{
+			every:   o.bookmarkEvery,
+			fn:      o.bookmarkFunc,
+			counter: counter,
+			hash:    hashw,
+			gz:      gz,
+			last:    counter.n,
+		}
+		if o.resume != nil {
+			a.bookmarks.skip = o.resume.Entries
+			if o.bookmarkFunc == nil {
+				a.bookmarks.every = o.resume.Interval
+			}
+		}
+	}
+	if !o.embedManifest {
+		return write(a)
+	}
+	// The manifest must be the first entry of the archive, but it
+	// is only known once every file has been written, so the other
+	// entries are staged in a temporary archive first.
+	staging, err := tmp.tempFile("staging")
+	if err != nil {
+		return fmt.Errorf("cannot create staging file: %v", err)
+	}
+	defer staging.Close()
+	a.tarw = tar.NewWriter(staging)
+	a.manifest = newManifest()
+	if err := write(a); err != nil {
+		return err
+	}
+	if err := a.tarw.Close(); err != nil {
+		return fmt.Errorf("cannot write staging file: %v", err)
+	}
+	if _, err := staging.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot read staging file: %v", err)
+	}
+	return writeWithManifest(tarw, a.manifest, tar.NewReader(staging))
+}
+
+// archiver holds the state of a single archive creation.
+type archiver struct {
+	tarw  entryWriter
+	strip string
+	opts  *options
+	tmp   *runDir
+
+	// ancestors holds the directories currently being walked,
+	// used to detect loops when following symlinks.
+	ancestors []os.FileInfo
+
+	// manifest records the written entries, if the
+	// archive is to embed a manifest.
+	manifest *Manifest
+
+	// bookmarks is used to report or resume from
+	// bookmarks, if requested.
+	bookmarks *bookmarker
+
+	// ahead lists directories and reads files ahead
+	// of the walk, if requested.
+	ahead *lookahead
+
+	// ignores holds the rules of the ignore files found in
+	// the directories being archived, outermost first.
+	ignores []ignoreSet
+
+	// contents holds the names of the regular files archived
+	// keyed by size and digest, when deduplicating them.
+	contents map[string]string
+}
+
+// writeAll creates entries for all the files in fileList.
+func (a *archiver) writeAll(fileList []string) error {
+	fileList, err := expandGlobs(fileList)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	return a.writeList(fileList)
+}
+
+// writeList creates entries for the files in
+// fileList, without expanding patterns.
+func (a *archiver) writeList(fileList []string) error {
+	defer a.ahead.drop(fileList)
+	for i, ent := range fileList {
+		a.ahead.scheduleFrom(fileList, i)
+		err := a.writeContents(ent)
+		// The listed files are all in the directory of the
+		// whole archive, which SkipDir leaves the rest of.
+		if err == StopArchiving || err == SkipDir {
+			break
+		}
+		if err != nil {
 			return fmt.Errorf("backup failed: %v", err)
 		}
 	}
 	return nil
 }
 
+// writeEntry writes the header h followed by the contents read from
+// r, if r is not nil, recording the entry in the manifest if one is
+// being built.
+func (a *archiver) writeEntry(fileName string, h *tar.Header, r io.Reader) error {
+	_, err := a.copyEntry(fileName, h, r)
+	return err
+}
+
+// copyEntry writes the entry as writeEntry does, and reports whether
+// it was written, which it is not when it is skipped WithResume.
+func (a *archiver) copyEntry(fileName string, h *tar.Header, r io.Reader) (bool, error) {
+	// The type of the file may not be that of its entry,
+	// as with files stored as hard links WithDedup.
+	if t := headerEntryType(h); !a.opts.selectsType(t) {
+		a.opts.log().Debugf("skipping %q: type %v not selected", fileName, t)
+		return false, nil
+	}
+	a.opts.pause()
+	if a.bookmarks != nil {
+		skip, err := a.bookmarks.beforeEntry(a.tarw)
+		if err != nil {
+			return false, err
+		}
+		if skip {
+			return false, nil
+		}
+	}
+	a.opts.setOwner(h)
+	if a.opts.layer {
+		normalizeLayerHeader(h)
+	}
+	if err := a.opts.checkPathLimits(h); err != nil {
+		return false, err
+	}
+	if err := a.tarw.WriteHeader(h); err != nil {
+		return false, fmt.Errorf("cannot write header for %q: %v", fileName, err)
+	}
+	var digest hash.Hash
+	if r != nil {
+		if a.opts.metrics != nil {
+			r = &meteredReader{r: r, count: a.opts.metrics.BytesRead}
+		}
+		var w io.Writer = a.tarw
+		if a.manifest != nil {
+			digest = sha256.New()
+			w = io.MultiWriter(w, digest)
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return false, fmt.Errorf("failed to write %q: %v", fileName, err)
+		}
+	}
+	if a.manifest != nil {
+		a.manifest.add(h, digest)
+	}
+	a.opts.meter().EntryProcessed(h)
+	a.opts.log().Debugf("archived %q as %q", fileName, h.Name)
+	return true, nil
+}
+
+// skipped reports whether fileName is left out
+// of the archive whatever the file it names.
+func (a *archiver) skipped(fileName string) bool {
+	return a.opts.excluded(fileName) || a.ignored(fileName)
+}
+
 // writeContents creates an entry for the given file
 // or directory in the given tar archive.
-func writeContents(fileName, strip string, tarw *tar.Writer) error {
-	f, err := os.Open(fileName)
-	if err != nil {
-		return err
+func (a *archiver) writeContents(fileName string) error {
+	if a.opts.excluded(fileName) {
+		a.opts.log().Debugf("skipping excluded %q", fileName)
+		return nil
 	}
-	defer f.Close()
-	fInfo, err := f.Stat()
-	if err != nil {
+	if a.ignored(fileName) {
+		a.opts.log().Debugf("skipping ignored %q", fileName)
+		return nil
+	}
+	pre := a.ahead.take(fileName)
+	f, fInfo, skip, err := a.openFile(fileName)
+	if skip || err != nil {
 		return err
 	}
+	if f != nil {
+		defer f.Close()
+	}
+	if a.skipUnselected(fileName, fInfo) {
+		return nil
+	}
+	if f == nil {
+		return a.writeSymlink(fileName, fInfo)
+	}
 	h, err := tar.FileInfoHeader(fInfo, "")
 	if err != nil {
 		return fmt.Errorf("cannot create tar header for %q: %v", fileName, err)
 	}
-	h.Name = filepath.ToSlash(strings.TrimPrefix(fileName, strip))
-	if err := tarw.WriteHeader(h); err != nil {
-		return fmt.Errorf("cannot write header for %q: %v", fileName, err)
+	h.Name = a.entryName(fileName)
+	if err := a.opts.setUTF8Names(h); err != nil {
+		return err
+	}
+	if w, ok := liveDatabaseWarning(fileName); ok {
+		a.opts.warn(w)
+	}
+	var r io.Reader = f
+	if pre != nil && pre.data != nil && int64(len(pre.data)) == fInfo.Size() && fInfo.Mode().IsRegular() {
+		r = bytes.NewReader(pre.data)
+	}
+	if r, err = a.readRange(fileName, h, r); err != nil {
+		return err
+	}
+	if !fInfo.IsDir() && a.opts.contentFilter != nil {
+		filtered, cleanup, err := filterContents(h, r, a.opts.contentFilter, a.tmp)
+		if err == SkipEntry {
+			a.opts.log().Debugf("skipping %q: filtered out", fileName)
+			return nil
+		}
+		if isWalkControl(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot filter contents of %q: %v", fileName, err)
+		}
+		defer cleanup()
+		r = filtered
 	}
 	if !fInfo.IsDir() {
-		if _, err := io.Copy(tarw, f); err != nil {
-			return fmt.Errorf("failed to write %q: %v", fileName, err)
+		if a.contents != nil {
+			linked, err := a.dedupEntry(h, r)
+			if err != nil {
+				return err
+			}
+			if linked {
+				return a.writeEntry(fileName, h, nil)
+			}
+		}
+		if !fInfo.Mode().IsRegular() {
+			return a.writeEntry(fileName, h, r)
+		}
+		sized := &sizedReader{r: r, size: h.Size}
+		copied, err := a.copyEntry(fileName, h, sized)
+		if err != nil {
+			return err
+		}
+		if !copied {
+			return nil
+		}
+		if changed := sized.changed(fileName, h.Name); changed != nil {
+			a.fileChanged(changed)
 		}
 		return nil
 	}
-	if !strings.HasSuffix(fileName, string(os.PathSeparator)) {
-		fileName = fileName + string(os.PathSeparator)
+	if a.opts.selectsType(EntryDir) {
+		if err := a.writeEntry(fileName, h, nil); err != nil {
+			return err
+		}
+	}
+	for _, ancestor := range a.ancestors {
+		if os.SameFile(ancestor, fInfo) {
+			return fmt.Errorf("symlink loop detected at %q", fileName)
+		}
+	}
+	a.ancestors = append(a.ancestors, fInfo)
+	defer func() {
+		a.ancestors = a.ancestors[:len(a.ancestors)-1]
+	}()
+	if sep := a.separator(); !strings.HasSuffix(fileName, sep) {
+		fileName = fileName + sep
+	}
+	restore, err := a.readIgnores(fileName)
+	if err != nil {
+		return err
 	}
+	defer restore()
 
-	for {
-		names, err := f.Readdirnames(100)
-		if len(names) == 0 && err == io.EOF {
+	// The names are sorted so that the same tree is always
+	// archived in the same order, which bookmarks rely on.
+	var names []string
+	if pre != nil && pre.names != nil {
+		names = pre.names
+	} else {
+		names, err = a.readDirNames(f, fileName)
+		if err != nil && a.opts.unreadable.Skip {
+			// The directory entry is written, so
+			// only what is below it is left out.
+			a.skipUnreadable(fileName, err)
 			return nil
 		}
 		if err != nil {
 			return fmt.Errorf("error reading directory %q: %v", fileName, err)
 		}
-		for _, name := range names {
-			if err := writeContents(filepath.Join(fileName, name), strip, tarw); err != nil {
-				return err
-			}
+		sort.Strings(names)
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = a.join(fileName, name)
+	}
+	defer a.ahead.drop(paths)
+	for i, p := range paths {
+		a.ahead.scheduleFrom(paths, i)
+		err := a.writeContents(p)
+		if err == SkipDir {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+// entryName returns the name in the archive of the given file.
+func (a *archiver) entryName(fileName string) string {
+	if a.opts.srcDir != "" {
+		return a.opts.rootName + filepath.ToSlash(strings.TrimPrefix(fileName, a.opts.srcDir))
+	}
+	return filepath.ToSlash(strings.TrimPrefix(fileName, a.strip))
 }
 
-func UntarFiles(tarFile, outputFolder string, compressed bool) error {
-	f, err := os.Open(tarFile)
+// writeSymlink creates an entry for the given symlink
+// itself rather than for the file it points to.
+func (a *archiver) writeSymlink(fileName string, fInfo os.FileInfo) error {
+	link, err := a.readlink(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot read symlink %q: %v", fileName, err)
+	}
+	h, err := tar.FileInfoHeader(fInfo, link)
 	if err != nil {
-		return fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+		return fmt.Errorf("cannot create tar header for %q: %v", fileName, err)
+	}
+	h.Name = a.entryName(fileName)
+	if err := a.opts.setUTF8Names(h); err != nil {
+		return err
+	}
+	return a.writeEntry(fileName, h, nil)
+}
+
+// UntarFiles extracts the tar archive tarFile into outputFolder. If
+// compressed is true, the archive is expected to be gzip compressed.
+func UntarFiles(tarFile, outputFolder string, compressed bool, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() { o.failed(err) }()
+	o.compress = compressed
+	if err := o.validate(opExtract); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			o.log().Infof("extracted %q into %q", tarFile, outputFolder)
+		}
+	}()
+	if o.spaceCheck {
+		checked := o.timePhase(PhaseSpaceCheck)
+		if err := checkSpace(tarFile, outputFolder, compressed, o); err != nil {
+			return err
+		}
+		checked()
+	}
+	if o.atomic {
+		var staging string
+		err := extractAtomically(outputFolder, func(dir string) error {
+			staging = dir
+			return untarFiles(tarFile, dir, compressed, o)
+		})
+		if err == nil {
+			o.report.relocate(staging, filepath.Clean(outputFolder))
+		}
+		return err
+	}
+	return untarFiles(tarFile, outputFolder, compressed, o)
+}
+
+// untarFiles extracts tarFile into outputFolder
+// as described by the validated options o.
+func untarFiles(tarFile, outputFolder string, compressed bool, o *options) error {
+	var prog *progress
+	if o.stateFile != "" {
+		var err error
+		if prog, err = loadProgress(o.stateFile, tarFile); err != nil {
+			return err
+		}
+	}
+	r, closeInput, err := openExtractStream(tarFile, compressed, o)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	if o.prefixPath != "" {
+		if err := createPrefix(outputFolder, o.prefixPath); err != nil {
+			return err
+		}
+	}
+	defer o.timePhase(PhaseExtract)()
+	x := &extractor{
+		outputFolder: outputFolder,
+		opts:         o,
+		progress:     prog,
+		memory:       newMemoryBudget(o.memoryBudget),
+	}
+	if o.limits != (Limits{}) {
+		x.limits = &limiter{limits: o.limits}
+	}
+	if o.report != nil || o.duplicatePolicy != DuplicateLastWins {
+		x.seen = make(map[string]bool)
+	}
+	if o.caseCollisions != CollisionIgnore {
+		x.collisions = append(x.collisions, newCaseCollisions(o.caseCollisions))
+	}
+	if o.normCollisions != CollisionIgnore {
+		x.collisions = append(x.collisions, newNormalizationCollisions(o.normCollisions))
+	}
+	if o.flatten {
+		x.flattener = newFlattener(o.flattenCollisions, o.prefixPath)
+	}
+	if o.syncPolicy == SyncAtEnd {
+		x.unsynced = &pendingSyncs{}
 	}
-	defer f.Close()
+	if o.whiteouts != whiteoutNone {
+		x.whiteouts = newWhiteouts(o.whiteouts)
+	}
+	if o.concurrency.Write > 0 {
+		x.files = newFileWriters(o.concurrency.Write)
+	}
+	if o.secure {
+		root, err := openSecureRoot(outputFolder)
+		if err != nil {
+			return err
+		}
+		defer root.Close()
+		x.root = root
+	}
+	err = x.extractAll(tar.NewReader(r))
+	if x.files != nil {
+		if writeErr := x.files.wait(); writeErr != nil {
+			return writeErr
+		}
+	}
+	o.report.setPeakMemory(x.memory.maxUsed())
+	// What was recovered WithBestEffort is
+	// finished as a complete extraction is.
+	if _, partial := err.(*PartialExtractionError); err == nil || partial {
+		if finishErr := x.finishTree(); finishErr != nil {
+			err = finishErr
+		}
+	}
+	if err == nil && prog != nil {
+		err = prog.finish()
+	}
+	return err
+}
+
+// finishTree sets the deferred times and modes of the
+// extracted directories, and syncs SyncAtEnd.
+func (x *extractor) finishTree() error {
+	x.applyDirTimes()
+	if err := x.applyDirModes(); err != nil {
+		return err
+	}
+	if x.unsynced == nil {
+		return nil
+	}
+	defer x.opts.timePhase(PhaseSync)()
+	return x.unsynced.sync()
+}
+
+// openExtractStream opens tarFile and returns the uncompressed and
+// decrypted tar stream it holds, as described by o, and a function
+// closing it.
+func openExtractStream(tarFile string, compressed bool, o *options) (_ io.Reader, _ func(), err error) {
+	if o.zipInput {
+		return openZipStream(tarFile)
+	}
+	f, err := openStored(o.storage(), tarFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open backup file %q: %v", tarFile, err)
+	}
+	closers := []io.Closer{f}
+	closeInput := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeInput()
+		}
+	}()
 	var r io.Reader = f
+	if o.metrics != nil {
+		r = &meteredReader{r: r, count: o.metrics.BytesRead}
+	}
+	if o.rateLimit > 0 {
+		r = &throttledReader{r: r, limiter: newRateLimiter(o.rateLimit)}
+	}
+	if o.concurrency.Read > 0 {
+		ar := newAheadReader(r, o.concurrency.Read)
+		closers = append(closers, ar)
+		r = ar
+	}
+	if o.encrypted() {
+		r, err = newDecryptReader(r, o)
+	} else if o.keyProvider != nil {
+		r, err = o.maybeDecrypt(r)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot decrypt tar file %q: %v", tarFile, err)
+	}
 	if compressed {
-		r, err = gzip.NewReader(r)
+		r, err = newGzipReader(r)
 		if err != nil {
-			return fmt.Errorf("cannot uncompress tar file %q: %v", tarFile, err)
+			return nil, nil, fmt.Errorf("cannot uncompress tar file %q: %v", tarFile, err)
+		}
+		if o.concurrency.Compress > 0 {
+			ar := newAheadReader(r, o.concurrency.Compress)
+			closers = append(closers, ar)
+			r = ar
 		}
 	}
-	tr := tar.NewReader(r)
-	for {
+	return r, closeInput, nil
+}
+
+// extractor holds the state of a single extraction.
+type extractor struct {
+	outputFolder string
+	opts         *options
+
+	// skipPrefix holds the directory whose remaining entries
+	// are skipped after a filter returned SkipDir.
+	skipPrefix string
+
+	// digests holds the expected digests of regular files
+	// when verifying contents, and mismatch the problems found.
+	digests  map[string]string
+	mismatch *ManifestMismatchError
+
+	// files writes regular files concurrently, if requested.
+	files *fileWriters
+
+	// progress records the progress of a resumable
+	// extraction, if requested.
+	progress *progress
+
+	// limits enforces the limits of the extraction, if any.
+	limits *limiter
+
+	// seen holds the names of the entries extracted so far,
+	// when duplicates need to be detected.
+	seen map[string]bool
+
+	// collisions holds the detectors of colliding
+	// names that were requested.
+	collisions []*collisionDetector
+
+	// unsynced holds the files to flush once
+	// extraction ends, if requested.
+	unsynced *pendingSyncs
+
+	// memory accounts for the memory holding entry contents.
+	memory *memoryBudget
+
+	// dirs holds the directories whose mode is
+	// set once extraction ends.
+	dirs []deferredDir
+
+	// root confines the files created to the output
+	// folder WithSecureExtraction.
+	root *secureRoot
+
+	// extracted holds the names of the entries
+	// extracted so far WithBestEffort.
+	extracted []string
+
+	// whiteouts applies whiteout markers
+	// when applying a layer.
+	whiteouts *whiteouts
+
+	// flattener gives the names of the files
+	// extracted WithFlatten.
+	flattener *flattener
+
+	// dirTimes holds the directories whose times
+	// are set once extraction ends.
+	dirTimes []deferredTimes
+
+	// symlinks holds the paths of the symlinks extracted so
+	// far, through which no later entry is extracted.
+	symlinks map[string]bool
+
+	// written holds the paths of the regular files written so
+	// far WithBackupDir, which are not backed up again.
+	written map[string]bool
+}
+
+// extractAll extracts every entry read from tr.
+func (x *extractor) extractAll(tr *tar.Reader) error {
+	for first := true; ; first = false {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			// end of tar archive
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed while reading tar header: %v", err)
+			return x.corrupt("", err)
+		}
+		if hdr.Name == ManifestName {
+			if first && x.opts.verifyContents {
+				m, err := decodeManifest(tr)
+				if err != nil {
+					return err
+				}
+				x.digests = m.digests()
+				x.mismatch = &ManifestMismatchError{}
+			}
+			continue
+		}
+		if first && x.opts.verifyContents {
+			return fmt.Errorf("cannot verify contents: %v", ErrNoManifest)
+		}
+		if skip, err := x.checkHeader(hdr); skip || err != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := x.opts.decodeNames(hdr); err != nil {
+			return err
+		}
+		x.opts.pause()
+		err = x.extract(hdr, tr)
+		if err == StopArchiving {
+			return nil
 		}
-		buf := make([]byte, hdr.Size)
-		buf, err = ioutil.ReadAll(tr)
 		if err != nil {
-			return fmt.Errorf("failed while reading tar contents: %v", err)
+			return err
+		}
+		x.opts.meter().EntryProcessed(hdr)
+		if x.progress != nil {
+			if err := x.progress.completed(hdr.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if x.mismatch == nil {
+		return nil
+	}
+	for name := range x.digests {
+		x.mismatch.Missing = append(x.mismatch.Missing, name)
+	}
+	sort.Strings(x.mismatch.Missing)
+	if len(x.mismatch.Mismatched)+len(x.mismatch.Missing)+len(x.mismatch.Unexpected) > 0 {
+		return x.mismatch
+	}
+	return nil
+}
+
+// extract extracts the entry with the given header,
+// reading its contents from r.
+func (x *extractor) extract(hdr *tar.Header, r io.Reader) error {
+	name, ok := x.opts.outputName(hdr.Name)
+	if !ok {
+		x.opts.log().Debugf("skipping %q: not selected", hdr.Name)
+		return nil
+	}
+	if x.skipPrefix != "" && strings.HasPrefix(cleanManifestPath(hdr.Name), x.skipPrefix) {
+		x.opts.log().Debugf("skipping %q: directory skipped", hdr.Name)
+		return nil
+	}
+	if t := headerEntryType(hdr); !x.opts.selectsType(t) {
+		x.opts.log().Debugf("skipping %q: type %v not selected", hdr.Name, t)
+		return nil
+	}
+	if x.opts.skipFunc != nil && x.opts.skipFunc(hdr) {
+		x.opts.log().Debugf("skipping %q: skipped by SkipFunc", hdr.Name)
+		return nil
+	}
+	if x.flattener != nil {
+		var err error
+		if name, ok, err = x.flattener.resolve(hdr, x.opts.report); !ok || err != nil {
+			if err == nil {
+				x.opts.log().Debugf("skipping %q: not flattened", hdr.Name)
+			}
+			return err
+		}
+	}
+	if x.whiteouts != nil {
+		if marker, err := x.whiteouts.apply(x, name, hdr); marker || err != nil {
+			return err
+		}
+	}
+	if skip, err := x.duplicate(name, hdr); skip || err != nil {
+		if skip {
+			x.opts.log().Debugf("skipping %q: duplicate entry", hdr.Name)
+		}
+		return err
+	}
+	name, ok, err := x.resolveCollisions(name, hdr)
+	if !ok || err != nil {
+		if err == nil {
+			x.opts.log().Debugf("skipping %q: name collision", hdr.Name)
+		}
+		return err
+	}
+	if x.limits != nil {
+		if err := x.limits.checkHeader(hdr); err != nil {
+			return err
 		}
-		fullPath := filepath.Join(outputFolder, hdr.Name)
+	}
+	contents := r
+	if x.opts.contentFilter != nil && hdr.FileInfo().Mode().IsRegular() {
+		var err error
+		contents, err = x.opts.contentFilter(hdr, r)
+		switch err {
+		case SkipEntry:
+			x.opts.log().Debugf("skipping %q: filtered out", hdr.Name)
+			return nil
+		case SkipDir:
+			x.opts.log().Debugf("skipping directory of %q: filtered out", hdr.Name)
+			if x.skipPrefix = skipDirPrefix(hdr); x.skipPrefix == "" {
+				return StopArchiving
+			}
+			return nil
+		case StopArchiving:
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot filter contents of %q: %v", hdr.Name, err)
+		}
+	}
+	if x.limits != nil {
+		// Filters may produce more than the archived contents, so
+		// at most one byte over the limit is read to detect it.
+		if n := x.limits.readLimit(); n >= 0 {
+			contents = io.LimitReader(contents, n+1)
+		}
+	}
+	held := hdr.Size
+	x.memory.acquire(held)
+	b := getBuffer()
+	queued := false
+	release := func() {
+		putBuffer(b)
+		x.memory.release(held)
+	}
+	defer func() {
+		if !queued {
+			release()
+		}
+	}()
+	if _, err := b.ReadFrom(contents); err != nil {
+		return x.corrupt(hdr.Name, err)
+	}
+	if n := int64(b.Len()); n > held {
+		x.memory.grow(n - held)
+		held = n
+	}
+	buf := b.Bytes()
+	if x.limits != nil {
+		if err := x.limits.extracted(hdr.Name, int64(len(buf))); err != nil {
+			return err
+		}
+	}
+	fullPath, err := extractPath(x.outputFolder, name)
+	if err != nil {
+		return err
+	}
+	if dir := x.symlinkParent(fullPath); dir != "" {
+		return fmt.Errorf("cannot extract %q: parent %q is a symlink", hdr.Name, dir)
+	}
+	if x.symlinks[fullPath] {
+		return fmt.Errorf("cannot extract %q: it would replace a symlink", hdr.Name)
+	}
+	if x.progress != nil && x.progress.extracted(fullPath, hdr, buf) {
+		x.opts.log().Debugf("skipping %q: already extracted", hdr.Name)
 		if hdr.Typeflag == tar.TypeDir {
-			if err = os.MkdirAll(fullPath, os.FileMode(hdr.Mode)); err != nil {
-				return fmt.Errorf("cannot extract directory %q: %v", fullPath, err)
+			x.restoreTimes(fullPath, hdr)
+			if err := x.deferDirMode(fullPath, x.opts.modePolicy.mode(hdr), false); err != nil {
+				return err
 			}
-		} else {
-			fh, err := os.Create(fullPath)
-			if err != nil {
-				return fmt.Errorf("some of the tar contents cannot be written to disk: %v", err)
+		}
+		if x.digests != nil && hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeSymlink {
+			x.verify(hdr.Name, buf)
+		}
+		return nil
+	}
+	if x.files != nil {
+		// Directories and links are created at once, so the
+		// files queued for their path or above it must be
+		// written first.
+		x.files.waitForParents(fullPath)
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+			x.files.waitFor(fullPath)
+		}
+	}
+	// Archives written by other tools may have file entries
+	// before their directory entries, or none at all.
+	if ok, err := x.createParents(fullPath); !ok || err != nil {
+		return err
+	}
+	replaced, replacedBackup, ok, err := x.typeConflict(fullPath, name, hdr)
+	if !ok || err != nil {
+		return err
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		mode := x.opts.modePolicy.mode(hdr)
+		_, statErr := os.Lstat(fullPath)
+		// The directory stays writable by its owner until
+		// everything below it is extracted.
+		if err = x.mkdirAll(fullPath, mode|ownerDirBits); err != nil {
+			return fmt.Errorf("cannot extract directory %q: %v", fullPath, err)
+		}
+		if os.IsNotExist(statErr) {
+			x.opts.report.created(fullPath, hdr, 0, replaced, replacedBackup)
+		}
+		x.opts.log().Debugf("extracted directory %q to %q", hdr.Name, fullPath)
+		x.restoreMetadata(fullPath, hdr)
+		x.restoreTimes(fullPath, hdr)
+		if err := x.deferDirMode(fullPath, mode, os.IsNotExist(statErr)); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := x.symlink(linkTarget(hdr.Linkname), fullPath); err != nil {
+			if !copySymlinks || !x.copySymlink(fullPath, hdr) {
+				return fmt.Errorf("cannot extract symlink %q: %v", fullPath, err)
 			}
-			_, err = fh.Write(buf)
-
-			if err != nil {
-				fh.Close()
-				return fmt.Errorf("some of the tar contents cannot be written to disk: %v", err)
+		}
+		if x.symlinks == nil {
+			x.symlinks = make(map[string]bool)
+		}
+		x.symlinks[fullPath] = true
+		x.opts.report.created(fullPath, hdr, 0, replaced, replacedBackup)
+		x.opts.log().Debugf("extracted symlink %q to %q", hdr.Name, fullPath)
+		x.restoreMetadata(fullPath, hdr)
+	case tar.TypeLink:
+		if err := x.extractLink(fullPath, hdr); err != nil {
+			return err
+		}
+		x.opts.report.created(fullPath, hdr, 0, replaced, replacedBackup)
+		x.opts.log().Debugf("extracted hard link %q to %q", hdr.Name, fullPath)
+	default:
+		if !hdr.FileInfo().Mode().IsRegular() {
+			x.opts.report.degrade(Degradation{
+				Kind:    DegradationType,
+				Path:    hdr.Name,
+				Message: fmt.Sprintf("%s entry extracted as a regular file", entryType(hdr)),
+			})
+		}
+		mode := x.opts.modePolicy.mode(hdr)
+		// Only what was there before the extraction is backed up,
+		// not what an earlier entry of the same name wrote.
+		rewrite := x.written[fullPath]
+		if x.opts.backupDir != "" {
+			if x.written == nil {
+				x.written = make(map[string]bool)
 			}
-			err = fh.Chmod(os.FileMode(hdr.Mode))
-			fh.Close()
-			if err != nil {
-				return fmt.Errorf("cannot set proper mode on file %q: %v", fullPath, err)
+			x.written[fullPath] = true
+		}
+		write := func() error {
+			_, statErr := os.Lstat(fullPath)
+			var backupPath string
+			if !rewrite {
+				var err error
+				if backupPath, err = x.backup(fullPath, name); err != nil {
+					return err
+				}
 			}
-
+			overwrote := (statErr == nil && !rewrite) || replaced
+			if replaced {
+				backupPath = replacedBackup
+			}
+			if err := x.writeFile(fullPath, buf, mode); err != nil {
+				return err
+			}
+			if x.unsynced != nil {
+				x.unsynced.add(fullPath)
+			}
+			x.opts.meter().BytesWritten(int64(len(buf)))
+			x.opts.report.created(fullPath, hdr, int64(len(buf)), overwrote, backupPath)
+			x.opts.log().Debugf("extracted %q to %q", hdr.Name, fullPath)
+			x.restoreMetadata(fullPath, hdr)
+			// Changing the owner clears the setuid and setgid bits.
+			if mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+				if err := x.chmod(fullPath, mode); err != nil {
+					return fmt.Errorf("cannot set proper mode on file %q: %v", fullPath, err)
+				}
+			}
+			x.restoreTimes(fullPath, hdr)
+			return nil
+		}
+		if x.digests != nil {
+			x.verify(hdr.Name, buf)
+		}
+		if x.files != nil {
+			if err := x.files.failed(); err != nil {
+				return err
+			}
+			queued = true
+			x.files.write(fullPath, func() error {
+				defer release()
+				return write()
+			})
+		} else if err := write(); err != nil {
+			return err
 		}
 	}
+	if x.opts.bestEffort {
+		x.extracted = append(x.extracted, hdr.Name)
+	}
 	return nil
 }
+
+// createParents creates the missing directories above fullPath,
+// one at a time from the outermost, recording each in the report.
+// Files in the way are handled as type conflicts; it reports whether
+// the entry at fullPath must still be extracted.
+func (x *extractor) createParents(fullPath string) (bool, error) {
+	dir := filepath.Dir(fullPath)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return true, nil
+	}
+	root, err := extractPath(x.outputFolder, "")
+	if err != nil {
+		return false, err
+	}
+	var missing []string
+	for d := dir; d != root && d != filepath.Dir(d); d = filepath.Dir(d) {
+		if info, err := os.Stat(d); err == nil && info.IsDir() {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return false, fmt.Errorf("cannot create directory %q: %v", root, err)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		rel, err := filepath.Rel(root, d)
+		if err != nil {
+			return false, err
+		}
+		hdr := &tar.Header{
+			Name:     filepath.ToSlash(rel) + "/",
+			Typeflag: tar.TypeDir,
+		}
+		replaced, backup, ok, err := x.typeConflict(d, filepath.ToSlash(rel), hdr)
+		if !ok || err != nil {
+			return false, err
+		}
+		if err := x.mkdir(d, 0755); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return false, fmt.Errorf("cannot create directory %q: %v", d, err)
+		}
+		x.opts.report.created(d, hdr, 0, replaced, backup)
+	}
+	return true, nil
+}
+
+// symlinkParent returns the first parent of fullPath
+// that is a symlink extracted earlier, if any.
+func (x *extractor) symlinkParent(fullPath string) string {
+	for dir := filepath.Dir(fullPath); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if x.symlinks[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// writeFile writes contents to a new file at fullPath and sets its
+// mode, flushing it to stable storage WithSyncPolicy SyncEachFile.
+func (x *extractor) writeFile(fullPath string, contents []byte, mode os.FileMode) error {
+	fh, err := x.create(fullPath)
+	if err != nil {
+		return fmt.Errorf("some of the tar contents cannot be written to disk: %v", err)
+	}
+	_, err = fh.Write(contents)
+
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("some of the tar contents cannot be written to disk: %v", err)
+	}
+	err = fh.Chmod(mode)
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("cannot set proper mode on file %q: %v", fullPath, err)
+	}
+	if x.opts.syncPolicy == SyncEachFile {
+		if err := fsync(fh); err != nil {
+			fh.Close()
+			return fmt.Errorf("cannot sync %q: %v", fullPath, err)
+		}
+	}
+	return fh.Close()
+}
+
+// verify checks the contents extracted for the named
+// entry against the expected digests.
+func (x *extractor) verify(name string, contents []byte) {
+	name = cleanManifestPath(name)
+	want, ok := x.digests[name]
+	if !ok {
+		x.mismatch.Unexpected = append(x.mismatch.Unexpected, name)
+		return
+	}
+	delete(x.digests, name)
+	sum := sha256.Sum256(contents)
+	if hex.EncodeToString(sum[:]) != want {
+		x.mismatch.Mismatched = append(x.mismatch.Mismatched, name)
+	}
+}
@@ -255,3 +255,165 @@ func (t *TarSuite) TestUntarTarFilesCompressed(c *gc.C) {
 	UntarFiles(outputTarGz, outputDir, true)
 	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
 }
+
+// Symlinks
+
+// readHeaders returns the headers of all entries in the given
+// uncompressed tar file, keyed by name.
+func readHeaders(c *gc.C, tarFile string) map[string]*tar.Header {
+	f, err := os.Open(tarFile)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		headers[hdr.Name] = hdr
+	}
+	return headers
+}
+
+func (t *TarSuite) createSymlinks(c *gc.C) {
+	t.createTestFiles(c)
+	link := filepath.Join(t.cwd, "TarLink")
+	err := os.Symlink("TarFile1", link)
+	c.Assert(err, gc.IsNil)
+	dirLink := filepath.Join(t.cwd, "TarDirLink")
+	err = os.Symlink("TarDirectoryPopulated", dirLink)
+	c.Assert(err, gc.IsNil)
+	t.testFiles = append(t.testFiles, link, dirLink)
+}
+
+func (t *TarSuite) TestTarFilesStoresSymlinks(c *gc.C) {
+	t.createSymlinks(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers["TarLink"].Typeflag, gc.Equals, byte(tar.TypeSymlink))
+	c.Assert(headers["TarLink"].Linkname, gc.Equals, "TarFile1")
+	c.Assert(headers["TarDirLink"].Typeflag, gc.Equals, byte(tar.TypeSymlink))
+
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	target, err := os.Readlink(filepath.Join(outputDir, "TarLink"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(target, gc.Equals, "TarFile1")
+}
+
+func (t *TarSuite) TestTarFilesDereference(c *gc.C) {
+	t.createSymlinks(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithDereference())
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers["TarLink"].Typeflag, gc.Equals, byte(tar.TypeReg))
+	c.Assert(headers["TarDirLink"].Typeflag, gc.Equals, byte(tar.TypeDir))
+	t.assertTarContents(c, []expectedTarContents{
+		{"TarLink", "TarFile1"},
+		{"TarDirLink/TarSubFile1", "TarSubFile1"},
+	}, outputTar, false)
+}
+
+func (t *TarSuite) TestTarFilesDereferenceLoop(c *gc.C) {
+	t.createTestFiles(c)
+	loop := filepath.Join(t.cwd, "TarDirectoryPopulated", "Loop")
+	err := os.Symlink(".", loop)
+	c.Assert(err, gc.IsNil)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithDereference())
+	c.Assert(err, gc.ErrorMatches, `backup failed: symlink loop detected at ".*Loop"`)
+}
+
+func (t *TarSuite) TestTarDirectory(c *gc.C) {
+	t.createTestFiles(c)
+	srcDir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	for _, test := range []struct {
+		opts []Option
+		root string
+	}{
+		{nil, "TarDirectoryPopulated"},
+		{[]Option{WithRootName("juju-backup/")}, "juju-backup"},
+		{[]Option{WithRootName("backups/juju")}, "backups/juju"},
+	} {
+		_, err := TarDirectory(srcDir+"/", outputTar, true, test.opts...)
+		c.Assert(err, gc.IsNil)
+		t.assertTarContents(c, []expectedTarContents{
+			{test.root, ""},
+			{test.root + "/TarSubFile1", "TarSubFile1"},
+			{test.root + "/TarDirectoryPopulatedSubDirectory", ""},
+		}, outputTar, true)
+	}
+}
+
+func (t *TarSuite) TestTarDirectoryInvalidRootName(c *gc.C) {
+	_, err := TarDirectory(t.cwd, filepath.Join(t.cwd, "out.tar"), false, WithRootName("../up"))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithRootName needs a relative name, not "../up"`)
+	_, err = TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false, WithRootName("root"))
+	c.Assert(err, gc.ErrorMatches, `invalid configuration: WithRootName only applies to TarDirectory`)
+}
+
+func (t *TarSuite) TestUntarMissingParentDirectories(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "parents.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"early/file", "before its directory"},
+		{"early/", ""},
+		{"a/b/c/d/deep", "no directory headers"},
+	})
+	outputDir := c.MkDir()
+	var report Report
+	err := UntarFiles(tarFile, outputDir, false, WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "early", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "before its directory")
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "a", "b", "c", "d", "deep"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "no directory headers")
+
+	var names []string
+	for _, e := range report.Entries {
+		names = append(names, e.Name)
+	}
+	c.Assert(names, gc.DeepEquals, []string{
+		"early/", "early/file", "a/", "a/b/", "a/b/c/", "a/b/c/d/", "a/b/c/d/deep",
+	})
+	c.Assert(Rollback(&report), gc.IsNil)
+	entries, err := ioutil.ReadDir(outputDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestUntarSymlinkParent(c *gc.C) {
+	outside := c.MkDir()
+	tarFile := filepath.Join(t.cwd, "escape.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "out", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "out/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := UntarFiles(tarFile, c.MkDir(), false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract "out/pwned": parent ".*out" is a symlink`)
+	_, err = os.Lstat(filepath.Join(outside, "pwned"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (t *TarSuite) TestUntarReplaceSymlink(c *gc.C) {
+	outside := c.MkDir()
+	victim := filepath.Join(outside, "victim")
+	c.Assert(ioutil.WriteFile(victim, []byte("safe"), 0644), gc.IsNil)
+	tarFile := filepath.Join(t.cwd, "replace.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: victim},
+		{Name: "evil", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := UntarFiles(tarFile, c.MkDir(), false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract "evil": it would replace a symlink`)
+	data, err := ioutil.ReadFile(victim)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "safe")
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Target stores archives, so that TarFiles can write them to, and
+// UntarFiles read them from, object stores such as S3, Swift or
+// GridFS without going through temporary files. Multi-volume
+// archives are stored as one object per volume.
+type Target interface {
+	// Create returns a writer storing the named object,
+	// replacing any existing one. The object is complete
+	// once the writer is closed successfully.
+	Create(name string) (io.WriteCloser, error)
+	// Open returns a reader for the named object. If there is
+	// no such object, the error must satisfy os.IsNotExist.
+	Open(name string) (io.ReadCloser, error)
+}
+
+// LocalTarget is a Target storing archives as files in the directory
+// it names, or relative to the current directory if it is empty.
+// It is the Target used when none is given.
+type LocalTarget string
+
+// Create implements Target.
+func (t LocalTarget) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(filepath.Join(string(t), name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open implements Target.
+func (t LocalTarget) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(t), name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WithTarget returns an Option that makes TarFiles store the archive,
+// and UntarFiles read it, through t, the archive path naming the
+// object in t. Unlike for local files, the extra volumes of an
+// earlier multi-volume archive with the same name are not removed.
+func WithTarget(t Target) Option {
+	return func(o *options) {
+		o.target = t
+	}
+}
+
+// storage returns the Target archives are stored in.
+func (o *options) storage() Target {
+	if o.target == nil {
+		return LocalTarget("")
+	}
+	return o.target
+}
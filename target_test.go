@@ -0,0 +1,93 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	gc "launchpad.net/gocheck"
+)
+
+// memTarget is a Target storing objects in memory.
+type memTarget struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (t *memTarget) Create(name string) (io.WriteCloser, error) {
+	return &memObject{target: t, name: name}, nil
+}
+
+func (t *memTarget) Open(name string) (io.ReadCloser, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, ok := t.objects[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (t *memTarget) names() []string {
+	var names []string
+	for name := range t.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memObject struct {
+	bytes.Buffer
+	target *memTarget
+	name   string
+}
+
+func (o *memObject) Close() error {
+	o.target.mu.Lock()
+	defer o.target.mu.Unlock()
+	o.target.objects[o.name] = o.Bytes()
+	return nil
+}
+
+func (t *TarSuite) TestTarget(c *gc.C) {
+	t.createTestFiles(c)
+	target := &memTarget{objects: make(map[string][]byte)}
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	shaSum, err := TarFiles(t.testFiles, "backups/juju.tar.gz", trimPath, true,
+		WithTarget(target), WithVolumeSize(100))
+	c.Assert(err, gc.IsNil)
+	names := target.names()
+	c.Assert(len(names) > 1, gc.Equals, true)
+	c.Assert(names[0], gc.Equals, "backups/juju.tar.gz.000")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gc.IsNil)
+	signature, err := SignArchive(shaSum, Ed25519Signer(priv))
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = VerifyAndUntar("backups/juju.tar.gz", outputDir, true, signature,
+		Ed25519Verifier(pub), WithTarget(target))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestLocalTarget(c *gc.C) {
+	t.createTestFiles(c)
+	dir := c.MkDir()
+	trimPath := fmt.Sprintf("%s/", t.cwd)
+	_, err := TarFiles(t.testFiles, "juju.tar", trimPath, false, WithTarget(LocalTarget(dir)))
+	c.Assert(err, gc.IsNil)
+	outputDir := c.MkDir()
+	err = UntarFiles("juju.tar", outputDir, false, WithTarget(LocalTarget(dir)))
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
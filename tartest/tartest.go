@@ -0,0 +1,214 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package tartest provides helpers to build, check and corrupt
+// archives in the tests of code using github.com/juju/tar.
+package tartest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TestingT is the part of *testing.T and *gocheck.C used by the
+// helpers. The helpers return once Fatalf is called, whether or
+// not it stops the test.
+type TestingT interface {
+	Fatalf(format string, args ...interface{})
+}
+
+// Entry describes an archive entry.
+type Entry struct {
+	// Type is the tar type of the entry. If it is zero, the entry
+	// is a directory when its name ends with a slash, and a regular
+	// file otherwise.
+	Type byte
+	// Contents holds the contents of regular files.
+	Contents string
+	// Mode holds the permission bits of the entry. If it is zero,
+	// 0755 is used for directories and 0644 for other entries when
+	// building archives, and the mode is not checked when asserting.
+	Mode int64
+	// Linkname holds the target of links.
+	Linkname string
+}
+
+// typeOf returns the type of the entry called name.
+func (e Entry) typeOf(name string) byte {
+	switch {
+	case e.Type != 0:
+		return e.Type
+	case strings.HasSuffix(name, "/"):
+		return tar.TypeDir
+	}
+	return tar.TypeReg
+}
+
+// BuildArchive returns an uncompressed tar archive holding
+// the given entries, keyed by name, in name order.
+func BuildArchive(t TestingT, entries map[string]Entry) []byte {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		e := entries[name]
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: e.typeOf(name),
+			Mode:     e.Mode,
+			Linkname: e.Linkname,
+			ModTime:  time.Unix(1400000000, 0),
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Mode = 0755
+			}
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.Contents))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("cannot write header for %q: %v", name, err)
+			return nil
+		}
+		if _, err := io.WriteString(tw, e.Contents[:hdr.Size]); err != nil {
+			t.Fatalf("cannot write %q: %v", name, err)
+			return nil
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("cannot close archive: %v", err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// WriteArchive writes an uncompressed tar archive holding
+// the given entries, as built by BuildArchive, to path.
+func WriteArchive(t TestingT, path string, entries map[string]Entry) {
+	if err := ioutil.WriteFile(path, BuildArchive(t, entries), 0644); err != nil {
+		t.Fatalf("cannot write archive: %v", err)
+	}
+}
+
+// ReadArchive returns the entries of the archive at path,
+// which may be gzip compressed, keyed by name.
+func ReadArchive(t TestingT, path string) map[string]Entry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("cannot open archive: %v", err)
+		return nil
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if r, err = gzip.NewReader(br); err != nil {
+			t.Fatalf("cannot uncompress archive: %v", err)
+			return nil
+		}
+	}
+	entries := make(map[string]Entry)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("cannot read archive: %v", err)
+			return nil
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("cannot read %q: %v", hdr.Name, err)
+			return nil
+		}
+		entries[hdr.Name] = Entry{
+			Type:     hdr.Typeflag,
+			Contents: string(contents),
+			Mode:     hdr.Mode,
+			Linkname: hdr.Linkname,
+		}
+	}
+}
+
+// AssertArchiveContains checks that the archive at path, which may
+// be gzip compressed, holds at least the expected entries, keyed by
+// name. Entry names are compared with any leading "./" and trailing
+// slash removed.
+func AssertArchiveContains(t TestingT, path string, expected map[string]Entry) {
+	entries := ReadArchive(t, path)
+	if entries == nil {
+		return
+	}
+	found := make(map[string]Entry)
+	for name, e := range entries {
+		found[cleanName(name)] = e
+	}
+	var problems []string
+	for name, want := range expected {
+		got, ok := found[cleanName(name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%q: missing", name))
+			continue
+		}
+		if typ := want.typeOf(name); got.Type != typ && !(typ == tar.TypeReg && got.Type == tar.TypeRegA) {
+			problems = append(problems, fmt.Sprintf("%q: type %q, expected %q", name, got.Type, typ))
+		}
+		if got.Contents != want.Contents {
+			problems = append(problems, fmt.Sprintf("%q: contents %q, expected %q", name, got.Contents, want.Contents))
+		}
+		if want.Mode != 0 && got.Mode&07777 != want.Mode {
+			problems = append(problems, fmt.Sprintf("%q: mode %o, expected %o", name, got.Mode&07777, want.Mode))
+		}
+		if got.Linkname != want.Linkname {
+			problems = append(problems, fmt.Sprintf("%q: link to %q, expected %q", name, got.Linkname, want.Linkname))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		t.Fatalf("archive %q does not hold the expected entries:\n%s", path, strings.Join(problems, "\n"))
+	}
+}
+
+// cleanName returns the canonical form of an entry name.
+func cleanName(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+}
+
+// CorruptArchive inverts the byte at offset in the file at path, to
+// check how corrupt archives are handled. A negative offset counts
+// from the end of the file.
+func CorruptArchive(t TestingT, path string, offset int64) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read archive: %v", err)
+		return
+	}
+	if offset < 0 {
+		offset += int64(len(data))
+	}
+	if offset < 0 || offset >= int64(len(data)) {
+		t.Fatalf("offset %d out of range for a %d byte archive", offset, len(data))
+		return
+	}
+	data[offset] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("cannot write archive: %v", err)
+	}
+}
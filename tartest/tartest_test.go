@@ -0,0 +1,109 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tartest_test
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	stdtesting "testing"
+
+	gc "launchpad.net/gocheck"
+
+	jtar "github.com/juju/tar"
+	"github.com/juju/tar/tartest"
+)
+
+func Test(t *stdtesting.T) {
+	gc.TestingT(t)
+}
+
+var _ = gc.Suite(&tartestSuite{})
+
+type tartestSuite struct{}
+
+// recorder is a TestingT recording its failure.
+type recorder struct {
+	failure string
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+var testEntries = map[string]tartest.Entry{
+	"dir/":         {},
+	"dir/file":     {Contents: "contents", Mode: 0600},
+	"dir/link":     {Type: tar.TypeSymlink, Linkname: "file"},
+	"dir/empty":    {},
+	"dir/sub/":     {Mode: 0700},
+	"dir/sub/deep": {Contents: "deep"},
+}
+
+func (s *tartestSuite) TestBuildArchive(c *gc.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "test.tar")
+	tartest.WriteArchive(c, path, testEntries)
+	c.Assert(tartest.ReadArchive(c, path), gc.DeepEquals, map[string]tartest.Entry{
+		"dir/":         {Type: tar.TypeDir, Mode: 0755},
+		"dir/file":     {Type: tar.TypeReg, Contents: "contents", Mode: 0600},
+		"dir/link":     {Type: tar.TypeSymlink, Mode: 0644, Linkname: "file"},
+		"dir/empty":    {Type: tar.TypeReg, Mode: 0644},
+		"dir/sub/":     {Type: tar.TypeDir, Mode: 0700},
+		"dir/sub/deep": {Type: tar.TypeReg, Contents: "deep", Mode: 0644},
+	})
+
+	// Archives built by the helpers can be extracted, and
+	// archives created from the result checked.
+	restore := filepath.Join(dir, "restore")
+	c.Assert(jtar.UntarFiles(path, restore, false), gc.IsNil)
+	archive := filepath.Join(dir, "again.tgz")
+	_, err := jtar.TarFiles([]string{filepath.Join(restore, "dir")}, archive, restore+"/", true)
+	c.Assert(err, gc.IsNil)
+	tartest.AssertArchiveContains(c, archive, testEntries)
+}
+
+func (s *tartestSuite) TestAssertArchiveContainsFails(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "test.tar")
+	tartest.WriteArchive(c, path, testEntries)
+	var r recorder
+	tartest.AssertArchiveContains(&r, path, map[string]tartest.Entry{
+		"./dir/file": {Contents: "other"},
+		"dir/sub":    {Mode: 0755},
+		"missing":    {},
+	})
+	c.Assert(r.failure, gc.Equals, fmt.Sprintf(`archive %q does not hold the expected entries:
+"./dir/file": contents "contents", expected "other"
+"dir/sub": mode 700, expected 755
+"dir/sub": type '5', expected '0'
+"missing": missing`, path))
+}
+
+func (s *tartestSuite) TestCorruptArchive(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "test.tar")
+	tartest.WriteArchive(c, path, testEntries)
+	before, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	tartest.CorruptArchive(c, path, 150)
+	problems, err := jtar.ValidateArchive(openFile(c, path))
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, gc.Not(gc.HasLen), 0)
+
+	tartest.CorruptArchive(c, path, 150-int64(len(before)))
+	after, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(after, gc.DeepEquals, before)
+
+	var r recorder
+	tartest.CorruptArchive(&r, path, int64(len(before)))
+	c.Assert(r.failure, gc.Equals, fmt.Sprintf("offset %d out of range for a %d byte archive", len(before), len(before)))
+}
+
+func openFile(c *gc.C, path string) *os.File {
+	f, err := os.Open(path)
+	c.Assert(err, gc.IsNil)
+	return f
+}
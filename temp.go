@@ -0,0 +1,127 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tempRoot starts the name of the directory, below the chosen
+// temporary directory, that holds the private directories of
+// every run of the package by the current user.
+const tempRoot = "juju-tar"
+
+// runPrefix starts the name of every run directory; it is followed
+// by the pid of the owning process so stale directories can be told
+// apart from those of concurrent runs.
+const runPrefix = "run-"
+
+// runDir is the private temporary directory of a single TarFiles or
+// UntarFiles call. All temporary files, staging directories and
+// journals of the call live inside it, so concurrent runs on the same
+// host never see each other's files. The directory is only created
+// when first needed.
+type runDir struct {
+	base string
+	path string
+}
+
+// newRunDir returns a runDir that will be created below base, or
+// below the system temporary directory if base is empty.
+func newRunDir(base string) *runDir {
+	if base == "" {
+		base = os.TempDir()
+	}
+	return &runDir{base: base}
+}
+
+// dir returns the path of the run directory, creating it if needed.
+// Directories left behind by runs that crashed are removed the first
+// time a new run directory is created.
+func (d *runDir) dir() (string, error) {
+	if d.path != "" {
+		return d.path, nil
+	}
+	root := userTempRoot(d.base)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", fmt.Errorf("cannot create temporary directory: %v", err)
+	}
+	if err := checkTempRoot(root); err != nil {
+		return "", fmt.Errorf("cannot use temporary directory: %v", err)
+	}
+	removeStaleRunDirs(root)
+	path, err := ioutil.TempDir(root, fmt.Sprintf("%s%d-", runPrefix, os.Getpid()))
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary directory: %v", err)
+	}
+	d.path = path
+	return path, nil
+}
+
+// tempFile creates a new temporary file inside the run directory.
+func (d *runDir) tempFile(prefix string) (*os.File, error) {
+	dir, err := d.dir()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, prefix)
+}
+
+// tempDir creates a new temporary directory inside the run directory.
+func (d *runDir) tempDir(prefix string) (string, error) {
+	dir, err := d.dir()
+	if err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(dir, prefix)
+}
+
+// remove deletes the run directory and everything in it.
+func (d *runDir) remove() error {
+	if d.path == "" {
+		return nil
+	}
+	err := os.RemoveAll(d.path)
+	d.path = ""
+	return err
+}
+
+// removeStaleRunDirs removes the run directories in root whose owning
+// process is no longer running. Errors are ignored, as a directory
+// that cannot be removed now will be retried by the next run.
+func removeStaleRunDirs(root string) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pid, ok := runDirPid(entry.Name())
+		if !ok || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(root, entry.Name()))
+	}
+}
+
+// runDirPid returns the pid encoded in the given run directory name.
+func runDirPid(name string) (int, bool) {
+	if !strings.HasPrefix(name, runPrefix) {
+		return 0, false
+	}
+	name = strings.TrimPrefix(name, runPrefix)
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(name[:i])
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
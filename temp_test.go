@@ -0,0 +1,83 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestRunDirIsPrivateAndRemoved(c *gc.C) {
+	d1 := newRunDir(t.cwd)
+	d2 := newRunDir(t.cwd)
+	f1, err := d1.tempFile("x")
+	c.Assert(err, gc.IsNil)
+	f1.Close()
+	f2, err := d2.tempFile("x")
+	c.Assert(err, gc.IsNil)
+	f2.Close()
+	c.Assert(filepath.Dir(f1.Name()), gc.Not(gc.Equals), filepath.Dir(f2.Name()))
+	c.Assert(strings.HasPrefix(f1.Name(), userTempRoot(t.cwd)), gc.Equals, true)
+
+	c.Assert(d1.remove(), gc.IsNil)
+	_, err = os.Stat(filepath.Dir(f1.Name()))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(f2.Name())
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestRunDirRemovesStaleDirs(c *gc.C) {
+	root := userTempRoot(t.cwd)
+	// A pid that cannot belong to a running process.
+	stale := filepath.Join(root, runPrefix+"2147483647-123")
+	err := os.MkdirAll(stale, 0700)
+	c.Assert(err, gc.IsNil)
+	live := filepath.Join(root, runPrefix+"1-123")
+	err = os.MkdirAll(live, 0700)
+	c.Assert(err, gc.IsNil)
+	other := filepath.Join(root, "unrelated")
+	err = os.MkdirAll(other, 0700)
+	c.Assert(err, gc.IsNil)
+
+	d := newRunDir(t.cwd)
+	defer d.remove()
+	_, err = d.dir()
+	c.Assert(err, gc.IsNil)
+
+	_, err = os.Stat(stale)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(live)
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(other)
+	c.Assert(err, gc.IsNil)
+}
+
+func (t *TarSuite) TestTarFilesLeavesNoTempFiles(c *gc.C) {
+	t.createTestFiles(c)
+	tmpDir := c.MkDir()
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false,
+		WithContentFilter(redactFilter), WithTempDir(tmpDir))
+	c.Assert(err, gc.IsNil)
+	entries, err := ioutil.ReadDir(userTempRoot(tmpDir))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestRunDirRefusesSharedRoot(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("the temporary directory of each user is private on Windows")
+	}
+	root := userTempRoot(t.cwd)
+	c.Assert(os.MkdirAll(root, 0700), gc.IsNil)
+	c.Assert(os.Chmod(root, 0777), gc.IsNil)
+	d := newRunDir(t.cwd)
+	_, err := d.dir()
+	c.Assert(err, gc.ErrorMatches, `cannot use temporary directory: ".*" has mode -rwxrwxrwx`)
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WithPreserveTimes returns an Option that makes UntarFiles restore
+// the modification and access times of extracted files and
+// directories. As extracting entries below a directory changes its
+// modification time, the times of directories are only restored once
+// everything else is extracted, deepest directories first. The times
+// of symlinks and hard links are not restored.
+func WithPreserveTimes() Option {
+	return func(o *options) {
+		o.preserveTimes = true
+	}
+}
+
+// deferredTimes holds the times to give an extracted
+// directory once everything below it has been extracted.
+type deferredTimes struct {
+	path         string
+	name         string
+	atime, mtime time.Time
+}
+
+// entryTimes returns the access and modification
+// times to restore for the entry described by hdr.
+func entryTimes(hdr *tar.Header) (atime, mtime time.Time) {
+	atime = hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	return atime, hdr.ModTime
+}
+
+// restoreTimes restores the times of the regular file extracted
+// at fullPath from the entry described by hdr, or defers it if the
+// entry is a directory, as requested by WithPreserveTimes.
+func (x *extractor) restoreTimes(fullPath string, hdr *tar.Header) {
+	if !x.opts.preserveTimes || hdr.ModTime.IsZero() {
+		return
+	}
+	atime, mtime := entryTimes(hdr)
+	if hdr.Typeflag == tar.TypeDir {
+		x.dirTimes = append(x.dirTimes, deferredTimes{
+			path:  fullPath,
+			name:  hdr.Name,
+			atime: atime,
+			mtime: mtime,
+		})
+		return
+	}
+	x.setTimes(fullPath, hdr.Name, atime, mtime)
+}
+
+// setTimes sets the times of the file at fullPath, extracted
+// from the entry called name, reporting any failure.
+func (x *extractor) setTimes(fullPath, name string, atime, mtime time.Time) {
+	if err := x.chtimes(fullPath, atime, mtime); err != nil {
+		x.opts.report.degrade(Degradation{
+			Kind:    DegradationTimes,
+			Path:    name,
+			Message: fmt.Sprintf("cannot restore times: %v", err),
+		})
+	}
+}
+
+// applyDirTimes sets the times recorded by restoreTimes,
+// deepest directories first, after which nothing is
+// extracted below them anymore.
+func (x *extractor) applyDirTimes() {
+	depth := func(p string) int {
+		return strings.Count(p, string(filepath.Separator))
+	}
+	sort.SliceStable(x.dirTimes, func(i, j int) bool {
+		return depth(x.dirTimes[i].path) > depth(x.dirTimes[j].path)
+	})
+	for _, d := range x.dirTimes {
+		x.setTimes(d.path, d.name, d.atime, d.mtime)
+	}
+	x.dirTimes = nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+var (
+	dirTime  = time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	fileTime = time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+// writeTimesArchive writes an archive holding a directory and
+// files below it with old modification times to tarFile.
+func writeTimesArchive(c *gc.C, tarFile string) {
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: dirTime},
+		{Name: "dir/sub/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: dirTime},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644, ModTime: fileTime},
+		{Name: "dir/sub/file", Typeflag: tar.TypeReg, Mode: 0644, ModTime: fileTime},
+	})
+}
+
+// assertModTime checks the modification time of the file at path.
+func assertModTime(c *gc.C, path string, mtime time.Time) {
+	info, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+	c.Check(info.ModTime().Equal(mtime), gc.Equals, true, gc.Commentf("%s: %v", path, info.ModTime()))
+}
+
+func (t *TarSuite) TestUntarFilesPreserveTimes(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "times.tar")
+	writeTimesArchive(c, tarFile)
+	for _, opts := range [][]Option{
+		{WithPreserveTimes()},
+		{WithPreserveTimes(), WithModePolicy(ModePolicy{DirMode: 0500})},
+	} {
+		outputDir := c.MkDir()
+		err := UntarFiles(tarFile, outputDir, false, opts...)
+		c.Assert(err, gc.IsNil)
+		assertModTime(c, filepath.Join(outputDir, "dir"), dirTime)
+		assertModTime(c, filepath.Join(outputDir, "dir", "sub"), dirTime)
+		assertModTime(c, filepath.Join(outputDir, "dir", "file"), fileTime)
+		assertModTime(c, filepath.Join(outputDir, "dir", "sub", "file"), fileTime)
+		os.Chmod(filepath.Join(outputDir, "dir", "sub"), 0755)
+		os.Chmod(filepath.Join(outputDir, "dir"), 0755)
+	}
+}
+
+func (t *TarSuite) TestUntarFilesPreserveTimesSecure(c *gc.C) {
+	if !secureExtraction {
+		c.Skip("secure extraction not supported")
+	}
+	tarFile := filepath.Join(c.MkDir(), "times.tar")
+	writeTimesArchive(c, tarFile)
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false, WithPreserveTimes(), WithSecureExtraction())
+	c.Assert(err, gc.IsNil)
+	assertModTime(c, filepath.Join(outputDir, "dir"), dirTime)
+	assertModTime(c, filepath.Join(outputDir, "dir", "sub", "file"), fileTime)
+}
+
+func (t *TarSuite) TestUntarFilesWithoutPreserveTimes(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "times.tar")
+	writeTimesArchive(c, tarFile)
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(filepath.Join(outputDir, "dir", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ModTime().After(fileTime), gc.Equals, true)
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TypeConflictPolicy decides what UntarFiles does with an entry
+// whose path exists as a directory when the entry is not one, or
+// exists as something else when the entry is a directory.
+type TypeConflictPolicy int
+
+const (
+	// TypeConflictError fails the extraction with a
+	// *TypeChangeError. This is the default.
+	TypeConflictError TypeConflictPolicy = iota
+	// TypeConflictReplace removes what exists at the path, moving
+	// it below the backup directory WithBackupDir, and extracts
+	// the entry in its place.
+	TypeConflictReplace
+	// TypeConflictSkip leaves what exists at the path
+	// and does not extract the entry.
+	TypeConflictSkip
+)
+
+// WithTypeConflicts returns an Option that makes UntarFiles
+// handle entries that change the type of an existing path
+// as described by p.
+func WithTypeConflicts(p TypeConflictPolicy) Option {
+	return func(o *options) {
+		o.typeConflicts = p
+	}
+}
+
+// TypeChangeError is returned by UntarFiles when an entry would change
+// the type of an existing path and the policy is TypeConflictError.
+type TypeChangeError struct {
+	// Name is the name of the entry.
+	Name string
+	// Path is the existing path.
+	Path string
+	// Existing is the type of what exists at the path.
+	Existing string
+	// Entry is the type of the entry.
+	Entry string
+}
+
+func (e *TypeChangeError) Error() string {
+	return fmt.Sprintf("cannot extract %s %q over existing %s %q", e.Entry, e.Name, e.Existing, e.Path)
+}
+
+// validate appends to problems any problem with the policy.
+func (p TypeConflictPolicy) validate(problems []string) []string {
+	if p < TypeConflictError || p > TypeConflictSkip {
+		problems = append(problems, fmt.Sprintf("unknown type conflict policy %d", p))
+	}
+	return problems
+}
+
+// typeConflict handles a change of the type of what exists at
+// fullPath by the entry with the given header, extracted under the
+// given output name. It reports whether the entry must be extracted
+// and, when something was replaced, where it was backed up to.
+func (x *extractor) typeConflict(fullPath, name string, hdr *tar.Header) (replaced bool, backup string, ok bool, err error) {
+	isDir := hdr.Typeflag == tar.TypeDir
+	var info os.FileInfo
+	if isDir {
+		// Symlinks to directories can be extracted through.
+		info, err = os.Stat(fullPath)
+	} else {
+		info, err = os.Lstat(fullPath)
+	}
+	if err != nil || info.IsDir() == isDir {
+		return false, "", true, nil
+	}
+	switch x.opts.typeConflicts {
+	case TypeConflictSkip:
+		x.opts.log().Debugf("skipping %q: type conflict", hdr.Name)
+		return false, "", false, nil
+	case TypeConflictReplace:
+		backup, err := x.backupAny(fullPath, name)
+		if err != nil {
+			return false, "", false, err
+		}
+		if backup == "" {
+			if err := x.removeAll(fullPath); err != nil {
+				return false, "", false, fmt.Errorf("cannot remove %q: %v", fullPath, err)
+			}
+		}
+		return true, backup, true, nil
+	}
+	existing := "file"
+	if info.IsDir() {
+		existing = "dir"
+	} else if lInfo, err := os.Lstat(fullPath); err == nil && lInfo.Mode()&os.ModeSymlink != 0 {
+		existing = "symlink"
+	}
+	return false, "", false, &TypeChangeError{
+		Name:     hdr.Name,
+		Path:     fullPath,
+		Existing: existing,
+		Entry:    entryType(hdr),
+	}
+}
+
+// backupAny moves whatever exists at fullPath, extracted under the
+// given output name, into the backup directory, and returns the path
+// it was moved to. It returns "" if there is no backup directory.
+func (x *extractor) backupAny(fullPath, name string) (string, error) {
+	if x.opts.backupDir == "" {
+		return "", nil
+	}
+	backupPath := filepath.Join(x.opts.backupDir, filepath.FromSlash(name))
+	// The backup directory is outside of the output
+	// folder, so it is created by path.
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return "", fmt.Errorf("cannot back up %q: %v", fullPath, err)
+	}
+	if err := x.renameOut(fullPath, backupPath); err != nil {
+		return "", fmt.Errorf("cannot back up %q: %v", fullPath, err)
+	}
+	return backupPath, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTypeConflicts(c *gc.C) {
+	tarFile := filepath.Join(t.cwd, "types.tar")
+	writeContentsArchive(c, tarFile, []testEntry{
+		{"data", "now a file"},
+		{"logs/", ""},
+		{"logs/current", "log line"},
+	})
+	setUp := func(c *gc.C) string {
+		outputDir := c.MkDir()
+		c.Assert(os.MkdirAll(filepath.Join(outputDir, "data", "old"), 0755), gc.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(outputDir, "logs"), []byte("was a file"), 0644), gc.IsNil)
+		return outputDir
+	}
+
+	outputDir := setUp(c)
+	err := UntarFiles(tarFile, outputDir, false)
+	c.Assert(err, gc.FitsTypeOf, &TypeChangeError{})
+	c.Assert(err, gc.ErrorMatches, `cannot extract file "data" over existing dir ".*data"`)
+
+	outputDir = setUp(c)
+	err = UntarFiles(tarFile, outputDir, false, WithTypeConflicts(TypeConflictSkip))
+	c.Assert(err, gc.IsNil)
+	info, err := os.Stat(filepath.Join(outputDir, "data", "old"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.IsDir(), gc.Equals, true)
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, "logs"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "was a file")
+
+	outputDir = setUp(c)
+	backupDir := c.MkDir()
+	var report Report
+	err = UntarFiles(tarFile, outputDir, false, WithTypeConflicts(TypeConflictReplace), WithBackupDir(backupDir), WithReport(&report))
+	c.Assert(err, gc.IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "data"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "now a file")
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "logs", "current"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "log line")
+	_, err = os.Stat(filepath.Join(backupDir, "data", "old"))
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(Rollback(&report), gc.IsNil)
+	info, err = os.Stat(filepath.Join(outputDir, "data", "old"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.IsDir(), gc.Equals, true)
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "logs"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "was a file")
+
+	// Files in the way of implied directories are replaced too.
+	implied := filepath.Join(t.cwd, "implied.tar")
+	writeContentsArchive(c, implied, []testEntry{{"logs/current", "log line"}})
+	outputDir = setUp(c)
+	err = UntarFiles(implied, outputDir, false)
+	c.Assert(err, gc.ErrorMatches, `cannot extract dir "logs/" over existing file ".*logs"`)
+	err = UntarFiles(implied, outputDir, false, WithTypeConflicts(TypeConflictReplace))
+	c.Assert(err, gc.IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(outputDir, "logs", "current"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "log line")
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EntryTypes is a set of entry types, used
+// to select the entries archived or extracted.
+type EntryTypes uint
+
+const (
+	// EntryFile holds regular files.
+	EntryFile EntryTypes = 1 << iota
+	// EntryDir holds directories.
+	EntryDir
+	// EntrySymlink holds symbolic links.
+	EntrySymlink
+	// EntryHardLink holds hard links.
+	EntryHardLink
+	// EntryCharDevice holds character devices.
+	EntryCharDevice
+	// EntryBlockDevice holds block devices.
+	EntryBlockDevice
+	// EntryFifo holds named pipes.
+	EntryFifo
+	// EntrySocket holds Unix domain sockets,
+	// which cannot be archived.
+	EntrySocket
+
+	// EntryDevices holds character and block devices.
+	EntryDevices = EntryCharDevice | EntryBlockDevice
+	// EntrySpecial holds devices, named pipes and sockets.
+	EntrySpecial = EntryDevices | EntryFifo | EntrySocket
+
+	allEntryTypes = EntrySocket<<1 - 1
+)
+
+var entryTypeNames = []string{
+	"file", "dir", "symlink", "hardlink",
+	"chardev", "blockdev", "fifo", "socket",
+}
+
+func (t EntryTypes) String() string {
+	var names []string
+	for i, name := range entryTypeNames {
+		if t&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := t &^ allEntryTypes; rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint(rest)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// WithTypes returns an Option that makes TarFiles and UntarFiles
+// only archive or extract the entries of the types in t, such as
+// only regular files, or only directories to produce a skeleton of a
+// tree. The directories below which selected entries are found are
+// still walked when archiving, and created when extracting.
+func WithTypes(t EntryTypes) Option {
+	return func(o *options) {
+		o.includeTypes = t
+	}
+}
+
+// WithoutTypes returns an Option that makes TarFiles and UntarFiles
+// leave out the entries of the types in t, such as devices and
+// sockets when producing sanitized archives for support bundles.
+func WithoutTypes(t EntryTypes) Option {
+	return func(o *options) {
+		o.excludeTypes = t
+	}
+}
+
+// validateTypes appends to problems the reasons why
+// the entry types selected by o cannot be used.
+func (o *options) validateTypes(problems []string) []string {
+	if (o.includeTypes|o.excludeTypes)&^allEntryTypes != 0 {
+		problems = append(problems, fmt.Sprintf("unknown entry types %v", (o.includeTypes|o.excludeTypes)&^allEntryTypes))
+	}
+	return problems
+}
+
+// selectsType reports whether entries of type t
+// are archived or extracted.
+func (o *options) selectsType(t EntryTypes) bool {
+	return (o.includeTypes == 0 || o.includeTypes&t != 0) && o.excludeTypes&t == 0
+}
+
+// headerEntryType returns the type of the entry described by hdr.
+func headerEntryType(hdr *tar.Header) EntryTypes {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return EntryDir
+	case tar.TypeSymlink:
+		return EntrySymlink
+	case tar.TypeLink:
+		return EntryHardLink
+	case tar.TypeChar:
+		return EntryCharDevice
+	case tar.TypeBlock:
+		return EntryBlockDevice
+	case tar.TypeFifo:
+		return EntryFifo
+	}
+	return EntryFile
+}
+
+// fileEntryType returns the type of the entry
+// archiving a file with the given mode.
+func fileEntryType(mode os.FileMode) EntryTypes {
+	switch {
+	case mode.IsDir():
+		return EntryDir
+	case mode&os.ModeSymlink != 0:
+		return EntrySymlink
+	case mode&os.ModeCharDevice != 0:
+		return EntryCharDevice
+	case mode&os.ModeDevice != 0:
+		return EntryBlockDevice
+	case mode&os.ModeNamedPipe != 0:
+		return EntryFifo
+	case mode&os.ModeSocket != 0:
+		return EntrySocket
+	}
+	return EntryFile
+}
@@ -0,0 +1,89 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesWithTypes(c *gc.C) {
+	t.createSymlinks(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(append(t.testFiles, filepath.Join(t.cwd, "TarLink")), outputTar, t.cwd+"/", false, WithTypes(EntryFile))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 3)
+	c.Assert(headers["TarFile1"], gc.NotNil)
+	c.Assert(headers["TarFile2"], gc.NotNil)
+	c.Assert(headers["TarDirectoryPopulated/TarSubFile1"], gc.NotNil)
+
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithTypes(EntryDir))
+	c.Assert(err, gc.IsNil)
+	headers = readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 3)
+	c.Assert(headers["TarDirectoryEmpty"], gc.NotNil)
+	c.Assert(headers["TarDirectoryPopulated"], gc.NotNil)
+	c.Assert(headers["TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory"], gc.NotNil)
+}
+
+func (t *TarSuite) TestTarFilesWithoutSockets(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("no unix sockets")
+	}
+	dir := c.MkDir()
+	l, err := net.Listen("unix", filepath.Join(dir, "app.sock"))
+	c.Assert(err, gc.IsNil)
+	defer l.Close()
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err = TarFiles([]string{dir}, outputTar, dir, false)
+	c.Assert(err, gc.ErrorMatches, "backup failed: .*app.sock.*")
+	_, err = TarFiles([]string{dir}, outputTar, dir, false, WithoutTypes(EntrySpecial))
+	c.Assert(err, gc.IsNil)
+	headers := readHeaders(c, outputTar)
+	c.Assert(headers, gc.HasLen, 1)
+}
+
+func (t *TarSuite) TestUntarFilesWithoutTypes(c *gc.C) {
+	tarFile := filepath.Join(c.MkDir(), "types.tar")
+	writeTestArchive(c, tarFile, []*tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+		{Name: "dir/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+	})
+	outputDir := c.MkDir()
+	err := UntarFiles(tarFile, outputDir, false, WithoutTypes(EntryDevices|EntrySymlink))
+	c.Assert(err, gc.IsNil)
+	for name, exists := range map[string]bool{"dir": true, "dir/file": true, "dir/link": false, "dir/null": false} {
+		_, err := os.Lstat(filepath.Join(outputDir, name))
+		c.Check(err == nil, gc.Equals, exists, gc.Commentf("%s", name))
+	}
+}
+
+func (t *TarSuite) TestWriterWithTypes(c *gc.C) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, false, WithoutTypes(EntryDevices))
+	c.Assert(err, gc.IsNil)
+	c.Assert(w.WriteEntry(&tar.Header{Name: "null", Typeflag: tar.TypeChar, Mode: 0666}, nil), gc.IsNil)
+	c.Assert(w.WriteEntry(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}, nil), gc.IsNil)
+	c.Assert(w.Close(), gc.IsNil)
+	files, err := ExtractToMap(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.DeepEquals, map[string][]byte{"file": {}})
+}
+
+func (t *TarSuite) TestEntryTypesString(c *gc.C) {
+	c.Assert(EntryDevices.String(), gc.Equals, "chardev|blockdev")
+	c.Assert(EntryTypes(0).String(), gc.Equals, "none")
+	c.Assert((EntryFile | 1<<10).String(), gc.Equals, "file|0x400")
+	_, err := TarFiles([]string{"x"}, filepath.Join(t.cwd, "out.tar"), "", false, WithTypes(1<<10))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: unknown entry types 0x400")
+}
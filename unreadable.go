@@ -0,0 +1,124 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// UnreadablePolicy decides what TarFiles does with files that
+// disappear or cannot be read while they are archived, as happens
+// when backing up live systems.
+type UnreadablePolicy struct {
+	// Retries is the number of times a file that cannot
+	// be read is tried again before giving up on it.
+	Retries int
+	// Skip leaves out the files given up on, listing them in
+	// CreateReport.Skipped, instead of failing the archive.
+	Skip bool
+}
+
+var (
+	// UnreadableError fails the archive creation on the first
+	// file that cannot be read. This is the default.
+	UnreadableError = UnreadablePolicy{}
+	// UnreadableSkip leaves out the files that cannot be read.
+	UnreadableSkip = UnreadablePolicy{Skip: true}
+)
+
+// UnreadableRetry returns a policy trying to read files n more
+// times before failing the archive creation. Its Skip field can
+// be set to leave the files out instead.
+func UnreadableRetry(n int) UnreadablePolicy {
+	return UnreadablePolicy{Retries: n}
+}
+
+// unreadableRetryDelay is the time waited
+// before trying to read a file again.
+var unreadableRetryDelay = 100 * time.Millisecond
+
+// WithUnreadable returns an Option that makes TarFiles handle files
+// that cannot be read as described by p. Files listed in the file
+// list that do not exist count as unreadable.
+func WithUnreadable(p UnreadablePolicy) Option {
+	return func(o *options) {
+		o.unreadable = p
+	}
+}
+
+// validate appends to problems the reasons
+// why p cannot be used.
+func (p UnreadablePolicy) validate(problems []string) []string {
+	if p.Retries < 0 {
+		problems = append(problems, "WithUnreadable needs a non-negative number of retries")
+	}
+	return problems
+}
+
+// readFile calls read until it succeeds or the unreadable policy
+// gives up on fileName, and reports whether the file must be skipped.
+func (a *archiver) readFile(fileName string, read func() error) (skip bool, err error) {
+	p := a.opts.unreadable
+	for i := 0; ; i++ {
+		if err = read(); err == nil {
+			return false, nil
+		}
+		if i >= p.Retries {
+			break
+		}
+		a.opts.log().Debugf("retrying %q: %v", fileName, err)
+		time.Sleep(unreadableRetryDelay)
+	}
+	if !p.Skip {
+		return false, err
+	}
+	a.skipUnreadable(fileName, err)
+	return true, nil
+}
+
+// skipUnreadable records that fileName was
+// left out because of err.
+func (a *archiver) skipUnreadable(fileName string, err error) {
+	a.opts.log().Warnf("skipping unreadable %q: %v", fileName, err)
+	a.opts.createReport.skipped(SkippedFile{
+		Path:   fileName,
+		Reason: err.Error(),
+	})
+}
+
+// openFile opens fileName for archiving, returning its info and,
+// unless it is a symlink that is not followed, the open file.
+func (a *archiver) openFile(fileName string) (f io.ReadCloser, fInfo os.FileInfo, skip bool, err error) {
+	skip, err = a.readFile(fileName, func() error {
+		var err error
+		if fsys := a.opts.readFS; fsys != nil {
+			f, fInfo, err = openFS(fsys, fileName)
+			return err
+		}
+		if fInfo, err = os.Lstat(fileName); err != nil {
+			return err
+		}
+		if fInfo.Mode()&os.ModeSymlink != 0 && !a.opts.dereference {
+			return nil
+		}
+		if t := fileEntryType(fInfo.Mode()); t != EntryDir && t != EntrySymlink && !a.opts.selectsType(t) {
+			// Sockets and fifos cannot always be opened, and
+			// skipUnselected leaves the file out anyway.
+			return nil
+		}
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		if fInfo, err = file.Stat(); err != nil {
+			file.Close()
+			return err
+		}
+		f = file
+		return nil
+	})
+	return f, fInfo, skip, err
+}
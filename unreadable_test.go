@@ -0,0 +1,73 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesUnreadableError(c *gc.C) {
+	t.createTestFiles(c)
+	missing := filepath.Join(t.cwd, "missing")
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	_, err := TarFiles(append(t.testFiles, missing), outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.ErrorMatches, "backup failed: .*missing: no such file or directory")
+}
+
+func (t *TarSuite) TestTarFilesUnreadableSkip(c *gc.C) {
+	t.createTestFiles(c)
+	missing := filepath.Join(t.cwd, "missing")
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	var report CreateReport
+	_, err := TarFiles(append(t.testFiles, missing), outputTar, t.cwd+"/", false,
+		WithUnreadable(UnreadableSkip), WithCreateReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Skipped, gc.HasLen, 1)
+	c.Assert(report.Skipped[0].Path, gc.Equals, missing)
+	c.Assert(report.Skipped[0].Reason, gc.Matches, ".*no such file or directory")
+	t.assertTarContents(c, testExpectedTarContents, outputTar, false)
+}
+
+func (t *TarSuite) TestTarFilesUnreadableRetry(c *gc.C) {
+	t.PatchValue(&unreadableRetryDelay, 50*time.Millisecond)
+	t.createTestFiles(c)
+	late := filepath.Join(t.cwd, "late")
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	go func() {
+		time.Sleep(60 * time.Millisecond)
+		ioutil.WriteFile(late, []byte("late"), 0644)
+	}()
+	_, err := TarFiles([]string{late}, outputTar, t.cwd+"/", false, WithUnreadable(UnreadableRetry(20)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(readHeaders(c, outputTar)["late"], gc.NotNil)
+}
+
+func (t *TarSuite) TestTarFilesUnreadableDirectory(c *gc.C) {
+	if os.Getuid() == 0 {
+		c.Skip("root can read any directory")
+	}
+	t.createTestFiles(c)
+	dir := filepath.Join(t.cwd, "TarDirectoryPopulated")
+	c.Assert(os.Chmod(dir, 0), gc.IsNil)
+	defer os.Chmod(dir, 0755)
+	outputTar := filepath.Join(c.MkDir(), "output.tar")
+	var report CreateReport
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false,
+		WithUnreadable(UnreadableSkip), WithCreateReport(&report))
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Skipped, gc.HasLen, 1)
+	c.Assert(report.Skipped[0].Path, gc.Equals, dir)
+}
+
+func (t *TarSuite) TestWithUnreadableInvalid(c *gc.C) {
+	_, err := TarFiles(nil, "x.tar", "", false, WithUnreadable(UnreadableRetry(-1)))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithUnreadable needs a non-negative number of retries")
+	err = UntarFiles("x.tar", c.MkDir(), false, WithUnreadable(UnreadableSkip), WithCreateReport(&CreateReport{}))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithCreateReport only applies to archive creation; WithUnreadable only applies to archive creation")
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// FindingKind identifies the kind of a Finding.
+type FindingKind string
+
+const (
+	// FindingAbsolutePath is reported for entries with absolute names.
+	FindingAbsolutePath FindingKind = "absolute-path"
+	// FindingPathTraversal is reported for entries whose names
+	// contain ".." components.
+	FindingPathTraversal FindingKind = "path-traversal"
+	// FindingSetuid is reported for setuid or setgid files
+	// outside the usual binary directories.
+	FindingSetuid FindingKind = "setuid"
+	// FindingManyDevices is reported once when an archive
+	// holds an unusually large number of device nodes.
+	FindingManyDevices FindingKind = "many-devices"
+)
+
+// maxDeviceNodes is the number of device nodes above
+// which FindingManyDevices is reported.
+const maxDeviceNodes = 1000
+
+// setuidDirs holds the directories where setuid
+// and setgid files are expected.
+var setuidDirs = []string{
+	"bin/",
+	"sbin/",
+	"usr/bin/",
+	"usr/sbin/",
+	"usr/lib/",
+	"usr/libexec/",
+	"usr/local/bin/",
+	"usr/local/sbin/",
+}
+
+// Finding describes a suspicious entry found by Verify.
+type Finding struct {
+	Kind FindingKind
+	// Path is the name of the entry, empty for
+	// findings about the archive as a whole.
+	Path    string
+	Message string
+}
+
+// VerifyResult holds the outcome of Verify.
+type VerifyResult struct {
+	// TypeCounts holds the number of entries of each type
+	// ("file", "dir", "symlink", "hardlink", "chardev",
+	// "blockdev", "fifo" or "other").
+	TypeCounts map[string]int
+	// Findings holds the suspicious patterns found.
+	Findings []Finding
+}
+
+// Verify reads the whole of tarFile, which may be gzip compressed,
+// returning an error if it is not a readable archive. It also audits
+// the entries, returning statistics about their types and findings
+// about suspicious patterns such as absolute names or unexpected
+// setuid files.
+func Verify(tarFile string) (*VerifyResult, error) {
+	tr, f, err := openArchive(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := &VerifyResult{
+		TypeCounts: make(map[string]int),
+	}
+	devices := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return nil, fmt.Errorf("failed while reading tar contents: %v", err)
+		}
+		typ := entryType(hdr)
+		result.TypeCounts[typ]++
+		if typ == "chardev" || typ == "blockdev" {
+			devices++
+		}
+		result.Findings = append(result.Findings, auditEntry(hdr)...)
+	}
+	if devices > maxDeviceNodes {
+		result.Findings = append(result.Findings, Finding{
+			Kind:    FindingManyDevices,
+			Message: fmt.Sprintf("archive holds %d device nodes", devices),
+		})
+	}
+	return result, nil
+}
+
+// auditEntry returns the findings about a single entry.
+func auditEntry(hdr *tar.Header) []Finding {
+	var findings []Finding
+	add := func(kind FindingKind, format string, args ...interface{}) {
+		findings = append(findings, Finding{
+			Kind:    kind,
+			Path:    hdr.Name,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	if path.IsAbs(hdr.Name) {
+		add(FindingAbsolutePath, "entry has an absolute name")
+	}
+	if hasParentRef(hdr.Name) {
+		add(FindingPathTraversal, "entry name refers to a parent directory")
+	}
+	if hdr.Mode&(cISUID|cISGID) != 0 && hdr.FileInfo().Mode().IsRegular() {
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		expected := false
+		for _, dir := range setuidDirs {
+			if strings.HasPrefix(name, dir) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			add(FindingSetuid, "setuid or setgid file outside the usual binary directories")
+		}
+	}
+	return findings
+}
+
+// Mode bits of tar headers, as defined by POSIX.
+const (
+	cISUID = 04000
+	cISGID = 02000
+)
@@ -0,0 +1,79 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+// writeTestArchive writes an uncompressed archive
+// holding the given headers, with empty contents.
+func writeTestArchive(c *gc.C, tarFile string, headers []*tar.Header) {
+	f, err := os.Create(tarFile)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, hdr := range headers {
+		err := tw.WriteHeader(hdr)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(tw.Close(), gc.IsNil)
+}
+
+func (t *TarSuite) TestVerifyClean(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true)
+	c.Assert(err, gc.IsNil)
+	result, err := Verify(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.TypeCounts, gc.DeepEquals, map[string]int{"file": 3, "dir": 3})
+	c.Assert(result.Findings, gc.HasLen, 0)
+}
+
+func (t *TarSuite) TestVerifyFindings(c *gc.C) {
+	headers := []*tar.Header{
+		{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a/../../escape", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/bin/sudo", Typeflag: tar.TypeReg, Mode: 04755},
+		{Name: "home/user/sneaky", Typeflag: tar.TypeReg, Mode: 04755},
+	}
+	for i := 0; i <= maxDeviceNodes; i++ {
+		headers = append(headers, &tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666})
+	}
+	outputTar := filepath.Join(t.cwd, "suspicious.tar")
+	writeTestArchive(c, outputTar, headers)
+
+	result, err := Verify(outputTar)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.TypeCounts["chardev"], gc.Equals, maxDeviceNodes+1)
+	var kinds []FindingKind
+	var paths []string
+	for _, f := range result.Findings {
+		kinds = append(kinds, f.Kind)
+		paths = append(paths, f.Path)
+	}
+	c.Assert(kinds, gc.DeepEquals, []FindingKind{
+		FindingAbsolutePath, FindingPathTraversal, FindingSetuid, FindingManyDevices,
+	})
+	c.Assert(paths, gc.DeepEquals, []string{"/etc/passwd", "a/../../escape", "home/user/sneaky", ""})
+}
+
+func (t *TarSuite) TestVerifyTruncated(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false)
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(outputTar)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(outputTar, data[:1600], 0644)
+	c.Assert(err, gc.IsNil)
+	_, err = Verify(outputTar)
+	c.Assert(err, gc.ErrorMatches, "failed while reading .*")
+}
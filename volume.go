@@ -0,0 +1,272 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// volumeName returns the name of the n-th volume
+// of a multi-volume archive.
+func volumeName(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// volumeWriter writes a stream as a sequence of files named after
+// base, none of them larger than size.
+type volumeWriter struct {
+	target  Target
+	base    string
+	size    int64
+	next    int
+	current io.WriteCloser
+	written int64
+
+	// staged holds the volumes written under temporary names when
+	// writing to local files, renamed into place once all are
+	// written so that a failure leaves the previous archive intact.
+	staged []*atomicFile
+	local  bool
+}
+
+func newVolumeWriter(target Target, base string, size int64) *volumeWriter {
+	return &volumeWriter{target: target, base: base, size: size}
+}
+
+// newLocalVolumeWriter returns a volumeWriter writing local files,
+// which replace the previous archive written to base when closed.
+func newLocalVolumeWriter(base string, size int64) *volumeWriter {
+	return &volumeWriter{base: base, size: size, local: true}
+}
+
+// Write implements io.Writer, starting a new volume
+// whenever the current one is full.
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.current == nil || w.written == w.size {
+			if err := w.rotate(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if room := w.size - w.written; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := w.current.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// rotate closes the current volume and creates the next one.
+func (w *volumeWriter) rotate() error {
+	if err := w.closeCurrent(); err != nil {
+		return err
+	}
+	name := volumeName(w.base, w.next)
+	var f io.WriteCloser
+	var err error
+	if w.local {
+		var af *atomicFile
+		if af, err = createAtomic(name); err == nil {
+			w.staged = append(w.staged, af)
+			f = af
+		}
+	} else {
+		f, err = w.target.Create(name)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create backup volume %q: %v", name, err)
+	}
+	w.current = f
+	w.written = 0
+	w.next++
+	return nil
+}
+
+// closeCurrent closes the volume being written, if any.
+func (w *volumeWriter) closeCurrent() error {
+	if w.current == nil {
+		return nil
+	}
+	var err error
+	if w.local {
+		err = w.staged[len(w.staged)-1].finish()
+	} else {
+		err = w.current.Close()
+	}
+	w.current = nil
+	return err
+}
+
+// Close implements io.Closer. Local volumes are renamed into place,
+// and what remains of the previous archive is removed.
+func (w *volumeWriter) Close() error {
+	if err := w.closeCurrent(); err != nil {
+		w.abort()
+		return err
+	}
+	if !w.local {
+		return nil
+	}
+	// A previous single file archive would
+	// be read instead of the volumes.
+	if err := os.Remove(w.base); err != nil && !os.IsNotExist(err) {
+		w.abort()
+		return fmt.Errorf("cannot remove previous backup file %q: %v", w.base, err)
+	}
+	for i, f := range w.staged {
+		if err := f.commit(); err != nil {
+			for _, f := range w.staged[i+1:] {
+				os.Remove(f.Name())
+			}
+			w.staged = nil
+			return err
+		}
+	}
+	removeVolumes(w.base, len(w.staged))
+	w.staged = nil
+	return nil
+}
+
+// abort removes the local volumes written so far.
+func (w *volumeWriter) abort() {
+	if w.current != nil && w.local {
+		w.staged[len(w.staged)-1].File.Close()
+	}
+	w.current = nil
+	for _, f := range w.staged {
+		os.Remove(f.Name())
+	}
+	w.staged = nil
+}
+
+// volumeReader reads the volumes written by a volumeWriter
+// as a single stream.
+type volumeReader struct {
+	target  Target
+	base    string
+	next    int
+	current io.ReadCloser
+}
+
+// Read implements io.Reader, moving on to the next volume
+// when the current one is exhausted.
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			f, err := r.target.Open(volumeName(r.base, r.next))
+			if os.IsNotExist(err) && r.next > 0 {
+				return 0, io.EOF
+			}
+			if err != nil {
+				return 0, err
+			}
+			r.current = f
+			r.next++
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close implements io.Closer.
+func (r *volumeReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// createOutput creates the file, or the first of the volumes,
+// the archive will be written to.
+func createOutput(targetPath string, o *options) (io.WriteCloser, error) {
+	if o.target == nil {
+		// The index of a previous archive would
+		// point into the wrong places of the new one.
+		if err := os.Remove(IndexPath(targetPath)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove previous index %q: %v", IndexPath(targetPath), err)
+		}
+	}
+	if o.volumeSize > 0 {
+		w := newVolumeWriter(o.storage(), targetPath, o.volumeSize)
+		if o.target == nil {
+			w = newLocalVolumeWriter(targetPath, o.volumeSize)
+		}
+		if err := w.rotate(); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	if o.target == nil {
+		if !o.directWrite {
+			f, err := createAtomic(targetPath)
+			if err != nil {
+				return nil, err
+			}
+			f.replaced = func() { removeVolumes(targetPath, 0) }
+			return f, nil
+		}
+		removeVolumes(targetPath, 0)
+	}
+	return o.storage().Create(targetPath)
+}
+
+// removeVolumes removes the volumes of a previous archive written
+// to base from the n-th on, so that they cannot be mistaken for part
+// of a new one, or for another archive written to base.
+func removeVolumes(base string, n int) {
+	for ; ; n++ {
+		if err := os.Remove(volumeName(base, n)); err != nil {
+			return
+		}
+	}
+}
+
+// openInput opens the archive at tarFile. If there is no such file
+// but there is a first volume of a multi-volume archive with that
+// name, all the volumes are read in sequence. It fails if there are
+// both, as it cannot tell which one is the archive.
+func openInput(tarFile string) (io.ReadCloser, error) {
+	return openStored(LocalTarget(""), tarFile)
+}
+
+// openStored is like openInput, but opens
+// the archive called tarFile in target.
+func openStored(target Target, tarFile string) (io.ReadCloser, error) {
+	f, err := target.Open(tarFile)
+	if err == nil {
+		if first, err := target.Open(volumeName(tarFile, 0)); err == nil {
+			first.Close()
+			f.Close()
+			return nil, fmt.Errorf("%q is both a file and a multi-volume archive", tarFile)
+		}
+	}
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	first, verr := target.Open(volumeName(tarFile, 0))
+	if verr != nil {
+		return nil, err
+	}
+	return &volumeReader{target: target, base: tarFile, next: 1, current: first}, nil
+}
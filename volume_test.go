@@ -0,0 +1,102 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestTarFilesVolumes(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	// Leave a stale single file archive behind
+	// that must not be read instead of the volumes.
+	err := ioutil.WriteFile(outputTar, []byte("stale"), 0644)
+	c.Assert(err, gc.IsNil)
+	shaSum, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithVolumeSize(100))
+	c.Assert(err, gc.IsNil)
+
+	_, err = os.Stat(outputTar)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	volumes, err := filepath.Glob(outputTar + ".*")
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(volumes) > 1, gc.Equals, true)
+	for _, v := range volumes {
+		info, err := os.Stat(v)
+		c.Assert(err, gc.IsNil)
+		c.Assert(info.Size() <= 100, gc.Equals, true)
+	}
+
+	r, err := openInput(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	h := sha1.New()
+	_, err = io.Copy(h, r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(base64.StdEncoding.EncodeToString(h.Sum(nil)), gc.Equals, shaSum)
+
+	t.removeTestFiles(c)
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, true)
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
+
+func (t *TarSuite) TestOpenInputVolumeConflict(c *gc.C) {
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	for _, name := range []string{outputTar, volumeName(outputTar, 0)} {
+		c.Assert(ioutil.WriteFile(name, []byte("archive"), 0644), gc.IsNil)
+	}
+	_, err := openInput(outputTar)
+	c.Assert(err, gc.ErrorMatches, `".*/output_tar_file.tar" is both a file and a multi-volume archive`)
+
+	// Writing a single file archive removes the volumes.
+	_, err = TarFiles(nil, outputTar, "", false)
+	c.Assert(err, gc.IsNil)
+	r, err := openInput(outputTar)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+}
+
+func (t *TarSuite) TestTarFilesVolumeSizeInvalid(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.tar"), "", false, WithVolumeSize(-1))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithVolumeSize needs a positive size")
+}
+
+func (t *TarSuite) TestTarFilesVolumesFailureKeepsPrevious(c *gc.C) {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithVolumeSize(1024))
+	c.Assert(err, gc.IsNil)
+	previous, err := filepath.Glob(outputTar + ".*")
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(previous) > 1, gc.Equals, true)
+
+	failing := func(hdr *tar.Header, r io.Reader) (io.Reader, error) {
+		if hdr.Name == "TarFile2" {
+			return nil, errors.New("disk on fire")
+		}
+		return r, nil
+	}
+	_, err = TarFiles(t.testFiles, outputTar, t.cwd+"/", false, WithVolumeSize(100), WithContentFilter(failing))
+	c.Assert(err, gc.ErrorMatches, ".*disk on fire")
+	t.assertNoTempArchives(c)
+	volumes, err := filepath.Glob(outputTar + ".*")
+	c.Assert(err, gc.IsNil)
+	c.Assert(volumes, gc.DeepEquals, previous)
+
+	outputDir := c.MkDir()
+	err = UntarFiles(outputTar, outputDir, false)
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, outputDir)
+}
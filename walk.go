@@ -0,0 +1,59 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WalkFunc is called by WalkArchive for each entry, with its header
+// and a reader for its contents, which is only valid until the
+// function returns. Returning SkipDir for a directory skips its
+// contents, and for any other entry skips the remaining entries of
+// its directory. Returning StopArchiving stops the walk without
+// error. Any other error stops the walk and is returned.
+type WalkFunc func(hdr *tar.Header, r io.Reader) error
+
+// WalkArchive calls fn for every entry of the archive read from r,
+// which may be gzip compressed, in archive order. It lets entries be
+// processed as a stream without extracting them to disk. The
+// manifest written WithManifest is not walked.
+func WalkArchive(r io.Reader, fn WalkFunc) error {
+	tr, err := newArchiveReader(r)
+	if err != nil {
+		return err
+	}
+	skipPrefix := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed while reading tar header: %v", err)
+		}
+		if hdr.Name == ManifestName {
+			continue
+		}
+		name := cleanManifestPath(hdr.Name)
+		if skipPrefix != "" && strings.HasPrefix(name, skipPrefix) {
+			continue
+		}
+		skipPrefix = ""
+		switch err := fn(hdr, tr); err {
+		case nil, SkipEntry:
+		case SkipDir:
+			if skipPrefix = skipDirPrefix(hdr); skipPrefix == "" {
+				return nil
+			}
+		case StopArchiving:
+			return nil
+		default:
+			return err
+		}
+	}
+}
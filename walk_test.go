@@ -0,0 +1,69 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) walkTestArchive(c *gc.C, fn WalkFunc) error {
+	t.createTestFiles(c)
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tgz")
+	_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", true, WithManifest())
+	c.Assert(err, gc.IsNil)
+	f, err := os.Open(outputTar)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	return WalkArchive(f, fn)
+}
+
+func (t *TarSuite) TestWalkArchive(c *gc.C) {
+	contents := make(map[string]string)
+	err := t.walkTestArchive(c, func(hdr *tar.Header, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+		contents[hdr.Name] = string(data)
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.DeepEquals, map[string]string{
+		"TarDirectoryEmpty":     "",
+		"TarDirectoryPopulated": "",
+		"TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory": "",
+		"TarDirectoryPopulated/TarSubFile1":                       "TarSubFile1",
+		"TarFile1":                                                "TarFile1",
+		"TarFile2":                                                "TarFile2",
+	})
+}
+
+func (t *TarSuite) TestWalkArchiveControl(c *gc.C) {
+	var names []string
+	err := t.walkTestArchive(c, func(hdr *tar.Header, r io.Reader) error {
+		names = append(names, hdr.Name)
+		switch hdr.Name {
+		case "TarDirectoryPopulated":
+			return SkipDir
+		case "TarFile1":
+			return StopArchiving
+		}
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.DeepEquals, []string{"TarDirectoryEmpty", "TarDirectoryPopulated", "TarFile1"})
+}
+
+func (t *TarSuite) TestWalkArchiveError(c *gc.C) {
+	boom := errors.New("boom")
+	err := t.walkTestArchive(c, func(hdr *tar.Header, r io.Reader) error {
+		return boom
+	})
+	c.Assert(err, gc.Equals, boom)
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// WarningKind identifies the kind of a Warning.
+type WarningKind string
+
+const (
+	// WarningLiveDatabase is reported for files that belong to
+	// databases that may be running while they are archived.
+	WarningLiveDatabase WarningKind = "live-database"
+	// WarningSpaceUnknown is reported when the space available
+	// for an extraction cannot be checked.
+	WarningSpaceUnknown WarningKind = "space-unknown"
+	// WarningPathLength is reported for entries whose names
+	// exceed the limits checked WithPathLimits.
+	WarningPathLength WarningKind = "path-length"
+	// WarningFileChanged is reported for files whose size
+	// changed while they were being archived.
+	WarningFileChanged WarningKind = "file-changed"
+)
+
+// Warning describes a problem that does not prevent an archive from
+// being created or extracted, but that the caller should know about.
+type Warning struct {
+	// Kind identifies the problem.
+	Kind WarningKind
+	// Path is the file the warning is about.
+	Path string
+	// Message is a human readable description of the problem.
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Path, w.Message)
+}
+
+// WarningFunc is called with each warning as it is found.
+type WarningFunc func(Warning)
+
+// liveDatabasePatterns maps patterns matching the base name of files
+// written by running databases to the name of those databases.
+var liveDatabasePatterns = []struct {
+	pattern  string
+	database string
+}{
+	{"mongod.lock", "MongoDB"},
+	{"WiredTiger", "MongoDB"},
+	{"WiredTiger.lock", "MongoDB"},
+	{"*.sqlite-wal", "SQLite"},
+	{"*.sqlite-shm", "SQLite"},
+	{"*.db-wal", "SQLite"},
+	{"*.db-shm", "SQLite"},
+}
+
+// liveDatabaseWarning returns a warning if fileName looks like a file
+// belonging to a live database, whose copy is unlikely to be usable.
+func liveDatabaseWarning(fileName string) (Warning, bool) {
+	base := filepath.Base(fileName)
+	for _, p := range liveDatabasePatterns {
+		if ok, _ := filepath.Match(p.pattern, base); ok {
+			return Warning{
+				Kind: WarningLiveDatabase,
+				Path: fileName,
+				Message: fmt.Sprintf("file belongs to a possibly running %s database; "+
+					"consider archiving a filesystem snapshot instead", p.database),
+			}, true
+		}
+	}
+	return Warning{}, false
+}
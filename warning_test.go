@@ -0,0 +1,43 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestLiveDatabaseWarning(c *gc.C) {
+	for _, name := range []string{"/var/lib/juju/db/mongod.lock", "WiredTiger", "app.sqlite-wal"} {
+		w, ok := liveDatabaseWarning(name)
+		c.Check(ok, gc.Equals, true)
+		c.Check(w.Kind, gc.Equals, WarningLiveDatabase)
+		c.Check(w.Path, gc.Equals, name)
+	}
+	_, ok := liveDatabaseWarning("/var/lib/juju/agents/agent.conf")
+	c.Check(ok, gc.Equals, false)
+}
+
+func (t *TarSuite) TestTarFilesWarnsAboutLiveDatabases(c *gc.C) {
+	t.createTestFiles(c)
+	db := filepath.Join(t.cwd, "db")
+	err := os.Mkdir(db, 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(db, "mongod.lock"), []byte("1234"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(filepath.Join(db, "collection-0.wt"), nil, 0644)
+	c.Assert(err, gc.IsNil)
+
+	var warnings []Warning
+	outputTar := filepath.Join(t.cwd, "output_tar_file.tar")
+	_, err = TarFiles(append(t.testFiles, db), outputTar, t.cwd+"/", false,
+		WithWarningFunc(func(w Warning) { warnings = append(warnings, w) }))
+	c.Assert(err, gc.IsNil)
+	c.Assert(warnings, gc.HasLen, 1)
+	c.Assert(warnings[0].Kind, gc.Equals, WarningLiveDatabase)
+	c.Assert(warnings[0].Path, gc.Equals, filepath.Join(db, "mongod.lock"))
+}
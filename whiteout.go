@@ -0,0 +1,196 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// whiteoutPrefix starts the name of an entry marking
+	// the deletion of its sibling without the prefix.
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque is the name of an entry marking its
+	// directory as opaque, hiding what the directory held
+	// before the archive was applied.
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// overlayOpaqueXattrs hold the extended attributes marking
+// directories as opaque in overlayfs upper layers, the user
+// one being used by unprivileged mounts.
+var overlayOpaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// whiteoutFormat selects the whiteout markers
+// recognised when extracting an archive.
+type whiteoutFormat int
+
+const (
+	whiteoutNone whiteoutFormat = iota
+	// whiteoutAUFS recognises the ".wh." markers
+	// used by AUFS and OCI image layers.
+	whiteoutAUFS
+	// whiteoutOverlay recognises the overlayfs markers,
+	// character devices numbered 0/0 and directories
+	// with an opaque extended attribute, as well as
+	// the AUFS ones.
+	whiteoutOverlay
+)
+
+// ApplyOverlay extracts the archive over the tree at dest, gzip
+// compressed if compressed is true, interpreting the whiteout markers
+// of AUFS and overlayfs as deletions, so that a full backup can be
+// restored and the archives of later changes applied over it in turn.
+// Entries named with a ".wh." prefix and character devices numbered
+// 0/0 remove what exists at their path, or the path without the
+// prefix, instead of being extracted. Directories named ".wh..wh..opq"
+// or carrying a "trusted.overlay.opaque" or "user.overlay.opaque"
+// extended attribute set to "y" are opaque: what their directory held
+// before the archive is removed. The markers never remove entries
+// extracted from the archive itself.
+func ApplyOverlay(archive, dest string, compressed bool, opts ...Option) error {
+	opts = append(opts[:len(opts):len(opts)], func(o *options) {
+		o.whiteouts = whiteoutOverlay
+	})
+	return UntarFiles(archive, dest, compressed, opts...)
+}
+
+// whiteouts applies the whiteout markers of a layer
+// archive to the tree it is extracted over.
+type whiteouts struct {
+	format whiteoutFormat
+	// layer holds the names extracted from the archive so far,
+	// which markers never delete, and all their parents.
+	layer map[string]bool
+}
+
+func newWhiteouts(format whiteoutFormat) *whiteouts {
+	return &whiteouts{format: format, layer: make(map[string]bool)}
+}
+
+// apply applies the entry with the given header, to be extracted as
+// name, if it is a whiteout marker, and reports whether it was one.
+// Other entries are recorded as coming from the archive.
+func (w *whiteouts) apply(x *extractor, name string, hdr *tar.Header) (bool, error) {
+	name = cleanManifestPath(name)
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if w.format == whiteoutOverlay && hdr.Typeflag == tar.TypeChar && hdr.Devmajor == 0 && hdr.Devminor == 0 {
+		return true, w.remove(x, name)
+	}
+	if !strings.HasPrefix(base, whiteoutPrefix) {
+		for p := name; p != "." && p != "" && !w.layer[p]; p = path.Dir(p) {
+			w.layer[p] = true
+		}
+		if w.format == whiteoutOverlay && hdr.Typeflag == tar.TypeDir && overlayOpaque(hdr) {
+			return false, w.opaque(x, name)
+		}
+		return false, nil
+	}
+	if base == whiteoutOpaque {
+		return true, w.opaque(x, dir)
+	}
+	target := strings.TrimPrefix(base, whiteoutPrefix)
+	if target == "" || target == "." || target == ".." {
+		return true, fmt.Errorf("invalid whiteout %q", hdr.Name)
+	}
+	return true, w.remove(x, path.Join(dir, target))
+}
+
+// overlayOpaque reports whether hdr describes an opaque overlayfs
+// directory, removing the attributes marking it so, as they are
+// meaningless outside of overlayfs.
+func overlayOpaque(hdr *tar.Header) bool {
+	opaque := false
+	for _, name := range overlayOpaqueXattrs {
+		if v, ok := hdr.PAXRecords[xattrPrefix+name]; ok {
+			opaque = opaque || v == "y"
+			delete(hdr.PAXRecords, xattrPrefix+name)
+		}
+	}
+	return opaque
+}
+
+// remove removes name, unless it was extracted from the archive.
+func (w *whiteouts) remove(x *extractor, name string) error {
+	if w.layer[name] {
+		return nil
+	}
+	if link, err := symlinkedDir(x, path.Dir(name)); link != "" || err != nil {
+		if err == nil {
+			err = fmt.Errorf("cannot remove %q: parent %q is a symlink", name, link)
+		}
+		return err
+	}
+	fullPath, err := extractPath(x.outputFolder, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+		return nil
+	}
+	x.opts.log().Debugf("removing %q: whited out", fullPath)
+	return x.removeAll(fullPath)
+}
+
+// opaque removes everything in the directory dir
+// that was not extracted from the archive.
+func (w *whiteouts) opaque(x *extractor, dir string) error {
+	if link, err := symlinkedDir(x, dir); link != "" || err != nil {
+		if err == nil {
+			err = fmt.Errorf("cannot make %q opaque: %q is a symlink", dir, link)
+		}
+		return err
+	}
+	fullPath, err := extractPath(x.outputFolder, dir)
+	if err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(fullPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := w.remove(x, path.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlinkedDir returns the first of dir and its parents, outermost
+// first, that exists below the output folder as a symlink, if any,
+// so that markers never remove anything outside of it.
+func symlinkedDir(x *extractor, dir string) (string, error) {
+	if dir == "." || dir == "" {
+		return "", nil
+	}
+	var p string
+	for _, elem := range strings.Split(dir, "/") {
+		p = path.Join(p, elem)
+		fullPath, err := extractPath(x.outputFolder, p)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Lstat(fullPath)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return p, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestApplyOverlay(c *gc.C) {
+	full := filepath.Join(t.cwd, "full.tar")
+	writeContentsArchive(c, full, []testEntry{
+		{"etc/", ""},
+		{"etc/passwd", "root"},
+		{"etc/shadow", "secret"},
+		{"var/", ""},
+		{"var/cache/", ""},
+		{"var/cache/stale", "stale"},
+		{"tmp/", ""},
+		{"tmp/junk", "junk"},
+	})
+	delta := filepath.Join(t.cwd, "delta.tar")
+	writeTestArchive(c, delta, []*tar.Header{
+		// An overlayfs whiteout.
+		{Name: "etc/shadow", Typeflag: tar.TypeChar, Mode: 0},
+		// An opaque overlayfs directory.
+		{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 0755, PAXRecords: map[string]string{
+			"SCHILY.xattr.trusted.overlay.opaque": "y",
+		}},
+		{Name: "var/cache/fresh", Typeflag: tar.TypeReg, Mode: 0644},
+		// An AUFS whiteout.
+		{Name: ".wh.tmp", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	dest := filepath.Join(t.cwd, "restore")
+	c.Assert(ApplyOverlay(full, dest, false), gc.IsNil)
+	c.Assert(ApplyOverlay(delta, dest, false), gc.IsNil)
+
+	var files []string
+	err := filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+		c.Assert(err, gc.IsNil)
+		rel, _ := filepath.Rel(dest, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	sort.Strings(files)
+	c.Assert(files, gc.DeepEquals, []string{".", "etc", "etc/passwd", "var", "var/cache", "var/cache/fresh"})
+	data, err := ioutil.ReadFile(filepath.Join(dest, "etc", "passwd"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "root")
+}
+
+func (t *TarSuite) TestApplyOverlayAtomic(c *gc.C) {
+	err := ApplyOverlay(filepath.Join(t.cwd, "delta.tar"), t.cwd, false, WithAtomicExtract())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithAtomicExtract cannot be used when applying layers")
+}
+
+func (t *TarSuite) TestApplyOverlaySymlinkParent(c *gc.C) {
+	outside := c.MkDir()
+	victim := filepath.Join(outside, "victim")
+	c.Assert(ioutil.WriteFile(victim, []byte("safe"), 0644), gc.IsNil)
+	delta := filepath.Join(t.cwd, "delta.tar")
+	writeTestArchive(c, delta, []*tar.Header{
+		{Name: "l", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "l/.wh.victim", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err := ApplyOverlay(delta, filepath.Join(t.cwd, "restore"), false)
+	c.Assert(err, gc.ErrorMatches, `cannot remove "l/victim": parent "l" is a symlink`)
+
+	writeTestArchive(c, delta, []*tar.Header{
+		{Name: "l/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	err = ApplyOverlay(delta, filepath.Join(t.cwd, "restore"), false)
+	c.Assert(err, gc.ErrorMatches, `cannot make "l" opaque: "l" is a symlink`)
+
+	data, err := ioutil.ReadFile(victim)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "safe")
+}
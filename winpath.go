@@ -0,0 +1,73 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// windowsMaxPath is the length from which Windows paths must use
+// the \\?\ prefix to escape the MAX_PATH limit.
+const windowsMaxPath = 260
+
+// windowsReserved holds the device names Windows
+// reserves, with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsPath returns the Windows path at which the entry called
+// name is extracted below the absolute directory outputFolder. Both
+// slashes and backslashes in name are taken as separators, the name
+// cannot escape outputFolder, and long results get the \\?\ prefix.
+// It does not depend on the running platform, so it can be tested
+// anywhere.
+func windowsPath(outputFolder, name string) (string, error) {
+	parts := strings.Split(cleanManifestPath(strings.Replace(name, `\`, "/", -1)), "/")
+	for _, part := range parts {
+		if err := checkWindowsName(part); err != nil {
+			return "", fmt.Errorf("cannot extract %q on Windows: %v", name, err)
+		}
+	}
+	full := strings.TrimRight(strings.Replace(outputFolder, "/", `\`, -1), `\`)
+	if parts[0] != "" {
+		full += `\` + strings.Join(parts, `\`)
+	}
+	if len(full) < windowsMaxPath || strings.HasPrefix(full, `\\?\`) {
+		return full, nil
+	}
+	if strings.HasPrefix(full, `\\`) {
+		return `\\?\UNC\` + full[2:], nil
+	}
+	return `\\?\` + full, nil
+}
+
+// checkWindowsName returns an error if name
+// cannot be used as a file name on Windows.
+func checkWindowsName(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, r := range name {
+		if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+			return fmt.Errorf("%q contains characters invalid on Windows", name)
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return fmt.Errorf("%q ends with a dot or space", name)
+	}
+	base := name
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return fmt.Errorf("%q is a reserved name", name)
+	}
+	return nil
+}
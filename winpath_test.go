@@ -0,0 +1,62 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"strings"
+
+	gc "launchpad.net/gocheck"
+)
+
+var windowsPathTests = []struct {
+	name   string
+	result string
+	err    string
+}{{
+	name:   "dir/file.txt",
+	result: `C:\restore\dir\file.txt`,
+}, {
+	name:   `dir\sub\file.txt`,
+	result: `C:\restore\dir\sub\file.txt`,
+}, {
+	name:   "../../escape",
+	result: `C:\restore\escape`,
+}, {
+	name: "dir/con.txt",
+	err:  `cannot extract "dir/con.txt" on Windows: "con.txt" is a reserved name`,
+}, {
+	name: "LPT1",
+	err:  `cannot extract "LPT1" on Windows: "LPT1" is a reserved name`,
+}, {
+	name: "what?",
+	err:  `cannot extract "what\?" on Windows: "what\?" contains characters invalid on Windows`,
+}, {
+	name: "trailing.",
+	err:  `cannot extract "trailing." on Windows: "trailing." ends with a dot or space`,
+}, {
+	name:   "console",
+	result: `C:\restore\console`,
+}, {
+	name:   strings.Repeat("long/", 60) + "file",
+	result: `\\?\C:\restore\` + strings.Repeat(`long\`, 60) + "file",
+}}
+
+func (t *TarSuite) TestWindowsPath(c *gc.C) {
+	for i, test := range windowsPathTests {
+		c.Logf("test %d: %s", i, test.name)
+		result, err := windowsPath(`C:\restore\`, test.name)
+		if test.err != "" {
+			c.Check(err, gc.ErrorMatches, test.err)
+			continue
+		}
+		c.Check(err, gc.IsNil)
+		c.Check(result, gc.Equals, test.result)
+	}
+}
+
+func (t *TarSuite) TestWindowsPathUNC(c *gc.C) {
+	result, err := windowsPath(`\\server\share`, strings.Repeat("x", 300))
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.Equals, `\\?\UNC\server\share\`+strings.Repeat("x", 300))
+}
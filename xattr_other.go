@@ -0,0 +1,12 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build !linux
+// +build !linux
+
+package tar
+
+// setXattr sets the extended attribute name of path to value.
+func setXattr(path, name, value string) error {
+	return errXattrUnsupported
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// zipWriter is an entryWriter writing a zip archive.
+type zipWriter struct {
+	zw     *zip.Writer
+	method uint16
+	w      io.Writer
+}
+
+func (z *zipWriter) WriteHeader(h *tar.Header) error {
+	z.w = nil
+	switch h.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink:
+	default:
+		return fmt.Errorf("cannot store %s in a zip archive", entryType(h))
+	}
+	fh, err := zip.FileInfoHeader(h.FileInfo())
+	if err != nil {
+		return err
+	}
+	fh.Name = h.Name
+	fh.Modified = h.ModTime
+	fh.Method = zip.Store
+	switch h.Typeflag {
+	case tar.TypeDir:
+		if !strings.HasSuffix(fh.Name, "/") {
+			fh.Name += "/"
+		}
+	case tar.TypeSymlink:
+		fh.UncompressedSize64 = uint64(len(h.Linkname))
+	default:
+		fh.Method = z.method
+	}
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if h.Typeflag == tar.TypeSymlink {
+		// Zip archives store the target of a symlink as its contents.
+		if _, err := io.WriteString(w, h.Linkname); err != nil {
+			return err
+		}
+		return nil
+	}
+	z.w = w
+	return nil
+}
+
+func (z *zipWriter) Write(p []byte) (int, error) {
+	if z.w == nil {
+		return 0, tar.ErrWriteTooLong
+	}
+	return z.w.Write(p)
+}
+
+func (z *zipWriter) Flush() error {
+	return z.zw.Flush()
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// maxZipLinkSize is the size of the longest symlink
+// target read from a zip archive.
+const maxZipLinkSize = 4096
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+)
+
+// UnarchiveFiles extracts the archive into outputFolder like
+// UntarFiles, finding out from its first bytes whether it is a
+// tar archive, a gzip compressed tar archive or a zip archive.
+// Encrypted archives cannot be recognised, and must be extracted
+// with UntarFiles.
+func UnarchiveFiles(archive, outputFolder string, opts ...Option) error {
+	o := newOptions(opts)
+	if o.encrypted() {
+		return &ConfigError{Problems: []string{"UnarchiveFiles cannot be used with encryption"}}
+	}
+	f, err := openStored(o.storage(), archive)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file %q: %v", archive, err)
+	}
+	magic := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(f, magic)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("cannot read backup file %q: %v", archive, err)
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, zipMagic), bytes.HasPrefix(magic, emptyZipMagic):
+		opts = append(opts[:len(opts):len(opts)], func(o *options) {
+			o.zipInput = true
+		})
+		return UntarFiles(archive, outputFolder, false, opts...)
+	case bytes.HasPrefix(magic, gzipMagic):
+		return UntarFiles(archive, outputFolder, true, opts...)
+	}
+	return UntarFiles(archive, outputFolder, false, opts...)
+}
+
+// openZipStream opens the zip archive zipFile and returns a tar
+// stream holding the same entries, so that it can be extracted
+// like any tar archive, and a function closing it.
+func openZipStream(zipFile string) (io.Reader, func(), error) {
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open backup file %q: %v", zipFile, err)
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(zipToTar(&zr.Reader, pw))
+	}()
+	closeInput := func() {
+		pr.Close()
+		<-done
+		zr.Close()
+	}
+	return pr, closeInput, nil
+}
+
+// zipToTar writes the entries of zr to w as a tar archive.
+func zipToTar(zr *zip.Reader, w io.Writer) error {
+	tarw := tar.NewWriter(w)
+	for _, f := range zr.File {
+		if err := zipEntryToTar(tarw, f); err != nil {
+			return err
+		}
+	}
+	return tarw.Close()
+}
+
+// zipEntryToTar writes the zip entry f to tarw.
+func zipEntryToTar(tarw *tar.Writer, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("cannot read zip entry %q: %v", f.Name, err)
+	}
+	defer rc.Close()
+	var link string
+	if f.Mode()&os.ModeSymlink != 0 {
+		data, err := ioutil.ReadAll(io.LimitReader(rc, maxZipLinkSize+1))
+		if err != nil {
+			return fmt.Errorf("cannot read zip entry %q: %v", f.Name, err)
+		}
+		if len(data) > maxZipLinkSize {
+			return fmt.Errorf("symlink target of zip entry %q is too long", f.Name)
+		}
+		link = string(data)
+	}
+	hdr, err := tar.FileInfoHeader(f.FileInfo(), link)
+	if err != nil {
+		return fmt.Errorf("cannot convert zip entry %q: %v", f.Name, err)
+	}
+	hdr.Name = f.Name
+	hdr.ModTime = f.Modified
+	if err := tarw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("cannot convert zip entry %q: %v", f.Name, err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	if _, err := io.Copy(tarw, rc); err != nil {
+		return fmt.Errorf("cannot read zip entry %q: %v", f.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tar
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "launchpad.net/gocheck"
+)
+
+func (t *TarSuite) TestZipFormat(c *gc.C) {
+	t.createTestFiles(c)
+	outputZip := filepath.Join(t.cwd, "output.zip")
+	_, err := TarFiles(t.testFiles, outputZip, t.cwd+"/", true, WithFormat(FormatZip))
+	c.Assert(err, gc.IsNil)
+
+	zr, err := zip.OpenReader(outputZip)
+	c.Assert(err, gc.IsNil)
+	defer zr.Close()
+	methods := make(map[string]uint16)
+	for _, f := range zr.File {
+		methods[f.Name] = f.Method
+	}
+	c.Assert(methods, gc.DeepEquals, map[string]uint16{
+		"TarDirectoryEmpty/":                                       zip.Store,
+		"TarDirectoryPopulated/":                                   zip.Store,
+		"TarDirectoryPopulated/TarSubFile1":                        zip.Deflate,
+		"TarDirectoryPopulated/TarDirectoryPopulatedSubDirectory/": zip.Store,
+		"TarFile1": zip.Deflate,
+		"TarFile2": zip.Deflate,
+	})
+
+	restore := filepath.Join(t.cwd, "restore")
+	err = UnarchiveFiles(outputZip, restore)
+	c.Assert(err, gc.IsNil)
+	t.assertFilesWhereUntared(c, testExpectedTarContents, restore)
+}
+
+func (t *TarSuite) TestZipSymlink(c *gc.C) {
+	src := filepath.Join(t.cwd, "src")
+	c.Assert(os.Mkdir(src, 0755), gc.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "target"), []byte("hello"), 0644), gc.IsNil)
+	c.Assert(os.Symlink("target", filepath.Join(src, "link")), gc.IsNil)
+	outputZip := filepath.Join(t.cwd, "output.zip")
+	_, err := TarFiles([]string{src}, outputZip, t.cwd+"/", false, WithFormat(FormatZip))
+	c.Assert(err, gc.IsNil)
+
+	restore := filepath.Join(t.cwd, "restore")
+	err = UnarchiveFiles(outputZip, restore)
+	c.Assert(err, gc.IsNil)
+	link, err := os.Readlink(filepath.Join(restore, "src", "link"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(link, gc.Equals, "target")
+	data, err := ioutil.ReadFile(filepath.Join(restore, "src", "target"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello")
+}
+
+func (t *TarSuite) TestUnarchiveFilesTar(c *gc.C) {
+	t.createTestFiles(c)
+	for i, compress := range []bool{false, true} {
+		c.Logf("test %d: compress %v", i, compress)
+		outputTar := filepath.Join(t.cwd, "output_tar_file")
+		_, err := TarFiles(t.testFiles, outputTar, t.cwd+"/", compress)
+		c.Assert(err, gc.IsNil)
+		restore := c.MkDir()
+		err = UnarchiveFiles(outputTar, restore)
+		c.Assert(err, gc.IsNil)
+		t.assertFilesWhereUntared(c, testExpectedTarContents, restore)
+	}
+}
+
+func (t *TarSuite) TestZipFormatValidation(c *gc.C) {
+	_, err := TarFiles(nil, filepath.Join(t.cwd, "out.zip"), "", false, WithFormat(FormatZip), WithDedup())
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithDedup cannot be used with zip archives")
+	_, err = TarFiles(nil, filepath.Join(t.cwd, "out.zip"), "", false, WithFormat(ArchiveFormat(7)))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: unknown archive format 7")
+	err = UntarFiles(filepath.Join(t.cwd, "out.zip"), t.cwd, false, WithFormat(FormatZip))
+	c.Assert(err, gc.ErrorMatches, "invalid configuration: WithFormat only applies to archive creation")
+}